@@ -0,0 +1,713 @@
+package command
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/mb0/glob"
+	"github.com/medusar/lucas/protocol"
+	"github.com/medusar/lucas/util"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultUserName = "default"
+	//aclLogMaxLen is the max number of entries kept by ACL LOG
+	aclLogMaxLen = 128
+	//entries of ACL LOG created within this interval are grouped together
+	aclLogGroupInterval = 60 * time.Second
+)
+
+//https://redis.io/topics/acl
+var (
+	aclCategories = []string{"keyspace", "read", "write", "set", "sortedset", "list", "hash", "string", "bitmap",
+		"hyperloglog", "geo", "stream", "pubsub", "admin", "fast", "slow", "blocking", "dangerous", "connection",
+		"transaction", "scripting"}
+	//groupCategories maps the group of a command to its data type category
+	groupCategories = map[string]string{"generic": "keyspace", "string": "string", "bitmap": "bitmap", "hash": "hash",
+		"list": "list", "set": "set", "sorted_set": "sortedset", "pubsub": "pubsub", "connection": "connection"}
+	//flagCategories maps the flags of a command to categories
+	flagCategories = map[string][]string{"write": {"write"}, "readonly": {"read"}, "admin": {"admin", "dangerous"},
+		"pubsub": {"pubsub"}, "blocking": {"blocking"}, "fast": {"fast"}}
+
+	users       = make(map[string]*aclUser)
+	defaultUser = newDefaultUser()
+	//aclFile is where ACL LOAD and ACL SAVE read and write users, it's empty when no aclfile is configured
+	aclFile string
+	//aclLog is written by all the executors when a command is denied
+	aclLogMu sync.Mutex
+	aclLog   []*aclLogEntry
+
+	errWrongPass    = errors.New("WRONGPASS invalid username-password pair or user is disabled.")
+	errNoAuth       = errors.New("NOAUTH Authentication required.")
+	errUnknownCmd   = errors.New("Unknown command or category name in ACL")
+	errInvalidHash  = errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+	errNoSuchPass   = errors.New("The password you are trying to remove from the user does not exist")
+	errKeyAfterAll  = errors.New("Adding a pattern after the * pattern (or the 'allkeys' flag) is not valid and does not have any effect. Try 'resetkeys' to start with an empty list of patterns")
+	errChanAfterAll = errors.New("Adding a pattern after the * pattern (or the 'allchannels' flag) is not valid and does not have any effect. Try 'resetchannels' to start with an empty list of channels")
+	errACLSyntax    = errors.New("Syntax error")
+)
+
+func init() {
+	users[defaultUserName] = defaultUser
+}
+
+//categories returns the ACL categories of a command, they are derived from the group and the flags
+func (info *RedisCmdInfo) categories() []string {
+	var categories []string
+	if c, ok := groupCategories[info.Group]; ok {
+		categories = append(categories, c)
+	}
+	for _, flag := range info.Flags {
+		for _, c := range flagCategories[flag] {
+			if len(categories) == 0 || c != categories[len(categories)-1] {
+				categories = append(categories, c)
+			}
+		}
+	}
+	if !info.hasFlag("fast") {
+		categories = append(categories, "slow")
+	}
+	return categories
+}
+
+func isCategory(category string) bool {
+	for _, c := range aclCategories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+//commandsOfCategory returns the sorted names of the commands in a category
+func commandsOfCategory(category string) []string {
+	var names []string
+	for name, info := range cmdInfoMap {
+		for _, c := range info.categories() {
+			if c == category {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+type aclUser struct {
+	name    string
+	enabled bool
+	nopass  bool
+	//sha256 of the passwords in hex
+	passwords   []string
+	allKeys     bool
+	keys        []string
+	allChannels bool
+	channels    []string
+	allCommands bool
+	commands    map[string]bool
+	//cmdRules are the command rules applied since the last +@all or -@all, they describe the allowed commands
+	cmdRules []string
+}
+
+func newUser(name string) *aclUser {
+	return &aclUser{name: name, commands: make(map[string]bool), cmdRules: []string{"-@all"}}
+}
+
+func newDefaultUser() *aclUser {
+	u := newUser(defaultUserName)
+	for _, rule := range []string{"on", "nopass", "allkeys", "allchannels", "allcommands"} {
+		u.setRule(rule)
+	}
+	return u
+}
+
+func (u *aclUser) clone() *aclUser {
+	c := *u
+	c.passwords = append([]string(nil), u.passwords...)
+	c.keys = append([]string(nil), u.keys...)
+	c.channels = append([]string(nil), u.channels...)
+	c.cmdRules = append([]string(nil), u.cmdRules...)
+	c.commands = make(map[string]bool, len(u.commands))
+	for k, v := range u.commands {
+		c.commands[k] = v
+	}
+	return &c
+}
+
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func isPasswordHash(hash string) bool {
+	if len(hash) != 64 {
+		return false
+	}
+	for _, c := range hash {
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func (u *aclUser) addPassword(hash string) {
+	u.nopass = false
+	for _, p := range u.passwords {
+		if p == hash {
+			return
+		}
+	}
+	u.passwords = append(u.passwords, hash)
+}
+
+func (u *aclUser) removePassword(hash string) error {
+	for i, p := range u.passwords {
+		if p == hash {
+			u.passwords = append(u.passwords[:i], u.passwords[i+1:]...)
+			return nil
+		}
+	}
+	return errNoSuchPass
+}
+
+func (u *aclUser) checkPassword(password string) bool {
+	if u.nopass {
+		return true
+	}
+	hash := hashPassword(password)
+	for _, p := range u.passwords {
+		if p == hash {
+			return true
+		}
+	}
+	return false
+}
+
+//setRule applies a single ACL rule to the user, see https://redis.io/commands/acl-setuser
+func (u *aclUser) setRule(rule string) error {
+	switch strings.ToLower(rule) {
+	case "on":
+		u.enabled = true
+	case "off":
+		u.enabled = false
+	case "nopass":
+		u.nopass = true
+		u.passwords = nil
+	case "resetpass":
+		u.nopass = false
+		u.passwords = nil
+	case "allkeys":
+		return u.setRule("~*")
+	case "resetkeys":
+		u.allKeys = false
+		u.keys = nil
+	case "allchannels":
+		return u.setRule("&*")
+	case "resetchannels":
+		u.allChannels = false
+		u.channels = nil
+	case "allcommands":
+		return u.setRule("+@all")
+	case "nocommands":
+		return u.setRule("-@all")
+	case "reset":
+		for _, r := range []string{"resetpass", "resetkeys", "resetchannels", "off", "-@all"} {
+			u.setRule(r)
+		}
+	default:
+		if rule == "" {
+			return errACLSyntax
+		}
+		switch rule[0] {
+		case '>':
+			u.addPassword(hashPassword(rule[1:]))
+		case '#':
+			if !isPasswordHash(rule[1:]) {
+				return errInvalidHash
+			}
+			u.addPassword(rule[1:])
+		case '<':
+			return u.removePassword(hashPassword(rule[1:]))
+		case '!':
+			if !isPasswordHash(rule[1:]) {
+				return errInvalidHash
+			}
+			return u.removePassword(rule[1:])
+		case '~':
+			if u.allKeys {
+				return errKeyAfterAll
+			}
+			if rule == "~*" {
+				u.allKeys = true
+				u.keys = nil
+			} else {
+				u.keys = append(u.keys, rule[1:])
+			}
+		case '&':
+			if u.allChannels {
+				return errChanAfterAll
+			}
+			if rule == "&*" {
+				u.allChannels = true
+				u.channels = nil
+			} else {
+				u.channels = append(u.channels, rule[1:])
+			}
+		case '+', '-':
+			return u.setCommandRule(rule)
+		default:
+			return errACLSyntax
+		}
+	}
+	return nil
+}
+
+//setCommandRule applies a rule like +get, -@write or +@all
+func (u *aclUser) setCommandRule(rule string) error {
+	allow := rule[0] == '+'
+	name := strings.ToLower(rule[1:])
+
+	var names []string
+	if name == "@all" {
+		u.allCommands = allow
+		u.commands = make(map[string]bool)
+		u.cmdRules = nil
+		if allow {
+			for n := range cmdInfoMap {
+				u.commands[n] = true
+			}
+		}
+	} else if strings.HasPrefix(name, "@") {
+		if !isCategory(name[1:]) {
+			return errUnknownCmd
+		}
+		names = commandsOfCategory(name[1:])
+	} else {
+		if _, ok := cmdInfoMap[name]; !ok {
+			return errUnknownCmd
+		}
+		names = []string{name}
+	}
+
+	for _, n := range names {
+		if allow {
+			u.commands[n] = true
+		} else {
+			delete(u.commands, n)
+			u.allCommands = false
+		}
+	}
+	u.cmdRules = append(u.cmdRules, rule[:1]+name)
+	return nil
+}
+
+func (u *aclUser) flags() []string {
+	flags := []string{"off"}
+	if u.enabled {
+		flags[0] = "on"
+	}
+	if u.allKeys {
+		flags = append(flags, "allkeys")
+	}
+	if u.allChannels {
+		flags = append(flags, "allchannels")
+	}
+	if u.allCommands {
+		flags = append(flags, "allcommands")
+	}
+	if u.nopass {
+		flags = append(flags, "nopass")
+	}
+	return flags
+}
+
+//describe returns the rules which create the user, in the format of ACL LIST and the aclfile
+func (u *aclUser) describe() string {
+	rules := []string{"user", u.name, u.flags()[0]}
+	if u.nopass {
+		rules = append(rules, "nopass")
+	}
+	for _, p := range u.passwords {
+		rules = append(rules, "#"+p)
+	}
+	if u.allKeys {
+		rules = append(rules, "~*")
+	}
+	for _, k := range u.keys {
+		rules = append(rules, "~"+k)
+	}
+	if u.allChannels {
+		rules = append(rules, "&*")
+	} else if len(u.channels) == 0 {
+		rules = append(rules, "resetchannels")
+	}
+	for _, c := range u.channels {
+		rules = append(rules, "&"+c)
+	}
+	rules = append(rules, u.cmdRules...)
+	return strings.Join(rules, " ")
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if ok, err := glob.Match(p, s); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+//check returns the reason and the object which is denied if the user can't run the command,
+//reason is empty when the command is allowed.
+func (u *aclUser) check(name string, info *RedisCmdInfo, args []string) (reason, object string) {
+	if !u.allCommands && !u.commands[name] {
+		return "command", name
+	}
+	if info != nil && !u.allKeys {
+		for _, key := range info.keys(args) {
+			if !matchAny(u.keys, key) {
+				return "key", key
+			}
+		}
+	}
+	if !u.allChannels {
+		for _, channel := range channelsOf(name, args) {
+			if !matchAny(u.channels, channel) {
+				return "channel", channel
+			}
+		}
+	}
+	return "", ""
+}
+
+func noPermError(reason, name string) string {
+	switch reason {
+	case "key":
+		return "NOPERM this user has no permissions to access one of the keys used as arguments"
+	case "channel":
+		return "NOPERM this user has no permissions to access one of the channels used as arguments"
+	}
+	return fmt.Sprintf("NOPERM this user has no permissions to run the '%s' command or its subcommand", name)
+}
+
+//SetRequirePass sets the password of the default user, an empty password makes the default user `nopass`
+func SetRequirePass(password string) {
+	defaultUser.setRule("resetpass")
+	if password == "" {
+		defaultUser.setRule("nopass")
+	} else {
+		defaultUser.setRule(">" + password)
+	}
+}
+
+//LoadACLFile loads users from an aclfile, which is also used by ACL LOAD and ACL SAVE
+func LoadACLFile(path string) error {
+	aclFile = path
+	return loadACL()
+}
+
+//loadACL replaces all the users by the ones in the aclfile,
+//nothing is changed if any line of the file is invalid.
+func loadACL() error {
+	f, err := os.Open(aclFile)
+	if err != nil {
+		return fmt.Errorf("ERR Error loading ACLs, opening file '%s': %v", aclFile, err)
+	}
+	defer f.Close()
+
+	loaded := make(map[string]*aclUser)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] != "user" || len(fields) < 2 {
+			return fmt.Errorf("ERR %s:%d: line should start with user keyword. WARNING: ACL errors detected, no change to the previously active ACL rules was performed", aclFile, n)
+		}
+		if _, ok := loaded[fields[1]]; ok {
+			return fmt.Errorf("ERR %s:%d: Duplicate user '%s' found. WARNING: ACL errors detected, no change to the previously active ACL rules was performed", aclFile, n, fields[1])
+		}
+		u := newUser(fields[1])
+		for _, rule := range fields[2:] {
+			if err := u.setRule(rule); err != nil {
+				return fmt.Errorf("ERR %s:%d: %v. WARNING: ACL errors detected, no change to the previously active ACL rules was performed", aclFile, n, err)
+			}
+		}
+		loaded[u.name] = u
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("ERR Error loading ACLs, reading file '%s': %v", aclFile, err)
+	}
+
+	if _, ok := loaded[defaultUserName]; !ok {
+		loaded[defaultUserName] = newDefaultUser()
+	}
+	users = loaded
+	defaultUser = loaded[defaultUserName]
+
+	//clients follow the new definition of their users, and are disconnected if the user is gone
+	for _, c := range clients {
+		if u, ok := users[c.user.name]; ok {
+			c.user = u
+		} else {
+			c.con.Close()
+		}
+	}
+	return nil
+}
+
+func saveACL() error {
+	names := make([]string, 0, len(users))
+	for name := range users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var content strings.Builder
+	for _, name := range names {
+		content.WriteString(users[name].describe())
+		content.WriteByte('\n')
+	}
+	tmp := aclFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(content.String()), 0600); err != nil {
+		return fmt.Errorf("ERR There was an error trying to save the ACLs. Please check the server logs for more information")
+	}
+	if err := os.Rename(tmp, aclFile); err != nil {
+		return fmt.Errorf("ERR There was an error trying to save the ACLs. Please check the server logs for more information")
+	}
+	return nil
+}
+
+//authenticate checks the password of the user and makes the client act as the user on success
+func authenticate(c *client, username, password string) error {
+	u, ok := users[username]
+	if !ok || !u.enabled || !u.checkPassword(password) {
+		addACLLog(c, "auth", "AUTH", username)
+		return errWrongPass
+	}
+	c.user = u
+	c.authenticated = true
+	return nil
+}
+
+type aclLogEntry struct {
+	count      int
+	reason     string
+	object     string
+	username   string
+	updatedAt  time.Time
+	clientInfo string
+}
+
+//addACLLog records a denied command or a failed authentication,
+//entries with the same reason, object and user are grouped if they happen close in time.
+func addACLLog(c *client, reason, object, username string) {
+	aclLogMu.Lock()
+	defer aclLogMu.Unlock()
+	now := time.Now()
+	for _, e := range aclLog {
+		if e.reason == reason && e.object == object && e.username == username && now.Sub(e.updatedAt) < aclLogGroupInterval {
+			e.count++
+			e.updatedAt = now
+			e.clientInfo = c.info()
+			return
+		}
+	}
+	//the object may be a key, a view into the buffer of the connection
+	e := &aclLogEntry{count: 1, reason: reason, object: util.Clone(object), username: util.Clone(username), updatedAt: now, clientInfo: c.info()}
+	aclLog = append([]*aclLogEntry{e}, aclLog...)
+	if len(aclLog) > aclLogMaxLen {
+		aclLog = aclLog[:aclLogMaxLen]
+	}
+}
+
+//https://redis.io/commands/auth
+var authFunc = func(args []string, r protocol.RedisRW) error {
+	if len(args) > 2 {
+		return r.WriteError("ERR syntax error")
+	}
+	username, password := defaultUserName, args[0]
+	if len(args) == 2 {
+		username, password = args[0], args[1]
+	} else if defaultUser.nopass {
+		return r.WriteError("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+	}
+	if err := authenticate(clientOf(r), username, password); err != nil {
+		return r.WriteError(err.Error())
+	}
+	return r.WriteString("OK")
+}
+
+//https://redis.io/commands/acl
+var aclFunc = func(args []string, r protocol.RedisRW) error {
+	c := clientOf(r)
+	sub := strings.ToLower(args[0])
+	switch {
+	case sub == "setuser" && len(args) >= 2:
+		return aclSetUser(args[1], args[2:], r)
+	case sub == "getuser" && len(args) == 2:
+		return aclGetUser(args[1], r)
+	case sub == "deluser" && len(args) >= 2:
+		return aclDelUser(args[1:], r)
+	case sub == "list" && len(args) == 1:
+		names := aclUserNames()
+		lines := make([]string, len(names))
+		for i, name := range names {
+			lines[i] = users[name].describe()
+		}
+		return r.WriteArray(toBulkArray(lines))
+	case sub == "users" && len(args) == 1:
+		return r.WriteArray(toBulkArray(aclUserNames()))
+	case sub == "whoami" && len(args) == 1:
+		return r.WriteBulk(c.user.name)
+	case sub == "cat" && len(args) == 1:
+		return r.WriteArray(toBulkArray(aclCategories))
+	case sub == "cat" && len(args) == 2:
+		category := strings.ToLower(args[1])
+		if !isCategory(category) {
+			return r.WriteError(fmt.Sprintf("ERR Unknown category '%s'", args[1]))
+		}
+		return r.WriteArray(toBulkArray(commandsOfCategory(category)))
+	case sub == "log" && len(args) <= 2:
+		return aclLogReply(args[1:], r)
+	case (sub == "load" || sub == "save") && len(args) == 1:
+		if aclFile == "" {
+			return r.WriteError("ERR This Redis instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE (assuming you have a Redis configuration file set) in order to store users in the Redis configuration.")
+		}
+		var err error
+		if sub == "load" {
+			err = loadACL()
+		} else {
+			err = saveACL()
+		}
+		if err != nil {
+			return r.WriteError(err.Error())
+		}
+		return r.WriteString("OK")
+	}
+	return r.WriteError(fmt.Sprintf("ERR Unknown subcommand or wrong number of arguments for '%s'. Try ACL HELP.", args[0]))
+}
+
+func aclUserNames() []string {
+	names := make([]string, 0, len(users))
+	for name := range users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//ACL SETUSER username [rule [rule ...]], the rules are applied atomically
+func aclSetUser(name string, rules []string, r protocol.RedisRW) error {
+	u, exists := users[name]
+	if exists {
+		u = u.clone()
+	} else {
+		u = newUser(name)
+	}
+	for _, rule := range rules {
+		if err := u.setRule(rule); err != nil {
+			return r.WriteError(fmt.Sprintf("ERR Error in ACL SETUSER modifier '%s': %v", rule, err))
+		}
+	}
+	if !exists {
+		users[name] = u
+		return r.WriteString("OK")
+	}
+	//update the user in place so that the authenticated clients see the new rules
+	*users[name] = *u
+	return r.WriteString("OK")
+}
+
+func aclGetUser(name string, r protocol.RedisRW) error {
+	u, ok := users[name]
+	if !ok {
+		return r.WriteNil()
+	}
+	keys := u.keys
+	if u.allKeys {
+		keys = []string{"*"}
+	}
+	channels := u.channels
+	if u.allChannels {
+		channels = []string{"*"}
+	}
+	return r.WriteMap([]*protocol.Resp{
+		protocol.NewBulk("flags"), protocol.NewSet(toBulkArray(u.flags())),
+		protocol.NewBulk("passwords"), protocol.NewArray(toBulkArray(u.passwords)),
+		protocol.NewBulk("commands"), protocol.NewBulk(strings.Join(u.cmdRules, " ")),
+		protocol.NewBulk("keys"), protocol.NewArray(toBulkArray(keys)),
+		protocol.NewBulk("channels"), protocol.NewArray(toBulkArray(channels)),
+	})
+}
+
+func aclDelUser(names []string, r protocol.RedisRW) error {
+	for _, name := range names {
+		if name == defaultUserName {
+			return r.WriteError("ERR The 'default' user cannot be removed")
+		}
+	}
+	deleted := 0
+	for _, name := range names {
+		u, ok := users[name]
+		if !ok {
+			continue
+		}
+		delete(users, name)
+		deleted++
+		for _, c := range clients {
+			if c.user == u {
+				c.con.Close()
+			}
+		}
+	}
+	return r.WriteInteger(deleted)
+}
+
+//ACL LOG [count | RESET]
+func aclLogReply(args []string, r protocol.RedisRW) error {
+	aclLogMu.Lock()
+	defer aclLogMu.Unlock()
+	count := 10
+	if len(args) == 1 {
+		if strings.ToLower(args[0]) == "reset" {
+			aclLog = nil
+			return r.WriteString("OK")
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			return r.WriteError("ERR value is not an integer or out of range")
+		}
+		count = n
+	}
+	if count > len(aclLog) {
+		count = len(aclLog)
+	}
+
+	now := time.Now()
+	entries := make([]*protocol.Resp, count)
+	for i, e := range aclLog[:count] {
+		entries[i] = protocol.NewMap([]*protocol.Resp{
+			protocol.NewBulk("count"), protocol.NewInteger(e.count),
+			protocol.NewBulk("reason"), protocol.NewBulk(e.reason),
+			protocol.NewBulk("context"), protocol.NewBulk("toplevel"),
+			protocol.NewBulk("object"), protocol.NewBulk(e.object),
+			protocol.NewBulk("username"), protocol.NewBulk(e.username),
+			protocol.NewBulk("age-seconds"), protocol.NewDouble(now.Sub(e.updatedAt).Seconds()),
+			protocol.NewBulk("client-info"), protocol.NewBulk(e.clientInfo),
+		})
+	}
+	return r.WriteArray(entries)
+}
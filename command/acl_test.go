@@ -0,0 +1,175 @@
+package command
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_aclUser_describe(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []string
+		want  string
+	}{
+		{"new user", nil, "user u off resetchannels -@all"},
+		{"all", []string{"on", "nopass", "allkeys", "allchannels", "allcommands"}, "user u on nopass ~* &* +@all"},
+		{"password", []string{"on", ">secret", ">secret"}, "user u on #" + hashPassword("secret") + " resetchannels -@all"},
+		{"password removed", []string{">secret", ">other", "<secret"}, "user u off #" + hashPassword("other") + " resetchannels -@all"},
+		{"nopass drops the passwords", []string{">secret", "nopass"}, "user u off nopass resetchannels -@all"},
+		{"keys and channels", []string{"~a:*", "~b", "&news.*"}, "user u off ~a:* ~b &news.* -@all"},
+		{"reset keys", []string{"allkeys", "resetkeys", "~a"}, "user u off ~a resetchannels -@all"},
+		{"commands", []string{"+@read", "-GET", "+set"}, "user u off resetchannels -@all +@read -get +set"},
+		{"all commands forget the previous rules", []string{"+get", "allcommands", "-@write"}, "user u off resetchannels +@all -@write"},
+		{"reset", []string{"on", ">secret", "allkeys", "allchannels", "+get", "reset"}, "user u off resetchannels -@all"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := newUser("u")
+			for _, rule := range tt.rules {
+				if err := u.setRule(rule); err != nil {
+					t.Fatalf("setRule(%q) error = %v", rule, err)
+				}
+			}
+			got := u.describe()
+			assert.Equal(t, tt.want, got)
+
+			//the description creates the same user again, it's how ACL SAVE and ACL LOAD work
+			again := newUser("u")
+			for _, rule := range strings.Fields(got)[2:] {
+				if err := again.setRule(rule); err != nil {
+					t.Fatalf("setRule(%q) error = %v", rule, err)
+				}
+			}
+			assert.Equal(t, got, again.describe())
+			assert.Equal(t, u.commands, again.commands)
+		})
+	}
+}
+
+func Test_aclUser_setRule_errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []string
+		want  error
+	}{
+		{"empty", []string{""}, errACLSyntax},
+		{"unknown", []string{"bogus"}, errACLSyntax},
+		{"unknown command", []string{"+nosuch"}, errUnknownCmd},
+		{"unknown category", []string{"-@nosuch"}, errUnknownCmd},
+		{"invalid hash", []string{"#abc"}, errInvalidHash},
+		{"invalid hash removed", []string{"!ABC"}, errInvalidHash},
+		{"no such password", []string{">secret", "<other"}, errNoSuchPass},
+		{"key after allkeys", []string{"allkeys", "~a"}, errKeyAfterAll},
+		{"channel after allchannels", []string{"allchannels", "&a"}, errChanAfterAll},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := newUser("u")
+			var err error
+			for _, rule := range tt.rules {
+				if err = u.setRule(rule); err != nil {
+					break
+				}
+			}
+			assert.Equal(t, tt.want, err)
+		})
+	}
+}
+
+func Test_aclUser_check(t *testing.T) {
+	u := newUser("u")
+	for _, rule := range []string{"on", "~k:*", "&news.*", "+@read", "+set", "+publish", "+subscribe"} {
+		if err := u.setRule(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name       string
+		args       []string
+		wantReason string
+		wantObject string
+	}{
+		{"allowed", []string{"get", "k:1"}, "", ""},
+		{"category", []string{"zrange", "k:1", "0", "-1"}, "", ""},
+		{"denied command", []string{"del", "k:1"}, "command", "del"},
+		{"denied write of the read category", []string{"incr", "k:1"}, "command", "incr"},
+		{"denied key", []string{"get", "other"}, "key", "other"},
+		{"allowed command", []string{"set", "k:1", "v"}, "", ""},
+		{"mget", []string{"mget", "k:1", "k:2", "x"}, "key", "x"},
+		{"allowed channel", []string{"publish", "news.1", "hi"}, "", ""},
+		{"denied channel", []string{"publish", "sports", "hi"}, "channel", "sports"},
+		{"denied subscription", []string{"subscribe", "news.1", "sports"}, "channel", "sports"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := tt.args[0]
+			reason, object := u.check(name, cmdInfoMap[name], tt.args[1:])
+			assert.Equal(t, tt.wantReason, reason)
+			assert.Equal(t, tt.wantObject, object)
+		})
+	}
+}
+
+func Test_commandsOfCategory(t *testing.T) {
+	read := commandsOfCategory("read")
+	assert.Contains(t, read, "get")
+	assert.NotContains(t, read, "set")
+	assert.Contains(t, commandsOfCategory("write"), "set")
+	assert.Contains(t, commandsOfCategory("slow"), "keys")
+	assert.NotContains(t, commandsOfCategory("slow"), "get")
+	assert.True(t, isCategory("sortedset"))
+	assert.False(t, isCategory("nosuch"))
+}
+
+func Test_loadACL(t *testing.T) {
+	savedUsers, savedDefault, savedFile := users, defaultUser, aclFile
+	defer func() {
+		users, defaultUser, aclFile = savedUsers, savedDefault, savedFile
+	}()
+	dir := t.TempDir()
+	warning := ". WARNING: ACL errors detected, no change to the previously active ACL rules was performed"
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"no user keyword", "user alice on\nalice off\n", ":2: line should start with user keyword" + warning},
+		{"no user name", "user\n", ":1: line should start with user keyword" + warning},
+		{"duplicate user", "user alice on\n\nuser alice off\n", ":3: Duplicate user 'alice' found" + warning},
+		{"invalid rule", "user alice on +nosuch\n", ":1: Unknown command or category name in ACL" + warning},
+		{"invalid hash", "user alice on #abc\n", ":1: The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters" + warning},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aclFile = filepath.Join(dir, "users.acl")
+			if err := os.WriteFile(aclFile, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			err := loadACL()
+			assert.EqualError(t, err, "ERR "+aclFile+tt.wantErr)
+			assert.Equal(t, savedUsers, users, "the users are unchanged")
+		})
+	}
+
+	aclFile = filepath.Join(dir, "missing.acl")
+	err := loadACL()
+	if assert.Error(t, err) {
+		assert.True(t, strings.HasPrefix(err.Error(), "ERR Error loading ACLs, opening file '"+aclFile+"'"))
+	}
+
+	aclFile = filepath.Join(dir, "users.acl")
+	content := "user alice on >secret ~cache:* resetchannels -@all +get\nuser bob off\n"
+	if err := os.WriteFile(aclFile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if assert.NoError(t, loadACL()) {
+		assert.Equal(t, []string{"alice", "bob", "default"}, aclUserNames())
+		assert.Equal(t, "user alice on #"+hashPassword("secret")+" ~cache:* resetchannels -@all +get", users["alice"].describe())
+		assert.Equal(t, "user default on nopass ~* &* +@all", defaultUser.describe())
+		assert.True(t, users["alice"].checkPassword("secret"))
+	}
+}
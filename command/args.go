@@ -0,0 +1,168 @@
+package command
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+//error replies shared by the commands, they match the ones of redis byte for byte
+const (
+	errSyntax       = "ERR syntax error"
+	errNotInteger   = "ERR value is not an integer or out of range"
+	errNotFloat     = "ERR value is not a valid float"
+	errMinMaxFloat  = "ERR min or max is not a float"
+	errBitOffset    = "ERR bit offset is not an integer or out of range"
+	errWrongArgsFmt = "ERR wrong number of arguments for '%s' command"
+	//errors of the commands with a numkeys argument
+	errIntercardKeys  = "ERR Number of keys can't be greater than number of args"
+	errIntercardLimit = "ERR LIMIT can't be negative"
+)
+
+//checkArity reports whether the number of arguments, including the command name, matches the arity:
+//a positive arity is the exact number of arguments, a negative one is the minimum.
+func checkArity(arity, argc int) bool {
+	if arity > 0 {
+		return argc == arity
+	}
+	return argc >= -arity
+}
+
+//argOption describes an optional argument of a command, such as NX, EX seconds or LIMIT offset count
+type argOption struct {
+	//name in lower case
+	name string
+	//nargs is the number of values following the option
+	nargs int
+	//conflicts are the options which can't be used together with this one
+	conflicts []string
+}
+
+//parsedOptions maps the name of the options found to their values
+type parsedOptions map[string][]string
+
+func (p parsedOptions) has(name string) bool {
+	_, ok := p[name]
+	return ok
+}
+
+//int returns the i-th value of an option as an integer
+func (p parsedOptions) int(name string, i int) (int, error) {
+	n, err := strconv.Atoi(p[name][i])
+	if err != nil {
+		return 0, errors.New(errNotInteger)
+	}
+	return n, nil
+}
+
+//float returns the i-th value of an option as a float
+func (p parsedOptions) float(name string, i int) (float64, error) {
+	f, err := strconv.ParseFloat(p[name][i], 64)
+	if err != nil {
+		return 0, errors.New(errNotFloat)
+	}
+	return f, nil
+}
+
+//parseOptions parses the optional arguments of a command in any order.
+//Unknown or conflicting options and missing values are syntax errors like redis does,
+//the last one wins if an option is repeated. An option conflicting with itself can't be repeated.
+func parseOptions(args []string, options []argOption) (parsedOptions, error) {
+	parsed, _, err := scanOptions(args, options, false)
+	return parsed, err
+}
+
+//parseLeadingOptions parses the options in front of the other arguments, like the flags of ZADD,
+//it stops at the first argument which isn't an option and returns how many arguments were parsed.
+func parseLeadingOptions(args []string, options []argOption) (parsedOptions, int, error) {
+	return scanOptions(args, options, true)
+}
+
+func scanOptions(args []string, options []argOption, leading bool) (parsedOptions, int, error) {
+	parsed := make(parsedOptions)
+	i := 0
+	for ; i < len(args); i++ {
+		name := strings.ToLower(args[i])
+		var opt *argOption
+		for j := range options {
+			if options[j].name == name {
+				opt = &options[j]
+				break
+			}
+		}
+		if opt == nil && leading {
+			break
+		}
+		if opt == nil || i+opt.nargs >= len(args) {
+			return nil, 0, errors.New(errSyntax)
+		}
+		for _, c := range opt.conflicts {
+			if parsed.has(c) {
+				return nil, 0, errors.New(errSyntax)
+			}
+		}
+		parsed[name] = args[i+1 : i+1+opt.nargs]
+		i += opt.nargs
+	}
+	return parsed, i, nil
+}
+
+//the options of LMPOP and ZMPOP, COUNT can't be repeated
+var mpopOptions = []argOption{{name: "count", nargs: 1, conflicts: []string{"count"}}}
+
+//parseMpopArgs parses the arguments of LMPOP and ZMPOP: numkeys key [key ...] side [COUNT count],
+//the side is one of sides and first reports if it's the first one.
+//It returns the error message when the arguments are invalid.
+func parseMpopArgs(args []string, sides [2]string) (keys []string, first bool, count int, msg string) {
+	numkeys, err := strconv.Atoi(args[0])
+	if err != nil || numkeys <= 0 {
+		return nil, false, 0, errNumkeys
+	}
+	if numkeys > len(args)-2 {
+		return nil, false, 0, errSyntax
+	}
+	keys, side := args[1:1+numkeys], args[1+numkeys]
+	switch strings.ToLower(side) {
+	case sides[0]:
+		first = true
+	case sides[1]:
+	default:
+		return nil, false, 0, errSyntax
+	}
+	opts, err := parseOptions(args[2+numkeys:], mpopOptions)
+	if err != nil {
+		return nil, false, 0, err.Error()
+	}
+	count = 1
+	if opts.has("count") {
+		if count, err = opts.int("count", 0); err != nil || count <= 0 {
+			return nil, false, 0, errMpopCount
+		}
+	}
+	return keys, first, count, ""
+}
+
+//the options of SINTERCARD and ZINTERCARD
+var intercardOptions = []argOption{{name: "limit", nargs: 1}}
+
+//parseIntercardArgs parses the arguments of SINTERCARD and ZINTERCARD: numkeys key [key ...] [LIMIT limit].
+//It returns the error message when the arguments are invalid.
+func parseIntercardArgs(args []string) (keys []string, limit int, msg string) {
+	numkeys, err := strconv.Atoi(args[0])
+	if err != nil || numkeys <= 0 {
+		return nil, 0, errNumkeys
+	}
+	if numkeys > len(args)-1 {
+		return nil, 0, errIntercardKeys
+	}
+	opts, err := parseOptions(args[1+numkeys:], intercardOptions)
+	if err != nil {
+		return nil, 0, err.Error()
+	}
+	if opts.has("limit") {
+		if limit, err = opts.int("limit", 0); err != nil || limit < 0 {
+			return nil, 0, errIntercardLimit
+		}
+	}
+	return args[1 : 1+numkeys], limit, ""
+}
@@ -0,0 +1,211 @@
+package command
+
+import (
+	"github.com/medusar/lucas/protocol"
+	"github.com/medusar/lucas/store"
+	"github.com/medusar/lucas/util"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	errTimeoutNotFloat = "ERR timeout is not a float or out of range"
+	errTimeoutNegative = "ERR timeout is negative"
+)
+
+var (
+	//blockingTables map the keys to the commands blocked on them, one table per shard guarded by the lock of the shard
+	blockingTables = newBlockingTables(store.ShardCount)
+	//blockedCount is the number of blocked clients, modified keys are only looked up when there is one
+	blockedCount int32
+)
+
+func newBlockingTables(n int) []map[string][]*blockState {
+	t := make([]map[string][]*blockState, n)
+	for i := range t {
+		t[i] = make(map[string][]*blockState)
+	}
+	return t
+}
+
+//blockState is a command waiting for one of its keys to be modified, like BZPOPMIN on empty sorted sets.
+//The command doesn't hold its executor while it waits: it's executed again once a key is modified,
+//and blocks again if it still can't be served.
+type blockState struct {
+	keys []string
+	//valueType is the type of value the command waits for, keys modified into other types don't wake it up
+	valueType string
+	//deadline is zero when the command waits forever
+	deadline time.Time
+	//ready is signaled when one of the keys is modified or the client is disconnected
+	ready chan struct{}
+}
+
+//parseTimeout parses the timeout of a blocking command in seconds, 0 means forever
+func parseTimeout(s string) (time.Duration, string) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) || f > math.MaxInt64/float64(time.Second) {
+		return 0, errTimeoutNotFloat
+	}
+	if f < 0 {
+		return 0, errTimeoutNegative
+	}
+	return time.Duration(f * float64(time.Second)), ""
+}
+
+//block makes the client wait for one of the keys to hold a value of the type instead of replying.
+//It's called by a blocking command while the shards of its keys are locked.
+//The deadline is set by the first execution, a command executed again keeps it.
+func block(r protocol.RedisRW, keys []string, valueType string, timeout time.Duration) {
+	c := clientOf(r)
+	b := &blockState{keys: util.CloneArray(keys), valueType: valueType, ready: make(chan struct{}, 1)}
+	if c.retried != nil {
+		b.deadline = c.retried.deadline
+	} else if timeout > 0 {
+		b.deadline = time.Now().Add(timeout)
+	}
+	for _, key := range b.keys {
+		table := blockingTables[store.ShardIndex(key)]
+		table[key] = append(table[key], b)
+	}
+	c.blocked = b
+	atomic.AddInt32(&blockedCount, 1)
+}
+
+//unblock forgets the command the client is blocked by, the shards of its keys must be locked
+func unblock(c *client) {
+	b := c.blocked
+	if b == nil {
+		return
+	}
+	c.blocked = nil
+	atomic.AddInt32(&blockedCount, -1)
+	for _, key := range b.keys {
+		table := blockingTables[store.ShardIndex(key)]
+		waiting := table[key]
+		for i, w := range waiting {
+			if w == b {
+				waiting = append(waiting[:i], waiting[i+1:]...)
+				break
+			}
+		}
+		if len(waiting) == 0 {
+			delete(table, key)
+		} else {
+			table[key] = waiting
+		}
+	}
+}
+
+//signalKeyReady wakes up the commands blocked on a modified key, it's called with the shard of the key locked
+func signalKeyReady(key string) {
+	if atomic.LoadInt32(&blockedCount) == 0 {
+		return
+	}
+	waiting := blockingTables[store.ShardIndex(key)][key]
+	if len(waiting) == 0 {
+		return
+	}
+	valueType := store.Type(key)
+	for _, b := range waiting {
+		if b.valueType == valueType {
+			b.signal()
+		}
+	}
+}
+
+func (b *blockState) signal() {
+	select {
+	case b.ready <- struct{}{}:
+	default:
+	}
+}
+
+//wait waits for one of the keys to be modified or the peer to hang up, it returns false once the timeout expires
+func (b *blockState) wait(hangup <-chan struct{}) bool {
+	var timeout <-chan time.Time
+	if !b.deadline.IsZero() {
+		timer := time.NewTimer(time.Until(b.deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-b.ready:
+	case <-hangup:
+	case <-timeout:
+		return false
+	}
+	return true
+}
+
+//waitingConn is a connection served by its own goroutine, like protocol.BufRedisConn.
+//While a command of it is blocked, the replies held are written and the peer is watched,
+//since the goroutine doesn't read anymore. The event loops of the reactor do both themselves.
+type waitingConn interface {
+	SetBatching(batching bool) error
+	WatchHangup(fn func()) (stop func())
+}
+
+//serveBlocked waits while a command is blocked, it's executed again by retry every time one of its keys is modified.
+//A command still blocked when the timeout expires gets a null reply.
+func serveBlocked(r protocol.RedisRW, b *blockState, retry func() (*blockState, error)) error {
+	var hangup chan struct{}
+	if wc, ok := r.(waitingConn); ok {
+		if err := wc.SetBatching(false); err != nil {
+			return err
+		}
+		hangup = make(chan struct{})
+		stop := wc.WatchHangup(func() {
+			//the command executed again sees the connection closed and returns
+			r.Close()
+			close(hangup)
+		})
+		defer stop()
+	}
+	var err error
+	for b != nil {
+		if !b.wait(hangup) {
+			return timeoutBlocked(r, b)
+		}
+		if b, err = retry(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//timeoutBlocked unblocks a client whose keys were not modified in time and replies null
+func timeoutBlocked(r protocol.RedisRW, b *blockState) error {
+	var shardBuf [4]int
+	shards := store.ShardsOf(shardBuf[:0], b.keys)
+	execMu.RLock()
+	store.LockShards(shards)
+	c, ok := clients[r]
+	ok = ok && c.blocked == b
+	if ok {
+		unblock(c)
+	}
+	store.UnlockShards(shards)
+	execMu.RUnlock()
+	if !ok {
+		return nil
+	}
+	return r.WriteResp(protocol.NewNilArray())
+}
+
+//Blocked is a command waiting for one of its keys to be modified, like BZPOPMIN on empty sorted sets.
+//The next commands of the connection must not be executed until Wait returns.
+type Blocked struct {
+	r     protocol.RedisRW
+	c     *RedisCmd
+	state *blockState
+}
+
+//Wait waits until the command is served or times out, it's executed again every time one of its keys is modified
+func (b *Blocked) Wait() error {
+	return serveBlocked(b.r, b.state, func() (*blockState, error) {
+		return invoke(b.r, b.c)
+	})
+}
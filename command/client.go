@@ -0,0 +1,332 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/medusar/lucas/protocol"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	nextClientID = int64(0)
+	clients      = make(map[protocol.RedisRW]*client)
+	clientsByID  = make(map[int64]*client)
+)
+
+//client holds the state of a connection.
+//It's changed by the commands of its own connection, one at a time,
+//or by commands running exclusively, like CLIENT KILL.
+type client struct {
+	id              int64
+	con             protocol.RedisRW
+	name            string
+	createdAt       time.Time
+	lastInteraction time.Time
+	lastCmd         string
+
+	//user the client acts as, and whether it passed the authentication
+	user          *aclUser
+	authenticated bool
+
+	//channels the client subscribed to
+	channels map[string]struct{}
+
+	//https://redis.io/topics/client-side-caching
+	tracking bool
+	bcast    bool
+	optin    bool
+	optout   bool
+	noloop   bool
+	//caching is set by CLIENT CACHING and only affects the next command
+	caching  bool
+	redirect int64
+	prefixes []string
+
+	//blocked is the command the client waits for, like BZPOPMIN on empty keys
+	blocked *blockState
+	//retried is the state of the blocked command while it's executed again, it's nil on the first execution
+	retried *blockState
+
+	//pushes queues the pub/sub and invalidation messages sent by the commands of other clients
+	pushes *pushQueue
+}
+
+func newClient(con protocol.RedisRW) *client {
+	nextClientID++
+	now := time.Now()
+	return &client{id: nextClientID, con: con, createdAt: now, lastInteraction: now, channels: make(map[string]struct{}),
+		user: defaultUser, authenticated: defaultUser.nopass && defaultUser.enabled}
+}
+
+//Connect registers a new connection, it should be called before any command of the connection is executed
+func Connect(r protocol.RedisRW) {
+	runExclusive(func() {
+		clientOf(r)
+	})
+}
+
+//Disconnect releases everything held by the connection once it is closed
+func Disconnect(r protocol.RedisRW) {
+	runExclusive(func() {
+		c, ok := clients[r]
+		if !ok {
+			return
+		}
+		if b := c.blocked; b != nil {
+			//the command waiting for the keys sees the connection is closed
+			unblock(c)
+			b.signal()
+		}
+		stopMonitor(c)
+		unsubscribeAll(c)
+		disableTracking(c)
+		if c.pushes != nil {
+			c.pushes.stop()
+		}
+		delete(clients, r)
+		delete(clientsByID, c.id)
+	})
+}
+
+//clientOf returns the client of a connection, it registers the connection if it's unknown
+func clientOf(r protocol.RedisRW) *client {
+	c, ok := clients[r]
+	if !ok {
+		c = newClient(r)
+		clients[r] = c
+		clientsByID[c.id] = c
+	}
+	return c
+}
+
+//isUnixSocket reports whether the client is connected through a unix socket
+func (c *client) isUnixSocket() bool {
+	return c.con.LocalAddr().Network() == "unix"
+}
+
+//addrs returns the remote and local address shown by CLIENT LIST,
+//for unix socket clients both are the path of the socket like redis does.
+func (c *client) addrs() (string, string) {
+	if c.isUnixSocket() {
+		addr := c.con.LocalAddr().String() + ":0"
+		return addr, addr
+	}
+	return c.con.RemoteAddr().String(), c.con.LocalAddr().String()
+}
+
+func (c *client) flags() string {
+	var flags bytes.Buffer
+	if c.isUnixSocket() {
+		flags.WriteByte('U')
+	}
+	if monitorOf(c) != nil {
+		flags.WriteByte('O')
+	}
+	if c.blocked != nil {
+		flags.WriteByte('b')
+	}
+	if c.inPubSubContext() {
+		flags.WriteByte('P')
+	}
+	if c.tracking {
+		flags.WriteByte('t')
+		if c.bcast {
+			flags.WriteByte('B')
+		}
+		if c.redirect != 0 && clientsByID[c.redirect] == nil {
+			flags.WriteByte('R')
+		}
+	}
+	if flags.Len() == 0 {
+		flags.WriteByte('N')
+	}
+	return flags.String()
+}
+
+func (c *client) info() string {
+	now := time.Now()
+	addr, laddr := c.addrs()
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=0 sub=%d cmd=%s user=%s resp=%d",
+		c.id, addr, laddr, c.name,
+		int(now.Sub(c.createdAt).Seconds()), int(now.Sub(c.lastInteraction).Seconds()),
+		c.flags(), len(c.channels), c.lastCmd, c.user.name, c.con.Protocol())
+}
+
+//https://redis.io/commands/client-list
+//https://redis.io/commands/client-tracking
+var clientFunc = func(args []string, r protocol.RedisRW) error {
+	c := clientOf(r)
+	sub := strings.ToLower(args[0])
+	switch {
+	case sub == "id" && len(args) == 1:
+		return r.WriteInteger(int(c.id))
+	case sub == "list" && len(args) == 1:
+		var buf bytes.Buffer
+		for _, cl := range clients {
+			buf.WriteString(cl.info())
+			buf.WriteByte('\n')
+		}
+		return r.WriteBulk(buf.String())
+	case sub == "setname" && len(args) == 2:
+		if strings.ContainsAny(args[1], " \n") {
+			return r.WriteError("ERR Client names cannot contain spaces, newlines or special characters.")
+		}
+		c.name = args[1]
+		return r.WriteString("OK")
+	case sub == "getname" && len(args) == 1:
+		if c.name == "" {
+			return r.WriteNil()
+		}
+		return r.WriteBulk(c.name)
+	case sub == "tracking" && len(args) >= 2:
+		return clientTracking(c, args[1:], r)
+	case sub == "caching" && len(args) == 2:
+		return clientCaching(c, args[1], r)
+	case sub == "getredir" && len(args) == 1:
+		if !c.tracking {
+			return r.WriteInteger(-1)
+		}
+		return r.WriteInteger(int(c.redirect))
+	case sub == "trackinginfo" && len(args) == 1:
+		return clientTrackingInfo(c, r)
+	}
+	return r.WriteError(fmt.Sprintf("ERR Unknown subcommand or wrong number of arguments for '%s'. Try CLIENT HELP.", args[0]))
+}
+
+//CLIENT TRACKING ON|OFF [REDIRECT client-id] [PREFIX prefix [PREFIX prefix ...]] [BCAST] [OPTIN] [OPTOUT] [NOLOOP]
+func clientTracking(c *client, args []string, r protocol.RedisRW) error {
+	var (
+		redirect                   int64
+		bcast, optin, optout, loop bool
+		prefixes                   []string
+	)
+	loop = true
+	for i := 1; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "redirect":
+			if i+1 >= len(args) {
+				return r.WriteError("ERR syntax error")
+			}
+			if redirect != 0 {
+				return r.WriteError("ERR A client can only redirect to a single other client")
+			}
+			i++
+			id, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return r.WriteError("ERR value is not an integer or out of range")
+			}
+			if clientsByID[id] == nil {
+				return r.WriteError("ERR The client ID you want redirect to does not exist")
+			}
+			redirect = id
+		case "prefix":
+			if i+1 >= len(args) {
+				return r.WriteError("ERR syntax error")
+			}
+			i++
+			prefixes = append(prefixes, args[i])
+		case "bcast":
+			bcast = true
+		case "optin":
+			optin = true
+		case "optout":
+			optout = true
+		case "noloop":
+			loop = false
+		default:
+			return r.WriteError("ERR syntax error")
+		}
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "on":
+		if !bcast && len(prefixes) > 0 {
+			return r.WriteError("ERR PREFIX option requires BCAST mode to be enabled")
+		}
+		if c.tracking && c.bcast != bcast {
+			return r.WriteError("ERR You can't switch BCAST mode on/off before disabling tracking for this client, and then re-enabling it with a different mode.")
+		}
+		if optin && optout {
+			return r.WriteError("ERR You can't use both OPTIN and OPTOUT.")
+		}
+		if (optin && c.optout) || (optout && c.optin) {
+			return r.WriteError("ERR You can't switch OPTIN/OPTOUT mode before disabling tracking for this client, and then re-enabling it with a different mode.")
+		}
+		if bcast && (optin || optout) {
+			return r.WriteError("ERR OPTIN and OPTOUT are not compatible with BCAST")
+		}
+		if err := checkPrefixCollisions(c, prefixes); err != nil {
+			return r.WriteError(err.Error())
+		}
+		enableTracking(c, redirect, bcast, optin, optout, !loop, prefixes)
+	case "off":
+		disableTracking(c)
+	default:
+		return r.WriteError("ERR syntax error")
+	}
+	return r.WriteString("OK")
+}
+
+//CLIENT CACHING YES|NO
+func clientCaching(c *client, arg string, r protocol.RedisRW) error {
+	if !c.tracking || !(c.optin || c.optout) {
+		return r.WriteError("ERR CLIENT CACHING can be called only when the client is in tracking mode with OPTIN or OPTOUT mode enabled")
+	}
+	switch strings.ToLower(arg) {
+	case "yes":
+		if !c.optin {
+			return r.WriteError("ERR CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode.")
+		}
+	case "no":
+		if !c.optout {
+			return r.WriteError("ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.")
+		}
+	default:
+		return r.WriteError("ERR syntax error")
+	}
+	c.caching = true
+	return r.WriteString("OK")
+}
+
+func clientTrackingInfo(c *client, r protocol.RedisRW) error {
+	var flags []string
+	if !c.tracking {
+		flags = append(flags, "off")
+	} else {
+		flags = append(flags, "on")
+		if c.bcast {
+			flags = append(flags, "bcast")
+		}
+		if c.optin {
+			flags = append(flags, "optin")
+			if c.caching {
+				flags = append(flags, "caching-yes")
+			}
+		}
+		if c.optout {
+			flags = append(flags, "optout")
+			if c.caching {
+				flags = append(flags, "caching-no")
+			}
+		}
+		if c.noloop {
+			flags = append(flags, "noloop")
+		}
+		if c.redirect != 0 && clientsByID[c.redirect] == nil {
+			flags = append(flags, "broken_redirect")
+		}
+	}
+
+	redirect := -1
+	if c.tracking {
+		redirect = int(c.redirect)
+	}
+
+	return r.WriteMap([]*protocol.Resp{
+		protocol.NewBulk("flags"), protocol.NewSet(toBulkArray(flags)),
+		protocol.NewBulk("redirect"), protocol.NewInteger(redirect),
+		protocol.NewBulk("prefixes"), protocol.NewArray(toBulkArray(c.prefixes)),
+	})
+}
@@ -0,0 +1,215 @@
+package command
+
+import (
+	"fmt"
+	"github.com/mb0/glob"
+	"github.com/medusar/lucas/protocol"
+	"strings"
+)
+
+func toStatusArray(val []string) []*protocol.Resp {
+	ret := make([]*protocol.Resp, len(val))
+	for i, v := range val {
+		ret[i] = protocol.NewString(v)
+	}
+	return ret
+}
+
+//keySpecFlags describes how the command accesses its keys, see https://redis.io/topics/key-specs
+func (info *RedisCmdInfo) keySpecFlags() []string {
+	if info.hasFlag("write") {
+		return []string{"RW", "update"}
+	}
+	return []string{"RO", "access"}
+}
+
+//keySpecs converts FirstKey, LastKey and Step to a key spec, and KeyNum to another one
+func (info *RedisCmdInfo) keySpecs() []*protocol.Resp {
+	var specs []*protocol.Resp
+	if info.FirstKey > 0 {
+		lastKey := info.LastKey
+		if lastKey > 0 {
+			lastKey -= info.FirstKey
+		}
+		specs = append(specs, info.keySpec(info.FirstKey, protocol.NewBulk("range"), []*protocol.Resp{
+			protocol.NewBulk("lastkey"), protocol.NewInteger(lastKey),
+			protocol.NewBulk("keystep"), protocol.NewInteger(info.Step),
+			protocol.NewBulk("limit"), protocol.NewInteger(0),
+		}))
+	}
+	if info.KeyNum > 0 {
+		specs = append(specs, info.keySpec(info.KeyNum, protocol.NewBulk("keynum"), []*protocol.Resp{
+			protocol.NewBulk("keynumidx"), protocol.NewInteger(0),
+			protocol.NewBulk("firstkey"), protocol.NewInteger(1),
+			protocol.NewBulk("keystep"), protocol.NewInteger(1),
+		}))
+	}
+	return specs
+}
+
+func (info *RedisCmdInfo) keySpec(index int, findType *protocol.Resp, find []*protocol.Resp) *protocol.Resp {
+	return protocol.NewMap([]*protocol.Resp{
+		protocol.NewBulk("flags"), protocol.NewSet(toStatusArray(info.keySpecFlags())),
+		protocol.NewBulk("begin_search"), protocol.NewMap([]*protocol.Resp{
+			protocol.NewBulk("type"), protocol.NewBulk("index"),
+			protocol.NewBulk("spec"), protocol.NewMap([]*protocol.Resp{
+				protocol.NewBulk("index"), protocol.NewInteger(index),
+			}),
+		}),
+		protocol.NewBulk("find_keys"), protocol.NewMap([]*protocol.Resp{
+			protocol.NewBulk("type"), findType,
+			protocol.NewBulk("spec"), protocol.NewMap(find),
+		}),
+	})
+}
+
+//reply is the entry of the command in the reply of COMMAND INFO
+func (info *RedisCmdInfo) reply() *protocol.Resp {
+	categories := info.categories()
+	for i, c := range categories {
+		categories[i] = "@" + c
+	}
+	return protocol.NewArray([]*protocol.Resp{
+		protocol.NewBulk(info.Name),
+		protocol.NewInteger(info.Arity),
+		protocol.NewSet(toStatusArray(info.Flags)),
+		protocol.NewInteger(info.FirstKey),
+		protocol.NewInteger(info.LastKey),
+		protocol.NewInteger(info.Step),
+		protocol.NewSet(toStatusArray(categories)),
+		protocol.NewArray(nil),
+		protocol.NewArray(info.keySpecs()),
+		protocol.NewArray(nil),
+	})
+}
+
+//docs is the entry of the command in the reply of COMMAND DOCS
+func (info *RedisCmdInfo) docs() *protocol.Resp {
+	docs := []*protocol.Resp{
+		protocol.NewBulk("summary"), protocol.NewBulk(info.Summary),
+		protocol.NewBulk("since"), protocol.NewBulk(info.Since),
+		protocol.NewBulk("group"), protocol.NewBulk(info.Group),
+	}
+	if info.Complexity != "" {
+		docs = append(docs, protocol.NewBulk("complexity"), protocol.NewBulk(info.Complexity))
+	}
+	return protocol.NewMap(docs)
+}
+
+//https://redis.io/commands/command
+var commandFunc = func(args []string, r protocol.RedisRW) error {
+	if len(args) == 0 {
+		return commandInfo(nil, r)
+	}
+
+	sub := strings.ToLower(args[0])
+	switch {
+	case sub == "count" && len(args) == 1:
+		return r.WriteInteger(len(cmdInfoTable))
+	case sub == "info":
+		return commandInfo(args[1:], r)
+	case sub == "docs":
+		var reply []*protocol.Resp
+		for _, info := range cmdInfoTable {
+			if len(args) > 1 && !containsFold(args[1:], info.Name) {
+				continue
+			}
+			reply = append(reply, protocol.NewBulk(info.Name), info.docs())
+		}
+		return r.WriteMap(reply)
+	case sub == "list" && (len(args) == 1 || len(args) == 4):
+		return commandList(args[1:], r)
+	case sub == "getkeys" && len(args) >= 2:
+		return commandGetKeys(args[1:], r)
+	}
+	return r.WriteError(fmt.Sprintf("ERR Unknown subcommand or wrong number of arguments for '%s'. Try COMMAND HELP.", args[0]))
+}
+
+//COMMAND INFO [command-name [command-name ...]], all the commands are returned if no name is given
+func commandInfo(names []string, r protocol.RedisRW) error {
+	if len(names) == 0 {
+		reply := make([]*protocol.Resp, len(cmdInfoTable))
+		for i, info := range cmdInfoTable {
+			reply[i] = info.reply()
+		}
+		return r.WriteArray(reply)
+	}
+	reply := make([]*protocol.Resp, len(names))
+	for i, name := range names {
+		if info, ok := cmdInfoMap[strings.ToLower(name)]; ok {
+			reply[i] = info.reply()
+		} else {
+			reply[i] = protocol.NewNil()
+		}
+	}
+	return r.WriteArray(reply)
+}
+
+func containsFold(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+//COMMAND LIST [FILTERBY MODULE module-name | ACLCAT category | PATTERN pattern]
+func commandList(args []string, r protocol.RedisRW) error {
+	match := func(info *RedisCmdInfo) bool {
+		return true
+	}
+	if len(args) > 0 {
+		if strings.ToLower(args[0]) != "filterby" {
+			return r.WriteError("ERR syntax error")
+		}
+		filter := args[2]
+		switch strings.ToLower(args[1]) {
+		case "module":
+			//there is no module, no command is returned
+			match = func(info *RedisCmdInfo) bool {
+				return false
+			}
+		case "aclcat":
+			match = func(info *RedisCmdInfo) bool {
+				for _, c := range info.categories() {
+					if strings.EqualFold(c, filter) {
+						return true
+					}
+				}
+				return false
+			}
+		case "pattern":
+			match = func(info *RedisCmdInfo) bool {
+				ok, err := glob.Match(strings.ToLower(filter), info.Name)
+				return err == nil && ok
+			}
+		default:
+			return r.WriteError("ERR syntax error")
+		}
+	}
+
+	var names []string
+	for _, info := range cmdInfoTable {
+		if match(info) {
+			names = append(names, info.Name)
+		}
+	}
+	return r.WriteArray(toBulkArray(names))
+}
+
+//COMMAND GETKEYS command [arg [arg ...]]
+func commandGetKeys(args []string, r protocol.RedisRW) error {
+	info, ok := cmdInfoMap[strings.ToLower(args[0])]
+	if !ok {
+		return r.WriteError("ERR Invalid command specified")
+	}
+	if (info.Arity > 0 && len(args) != info.Arity) || len(args) < -info.Arity {
+		return r.WriteError("ERR Invalid number of arguments specified for command")
+	}
+	keys := info.keys(args[1:])
+	if len(keys) == 0 {
+		return r.WriteError("ERR The command has no key arguments")
+	}
+	return r.WriteArray(toBulkArray(keys))
+}
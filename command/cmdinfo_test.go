@@ -0,0 +1,60 @@
+package command
+
+import (
+	"github.com/medusar/lucas/protocol"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCommandInfo(t *testing.T) {
+	c := newTestClient(t)
+	getKeySpec := strings.Join([]string{"*6",
+		"$5", "flags", "*2", "+RO", "+access",
+		"$12", "begin_search", "*4", "$4", "type", "$5", "index", "$4", "spec", "*2", "$5", "index", ":1",
+		"$9", "find_keys", "*4", "$4", "type", "$5", "range", "$4", "spec", "*6", "$7", "lastkey", ":0", "$7", "keystep", ":1", "$5", "limit", ":0",
+	}, "\r\n")
+	get := strings.Join([]string{"*10", "$3", "get", ":2", "*2", "+readonly", "+fast", ":1", ":1", ":1",
+		"*3", "+@string", "+@read", "+@fast", "*0", "*1", getKeySpec, "*0", ""}, "\r\n")
+	assert.Equal(t, "*2\r\n"+get+"$-1\r\n", c.do("command", "info", "GET", "nosuch"))
+
+	assert.Equal(t, ":"+strconv.Itoa(len(cmdInfoTable))+"\r\n", c.do("command", "count"))
+	assert.True(t, strings.HasPrefix(c.do("command"), "*"+strconv.Itoa(len(cmdInfoTable))+"\r\n"))
+}
+
+func Test_RedisCmdInfo_keySpecs(t *testing.T) {
+	//ZUNIONSTORE has a destination key and numkeys source keys
+	specs := cmdInfoMap["zunionstore"].keySpecs()
+	if assert.Equal(t, 2, len(specs)) {
+		find := func(spec *protocol.Resp) []*protocol.Resp {
+			return spec.Val.([]*protocol.Resp)[5].Val.([]*protocol.Resp)
+		}
+		assert.Equal(t, "range", find(specs[0])[1].Val)
+		assert.Equal(t, "keynum", find(specs[1])[1].Val)
+	}
+	assert.Empty(t, cmdInfoMap["ping"].keySpecs())
+}
+
+func TestCommandGetKeys(t *testing.T) {
+	c := newTestClient(t)
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"one key", []string{"get", "k"}, "*1\r\n$1\r\nk\r\n"},
+		{"key step", []string{"MSET", "a", "1", "b", "2"}, "*2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{"numkeys", []string{"zunion", "2", "a", "b", "withscores"}, "*2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{"destination and numkeys", []string{"zunionstore", "dst", "2", "a", "b"}, "*3\r\n$3\r\ndst\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{"unknown command", []string{"nosuch", "k"}, "-ERR Invalid command specified\r\n"},
+		{"wrong arity", []string{"get", "a", "b"}, "-ERR Invalid number of arguments specified for command\r\n"},
+		{"too few arguments", []string{"mset", "a"}, "-ERR Invalid number of arguments specified for command\r\n"},
+		{"no key", []string{"ping"}, "-ERR The command has no key arguments\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, c.do(append([]string{"command", "getkeys"}, tt.args...)...))
+		})
+	}
+}
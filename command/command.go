@@ -4,15 +4,19 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/medusar/lucas/protocol"
+	"github.com/medusar/lucas/store"
+	"github.com/medusar/lucas/util"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 var (
-	GetInfo = &RedisCmdInfo{Name: "get", Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1}
-	SetInfo = &RedisCmdInfo{"set", -3, []string{"write", "denyoom"}, 1, 1, 1}
-	//TODO
-	invokerChan = make(chan *invoker, 1024*1024)
+	GetInfo = &RedisCmdInfo{Name: "get", Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "string",
+		Summary: "Returns the string value of a key.", Since: "1.0.0", Complexity: "O(1)"}
+	SetInfo = &RedisCmdInfo{Name: "set", Arity: -3, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "string",
+		Summary: "Sets the string value of a key, ignoring its type. The key is created if it doesn't exist.", Since: "1.0.0", Complexity: "O(1)"}
 )
 
 //https://redis.io/commands/command
@@ -23,6 +27,65 @@ type RedisCmdInfo struct {
 	FirstKey int
 	LastKey  int
 	Step     int
+	//KeyNum is the index of the argument giving the number of keys which follow it, like numkeys of ZMPOP.
+	//These keys come after the ones of FirstKey, LastKey and Step.
+	KeyNum int
+	//Group is the kind of data or feature the command belongs to, such as string, hash or connection
+	Group string
+
+	//documentation returned by COMMAND DOCS
+	Summary    string
+	Since      string
+	Complexity string
+}
+
+func (info *RedisCmdInfo) hasFlag(flag string) bool {
+	if info == nil {
+		return false
+	}
+	for _, f := range info.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+//keys returns the arguments which are keys according to FirstKey, LastKey and Step,
+//args should not contain the command name.
+func (info *RedisCmdInfo) keys(args []string) []string {
+	return info.appendKeys(nil, args)
+}
+
+//appendKeys appends the keys of the arguments to dst, like keys
+func (info *RedisCmdInfo) appendKeys(dst []string, args []string) []string {
+	if info.FirstKey > 0 && info.Step > 0 {
+		last := info.LastKey
+		if last < 0 {
+			last = len(args) + 1 + last
+		}
+		if last > len(args) {
+			last = len(args)
+		}
+		for i := info.FirstKey; i <= last; i += info.Step {
+			dst = append(dst, args[i-1])
+		}
+	}
+	if info.KeyNum > 0 && info.KeyNum <= len(args) {
+		n, err := strconv.Atoi(args[info.KeyNum-1])
+		if err != nil || n <= 0 {
+			return dst
+		}
+		for i := info.KeyNum + 1; i <= info.KeyNum+n && i <= len(args); i++ {
+			dst = append(dst, args[i-1])
+		}
+	}
+	return dst
+}
+
+//hasKeys reports whether the command accesses keys
+func (info *RedisCmdInfo) hasKeys() bool {
+	return info.FirstKey > 0 || info.KeyNum > 0
 }
 
 type RedisCmd struct {
@@ -30,36 +93,86 @@ type RedisCmd struct {
 	Args []string
 }
 
-type invoker struct {
-	rc  *RedisCmd
-	con protocol.RedisRW
-}
-
 type cmdFunc func(args []string, r protocol.RedisRW) error
 
 var (
 	cmdFuncMap = make(map[string]cmdFunc)
+	cmdInfoMap = indexCmdInfo()
+	//cmdPool recycles the commands, a command is released once executed
+	cmdPool = sync.Pool{New: func() interface{} {
+		return new(RedisCmd)
+	}}
 )
 
-var commandFunc = func(args []string, r protocol.RedisRW) error {
-	return r.WriteString("OK") //TODO: implement COMMAND
+//lookupCmd finds the info of a command by its name in any case, without allocating for names of usual length
+func lookupCmd(name string) (*RedisCmdInfo, bool) {
+	var buf [32]byte
+	if len(name) > len(buf) {
+		info, ok := cmdInfoMap[strings.ToLower(name)]
+		return info, ok
+	}
+	lower := buf[:len(name)]
+	for i := 0; i < len(name); i++ {
+		b := name[i]
+		if 'A' <= b && b <= 'Z' {
+			b += 'a' - 'A'
+		}
+		lower[i] = b
+	}
+	//the conversion doesn't allocate when it's only used as the key of a lookup
+	info, ok := cmdInfoMap[string(lower)]
+	return info, ok
+}
+
+//indexCmdInfo maps the name of every command in cmdInfoTable to its info,
+//it's used as an initializer so that users created at package init see every command.
+func indexCmdInfo() map[string]*RedisCmdInfo {
+	m := make(map[string]*RedisCmdInfo, len(cmdInfoTable))
+	for _, info := range cmdInfoTable {
+		m[info.Name] = info
+	}
+	return m
 }
 
 func init() {
+	store.OnKeyModified(trackingInvalidateKey)
+	store.OnKeyModified(signalKeyReady)
+
 	cmdFuncMap["command"] = WithTime(commandFunc)
 
 	//connection
 	cmdFuncMap["ping"] = pingFunc
 	//quit for telnet
 	cmdFuncMap["quit"] = quitFunc
+	cmdFuncMap["hello"] = helloFunc
+	cmdFuncMap["client"] = clientFunc
+	cmdFuncMap["auth"] = authFunc
+
+	//server
+	cmdFuncMap["acl"] = aclFunc
+	cmdFuncMap["monitor"] = monitorFunc
+	cmdFuncMap["latency"] = latencyFunc
+
+	//pubsub
+	cmdFuncMap["subscribe"] = subscribeFunc
+	cmdFuncMap["unsubscribe"] = unsubscribeFunc
+	cmdFuncMap["publish"] = WithTime(publishFunc)
 
 	//keys
 	cmdFuncMap["ttl"] = WithTime(ttlFunc)
 	cmdFuncMap["expire"] = WithTime(expireFunc)
+	cmdFuncMap["expireat"] = WithTime(expireAtFunc)
 	cmdFuncMap["keys"] = WithTime(keysFunc)
 	cmdFuncMap["exists"] = WithTime(existsFunc)
 	cmdFuncMap["del"] = WithTime(delFunc)
 	cmdFuncMap["type"] = WithTime(typeFunc)
+	cmdFuncMap["object"] = WithTime(objectFunc)
+	cmdFuncMap["unlink"] = WithTime(unlinkFunc)
+	cmdFuncMap["touch"] = WithTime(touchFunc)
+	cmdFuncMap["rename"] = WithTime(renameFunc)
+	cmdFuncMap["renamenx"] = WithTime(renamenxFunc)
+	cmdFuncMap["copy"] = WithTime(copyFunc)
+	cmdFuncMap["randomkey"] = WithTime(randomkeyFunc)
 
 	//string
 	cmdFuncMap["get"] = WithTime(getFunc)
@@ -67,7 +180,7 @@ func init() {
 	cmdFuncMap["getset"] = WithTime(getsetFunc)
 	cmdFuncMap["setex"] = WithTime(setexFunc)
 	cmdFuncMap["setnx"] = WithTime(setnxFunc)
-	cmdFuncMap["meget"] = WithTime(mgetFunc)
+	cmdFuncMap["mget"] = WithTime(mgetFunc)
 	cmdFuncMap["mset"] = WithTime(msetFunc)
 	cmdFuncMap["strlen"] = WithTime(strlenFunc)
 	cmdFuncMap["incr"] = WithTime(incrFunc)
@@ -80,6 +193,11 @@ func init() {
 	cmdFuncMap["setbit"] = WithTime(setbitFunc)
 	cmdFuncMap["getbit"] = WithTime(getbitFunc)
 	cmdFuncMap["bitcount"] = WithTime(bitcountFunc)
+	cmdFuncMap["getex"] = WithTime(getexFunc)
+	cmdFuncMap["getdel"] = WithTime(getdelFunc)
+	cmdFuncMap["incrbyfloat"] = WithTime(incrByFloatFunc)
+	cmdFuncMap["msetnx"] = WithTime(msetnxFunc)
+	cmdFuncMap["lcs"] = WithTime(lcsFunc)
 
 	//hash
 	cmdFuncMap["hset"] = WithTime(hsetFunc)
@@ -96,8 +214,19 @@ func init() {
 	cmdFuncMap["hvals"] = WithTime(hvalsFunc)
 	cmdFuncMap["hincrby"] = WithTime(hincrByFunc)
 	cmdFuncMap["hincrbyfloat"] = hincrByFloatFunc
-	//HSCAN
-	//cmdFuncMap["hscan"] = WithTime(hcanFunc)
+	cmdFuncMap["hscan"] = WithTime(hscanFunc)
+	cmdFuncMap["hrandfield"] = WithTime(hrandfieldFunc)
+	cmdFuncMap["hgetdel"] = WithTime(hgetdelFunc)
+	cmdFuncMap["hgetex"] = WithTime(hgetexFunc)
+	cmdFuncMap["hexpire"] = WithTime(hexpireFunc)
+	cmdFuncMap["hpexpire"] = WithTime(hpexpireFunc)
+	cmdFuncMap["hexpireat"] = WithTime(hexpireatFunc)
+	cmdFuncMap["hpexpireat"] = WithTime(hpexpireatFunc)
+	cmdFuncMap["httl"] = WithTime(httlFunc)
+	cmdFuncMap["hpttl"] = WithTime(hpttlFunc)
+	cmdFuncMap["hexpiretime"] = WithTime(hexpiretimeFunc)
+	cmdFuncMap["hpexpiretime"] = WithTime(hpexpiretimeFunc)
+	cmdFuncMap["hpersist"] = WithTime(hpersistFunc)
 
 	//set
 	cmdFuncMap["sadd"] = WithTime(saddFunc)
@@ -110,6 +239,9 @@ func init() {
 	cmdFuncMap["smembers"] = WithTime(smembersFunc)
 	cmdFuncMap["smove"] = WithTime(smoveFunc)
 	cmdFuncMap["spop"] = WithTime(spopFunc)
+	cmdFuncMap["srandmember"] = WithTime(srandmemberFunc)
+	cmdFuncMap["smismember"] = WithTime(smismemberFunc)
+	cmdFuncMap["sintercard"] = WithTime(sintercardFunc)
 	cmdFuncMap["srem"] = WithTime(sremFunc)
 	cmdFuncMap["sunion"] = WithTime(sunionFunc)
 	cmdFuncMap["sunionstore"] = WithTime(sunionStoreFunc)
@@ -126,6 +258,12 @@ func init() {
 	cmdFuncMap["rpushx"] = WithTime(rpushXFunc)
 	cmdFuncMap["lpushx"] = WithTime(lpushXFunc)
 	cmdFuncMap["lrange"] = WithTime(lrangeFunc)
+	cmdFuncMap["linsert"] = WithTime(linsertFunc)
+	cmdFuncMap["ltrim"] = WithTime(ltrimFunc)
+	cmdFuncMap["lpos"] = WithTime(lposFunc)
+	cmdFuncMap["lmove"] = WithTime(lmoveFunc)
+	cmdFuncMap["rpoplpush"] = WithTime(rpoplpushFunc)
+	cmdFuncMap["lmpop"] = WithTime(lmpopFunc)
 
 	//zset
 	cmdFuncMap["zadd"] = WithTime(zaddFunc)
@@ -137,28 +275,42 @@ func init() {
 	cmdFuncMap["zrem"] = WithTime(zremFunc)
 	cmdFuncMap["zscore"] = WithTime(zscoreFunc)
 	cmdFuncMap["zrevrank"] = WithTime(zrevrankFunc)
-}
+	cmdFuncMap["zrangestore"] = WithTime(zrangestoreFunc)
+	cmdFuncMap["zrevrange"] = WithTime(zrevrangeFunc)
+	cmdFuncMap["zrevrangebyscore"] = WithTime(zrevrangeByScoreFunc)
+	cmdFuncMap["zrangebylex"] = WithTime(zrangeByLexFunc)
+	cmdFuncMap["zrevrangebylex"] = WithTime(zrevrangeByLexFunc)
+	cmdFuncMap["zlexcount"] = WithTime(zlexcountFunc)
+	cmdFuncMap["zincrby"] = WithTime(zincrbyFunc)
+	cmdFuncMap["zremrangebyrank"] = WithTime(zremrangebyrankFunc)
+	cmdFuncMap["zremrangebyscore"] = WithTime(zremrangebyscoreFunc)
+	cmdFuncMap["zremrangebylex"] = WithTime(zremrangebylexFunc)
+	cmdFuncMap["zpopmin"] = WithTime(zpopminFunc)
+	cmdFuncMap["zpopmax"] = WithTime(zpopmaxFunc)
+	cmdFuncMap["bzpopmin"] = WithTime(bzpopminFunc)
+	cmdFuncMap["bzpopmax"] = WithTime(bzpopmaxFunc)
+	cmdFuncMap["zmpop"] = WithTime(zmpopFunc)
+	cmdFuncMap["bzmpop"] = WithTime(bzmpopFunc)
+	cmdFuncMap["zmscore"] = WithTime(zmscoreFunc)
+	cmdFuncMap["zrandmember"] = WithTime(zrandmemberFunc)
+	cmdFuncMap["zunion"] = WithTime(zunionFunc)
+	cmdFuncMap["zunionstore"] = WithTime(zunionstoreFunc)
+	cmdFuncMap["zinter"] = WithTime(zinterFunc)
+	cmdFuncMap["zinterstore"] = WithTime(zinterstoreFunc)
+	cmdFuncMap["zdiff"] = WithTime(zdiffFunc)
+	cmdFuncMap["zdiffstore"] = WithTime(zdiffstoreFunc)
+	cmdFuncMap["zintercard"] = WithTime(zintercardFunc)
 
-func LoopAndInvoke() {
-	for in := range invokerChan {
-		if in.con.IsClosed() {
-			continue
-		}
-		if err := execCmd(in.con, in.rc); err != nil {
-			in.con.Close()
+	//execCmd validates the arguments with the table, every command must be described there
+	for name := range cmdFuncMap {
+		if _, ok := cmdInfoMap[name]; !ok {
+			panic(fmt.Sprintf("command '%s' is missing in cmdInfoTable", name))
 		}
 	}
 }
 
-func Execute(r protocol.RedisRW, c *RedisCmd) error {
-	select {
-	case invokerChan <- &invoker{rc: c, con: r}:
-		return nil
-	case <-time.After(time.Millisecond * 100):
-		return fmt.Errorf("server too busy")
-	}
-}
-
+//ParseRequest builds a command from a request without copying it, Name and Args are views of reqs.
+//The command is taken from a pool and should be given back with Release once executed.
 func ParseRequest(reqs []string) (*RedisCmd, error) {
 	l := len(reqs)
 	if l == 0 {
@@ -170,33 +322,87 @@ func ParseRequest(reqs []string) (*RedisCmd, error) {
 		return nil, fmt.Errorf("illegal command, name is empty")
 	}
 
-	if l > 1 {
-		args := make([]string, 0)
-		for i := 1; i < l; i++ {
-			args = append(args, reqs[i])
-		}
-		return &RedisCmd{Name: name, Args: args}, nil
-	}
+	c := cmdPool.Get().(*RedisCmd)
+	c.Name, c.Args = name, reqs[1:]
+	return c, nil
+}
 
-	return &RedisCmd{Name: name}, nil
+//Release puts the command back in the pool, it must not be used anymore
+func (c *RedisCmd) Release() {
+	c.Name, c.Args = "", nil
+	cmdPool.Put(c)
 }
 
 func execCmd(r protocol.RedisRW, c *RedisCmd) error {
-	name := strings.ToLower(c.Name)
-	f, ok := cmdFuncMap[name]
+	cl := clientOf(r)
+	cl.lastInteraction = time.Now()
+	cl.flushPushes()
+
+	info, ok := lookupCmd(c.Name)
+	var f cmdFunc
+	if ok {
+		f, ok = cmdFuncMap[info.Name]
+	}
 	if !ok {
+		//the name is a view into the buffer of the connection
+		cl.lastCmd = util.Clone(strings.ToLower(c.Name))
 		var buf bytes.Buffer
-		buf.WriteString(fmt.Sprintf("ERR unknown command `%s`, with args beginning with:", name))
-		args := c.Args
-		if args != nil {
-			for i := 0; i < len(args); i++ {
-				args[i] = fmt.Sprintf("`%s`", args[i])
-			}
-			buf.WriteString(strings.Join(args, ", "))
+		buf.WriteString(fmt.Sprintf("ERR unknown command `%s`, with args beginning with: ", c.Name))
+		for _, arg := range c.Args {
+			buf.WriteString(fmt.Sprintf("`%s`, ", arg))
 		}
 		return r.WriteError(buf.String())
 	}
-	return f(c.Args, r)
+	name := info.Name
+	cl.lastCmd = name
+	if !checkArity(info.Arity, len(c.Args)+1) {
+		return r.WriteError(fmt.Sprintf(errWrongArgsFmt, name))
+	}
+
+	if !cl.authenticated && !info.hasFlag("no_auth") {
+		return r.WriteError(errNoAuth.Error())
+	}
+	if !info.hasFlag("no_auth") {
+		if reason, object := cl.user.check(name, info, c.Args); reason != "" {
+			addACLLog(cl, reason, object, cl.user.name)
+			return r.WriteError(noPermError(reason, name))
+		}
+	}
+
+	if cl.inPubSubContext() && !allowedInPubSub[name] {
+		return r.WriteError(fmt.Sprintf("ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", name))
+	}
+
+	//the connection of a monitor is written by its own goroutine, the monitor can only quit
+	if m := monitorOf(cl); m != nil {
+		if name == "quit" {
+			stopMonitor(cl)
+			r.Close()
+			return nil
+		}
+		if !m.push(protocol.NewError(fmt.Sprintf("ERR Can't execute '%s': only QUIT is allowed in MONITOR mode", name))) {
+			stopMonitor(cl)
+		}
+		return nil
+	}
+
+	//a blocked command executed again was already fed to the monitors
+	if cl.retried == nil && !info.hasFlag("skip_monitor") && !info.hasFlag("admin") {
+		feedMonitors(cl, c.Name, c.Args)
+	}
+
+	err := f(c.Args, r)
+
+	if cl.tracking {
+		if info != nil && info.hasFlag("readonly") {
+			trackingRememberKeys(cl, info.keys(c.Args))
+		}
+		//CLIENT CACHING only affects the command executed right after it
+		if name != "client" {
+			cl.caching = false
+		}
+	}
+	return err
 }
 
 func toBulkArray(val []string) []*protocol.Resp {
@@ -206,3 +412,24 @@ func toBulkArray(val []string) []*protocol.Resp {
 	}
 	return r
 }
+
+//toNullableBulkArray converts the values to bulk strings, nil ones to nil bulk strings
+func toNullableBulkArray(val []*string) []*protocol.Resp {
+	r := make([]*protocol.Resp, len(val))
+	for i, v := range val {
+		if v == nil {
+			r[i] = protocol.NewNil()
+		} else {
+			r[i] = protocol.NewBulk(*v)
+		}
+	}
+	return r
+}
+
+func toIntegerArray(val []int) []*protocol.Resp {
+	r := make([]*protocol.Resp, len(val))
+	for i, v := range val {
+		r[i] = protocol.NewInteger(v)
+	}
+	return r
+}
@@ -0,0 +1,75 @@
+package command
+
+import (
+	"bytes"
+	"github.com/medusar/lucas/protocol"
+	"net"
+	"sync"
+	"testing"
+)
+
+//testConn is a connection keeping what the server writes to it,
+//it's written by the goroutine of the push queue too so it's guarded by a mutex
+type testConn struct {
+	net.Conn
+	mu  sync.Mutex
+	out bytes.Buffer
+}
+
+func (c *testConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.out.Write(p)
+}
+
+func (c *testConn) Close() error {
+	return nil
+}
+
+func (c *testConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000}
+}
+
+func (c *testConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 6380}
+}
+
+//take returns what was written since the previous call
+func (c *testConn) take() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.out.String()
+	c.out.Reset()
+	return s
+}
+
+//testClient runs commands like a connected client and returns the raw replies
+type testClient struct {
+	t   *testing.T
+	r   *protocol.BufRedisConn
+	con *testConn
+}
+
+//newTestClient connects a client, it's disconnected at the end of the test
+func newTestClient(t *testing.T) *testClient {
+	con := &testConn{}
+	r := protocol.NewBufRedisConn(con)
+	Connect(r)
+	t.Cleanup(func() {
+		Disconnect(r)
+	})
+	return &testClient{t: t, r: r, con: con}
+}
+
+//do executes a command and returns everything written to the connection since the previous command,
+//the messages pushed to the client are written before the reply
+func (c *testClient) do(args ...string) string {
+	if _, err := invoke(c.r, &RedisCmd{Name: args[0], Args: args[1:]}); err != nil {
+		c.t.Fatal(err)
+	}
+	return c.con.take()
+}
+
+func (c *testClient) id() int64 {
+	return clients[c.r].id
+}
@@ -1,6 +1,14 @@
 package command
 
-import "github.com/medusar/lucas/protocol"
+import (
+	"fmt"
+	"github.com/medusar/lucas/protocol"
+	"strconv"
+	"strings"
+)
+
+//serverVersion is the version of redis the server is compatible with
+const serverVersion = "6.0.0"
 
 var pingFunc = func(args []string, r protocol.RedisRW) error {
 	if len(args) != 0 && len(args) != 1 {
@@ -17,4 +25,61 @@ var quitFunc = func(args []string, r protocol.RedisRW) error {
 	r.WriteString("OK")
 	r.Close() //TODO: will cause an error in reading. should find a better way.
 	return nil
-}
\ No newline at end of file
+}
+
+//https://redis.io/commands/hello
+var helloFunc = func(args []string, r protocol.RedisRW) error {
+	c := clientOf(r)
+	proto := r.Protocol()
+	if len(args) > 0 {
+		v, err := strconv.Atoi(args[0])
+		if err != nil {
+			return r.WriteError("ERR Protocol version is not an integer or out of range")
+		}
+		if v < protocol.Resp2 || v > protocol.Resp3 {
+			return r.WriteError("NOPROTO unsupported protocol version")
+		}
+		proto = v
+	}
+
+	name := c.name
+	var username, password string
+	for i := 1; i < len(args); i++ {
+		opt := strings.ToLower(args[i])
+		if opt == "auth" && i+2 < len(args) {
+			username, password = args[i+1], args[i+2]
+			i += 2
+			continue
+		}
+		if opt == "setname" && i+1 < len(args) {
+			name = args[i+1]
+			if strings.ContainsAny(name, " \n") {
+				return r.WriteError("ERR Client names cannot contain spaces, newlines or special characters.")
+			}
+			i++
+			continue
+		}
+		return r.WriteError(fmt.Sprintf("ERR Syntax error in HELLO option '%s'", args[i]))
+	}
+
+	if username != "" {
+		if err := authenticate(c, username, password); err != nil {
+			return r.WriteError(err.Error())
+		}
+	}
+	if !c.authenticated {
+		return r.WriteError("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+	}
+
+	c.name = name
+	r.SetProtocol(proto)
+	return r.WriteMap([]*protocol.Resp{
+		protocol.NewBulk("server"), protocol.NewBulk("redis"),
+		protocol.NewBulk("version"), protocol.NewBulk(serverVersion),
+		protocol.NewBulk("proto"), protocol.NewInteger(proto),
+		protocol.NewBulk("id"), protocol.NewInteger(int(c.id)),
+		protocol.NewBulk("mode"), protocol.NewBulk("standalone"),
+		protocol.NewBulk("role"), protocol.NewBulk("master"),
+		protocol.NewBulk("modules"), protocol.NewArray(nil),
+	})
+}
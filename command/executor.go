@@ -0,0 +1,194 @@
+package command
+
+import (
+	"fmt"
+	"github.com/medusar/lucas/protocol"
+	"github.com/medusar/lucas/store"
+	"github.com/medusar/lucas/util"
+	"log"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//number of keys visited by the active expire cycle of every shard on every run
+const activeExpireSample = 200
+
+var (
+	//executors run the commands, one per shard of the keyspace
+	executors = newExecutors(store.ShardCount)
+	//execMu is held for reading by the commands which only access the shards they lock,
+	//and for writing by the commands that access the whole keyspace or the state shared by all the clients.
+	execMu sync.RWMutex
+	//shardClients is the client executing a command on every shard, NOLOOP tracking uses it to skip the client itself
+	shardClients = make([]*client, store.ShardCount)
+	//sharedKeyless are the commands without keys which run concurrently with the other commands
+	sharedKeyless = map[string]bool{"ping": true, "publish": true, "quit": true}
+	//nextKeyless spreads the commands without keys over the executors
+	nextKeyless uint32
+	//invokerPool recycles the invokers with their done channel
+	invokerPool = sync.Pool{New: func() interface{} {
+		return &invoker{done: make(chan error, 1)}
+	}}
+)
+
+type invoker struct {
+	rc   *RedisCmd
+	con  protocol.RedisRW
+	done chan error
+	//blocked is set when the command waits for its keys
+	blocked *blockState
+}
+
+//executor runs the commands routed to its shard in order,
+//commands of different executors run in parallel as long as they don't lock the same shards.
+type executor struct {
+	shard int
+	ch    chan *invoker
+}
+
+func newExecutors(n int) []*executor {
+	e := make([]*executor, n)
+	for i := range e {
+		e[i] = &executor{shard: i, ch: make(chan *invoker, 1024)}
+	}
+	return e
+}
+
+//LoopAndInvoke starts the executors, it never returns
+func LoopAndInvoke() {
+	for _, e := range executors[1:] {
+		go e.loop()
+	}
+	executors[0].loop()
+}
+
+func (e *executor) loop() {
+	cron := time.NewTicker(100 * time.Millisecond)
+	defer cron.Stop()
+	shard := []int{e.shard}
+	for {
+		select {
+		case in := <-e.ch:
+			var err error
+			in.blocked, err = invoke(in.con, in.rc)
+			in.done <- err
+		case <-cron.C:
+			execMu.RLock()
+			store.LockShards(shard)
+			start := time.Now()
+			store.ActiveExpireCycle(e.shard, activeExpireSample)
+			store.AddLatencySample("expire-cycle", time.Since(start))
+			store.UnlockShards(shard)
+			execMu.RUnlock()
+		}
+	}
+}
+
+//Execute runs a command on the executor of the shard of its first key and waits for it,
+//so that the replies of a connection are written in the order of its commands.
+//A blocked command, like BZPOPMIN on empty keys, is waited for here and not on the executor.
+func Execute(r protocol.RedisRW, c *RedisCmd) error {
+	in := invokerPool.Get().(*invoker)
+	in.rc, in.con = c, r
+	retry := func() (*blockState, error) {
+		executorOf(c).ch <- in
+		err := <-in.done
+		return in.blocked, err
+	}
+	b, err := retry()
+	if err == nil && b != nil {
+		err = serveBlocked(r, b, retry)
+	}
+	in.rc, in.con, in.blocked = nil, nil, nil
+	invokerPool.Put(in)
+	return err
+}
+
+func executorOf(c *RedisCmd) *executor {
+	if info, ok := lookupCmd(c.Name); ok && info.hasKeys() {
+		var keyBuf [4]string
+		if keys := info.appendKeys(keyBuf[:0], c.Args); len(keys) > 0 {
+			return executors[store.ShardIndex(keys[0])]
+		}
+	}
+	return executors[atomic.AddUint32(&nextKeyless, 1)%uint32(len(executors))]
+}
+
+//invoke locks what the command accesses and executes it.
+//Multi-key commands lock all the shards of their keys in ascending order, so they stay atomic.
+//It returns the state of the command when it blocks.
+func invoke(r protocol.RedisRW, c *RedisCmd) (b *blockState, err error) {
+	//a command which panics closes its connection instead of killing the server, its locks are released by their defers
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("panic executing '%s': %v\n%s", c.Name, p, debug.Stack())
+			b, err = nil, fmt.Errorf("panic executing '%s': %v", c.Name, p)
+		}
+	}()
+	if r.IsClosed() {
+		return nil, nil
+	}
+	info, ok := lookupCmd(c.Name)
+	if ok && !info.hasKeys() && !sharedKeyless[info.Name] {
+		//these commands are rare and may keep their arguments, like client names, ACL rules or channels,
+		//so they run on a copy instead of views into the buffer of the connection
+		c.Args = util.CloneArray(c.Args)
+		runExclusive(func() {
+			cl := clientOf(r)
+			for i := range shardClients {
+				shardClients[i] = cl
+			}
+			err = execCmd(r, c)
+			for i := range shardClients {
+				shardClients[i] = nil
+			}
+		})
+		return nil, err
+	}
+
+	//most commands have few keys, the buffers avoid allocating
+	var keyBuf [4]string
+	var shardBuf [4]int
+	var shards []int
+	if ok {
+		shards = store.ShardsOf(shardBuf[:0], info.appendKeys(keyBuf[:0], c.Args))
+	}
+	execMu.RLock()
+	defer execMu.RUnlock()
+	store.LockShards(shards)
+	defer store.UnlockShards(shards)
+	cl := clientOf(r)
+	//a blocked command executed again registers again if it still can't be served, with the same deadline
+	cl.retried = cl.blocked
+	unblock(cl)
+	for _, s := range shards {
+		shardClients[s] = cl
+	}
+	err = execCmd(r, c)
+	for _, s := range shards {
+		shardClients[s] = nil
+	}
+	cl.retried = nil
+	return cl.blocked, err
+}
+
+//runExclusive runs fn while no command is executed, it's used to change the state shared by all the clients
+func runExclusive(fn func()) {
+	execMu.Lock()
+	defer execMu.Unlock()
+	fn()
+}
+
+//Invoke executes a command in the goroutine of the caller,
+//it's used by the reactor whose event loops execute the commands inline.
+//When the command blocks it returns a Blocked, which owns the command with a copy of its arguments.
+func Invoke(r protocol.RedisRW, c *RedisCmd) (*Blocked, error) {
+	b, err := invoke(r, c)
+	if b == nil || err != nil {
+		return nil, err
+	}
+	c.Name, c.Args = util.Clone(c.Name), util.CloneArray(c.Args)
+	return &Blocked{r: r, c: c, state: b}, nil
+}
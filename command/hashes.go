@@ -1,13 +1,29 @@
 package command
 
 import (
+	"fmt"
 	"github.com/medusar/lucas/protocol"
 	"github.com/medusar/lucas/store"
+	"strconv"
+	"strings"
+	"time"
 )
 
+//errors of the commands with a FIELDS argument
+const (
+	errFieldsArg      = "ERR Mandatory argument FIELDS is missing or not at the right position"
+	errNumFields      = "ERR Parameter `numFields` should be greater than 0"
+	errNumFieldsMatch = "ERR The `numfields` parameter must match the number of arguments"
+	errFieldExpire    = "ERR invalid expire time, must be >= 0"
+	errCursor         = "ERR invalid cursor"
+)
+
+//maxFieldExpireAt is the latest unix time in milliseconds a field can expire at, like redis
+const maxFieldExpireAt = 1<<48 - 1
+
 // https://redis.io/commands/hset
 var hsetFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) < 3 || len(args)%2 != 1 {
+	if len(args)%2 != 1 {
 		return r.WriteError("ERR wrong number of arguments for 'hset' command")
 	}
 	added := 0
@@ -26,9 +42,6 @@ var hsetFunc = func(args []string, r protocol.RedisRW) error {
 
 //https://redis.io/commands/hget
 var hgetFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 2 {
-		return r.WriteError("ERR wrong number of arguments for 'hget' command")
-	}
 	key, field := args[0], args[1]
 	v, exist, err := store.Hget(key, field)
 	if err != nil {
@@ -42,9 +55,6 @@ var hgetFunc = func(args []string, r protocol.RedisRW) error {
 
 //https://redis.io/commands/hgetall
 var hgetAllFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 1 {
-		return r.WriteError("ERR wrong number of arguments for 'hgetall' command")
-	}
 	m, err := store.Hgetall(args[0])
 	if err != nil {
 		return r.WriteError(err.Error())
@@ -53,14 +63,11 @@ var hgetAllFunc = func(args []string, r protocol.RedisRW) error {
 	for k, v := range m {
 		ret = append(ret, protocol.NewBulk(k), protocol.NewBulk(v))
 	}
-	return r.WriteArray(ret)
+	return r.WriteMap(ret)
 }
 
 //https://redis.io/commands/hkeys
 var hkeysFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 1 {
-		return r.WriteError("ERR wrong number of arguments for 'hkeys' command")
-	}
 	keys, err := store.Hkeys(args[0])
 	if err != nil {
 		return r.WriteError(err.Error())
@@ -74,9 +81,6 @@ var hkeysFunc = func(args []string, r protocol.RedisRW) error {
 
 //https://redis.io/commands/hlen
 var hlenFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 1 {
-		return r.WriteError("ERR wrong number of arguments for 'hlen' command")
-	}
 	l, err := store.Hlen(args[0])
 	if err != nil {
 		return r.WriteError(err.Error())
@@ -86,9 +90,6 @@ var hlenFunc = func(args []string, r protocol.RedisRW) error {
 
 //https://redis.io/commands/hexists
 var hexistsFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 2 {
-		return r.WriteError("ERR wrong number of arguments for 'hexists' command")
-	}
 	l, err := store.Hexists(args[0], args[1])
 	if err != nil {
 		return r.WriteError(err.Error())
@@ -98,9 +99,6 @@ var hexistsFunc = func(args []string, r protocol.RedisRW) error {
 
 //https://redis.io/commands/hdel
 var hdelFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) < 2 {
-		return r.WriteError("ERR wrong number of arguments for 'hdel' command")
-	}
 	total, err := store.Hdel(args[0], args[1:])
 	if err != nil {
 		return r.WriteError(err.Error())
@@ -110,9 +108,6 @@ var hdelFunc = func(args []string, r protocol.RedisRW) error {
 
 //https://redis.io/commands/hmget
 var hmgetFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) < 2 {
-		return r.WriteError("ERR wrong number of arguments for 'hmget' command")
-	}
 	ret := make([]*protocol.Resp, 0)
 	for i := 1; i < len(args); i++ {
 		v, exists, err := store.Hget(args[0], args[i])
@@ -135,9 +130,6 @@ var hmsetFunc = func(args []string, r protocol.RedisRW) error {
 
 //https://redis.io/commands/hsetnx
 var hsetnxFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 3 {
-		return r.WriteError("ERR wrong number of arguments for 'hsetnx' command")
-	}
 	l, err := store.HsetNX(args[0], args[1], args[2])
 	if err != nil {
 		return r.WriteError(err.Error())
@@ -147,9 +139,6 @@ var hsetnxFunc = func(args []string, r protocol.RedisRW) error {
 
 //https://redis.io/commands/hstrlen
 var hstrlenFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 2 {
-		return r.WriteError("ERR wrong number of arguments for 'hstrlen' command")
-	}
 	l, err := store.HstrLen(args[0], args[1])
 	if err != nil {
 		return r.WriteError(err.Error())
@@ -159,9 +148,6 @@ var hstrlenFunc = func(args []string, r protocol.RedisRW) error {
 
 //https://redis.io/commands/hvals
 var hvalsFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 1 {
-		return r.WriteError("ERR wrong number of arguments for 'hvals' command")
-	}
 	vals, err := store.Hvals(args[0])
 	if err != nil {
 		return r.WriteError(err.Error())
@@ -171,9 +157,6 @@ var hvalsFunc = func(args []string, r protocol.RedisRW) error {
 
 //https://redis.io/commands/hincrby
 var hincrByFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 3 {
-		return r.WriteError("ERR wrong number of arguments for 'hincrby' command")
-	}
 	v, err := store.HincrBy(args[0], args[1], args[2])
 	if err != nil {
 		return r.WriteError(err.Error())
@@ -183,12 +166,281 @@ var hincrByFunc = func(args []string, r protocol.RedisRW) error {
 
 //https://redis.io/commands/hincrbyfloat
 var hincrByFloatFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 3 {
-		return r.WriteError("ERR wrong number of arguments for 'hincrbyfloat' command")
-	}
 	v, err := store.HincrByFloat(args[0], args[1], args[2])
 	if err != nil {
 		return r.WriteError(err.Error())
 	}
 	return r.WriteBulk(v)
 }
+
+//parseFields parses FIELDS numfields field [field ...], the last arguments of the commands on hash fields.
+//It returns the error message when the arguments are invalid.
+func parseFields(args []string) ([]string, string) {
+	if len(args) < 2 || strings.ToLower(args[0]) != "fields" {
+		return nil, errFieldsArg
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n <= 0 {
+		return nil, errNumFields
+	}
+	if n != len(args)-2 {
+		return nil, errNumFieldsMatch
+	}
+	return args[2:], ""
+}
+
+//https://redis.io/commands/hexpire
+var hexpireFunc = func(args []string, r protocol.RedisRW) error {
+	return hexpireGeneric("hexpire", args, r, 1000, false)
+}
+
+//https://redis.io/commands/hpexpire
+var hpexpireFunc = func(args []string, r protocol.RedisRW) error {
+	return hexpireGeneric("hpexpire", args, r, 1, false)
+}
+
+//https://redis.io/commands/hexpireat
+var hexpireatFunc = func(args []string, r protocol.RedisRW) error {
+	return hexpireGeneric("hexpireat", args, r, 1000, true)
+}
+
+//https://redis.io/commands/hpexpireat
+var hpexpireatFunc = func(args []string, r protocol.RedisRW) error {
+	return hexpireGeneric("hpexpireat", args, r, 1, true)
+}
+
+//hexpireGeneric implements HEXPIRE key time [NX | XX | GT | LT] FIELDS numfields field [field ...] and its variants,
+//the time is a number of units of milliseconds, from now or from the epoch when at is set.
+func hexpireGeneric(name string, args []string, r protocol.RedisRW, unit int64, at bool) error {
+	n, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return r.WriteError(errNotInteger)
+	}
+	if n < 0 {
+		return r.WriteError(errFieldExpire)
+	}
+	base := int64(0)
+	if !at {
+		base = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+	if n > (maxFieldExpireAt-base)/unit {
+		return r.WriteError(fmt.Sprintf("ERR invalid expire time in '%s' command", name))
+	}
+	flags, opts := 0, args[2:]
+	switch strings.ToLower(opts[0]) {
+	case "nx":
+		flags = store.HexpireNX
+	case "xx":
+		flags = store.HexpireXX
+	case "gt":
+		flags = store.HexpireGT
+	case "lt":
+		flags = store.HexpireLT
+	}
+	if flags != 0 {
+		opts = opts[1:]
+	}
+	fields, msg := parseFields(opts)
+	if msg != "" {
+		return r.WriteError(msg)
+	}
+	results, err := store.Hexpire(args[0], fields, base+n*unit, flags)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	return r.WriteArray(toIntegerArray(results))
+}
+
+//https://redis.io/commands/httl
+var httlFunc = func(args []string, r protocol.RedisRW) error {
+	return httlGeneric(args, r, 1000, false)
+}
+
+//https://redis.io/commands/hpttl
+var hpttlFunc = func(args []string, r protocol.RedisRW) error {
+	return httlGeneric(args, r, 1, false)
+}
+
+//https://redis.io/commands/hexpiretime
+var hexpiretimeFunc = func(args []string, r protocol.RedisRW) error {
+	return httlGeneric(args, r, 1000, true)
+}
+
+//https://redis.io/commands/hpexpiretime
+var hpexpiretimeFunc = func(args []string, r protocol.RedisRW) error {
+	return httlGeneric(args, r, 1, true)
+}
+
+//httlGeneric implements HTTL key FIELDS numfields field [field ...] and its variants,
+//the TTL, or the expiration time when at is set, is a number of units of milliseconds rounded up like redis does.
+func httlGeneric(args []string, r protocol.RedisRW, unit int64, at bool) error {
+	fields, msg := parseFields(args[1:])
+	if msg != "" {
+		return r.WriteError(msg)
+	}
+	times, err := store.HfieldExpireAt(args[0], fields)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	base := int64(0)
+	if !at {
+		base = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+	ret := make([]*protocol.Resp, len(times))
+	for i, t := range times {
+		if t >= 0 {
+			t = (t - base + unit - 1) / unit
+		}
+		ret[i] = protocol.NewInteger(int(t))
+	}
+	return r.WriteArray(ret)
+}
+
+//https://redis.io/commands/hpersist
+var hpersistFunc = func(args []string, r protocol.RedisRW) error {
+	fields, msg := parseFields(args[1:])
+	if msg != "" {
+		return r.WriteError(msg)
+	}
+	results, err := store.Hpersist(args[0], fields)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	return r.WriteArray(toIntegerArray(results))
+}
+
+//https://redis.io/commands/hgetdel
+//HGETDEL key FIELDS numfields field [field ...]
+var hgetdelFunc = func(args []string, r protocol.RedisRW) error {
+	fields, msg := parseFields(args[1:])
+	if msg != "" {
+		return r.WriteError(msg)
+	}
+	vals, err := store.Hgetdel(args[0], fields)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	return r.WriteArray(toNullableBulkArray(vals))
+}
+
+//https://redis.io/commands/hgetex
+//HGETEX key [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | PERSIST] FIELDS numfields field [field ...]
+var hgetexFunc = func(args []string, r protocol.RedisRW) error {
+	i := 1
+	for i < len(args) && strings.ToLower(args[i]) != "fields" {
+		i++
+	}
+	opts, err := parseOptions(args[1:i], getexOptions)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	expireAt, err := parseExpireAt(opts, "hgetex")
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	if opts.has("persist") {
+		expireAt = -1
+	}
+	fields, msg := parseFields(args[i:])
+	if msg != "" {
+		return r.WriteError(msg)
+	}
+	vals, err := store.Hgetex(args[0], fields, expireAt)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	return r.WriteArray(toNullableBulkArray(vals))
+}
+
+//https://redis.io/commands/hrandfield
+//HRANDFIELD key [count [WITHVALUES]]
+var hrandfieldFunc = func(args []string, r protocol.RedisRW) error {
+	if len(args) == 1 {
+		fields, err := store.Hrandfield(args[0], 1)
+		if err != nil {
+			return r.WriteError(err.Error())
+		}
+		if len(fields) == 0 {
+			return r.WriteNil()
+		}
+		return r.WriteBulk(fields[0].Field)
+	}
+	count, err := strconv.Atoi(args[1])
+	if err != nil {
+		return r.WriteError(errNotInteger)
+	}
+	withValues := false
+	if len(args) == 3 && strings.ToLower(args[2]) == "withvalues" {
+		withValues = true
+	} else if len(args) > 2 {
+		return r.WriteError(errSyntax)
+	}
+	if count < -randCountLimit || count > randCountLimit {
+		return r.WriteError(errRandRange)
+	}
+	fields, err := store.Hrandfield(args[0], count)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	if withValues && r.Protocol() == protocol.Resp3 {
+		arr := make([]*protocol.Resp, len(fields))
+		for i, f := range fields {
+			arr[i] = protocol.NewArray([]*protocol.Resp{protocol.NewBulk(f.Field), protocol.NewBulk(f.Value)})
+		}
+		return r.WriteArray(arr)
+	}
+	return r.WriteArray(hashFieldsArray(fields, withValues))
+}
+
+//the options of HSCAN
+var hscanOptions = []argOption{
+	{name: "match", nargs: 1},
+	{name: "count", nargs: 1},
+	{name: "novalues"},
+}
+
+//https://redis.io/commands/hscan
+//HSCAN key cursor [MATCH pattern] [COUNT count] [NOVALUES]
+var hscanFunc = func(args []string, r protocol.RedisRW) error {
+	cursor, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return r.WriteError(errCursor)
+	}
+	opts, err := parseOptions(args[2:], hscanOptions)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	count := 10
+	if opts.has("count") {
+		if count, err = opts.int("count", 0); err != nil {
+			return r.WriteError(err.Error())
+		}
+		if count < 1 {
+			return r.WriteError(errSyntax)
+		}
+	}
+	pattern := ""
+	if opts.has("match") && opts["match"][0] != "*" {
+		pattern = opts["match"][0]
+	}
+	next, fields, err := store.Hscan(args[0], cursor, pattern, count)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	return r.WriteArray([]*protocol.Resp{
+		protocol.NewBulk(strconv.FormatUint(next, 10)),
+		protocol.NewArray(hashFieldsArray(fields, !opts.has("novalues"))),
+	})
+}
+
+//hashFieldsArray returns the fields, each followed by its value when withValues is set
+func hashFieldsArray(fields []store.HashField, withValues bool) []*protocol.Resp {
+	arr := make([]*protocol.Resp, 0, 2*len(fields))
+	for _, f := range fields {
+		arr = append(arr, protocol.NewBulk(f.Field))
+		if withValues {
+			arr = append(arr, protocol.NewBulk(f.Value))
+		}
+	}
+	return arr
+}
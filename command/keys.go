@@ -1,25 +1,19 @@
 package command
 
 import (
+	"fmt"
 	"github.com/medusar/lucas/protocol"
 	"github.com/medusar/lucas/store"
 	"strconv"
+	"strings"
 )
 
 var ttlFunc = func(args []string, r protocol.RedisRW) error {
-	var err error
-	if len(args) != 1 {
-		err = r.WriteError("ERR wrong number of arguments for 'ttl' command")
-		return err
-	}
 	ttl := store.Ttl(args[0])
 	return r.WriteInteger(ttl)
 }
 
 var expireFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 2 {
-		return r.WriteError("ERR wrong number of arguments for 'expire' command")
-	}
 	sec, err := strconv.Atoi(args[1])
 	if err != nil {
 		return r.WriteError("ERR value is not an integer or out of range")
@@ -32,10 +26,6 @@ var expireFunc = func(args []string, r protocol.RedisRW) error {
 }
 
 var expireAtFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 2 {
-		return r.WriteError("ERR wrong number of arguments for 'expire' command")
-	}
-
 	timestamp, err := strconv.Atoi(args[1])
 	if err != nil {
 		return r.WriteError("ERR value is not an integer or out of range")
@@ -49,9 +39,6 @@ var expireAtFunc = func(args []string, r protocol.RedisRW) error {
 }
 
 var keysFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 1 {
-		return r.WriteError("ERR wrong number of arguments for 'keys' command")
-	}
 	keys := store.Keys(args[0])
 	if keys == nil || len(keys) == 0 {
 		return r.WriteArray(nil)
@@ -60,9 +47,6 @@ var keysFunc = func(args []string, r protocol.RedisRW) error {
 }
 
 var existsFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) == 0 {
-		return r.WriteError("ERR wrong number of arguments for 'exists' command")
-	}
 	total := 0
 
 	for _, key := range args {
@@ -75,9 +59,6 @@ var existsFunc = func(args []string, r protocol.RedisRW) error {
 }
 
 var delFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) == 0 {
-		return r.WriteError("ERR wrong number of arguments for 'del' command")
-	}
 	total := 0
 	for _, key := range args {
 		if store.Del(key) {
@@ -88,9 +69,141 @@ var delFunc = func(args []string, r protocol.RedisRW) error {
 }
 
 var typeFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 1 {
-		return r.WriteError("ERR wrong number of arguments for 'type' command")
-	}
 	t := store.Type(args[0])
 	return r.WriteString(t)
 }
+
+//https://redis.io/commands/unlink
+//Deleting a key only drops the reference to its value whatever its size, the value is then reclaimed
+//by the garbage collector which runs concurrently with the executors. So nothing is left to free on the executor
+//and UNLINK is DEL.
+var unlinkFunc = delFunc
+
+//https://redis.io/commands/touch
+var touchFunc = func(args []string, r protocol.RedisRW) error {
+	total := 0
+	for _, key := range args {
+		if store.Touch(key) {
+			total++
+		}
+	}
+	return r.WriteInteger(total)
+}
+
+//https://redis.io/commands/rename
+var renameFunc = func(args []string, r protocol.RedisRW) error {
+	if _, err := store.Rename(args[0], args[1], false); err != nil {
+		return r.WriteError(err.Error())
+	}
+	return r.WriteString("OK")
+}
+
+//https://redis.io/commands/renamenx
+var renamenxFunc = func(args []string, r protocol.RedisRW) error {
+	renamed, err := store.Rename(args[0], args[1], true)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	if renamed {
+		return r.WriteInteger(1)
+	}
+	return r.WriteInteger(0)
+}
+
+//the options of COPY
+var copyOptions = []argOption{
+	{name: "db", nargs: 1},
+	{name: "replace"},
+}
+
+//https://redis.io/commands/copy
+//COPY source destination [DB destination-db] [REPLACE]
+var copyFunc = func(args []string, r protocol.RedisRW) error {
+	opts, err := parseOptions(args[2:], copyOptions)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	//there's a single database, the 0
+	if opts.has("db") {
+		db, err := opts.int("db", 0)
+		if err != nil {
+			return r.WriteError(err.Error())
+		}
+		if db != 0 {
+			return r.WriteError("ERR DB index is out of range")
+		}
+	}
+	if args[0] == args[1] {
+		return r.WriteError("ERR source and destination objects are the same")
+	}
+	if store.Copy(args[0], args[1], opts.has("replace")) {
+		return r.WriteInteger(1)
+	}
+	return r.WriteInteger(0)
+}
+
+//https://redis.io/commands/randomkey
+var randomkeyFunc = func(args []string, r protocol.RedisRW) error {
+	key, ok := store.RandomKey()
+	if !ok {
+		return r.WriteNil()
+	}
+	return r.WriteBulk(key)
+}
+
+//objectHelp is the reply of OBJECT HELP
+var objectHelp = []string{
+	"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"ENCODING <key>",
+	"    Return the kind of internal representation used in order to store the value",
+	"    associated with a <key>.",
+	"FREQ <key>",
+	"    Return the access frequency index of the <key>. The returned integer is",
+	"    proportional to the logarithm of the recent access frequency of the key.",
+	"IDLETIME <key>",
+	"    Return the idle time of the <key>, that is the approximated number of",
+	"    seconds elapsed since the last access to the key.",
+	"REFCOUNT <key>",
+	"    Return the number of references of the value associated with the specified",
+	"    <key>.",
+	"HELP",
+	"    Print this help.",
+}
+
+//https://redis.io/commands/object
+//The access frequency is always tracked, so OBJECT FREQ works whatever the eviction policy unlike redis.
+//Values are never shared, their only reference is the keyspace.
+var objectFunc = func(args []string, r protocol.RedisRW) error {
+	sub := strings.ToLower(args[0])
+	if sub == "help" && len(args) == 1 {
+		return r.WriteArray(toStatusArray(objectHelp))
+	}
+	if len(args) == 2 {
+		switch sub {
+		case "encoding":
+			encoding, ok := store.ObjectEncoding(args[1])
+			if !ok {
+				return r.WriteNil()
+			}
+			return r.WriteBulk(encoding)
+		case "idletime":
+			idle, ok := store.ObjectIdletime(args[1])
+			if !ok {
+				return r.WriteNil()
+			}
+			return r.WriteInteger(idle)
+		case "freq":
+			freq, ok := store.ObjectFreq(args[1])
+			if !ok {
+				return r.WriteNil()
+			}
+			return r.WriteInteger(freq)
+		case "refcount":
+			if !store.Exists(args[1]) {
+				return r.WriteNil()
+			}
+			return r.WriteInteger(1)
+		}
+	}
+	return r.WriteError(fmt.Sprintf("ERR Unknown subcommand or wrong number of arguments for '%s'. Try OBJECT HELP.", args[0]))
+}
@@ -0,0 +1,245 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/medusar/lucas/protocol"
+	"github.com/medusar/lucas/store"
+	"strings"
+	"time"
+)
+
+const (
+	//latencyGraphRows is the height of LATENCY GRAPH, every row shows two levels
+	latencyGraphRows = 4
+	//latencyGraphColumns is the max number of samples shown by LATENCY GRAPH
+	latencyGraphColumns = 80
+)
+
+//latencyHelp is the reply of LATENCY HELP
+var latencyHelp = []string{
+	"LATENCY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"DOCTOR",
+	"    Return a human readable latency analysis report.",
+	"GRAPH <event>",
+	"    Return an ASCII latency graph for the <event> class.",
+	"HISTORY <event>",
+	"    Return time-latency samples for the <event> class.",
+	"LATEST",
+	"    Return the latest latency samples for all events.",
+	"RESET [<event> ...]",
+	"    Reset latency data of one or more <event> classes.",
+	"    (default: reset all data for all event classes)",
+	"HISTOGRAM [COMMAND ...]",
+	"    Return a cumulative distribution of latencies in the format of a histogram for the specified command names.",
+	"    If no commands are specified then all histograms are replied.",
+	"HELP",
+	"    Print this help.",
+}
+
+//https://redis.io/commands/latency-latest
+//https://redis.io/commands/latency-histogram
+//https://redis.io/commands/latency-help
+var latencyFunc = func(args []string, r protocol.RedisRW) error {
+	sub := strings.ToLower(args[0])
+	switch {
+	case sub == "help" && len(args) == 1:
+		return r.WriteArray(toStatusArray(latencyHelp))
+	case sub == "latest" && len(args) == 1:
+		latest := store.LatencyLatest()
+		reply := make([]*protocol.Resp, len(latest))
+		for i, s := range latest {
+			reply[i] = protocol.NewArray([]*protocol.Resp{protocol.NewBulk(s.Event), protocol.NewInteger(int(s.Time)),
+				protocol.NewInteger(int(s.Latency)), protocol.NewInteger(int(s.Max))})
+		}
+		return r.WriteArray(reply)
+	case sub == "history" && len(args) == 2:
+		samples := store.LatencyHistory(args[1])
+		reply := make([]*protocol.Resp, len(samples))
+		for i, s := range samples {
+			reply[i] = protocol.NewArray([]*protocol.Resp{protocol.NewInteger(int(s.Time)), protocol.NewInteger(int(s.Latency))})
+		}
+		return r.WriteArray(reply)
+	case sub == "reset":
+		return r.WriteInteger(store.ResetLatency(args[1:]))
+	case sub == "graph" && len(args) == 2:
+		samples := store.LatencyHistory(args[1])
+		if len(samples) == 0 {
+			return r.WriteError(fmt.Sprintf("ERR No samples available for event '%s'", args[1]))
+		}
+		return r.WriteVerbatim("txt", latencyGraph(args[1], samples))
+	case sub == "doctor" && len(args) == 1:
+		return r.WriteVerbatim("txt", latencyDoctor())
+	case sub == "histogram":
+		return latencyHistogram(args[1:], r)
+	}
+	return r.WriteError(fmt.Sprintf("ERR Unknown subcommand or wrong number of arguments for '%s'. Try LATENCY HELP.", args[0]))
+}
+
+//LATENCY HISTOGRAM [command ...]
+func latencyHistogram(names []string, r protocol.RedisRW) error {
+	histograms := store.CommandHistograms()
+	if len(names) == 0 {
+		for name := range histograms {
+			names = append(names, name)
+		}
+	}
+	var reply []*protocol.Resp
+	for _, name := range names {
+		name = strings.ToLower(name)
+		h, ok := histograms[name]
+		if !ok {
+			continue
+		}
+		var buckets []*protocol.Resp
+		for _, p := range h.Cumulative() {
+			buckets = append(buckets, protocol.NewInteger(int(p[0])), protocol.NewInteger(int(p[1])))
+		}
+		reply = append(reply, protocol.NewBulk(name), protocol.NewMap([]*protocol.Resp{
+			protocol.NewBulk("calls"), protocol.NewInteger(int(h.Calls)),
+			protocol.NewBulk("histogram_usec"), protocol.NewMap(buckets),
+		}))
+	}
+	return r.WriteMap(reply)
+}
+
+//formatAge formats the age of a sample in a short way, like 12s, 3m, 5h or 2d
+func formatAge(seconds int64) string {
+	switch {
+	case seconds < 60:
+		return fmt.Sprintf("%ds", seconds)
+	case seconds < 3600:
+		return fmt.Sprintf("%dm", seconds/60)
+	case seconds < 86400:
+		return fmt.Sprintf("%dh", seconds/3600)
+	}
+	return fmt.Sprintf("%dd", seconds/86400)
+}
+
+//latencyGraph draws the samples as an ASCII chart, the age of every sample is written vertically below it
+func latencyGraph(event string, samples []store.LatencySample) string {
+	if len(samples) > latencyGraphColumns {
+		samples = samples[len(samples)-latencyGraphColumns:]
+	}
+	high, low := samples[0].Latency, samples[0].Latency
+	for _, s := range samples {
+		if s.Latency > high {
+			high = s.Latency
+		}
+		if s.Latency < low {
+			low = s.Latency
+		}
+	}
+	allTimeHigh := high
+	for _, s := range store.LatencyLatest() {
+		if s.Event == event {
+			allTimeHigh = s.Max
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s - high %d ms, low %d ms (all time high %d ms)\n", event, high, low, allTimeHigh)
+	buf.WriteString(strings.Repeat("-", latencyGraphColumns))
+	buf.WriteByte('\n')
+
+	//levels go from 1 to latencyGraphRows*2, the lowest sample is still visible
+	levels := make([]int64, len(samples))
+	for i, s := range samples {
+		levels[i] = latencyGraphRows * 2
+		if high > low {
+			levels[i] = 1 + (s.Latency-low)*(latencyGraphRows*2-1)/(high-low)
+		}
+	}
+	for row := latencyGraphRows - 1; row >= 0; row-- {
+		bottom := int64(row * 2)
+		for _, level := range levels {
+			switch {
+			case level <= bottom:
+				buf.WriteByte(' ')
+			case level == bottom+1:
+				buf.WriteByte('_')
+			case level == bottom+2:
+				buf.WriteByte('#')
+			default:
+				buf.WriteByte('|')
+			}
+		}
+		buf.WriteByte('\n')
+	}
+
+	now := time.Now().Unix()
+	labels := make([]string, len(samples))
+	height := 0
+	for i, s := range samples {
+		labels[i] = formatAge(now - s.Time)
+		if len(labels[i]) > height {
+			height = len(labels[i])
+		}
+	}
+	buf.WriteByte('\n')
+	for j := 0; j < height; j++ {
+		for _, label := range labels {
+			if j < len(label) {
+				buf.WriteByte(label[j])
+			} else {
+				buf.WriteByte(' ')
+			}
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+//latencyDoctor writes a human readable report of the latency events, with advices to fix them
+func latencyDoctor() string {
+	if store.LatencyThreshold() == 0 {
+		return "I'm sorry, Dave, I can't do that. Latency monitoring is disabled in this Redis instance. " +
+			"You may use the -latency-monitor-threshold option in order to enable it.\n"
+	}
+	latest := store.LatencyLatest()
+	if len(latest) == 0 {
+		return "Dave, no latency spike was observed during the lifetime of this Redis instance, not in the slightest bit. " +
+			"I honestly think you ought to sleep better.\n"
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("Dave, I have observed latency spikes in this Redis instance. You don't mind talking about it, do you Dave?\n\n")
+	events := make(map[string]bool)
+	for i, s := range latest {
+		samples := store.LatencyHistory(s.Event)
+		sum := int64(0)
+		for _, sample := range samples {
+			sum += sample.Latency
+		}
+		avg := sum / int64(len(samples))
+		dev := int64(0)
+		for _, sample := range samples {
+			d := sample.Latency - avg
+			if d < 0 {
+				d = -d
+			}
+			dev += d
+		}
+		dev /= int64(len(samples))
+		period := float64(samples[len(samples)-1].Time-samples[0].Time) / float64(len(samples))
+		fmt.Fprintf(&buf, "%d. %s: %d latency spikes (average %dms, mean deviation %dms, period %.2f sec). Worst all time event %dms.\n",
+			i+1, s.Event, len(samples), avg, dev, period, s.Max)
+		events[s.Event] = true
+	}
+
+	buf.WriteString("\nI have a few advices for you:\n\n")
+	if events["command"] || events["fast-command"] {
+		buf.WriteString("- Check your Slow Log to understand what are the commands you are running which are too slow to execute. " +
+			"Please check https://redis.io/commands/slowlog for more information.\n")
+	}
+	if events["fast-command"] {
+		buf.WriteString("- The system is slow to execute Redis code paths not containing system calls. " +
+			"This usually means the system does not provide Redis CPU time to run for long periods. " +
+			"You should try to check the system load and the CPU usage.\n")
+	}
+	if events["expire-cycle"] {
+		buf.WriteString("- Deleting, expiring or evicting (because of maxmemory policy) large objects is a blocking operation. " +
+			"If you have very large objects that are often deleted, expired, or evicted, try to fragment those objects into multiple smaller objects.\n")
+	}
+	return buf.String()
+}
@@ -1,16 +1,21 @@
 package command
 
 import (
+	"fmt"
 	"github.com/medusar/lucas/protocol"
 	"github.com/medusar/lucas/store"
 	"strconv"
+	"strings"
+)
+
+const (
+	errLposRank   = "ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list"
+	errLposCount  = "ERR COUNT can't be negative"
+	errLposMaxlen = "ERR MAXLEN can't be negative"
 )
 
 // https://redis.io/commands/lpush
 var lpushFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) < 2 {
-		return r.WriteError("ERR wrong number of arguments for 'lpush' command")
-	}
 	n, err := store.Lpush(args[0], args[1:])
 	if err != nil {
 		return r.WriteError(err.Error())
@@ -20,9 +25,6 @@ var lpushFunc = func(args []string, r protocol.RedisRW) error {
 
 //https://redis.io/commands/rpush
 var rpushFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) < 2 {
-		return r.WriteError("ERR wrong number of arguments for 'rpush' command")
-	}
 	n, err := store.Rpush(args[0], args[1:])
 	if err != nil {
 		return r.WriteError(err.Error())
@@ -32,9 +34,6 @@ var rpushFunc = func(args []string, r protocol.RedisRW) error {
 
 //https://redis.io/commands/llen
 var llenFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 1 {
-		return r.WriteError("ERR wrong number of arguments for 'llen' command")
-	}
 	n, err := store.Llen(args[0])
 	if err != nil {
 		return r.WriteError(err.Error())
@@ -43,41 +42,56 @@ var llenFunc = func(args []string, r protocol.RedisRW) error {
 }
 
 //https://redis.io/commands/lpop
+//LPOP key [count]
 var lpopFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 1 {
-		return r.WriteError("ERR wrong number of arguments for 'lpop' command")
-	}
-	v, exists, err := store.Lpop(args[0])
-	if err != nil {
-		return r.WriteError(err.Error())
-	}
-	if exists {
-		return r.WriteBulk(v)
-	}
-	return r.WriteNil()
+	return popGeneric("lpop", args, r, true)
 }
 
 //https://redis.io/commands/rpop
+//RPOP key [count]
 var rpopFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 1 {
-		return r.WriteError("ERR wrong number of arguments for 'rpop' command")
+	return popGeneric("rpop", args, r, false)
+}
+
+//popGeneric implements LPOP and RPOP, the reply is an array when a count is given
+func popGeneric(name string, args []string, r protocol.RedisRW, left bool) error {
+	if len(args) > 2 {
+		return r.WriteError(fmt.Sprintf(errWrongArgsFmt, name))
+	}
+	if len(args) == 1 {
+		var v string
+		var exists bool
+		var err error
+		if left {
+			v, exists, err = store.Lpop(args[0])
+		} else {
+			v, exists, err = store.Rpop(args[0])
+		}
+		if err != nil {
+			return r.WriteError(err.Error())
+		}
+		if exists {
+			return r.WriteBulk(v)
+		}
+		return r.WriteNil()
 	}
-	v, exists, err := store.Rpop(args[0])
+
+	count, err := strconv.Atoi(args[1])
+	if err != nil || count < 0 {
+		return r.WriteError(errZpopCount)
+	}
+	list, err := store.PopN(args[0], count, left)
 	if err != nil {
 		return r.WriteError(err.Error())
 	}
-	if exists {
-		return r.WriteBulk(v)
+	if list == nil {
+		return r.WriteResp(protocol.NewNilArray())
 	}
-	return r.WriteNil()
+	return r.WriteArray(toBulkArray(list))
 }
 
 //https://redis.io/commands/lindex
 var lindexFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 2 {
-		return r.WriteError("ERR wrong number of arguments for 'lindex' command")
-	}
-
 	//TODO: return error only when the key exists
 	idx, err := strconv.Atoi(args[1])
 	if err != nil {
@@ -95,10 +109,6 @@ var lindexFunc = func(args []string, r protocol.RedisRW) error {
 
 //https://redis.io/commands/lrem
 var lremFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 3 {
-		return r.WriteError("ERR wrong number of arguments for 'lrem' command")
-	}
-
 	//TODO: return error only when the key exists
 	count, err := strconv.Atoi(args[1])
 	if err != nil {
@@ -114,17 +124,13 @@ var lremFunc = func(args []string, r protocol.RedisRW) error {
 
 //https://redis.io/commands/lset
 var lsetFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 3 {
-		return r.WriteError("ERR wrong number of arguments for 'lrem' command")
-	}
-
 	//TODO: return error only when the key exists
 	index, err := strconv.Atoi(args[1])
 	if err != nil {
 		return r.WriteError("ERR value is not an integer or out of rang")
 	}
 
-	err = store.Lset(args[0], index, args[1])
+	err = store.Lset(args[0], index, args[2])
 	if err != nil {
 		return r.WriteError(err.Error())
 	}
@@ -133,9 +139,6 @@ var lsetFunc = func(args []string, r protocol.RedisRW) error {
 
 //https://redis.io/commands/rpushx
 var rpushXFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) < 2 {
-		return r.WriteError("ERR wrong number of arguments for 'rpushx' command")
-	}
 	n, err := store.RpushX(args[0], args[1:])
 	if err != nil {
 		return r.WriteError(err.Error())
@@ -145,9 +148,6 @@ var rpushXFunc = func(args []string, r protocol.RedisRW) error {
 
 //https://redis.io/commands/lpushx
 var lpushXFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) < 2 {
-		return r.WriteError("ERR wrong number of arguments for 'lpushx' command")
-	}
 	n, err := store.LpushX(args[0], args[1:])
 	if err != nil {
 		return r.WriteError(err.Error())
@@ -157,10 +157,6 @@ var lpushXFunc = func(args []string, r protocol.RedisRW) error {
 
 //https://redis.io/commands/lrange
 var lrangeFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 3 {
-		return r.WriteError("ERR wrong number of arguments for 'lrange' command")
-	}
-
 	//TODO: return error only when the key exists
 	start, err := strconv.Atoi(args[1])
 	if err != nil {
@@ -180,4 +176,150 @@ var lrangeFunc = func(args []string, r protocol.RedisRW) error {
 	return r.WriteArray(array)
 }
 
+//https://redis.io/commands/linsert
+//LINSERT key BEFORE|AFTER pivot element
+var linsertFunc = func(args []string, r protocol.RedisRW) error {
+	var before bool
+	switch strings.ToLower(args[1]) {
+	case "before":
+		before = true
+	case "after":
+	default:
+		return r.WriteError(errSyntax)
+	}
+	n, err := store.Linsert(args[0], before, args[2], args[3])
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	return r.WriteInteger(n)
+}
+
+//https://redis.io/commands/ltrim
+var ltrimFunc = func(args []string, r protocol.RedisRW) error {
+	start, err := strconv.Atoi(args[1])
+	if err != nil {
+		return r.WriteError(errNotInteger)
+	}
+	stop, err := strconv.Atoi(args[2])
+	if err != nil {
+		return r.WriteError(errNotInteger)
+	}
+	if err := store.Ltrim(args[0], start, stop); err != nil {
+		return r.WriteError(err.Error())
+	}
+	return r.WriteString("OK")
+}
+
+//the options of LPOS
+var lposOptions = []argOption{{name: "rank", nargs: 1}, {name: "count", nargs: 1}, {name: "maxlen", nargs: 1}}
+
+//https://redis.io/commands/lpos
+//LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+var lposFunc = func(args []string, r protocol.RedisRW) error {
+	opts, err := parseOptions(args[2:], lposOptions)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	rank, count, maxlen := 1, 1, 0
+	if opts.has("rank") {
+		if rank, err = opts.int("rank", 0); err != nil {
+			return r.WriteError(err.Error())
+		}
+		if rank == 0 {
+			return r.WriteError(errLposRank)
+		}
+	}
+	withCount := opts.has("count")
+	if withCount {
+		if count, err = opts.int("count", 0); err != nil {
+			return r.WriteError(err.Error())
+		}
+		if count < 0 {
+			return r.WriteError(errLposCount)
+		}
+	}
+	if opts.has("maxlen") {
+		if maxlen, err = opts.int("maxlen", 0); err != nil {
+			return r.WriteError(err.Error())
+		}
+		if maxlen < 0 {
+			return r.WriteError(errLposMaxlen)
+		}
+	}
+
+	matches, err := store.Lpos(args[0], args[1], rank, count, maxlen)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	if withCount {
+		arr := make([]*protocol.Resp, len(matches))
+		for i, m := range matches {
+			arr[i] = protocol.NewInteger(m)
+		}
+		return r.WriteArray(arr)
+	}
+	if len(matches) == 0 {
+		return r.WriteNil()
+	}
+	return r.WriteInteger(matches[0])
+}
+
+//https://redis.io/commands/lmove
+//LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+var lmoveFunc = func(args []string, r protocol.RedisRW) error {
+	fromLeft, ok := parseListSide(args[2])
+	if !ok {
+		return r.WriteError(errSyntax)
+	}
+	toLeft, ok := parseListSide(args[3])
+	if !ok {
+		return r.WriteError(errSyntax)
+	}
+	return lmoveGeneric(args[0], args[1], fromLeft, toLeft, r)
+}
+
 //https://redis.io/commands/rpoplpush
+var rpoplpushFunc = func(args []string, r protocol.RedisRW) error {
+	return lmoveGeneric(args[0], args[1], false, true, r)
+}
+
+func lmoveGeneric(source, destination string, fromLeft, toLeft bool, r protocol.RedisRW) error {
+	v, exists, err := store.Lmove(source, destination, fromLeft, toLeft)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	if exists {
+		return r.WriteBulk(v)
+	}
+	return r.WriteNil()
+}
+
+//parseListSide parses LEFT or RIGHT, it reports true for LEFT
+func parseListSide(arg string) (left bool, ok bool) {
+	switch strings.ToLower(arg) {
+	case "left":
+		return true, true
+	case "right":
+		return false, true
+	}
+	return false, false
+}
+
+//https://redis.io/commands/lmpop
+//LMPOP numkeys key [key ...] LEFT|RIGHT [COUNT count]
+var lmpopFunc = func(args []string, r protocol.RedisRW) error {
+	keys, left, count, msg := parseMpopArgs(args, [2]string{"left", "right"})
+	if msg != "" {
+		return r.WriteError(msg)
+	}
+	for _, key := range keys {
+		list, err := store.PopN(key, count, left)
+		if err != nil {
+			return r.WriteError(err.Error())
+		}
+		if len(list) > 0 {
+			return r.WriteArray([]*protocol.Resp{protocol.NewBulk(key), protocol.NewArray(toBulkArray(list))})
+		}
+	}
+	return r.WriteResp(protocol.NewNilArray())
+}
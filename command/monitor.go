@@ -0,0 +1,121 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/medusar/lucas/protocol"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	//monitors are the clients which ran MONITOR, they are fed by all the executors.
+	//The connection of a monitor is written by its push queue, so a slow reader never blocks the executors.
+	monitorsMu sync.Mutex
+	monitors   = make(map[*client]*pushQueue)
+	//monitorCount is the size of monitors, read without the lock by every command
+	monitorCount int32
+)
+
+func monitorOf(c *client) *pushQueue {
+	if atomic.LoadInt32(&monitorCount) == 0 {
+		return nil
+	}
+	monitorsMu.Lock()
+	defer monitorsMu.Unlock()
+	return monitors[c]
+}
+
+func stopMonitor(c *client) {
+	monitorsMu.Lock()
+	defer monitorsMu.Unlock()
+	removeMonitor(c)
+}
+
+//removeMonitor must be called with monitorsMu held
+func removeMonitor(c *client) {
+	if m, ok := monitors[c]; ok {
+		delete(monitors, c)
+		atomic.AddInt32(&monitorCount, -1)
+		m.stop()
+	}
+}
+
+//feedMonitors sends a command to every monitor in the format of redis:
+//1339518083.107412 [0 127.0.0.1:60866] "keys" "*"
+func feedMonitors(c *client, name string, args []string) {
+	if atomic.LoadInt32(&monitorCount) == 0 {
+		return
+	}
+	now := time.Now()
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d.%06d [0 ", now.Unix(), now.Nanosecond()/1000)
+	if c.isUnixSocket() {
+		buf.WriteString("unix:")
+		buf.WriteString(c.con.LocalAddr().String())
+	} else {
+		buf.WriteString(c.con.RemoteAddr().String())
+	}
+	buf.WriteByte(']')
+	buf.WriteByte(' ')
+	writeQuoted(&buf, name)
+	for _, arg := range args {
+		buf.WriteByte(' ')
+		writeQuoted(&buf, arg)
+	}
+
+	line := protocol.NewString(buf.String())
+	monitorsMu.Lock()
+	defer monitorsMu.Unlock()
+	for mc, m := range monitors {
+		//the monitor fell behind, it is dropped and its push queue closes the connection
+		if !m.push(line) {
+			removeMonitor(mc)
+		}
+	}
+}
+
+//writeQuoted writes s in double quotes, escaping the characters which are not printable
+func writeQuoted(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch b := s[i]; b {
+		case '\\', '"':
+			buf.WriteByte('\\')
+			buf.WriteByte(b)
+		case '\n':
+			buf.WriteString("\\n")
+		case '\r':
+			buf.WriteString("\\r")
+		case '\t':
+			buf.WriteString("\\t")
+		case '\a':
+			buf.WriteString("\\a")
+		case '\b':
+			buf.WriteString("\\b")
+		default:
+			if b < 0x20 || b > 0x7e {
+				fmt.Fprintf(buf, "\\x%02x", b)
+			} else {
+				buf.WriteByte(b)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+//https://redis.io/commands/monitor
+var monitorFunc = func(args []string, r protocol.RedisRW) error {
+	c := clientOf(r)
+	monitorsMu.Lock()
+	defer monitorsMu.Unlock()
+	if _, ok := monitors[c]; ok {
+		return nil
+	}
+	m := startPushQueue(c.con)
+	monitors[c] = m
+	atomic.AddInt32(&monitorCount, 1)
+	m.push(protocol.NewString("OK"))
+	return nil
+}
@@ -0,0 +1,70 @@
+package command
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+//waitLines waits for the push queue of the client to write n lines and returns them
+func waitLines(t *testing.T, c *testClient, n int) []string {
+	var out string
+	deadline := time.Now().Add(5 * time.Second)
+	for strings.Count(out, "\r\n") < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %q, want %d lines", out, n)
+		}
+		time.Sleep(time.Millisecond)
+		out += c.con.take()
+	}
+	return strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n")
+}
+
+func TestMonitor(t *testing.T) {
+	writer := newTestClient(t)
+	monitor := newTestClient(t)
+	assert.Equal(t, "", monitor.do("monitor"), "the reply is written by the push queue")
+	assert.Equal(t, []string{"+OK"}, waitLines(t, monitor, 1))
+
+	writer.do("set", "monitor:k", "a \"b\"\n\x01")
+	//the admin commands and the ones with secrets aren't shown
+	writer.do("acl", "whoami")
+	writer.do("auth", "secret")
+	writer.do("get", "monitor:k")
+	lines := waitLines(t, monitor, 2)
+	assert.Regexp(t, regexp.MustCompile(`^\+\d+\.\d{6} \[0 127\.0\.0\.1:50000\] "set" "monitor:k" "a \\"b\\"\\n\\x01"$`), lines[0])
+	assert.Regexp(t, regexp.MustCompile(`^\+\d+\.\d{6} \[0 127\.0\.0\.1:50000\] "get" "monitor:k"$`), lines[1])
+
+	monitor.do("get", "monitor:k")
+	assert.Equal(t, []string{"-ERR Can't execute 'get': only QUIT is allowed in MONITOR mode"}, waitLines(t, monitor, 1))
+
+	//QUIT stops the monitor
+	monitor.do("quit")
+	writer.do("get", "monitor:k")
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, "", monitor.con.take())
+}
+
+func Test_writeQuoted(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want string
+	}{
+		{"plain", "key", `"key"`},
+		{"empty", "", `""`},
+		{"quotes", `a"b\c`, `"a\"b\\c"`},
+		{"escapes", "\n\r\t\a\b", `"\n\r\t\a\b"`},
+		{"not printable", "\x00\x7f\xe4", `"\x00\x7f\xe4"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			writeQuoted(&buf, tt.s)
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package command
+
+import (
+	"github.com/medusar/lucas/protocol"
+	"github.com/medusar/lucas/util"
+)
+
+var (
+	//pubsubChannels maps a channel to the clients subscribed to it
+	pubsubChannels = make(map[string]map[*client]struct{})
+	//allowedInPubSub are the commands a RESP2 client can still run after subscribing to a channel
+	allowedInPubSub = map[string]bool{"subscribe": true, "unsubscribe": true, "ping": true, "quit": true}
+)
+
+//inPubSubContext reports whether the client is restricted to pub/sub commands,
+//RESP3 clients can run any command because messages are sent as push data.
+func (c *client) inPubSubContext() bool {
+	return len(c.channels) > 0 && c.con.Protocol() != protocol.Resp3
+}
+
+//writePubSub writes a pub/sub message or the reply of (UN)SUBSCRIBE
+func writePubSub(r protocol.RedisRW, msg []*protocol.Resp) error {
+	return r.WriteResp(pubsubMessage(r, msg))
+}
+
+//pubsubMessage returns a pub/sub message as push data in RESP3 and as an array in RESP2
+func pubsubMessage(r protocol.RedisRW, msg []*protocol.Resp) *protocol.Resp {
+	if r.Protocol() == protocol.Resp3 {
+		return protocol.NewPush(msg)
+	}
+	return protocol.NewArray(msg)
+}
+
+func subscribe(c *client, channel string) {
+	if _, ok := c.channels[channel]; ok {
+		return
+	}
+	c.startPushes()
+	c.channels[channel] = struct{}{}
+	subscribers, ok := pubsubChannels[channel]
+	if !ok {
+		subscribers = make(map[*client]struct{})
+		pubsubChannels[channel] = subscribers
+	}
+	subscribers[c] = struct{}{}
+}
+
+func unsubscribe(c *client, channel string) {
+	if _, ok := c.channels[channel]; !ok {
+		return
+	}
+	delete(c.channels, channel)
+	subscribers := pubsubChannels[channel]
+	delete(subscribers, c)
+	if len(subscribers) == 0 {
+		delete(pubsubChannels, channel)
+	}
+}
+
+func unsubscribeAll(c *client) {
+	for channel := range c.channels {
+		unsubscribe(c, channel)
+	}
+}
+
+//channelsOf returns the channels used as arguments of a pub/sub command
+func channelsOf(name string, args []string) []string {
+	switch name {
+	case "subscribe":
+		return args
+	case "publish":
+		if len(args) > 0 {
+			return args[:1]
+		}
+	}
+	return nil
+}
+
+func pubsubReply(kind string, channel *protocol.Resp, count int) []*protocol.Resp {
+	return []*protocol.Resp{protocol.NewBulk(kind), channel, protocol.NewInteger(count)}
+}
+
+//https://redis.io/commands/subscribe
+var subscribeFunc = func(args []string, r protocol.RedisRW) error {
+	c := clientOf(r)
+	for _, channel := range args {
+		subscribe(c, channel)
+		if err := writePubSub(r, pubsubReply("subscribe", protocol.NewBulk(channel), len(c.channels))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//https://redis.io/commands/unsubscribe
+var unsubscribeFunc = func(args []string, r protocol.RedisRW) error {
+	c := clientOf(r)
+	channels := args
+	if len(channels) == 0 {
+		if len(c.channels) == 0 {
+			return writePubSub(r, pubsubReply("unsubscribe", protocol.NewNil(), 0))
+		}
+		for channel := range c.channels {
+			channels = append(channels, channel)
+		}
+	}
+	for _, channel := range channels {
+		unsubscribe(c, channel)
+		if err := writePubSub(r, pubsubReply("unsubscribe", protocol.NewBulk(channel), len(c.channels))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//https://redis.io/commands/publish
+//The message is queued for every subscriber, the arguments are copied because they are views into the buffer of the connection.
+var publishFunc = func(args []string, r protocol.RedisRW) error {
+	subscribers := pubsubChannels[args[0]]
+	if len(subscribers) == 0 {
+		return r.WriteInteger(0)
+	}
+	msg := toBulkArray([]string{"message", util.Clone(args[0]), util.Clone(args[1])})
+	for c := range subscribers {
+		c.push(pubsubMessage(c.con, msg))
+	}
+	return r.WriteInteger(len(subscribers))
+}
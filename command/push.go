@@ -0,0 +1,141 @@
+package command
+
+import (
+	"github.com/medusar/lucas/protocol"
+	"sync"
+)
+
+const (
+	//pushBufferSize is the number of messages buffered for a client by pub/sub, tracking or MONITOR,
+	//the client is disconnected once it falls behind by more than that.
+	pushBufferSize = 1024
+	//pushMaxPending is the most bytes a connection which never blocks its writers may hold for the client,
+	//the queue of a reactor connection is always empty and the client is disconnected on this limit instead.
+	pushMaxPending = 32 << 20
+)
+
+//pendingWriter is a connection buffering the replies the socket can't take yet, like the ones of the reactor
+type pendingWriter interface {
+	Pending() int
+}
+
+//aborter is a connection which can be closed without writing what it buffers, like BufRedisConn
+type aborter interface {
+	Abort()
+}
+
+//pushQueue writes the messages sent to a client by the commands of other clients in its own goroutine,
+//so a command never writes to another connection while it holds the locks of the keyspace.
+type pushQueue struct {
+	con protocol.RedisRW
+	ch  chan *protocol.Resp
+	//ready wakes the goroutine up, it's closed to stop the goroutine
+	ready chan struct{}
+	//mu is held while the messages are written, so they keep their order whoever writes them
+	mu sync.Mutex
+	//stateMu guards stopped and overflow, it's never held while writing so the pushers can't be blocked by the socket
+	stateMu sync.Mutex
+	stopped bool
+	//overflow is set when the buffer is full, the goroutine closes the connection and the next messages are dropped
+	overflow bool
+}
+
+func startPushQueue(con protocol.RedisRW) *pushQueue {
+	q := &pushQueue{con: con, ch: make(chan *protocol.Resp, pushBufferSize), ready: make(chan struct{}, 1)}
+	go func() {
+		for range q.ready {
+			q.flush()
+		}
+	}()
+	return q
+}
+
+//push queues a message without blocking, it reports false when the buffer is full.
+//The connection of a client falling behind is closed by the goroutine, not by the pusher:
+//closing it flushes the replies buffered for the socket, which would block the executor holding the keyspace locks.
+//A connection which can be aborted is closed at once, so a goroutine stuck writing to the socket returns.
+func (q *pushQueue) push(resp *protocol.Resp) bool {
+	q.stateMu.Lock()
+	defer q.stateMu.Unlock()
+	if q.stopped || q.overflow {
+		return false
+	}
+	ok := true
+	select {
+	case q.ch <- resp:
+	default:
+		q.overflow, ok = true, false
+		if a, isAborter := q.con.(aborter); isAborter {
+			a.Abort()
+		}
+	}
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return ok
+}
+
+func (q *pushQueue) overflowed() bool {
+	q.stateMu.Lock()
+	defer q.stateMu.Unlock()
+	return q.overflow
+}
+
+//flush writes the queued messages, the connection is closed if it fails or if the buffer overflowed
+func (q *pushQueue) flush() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.overflowed() {
+		q.con.Close()
+		return
+	}
+	for {
+		select {
+		case resp := <-q.ch:
+			if p, ok := q.con.(pendingWriter); ok && p.Pending() > pushMaxPending {
+				q.con.Close()
+				return
+			}
+			if err := q.con.WriteResp(resp); err != nil {
+				q.con.Close()
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+//stop ends the goroutine once it handled the last signal, the messages pushed after it are dropped
+func (q *pushQueue) stop() {
+	q.stateMu.Lock()
+	defer q.stateMu.Unlock()
+	if !q.stopped {
+		q.stopped = true
+		close(q.ready)
+	}
+}
+
+//startPushes gives the client a push queue before it may receive pub/sub or invalidation messages.
+//It's called by the commands running exclusively, the other ones only read c.pushes.
+func (c *client) startPushes() {
+	if c.pushes == nil {
+		c.pushes = startPushQueue(c.con)
+	}
+}
+
+//push queues a pub/sub or invalidation message for the client, which is disconnected when it falls behind
+func (c *client) push(resp *protocol.Resp) {
+	if c.pushes != nil {
+		c.pushes.push(resp)
+	}
+}
+
+//flushPushes writes the messages queued for the client before the reply of its next command,
+//so a message sent before the command is received before its reply.
+func (c *client) flushPushes() {
+	if c.pushes != nil {
+		c.pushes.flush()
+	}
+}
@@ -8,9 +8,6 @@ import (
 
 //https://redis.io/commands/sadd
 var saddFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) < 2 {
-		return r.WriteError("ERR wrong number of arguments for 'sadd' command")
-	}
 	l, err := store.Sadd(args[0], args[1:])
 	if err != nil {
 		return r.WriteError(err.Error())
@@ -20,9 +17,6 @@ var saddFunc = func(args []string, r protocol.RedisRW) error {
 
 //https://redis.io/commands/scard
 var scardFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 1 {
-		return r.WriteError("ERR wrong number of arguments for 'scard' command")
-	}
 	l, err := store.Scard(args[0])
 	if err != nil {
 		return r.WriteError(err.Error())
@@ -32,9 +26,6 @@ var scardFunc = func(args []string, r protocol.RedisRW) error {
 
 //https://redis.io/commands/sdiff
 var sdiffFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) < 1 {
-		return r.WriteError("ERR wrong number of arguments for 'sdiff' command")
-	}
 	if len(args) == 1 {
 		return smembersFunc(args, r)
 	}
@@ -47,14 +38,11 @@ var sdiffFunc = func(args []string, r protocol.RedisRW) error {
 	for i := 0; i < len(set); i++ {
 		ret[i] = protocol.NewBulk(set[i])
 	}
-	return r.WriteArray(ret)
+	return r.WriteSet(ret)
 }
 
 //https://redis.io/commands/smembers
 var smembersFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 1 {
-		return r.WriteError("ERR wrong number of arguments for 'smembers' command")
-	}
 	keys, err := store.Smembers(args[0])
 	if err != nil {
 		return r.WriteError(err.Error())
@@ -63,14 +51,11 @@ var smembersFunc = func(args []string, r protocol.RedisRW) error {
 	for i := 0; i < len(keys); i++ {
 		ret[i] = protocol.NewBulk(keys[i])
 	}
-	return r.WriteArray(ret)
+	return r.WriteSet(ret)
 }
 
 //https://redis.io/commands/sismember
 var sismemberFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 2 {
-		return r.WriteError("ERR wrong number of arguments for 'sismember' command")
-	}
 	is, err := store.Sismember(args[0], args[1])
 	if err != nil {
 		return r.WriteError(err.Error())
@@ -82,39 +67,97 @@ var sismemberFunc = func(args []string, r protocol.RedisRW) error {
 }
 
 //https://redis.io/commands/spop
+//SPOP key [count]
 var spopFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) > 2 || len(args) <= 0 {
-		return r.WriteError("ERR wrong number of arguments for 'spop' command")
+	if len(args) > 2 {
+		return r.WriteError(errSyntax)
 	}
-
-	count := 1
-	if len(args) == 2 {
-		i, err := strconv.Atoi(args[1])
+	if len(args) == 1 {
+		removed, err := store.Spop(args[0], 1)
 		if err != nil {
-			return r.WriteError("ERR value is not an integer or out of range")
+			return r.WriteError(err.Error())
 		}
-		if i < 0 {
-			return r.WriteError("ERR index out of range")
+		if len(removed) == 0 {
+			return r.WriteNil()
 		}
-		count = i
+		return r.WriteBulk(removed[0])
 	}
 
+	count, err := strconv.Atoi(args[1])
+	if err != nil || count < 0 {
+		return r.WriteError(errZpopCount)
+	}
 	removed, err := store.Spop(args[0], count)
 	if err != nil {
 		return r.WriteError(err.Error())
 	}
-	if removed != nil {
-		return r.WriteArray(toBulkArray(removed))
+	return r.WriteSet(toBulkArray(removed))
+}
+
+//https://redis.io/commands/srandmember
+//SRANDMEMBER key [count]
+var srandmemberFunc = func(args []string, r protocol.RedisRW) error {
+	if len(args) > 2 {
+		return r.WriteError(errSyntax)
+	}
+	if len(args) == 1 {
+		members, err := store.Srandmember(args[0], 1)
+		if err != nil {
+			return r.WriteError(err.Error())
+		}
+		if len(members) == 0 {
+			return r.WriteNil()
+		}
+		return r.WriteBulk(members[0])
+	}
+
+	count, err := strconv.Atoi(args[1])
+	if err != nil {
+		return r.WriteError(errNotInteger)
+	}
+	if count < -randCountLimit || count > randCountLimit {
+		return r.WriteError(errRandRange)
+	}
+	members, err := store.Srandmember(args[0], count)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	return r.WriteArray(toBulkArray(members))
+}
+
+//https://redis.io/commands/smismember
+var smismemberFunc = func(args []string, r protocol.RedisRW) error {
+	is, err := store.Smismember(args[0], args[1:])
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	ret := make([]*protocol.Resp, len(is))
+	for i, b := range is {
+		if b {
+			ret[i] = protocol.NewInteger(1)
+		} else {
+			ret[i] = protocol.NewInteger(0)
+		}
 	}
-	return r.WriteNil()
+	return r.WriteArray(ret)
 }
 
-//https://redis.io/commands/sdiffstore
-var sdiffStoreFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) < 2 {
-		return r.WriteError("ERR wrong number of arguments for 'sdiffstore' command")
+//https://redis.io/commands/sintercard
+//SINTERCARD numkeys key [key ...] [LIMIT limit]
+var sintercardFunc = func(args []string, r protocol.RedisRW) error {
+	keys, limit, msg := parseIntercardArgs(args)
+	if msg != "" {
+		return r.WriteError(msg)
+	}
+	n, err := store.Sintercard(keys, limit)
+	if err != nil {
+		return r.WriteError(err.Error())
 	}
+	return r.WriteInteger(n)
+}
 
+//https://redis.io/commands/sdiffstore
+var sdiffStoreFunc = func(args []string, r protocol.RedisRW) error {
 	var n int
 	var err error
 
@@ -132,9 +175,6 @@ var sdiffStoreFunc = func(args []string, r protocol.RedisRW) error {
 
 //https://redis.io/commands/sinter
 var sinterFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) < 1 {
-		return r.WriteError("ERR wrong number of arguments for 'sinter' command")
-	}
 	if len(args) == 1 {
 		return smembersFunc(args, r)
 	}
@@ -147,15 +187,11 @@ var sinterFunc = func(args []string, r protocol.RedisRW) error {
 	for i := 0; i < len(set); i++ {
 		ret[i] = protocol.NewBulk(set[i])
 	}
-	return r.WriteArray(ret)
+	return r.WriteSet(ret)
 }
 
 //https://redis.io/commands/sinterstore
 var sinterStoreFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) < 2 {
-		return r.WriteError("ERR wrong number of arguments for 'sinterstore' command")
-	}
-
 	var n int
 	var err error
 
@@ -172,9 +208,6 @@ var sinterStoreFunc = func(args []string, r protocol.RedisRW) error {
 
 //https://redis.io/commands/srem
 var sremFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) < 2 {
-		return r.WriteError("ERR wrong number of arguments for 'srem' command")
-	}
 	n, err := store.Srem(args[0], args[1:])
 	if err != nil {
 		return r.WriteError(err.Error())
@@ -184,9 +217,6 @@ var sremFunc = func(args []string, r protocol.RedisRW) error {
 
 //https://redis.io/commands/sunion
 var sunionFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) < 1 {
-		return r.WriteError("ERR wrong number of arguments for 'sunion' command")
-	}
 	set, err := store.Sunion(args)
 	if err != nil {
 		return r.WriteError(err.Error())
@@ -195,15 +225,11 @@ var sunionFunc = func(args []string, r protocol.RedisRW) error {
 	for i := 0; i < len(set); i++ {
 		ret[i] = protocol.NewBulk(set[i])
 	}
-	return r.WriteArray(ret)
+	return r.WriteSet(ret)
 }
 
 //https://redis.io/commands/sunionstore
 var sunionStoreFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) < 2 {
-		return r.WriteError("ERR wrong number of arguments for 'sunionstore' command")
-	}
-
 	var n int
 	var err error
 
@@ -220,9 +246,6 @@ var sunionStoreFunc = func(args []string, r protocol.RedisRW) error {
 
 //https://redis.io/commands/smove
 var smoveFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 3 {
-		return r.WriteError("ERR wrong number of arguments for 'smove' command")
-	}
 	n, err := store.Smove(args[0], args[1], args[2])
 	if err != nil {
 		return r.WriteError(err.Error())
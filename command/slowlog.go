@@ -7,17 +7,27 @@ import (
 )
 
 //WithTime will monitor the time a request costs,
-// if it costs more than 10 microseconds it will be added to the slow log log
+// if it costs more than the slowlog threshold it will be added to the slow log.
+//The time is also added to the latency histogram of the command and to the latency monitor.
+//Only the first execution of a blocked command is tracked, not the ones after its keys are modified.
 func WithTime(realFunc cmdFunc) cmdFunc {
 	return func(args []string, r protocol.RedisRW) error {
-		defer timeTrack(time.Now(), args)
+		if clientOf(r).retried != nil {
+			return realFunc(args, r)
+		}
+		defer timeTrack(time.Now(), args, r)
 		return realFunc(args, r)
 	}
 }
 
-func timeTrack(start time.Time, args []string) {
+func timeTrack(start time.Time, args []string, r protocol.RedisRW) {
 	takes := time.Since(start)
-	if takes > 10*time.Microsecond {
-		store.AddSlowLog(start, args, int64(takes/time.Microsecond))
+	store.AddSlowLog(start, args, int64(takes/time.Microsecond))
+	name := clientOf(r).lastCmd
+	store.RecordCommandLatency(name, takes)
+	if cmdInfoMap[name].hasFlag("fast") {
+		store.AddLatencySample("fast-command", takes)
+	} else {
+		store.AddLatencySample("command", takes)
 	}
 }
@@ -1,15 +1,16 @@
 package command
 
 import (
+	"errors"
+	"fmt"
 	"github.com/medusar/lucas/protocol"
 	"github.com/medusar/lucas/store"
+	"math"
 	"strconv"
+	"time"
 )
 
 var getFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 1 {
-		return r.WriteError("ERR wrong number of arguments for 'get' command")
-	}
 	val, e := store.Get(args[0])
 	if e != nil {
 		return r.WriteError(e.Error())
@@ -20,18 +21,122 @@ var getFunc = func(args []string, r protocol.RedisRW) error {
 	return r.WriteBulk(*val)
 }
 
+//the options of SET, the expiration options and the conditions exclude each other
+var setOptions = []argOption{
+	{name: "nx", conflicts: []string{"xx"}},
+	{name: "xx", conflicts: []string{"nx"}},
+	{name: "get"},
+	{name: "ex", nargs: 1, conflicts: []string{"px", "exat", "pxat", "keepttl"}},
+	{name: "px", nargs: 1, conflicts: []string{"ex", "exat", "pxat", "keepttl"}},
+	{name: "exat", nargs: 1, conflicts: []string{"ex", "px", "pxat", "keepttl"}},
+	{name: "pxat", nargs: 1, conflicts: []string{"ex", "px", "exat", "keepttl"}},
+	{name: "keepttl", conflicts: []string{"ex", "px", "exat", "pxat"}},
+}
+
+//https://redis.io/commands/set
+//SET key value [NX | XX] [GET] [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | KEEPTTL]
 var setFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 2 {
-		return r.WriteError("ERR wrong number of arguments for 'set' command")
+	opts, err := parseOptions(args[2:], setOptions)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	expireAt, err := parseExpireAt(opts, "set")
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	get := opts.has("get")
+	old, set, err := store.SetWith(args[0], args[1], &store.SetOptions{
+		NX: opts.has("nx"), XX: opts.has("xx"), ExpireAt: expireAt, KeepTTL: opts.has("keepttl"), Get: get})
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	if get {
+		if old == nil {
+			return r.WriteNil()
+		}
+		return r.WriteBulk(*old)
 	}
-	store.Set(args[0], args[1])
-	return r.WriteString("OK") //TODO:support NX, EX
+	if !set {
+		return r.WriteNil()
+	}
+	return r.WriteString("OK")
 }
 
-var getsetFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 2 {
-		return r.WriteError("ERR wrong number of arguments for 'getset' command")
+//parseExpireAt returns the unix time in milliseconds set by the EX, PX, EXAT or PXAT option, 0 without any
+func parseExpireAt(opts parsedOptions, cmd string) (int64, error) {
+	for _, name := range []string{"ex", "px", "exat", "pxat"} {
+		if !opts.has(name) {
+			continue
+		}
+		n, err := strconv.ParseInt(opts[name][0], 10, 64)
+		if err != nil {
+			return 0, errors.New(errNotInteger)
+		}
+		invalid := fmt.Errorf("ERR invalid expire time in '%s' command", cmd)
+		seconds := name == "ex" || name == "exat"
+		if n <= 0 || (seconds && n > math.MaxInt64/1000) {
+			return 0, invalid
+		}
+		if seconds {
+			n *= 1000
+		}
+		if name == "ex" || name == "px" {
+			n += time.Now().UnixNano() / int64(time.Millisecond)
+			if n <= 0 {
+				return 0, invalid
+			}
+		}
+		return n, nil
+	}
+	return 0, nil
+}
+
+//the options of GETEX, they exclude each other
+var getexOptions = []argOption{
+	{name: "ex", nargs: 1, conflicts: []string{"px", "exat", "pxat", "persist"}},
+	{name: "px", nargs: 1, conflicts: []string{"ex", "exat", "pxat", "persist"}},
+	{name: "exat", nargs: 1, conflicts: []string{"ex", "px", "pxat", "persist"}},
+	{name: "pxat", nargs: 1, conflicts: []string{"ex", "px", "exat", "persist"}},
+	{name: "persist", conflicts: []string{"ex", "px", "exat", "pxat"}},
+}
+
+//https://redis.io/commands/getex
+//GETEX key [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | PERSIST]
+var getexFunc = func(args []string, r protocol.RedisRW) error {
+	opts, err := parseOptions(args[1:], getexOptions)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	expireAt, err := parseExpireAt(opts, "getex")
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	if opts.has("persist") {
+		expireAt = -1
+	}
+	val, err := store.GetEx(args[0], expireAt)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	if val == nil {
+		return r.WriteNil()
 	}
+	return r.WriteBulk(*val)
+}
+
+//https://redis.io/commands/getdel
+var getdelFunc = func(args []string, r protocol.RedisRW) error {
+	val, err := store.GetDel(args[0])
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	if val == nil {
+		return r.WriteNil()
+	}
+	return r.WriteBulk(*val)
+}
+
+var getsetFunc = func(args []string, r protocol.RedisRW) error {
 	val, e := store.GetSet(args[0], args[1])
 	if e != nil {
 		return r.WriteError(e.Error())
@@ -43,9 +148,6 @@ var getsetFunc = func(args []string, r protocol.RedisRW) error {
 }
 
 var setexFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 3 {
-		return r.WriteError("ERR wrong number of arguments for 'setex' command")
-	}
 	key, sec, val := args[0], args[1], args[2]
 	ttl, err := strconv.Atoi(sec)
 	if err != nil {
@@ -60,9 +162,6 @@ var setexFunc = func(args []string, r protocol.RedisRW) error {
 }
 
 var setnxFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 2 {
-		return r.WriteError("ERR wrong number of arguments for 'setnx' command")
-	}
 	key, val := args[0], args[1]
 	if set := store.SetNX(key, val); set {
 		return r.WriteInteger(1)
@@ -71,9 +170,6 @@ var setnxFunc = func(args []string, r protocol.RedisRW) error {
 }
 
 var setRangeFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 3 {
-		return r.WriteError("ERR wrong number of arguments for 'setrange' command")
-	}
 	key, offset, val := args[0], args[1], args[2]
 	intOff, e := strconv.Atoi(offset)
 	if e != nil {
@@ -87,9 +183,6 @@ var setRangeFunc = func(args []string, r protocol.RedisRW) error {
 }
 
 var getRangeFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 3 {
-		return r.WriteError("ERR wrong number of arguments for 'getrange' command")
-	}
 	key, start, end := args[0], args[1], args[2]
 	startInt, e := strconv.Atoi(start)
 	if e != nil {
@@ -107,9 +200,6 @@ var getRangeFunc = func(args []string, r protocol.RedisRW) error {
 }
 
 var appendFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 2 {
-		return r.WriteError("ERR wrong number of arguments for 'append' command")
-	}
 	l, e := store.Append(args[0], args[1])
 	if e != nil {
 		return r.WriteError(e.Error())
@@ -118,9 +208,6 @@ var appendFunc = func(args []string, r protocol.RedisRW) error {
 }
 
 var mgetFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) == 0 {
-		return r.WriteError("ERR wrong number of arguments for 'mget' command")
-	}
 	values := store.Mget(args)
 	ret := make([]*protocol.Resp, len(values))
 	for i, v := range values {
@@ -135,17 +222,25 @@ var mgetFunc = func(args []string, r protocol.RedisRW) error {
 
 //https://redis.io/commands/mset
 var msetFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) == 0 || len(args)%2 != 0 {
+	if len(args)%2 != 0 {
 		return r.WriteError("ERR wrong number of arguments for 'mset' command")
 	}
 	store.Mset(args)
 	return r.WriteString("OK")
 }
 
-var strlenFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 1 {
-		return r.WriteError("ERR wrong number of arguments for 'strlen' command")
+//https://redis.io/commands/msetnx
+var msetnxFunc = func(args []string, r protocol.RedisRW) error {
+	if len(args)%2 != 0 {
+		return r.WriteError("ERR wrong number of arguments for 'msetnx' command")
+	}
+	if store.Msetnx(args) {
+		return r.WriteInteger(1)
 	}
+	return r.WriteInteger(0)
+}
+
+var strlenFunc = func(args []string, r protocol.RedisRW) error {
 	n, e := store.StrLen(args[0])
 	if e != nil {
 		return r.WriteError(e.Error())
@@ -154,9 +249,6 @@ var strlenFunc = func(args []string, r protocol.RedisRW) error {
 }
 
 var incrFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 1 {
-		return r.WriteError("ERR wrong number of arguments for 'incr' command")
-	}
 	v, e := store.Incr(args[0])
 	if e != nil {
 		return r.WriteError(e.Error())
@@ -165,10 +257,6 @@ var incrFunc = func(args []string, r protocol.RedisRW) error {
 }
 
 var incrByFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 2 {
-		return r.WriteError("ERR wrong number of arguments for 'incrby' command")
-	}
-
 	key, val := args[0], args[1]
 	intV, e := strconv.Atoi(val)
 	if e != nil {
@@ -182,10 +270,20 @@ var incrByFunc = func(args []string, r protocol.RedisRW) error {
 	return r.WriteInteger(v)
 }
 
-var decrFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 1 {
-		return r.WriteError("ERR wrong number of arguments for 'decr' command")
+//https://redis.io/commands/incrbyfloat
+var incrByFloatFunc = func(args []string, r protocol.RedisRW) error {
+	incr, err := strconv.ParseFloat(args[1], 64)
+	if err != nil || math.IsNaN(incr) {
+		return r.WriteError(errNotFloat)
 	}
+	v, err := store.IncrByFloat(args[0], incr)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	return r.WriteBulk(v)
+}
+
+var decrFunc = func(args []string, r protocol.RedisRW) error {
 	v, e := store.IncrBy(args[0], -1)
 	if e != nil {
 		return r.WriteError(e.Error())
@@ -194,10 +292,6 @@ var decrFunc = func(args []string, r protocol.RedisRW) error {
 }
 
 var decrByFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 2 {
-		return r.WriteError("ERR wrong number of arguments for 'decrby' command")
-	}
-
 	key, val := args[0], args[1]
 	intV, e := strconv.Atoi(val)
 	if e != nil {
@@ -213,12 +307,9 @@ var decrByFunc = func(args []string, r protocol.RedisRW) error {
 
 //https://redis.io/commands/setbit
 var setbitFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 3 {
-		return r.WriteError("ERR wrong number of arguments for 'setbit' command")
-	}
 	offset, e := strconv.Atoi(args[1])
 	if e != nil {
-		return r.WriteError("ERR offset is not an integer or out of range")
+		return r.WriteError(errBitOffset)
 	}
 	bit, e := strconv.Atoi(args[2])
 	if e != nil {
@@ -233,13 +324,9 @@ var setbitFunc = func(args []string, r protocol.RedisRW) error {
 
 //https://redis.io/commands/getbit
 var getbitFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 2 {
-		return r.WriteError("ERR wrong number of arguments for 'getbit' command")
-	}
-
 	offset, e := strconv.Atoi(args[1])
 	if e != nil {
-		return r.WriteError("ERR offset is not an integer or out of range")
+		return r.WriteError(errBitOffset)
 	}
 
 	n, err := store.GetBit(args[0], offset)
@@ -252,7 +339,7 @@ var getbitFunc = func(args []string, r protocol.RedisRW) error {
 //https://redis.io/commands/bitcount
 var bitcountFunc = func(args []string, r protocol.RedisRW) error {
 	if len(args) != 3 && len(args) != 1 {
-		return r.WriteError("ERR wrong number of arguments for 'bitcount' command")
+		return r.WriteError(errSyntax)
 	}
 
 	start := 0
@@ -261,11 +348,11 @@ var bitcountFunc = func(args []string, r protocol.RedisRW) error {
 	if len(args) == 3 {
 		start, e = strconv.Atoi(args[1])
 		if e != nil {
-			return r.WriteError("ERR offset is not an integer or out of range")
+			return r.WriteError(errNotInteger)
 		}
 		end, e = strconv.Atoi(args[2])
 		if e != nil {
-			return r.WriteError("ERR offset is not an integer or out of range")
+			return r.WriteError(errNotInteger)
 		}
 	}
 
@@ -276,10 +363,60 @@ var bitcountFunc = func(args []string, r protocol.RedisRW) error {
 	return r.WriteInteger(n)
 }
 
+//the options of LCS
+var lcsOptions = []argOption{{name: "len"}, {name: "idx"}, {name: "minmatchlen", nargs: 1}, {name: "withmatchlen"}}
+
+//https://redis.io/commands/lcs
+//LCS key1 key2 [LEN] [IDX] [MINMATCHLEN min-match-len] [WITHMATCHLEN]
+var lcsFunc = func(args []string, r protocol.RedisRW) error {
+	opts, err := parseOptions(args[2:], lcsOptions)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	getLen, getIdx, withMatchLen := opts.has("len"), opts.has("idx"), opts.has("withmatchlen")
+	minMatchLen := 0
+	if opts.has("minmatchlen") {
+		n, err := opts.int("minmatchlen", 0)
+		if err != nil {
+			return r.WriteError(err.Error())
+		}
+		if n > 0 {
+			minMatchLen = n
+		}
+	}
+	if getLen && getIdx {
+		return r.WriteError("ERR If you want both the length and indexes, please just use IDX.")
+	}
+
+	lcs, matches, err := store.Lcs(args[0], args[1], getIdx, minMatchLen)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	if getLen {
+		return r.WriteInteger(len(lcs))
+	}
+	if !getIdx {
+		return r.WriteBulk(lcs)
+	}
+	arr := make([]*protocol.Resp, len(matches))
+	for i, m := range matches {
+		match := []*protocol.Resp{
+			protocol.NewArray([]*protocol.Resp{protocol.NewInteger(m.A[0]), protocol.NewInteger(m.A[1])}),
+			protocol.NewArray([]*protocol.Resp{protocol.NewInteger(m.B[0]), protocol.NewInteger(m.B[1])}),
+		}
+		if withMatchLen {
+			match = append(match, protocol.NewInteger(m.Len))
+		}
+		arr[i] = protocol.NewArray(match)
+	}
+	return r.WriteMap([]*protocol.Resp{
+		protocol.NewBulk("matches"), protocol.NewArray(arr),
+		protocol.NewBulk("len"), protocol.NewInteger(len(lcs)),
+	})
+}
+
 //TODO:
 //https://redis.io/commands/bitfield
 //https://redis.io/commands/bitop
 //https://redis.io/commands/bitpos
-//https://redis.io/commands/incrbyfloat
-//https://redis.io/commands/msetnx
 //https://redis.io/commands/psetex
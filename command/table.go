@@ -0,0 +1,165 @@
+package command
+
+//cmdInfoTable describes every supported command in the same way as the reply of the COMMAND command,
+//see https://redis.io/commands/command
+var cmdInfoTable = []*RedisCmdInfo{
+	{Name: "command", Arity: -1, Flags: []string{"random", "loading", "stale"}, Group: "server", Summary: "Returns detailed information about all commands.", Since: "2.8.13", Complexity: "O(N) where N is the total number of Redis commands"},
+	{Name: "acl", Arity: -2, Flags: []string{"admin", "noscript", "loading", "stale", "skip_slowlog"}, Group: "server", Summary: "A container for Access List Control commands.", Since: "6.0.0", Complexity: "Depends on subcommand."},
+	{Name: "latency", Arity: -2, Flags: []string{"admin", "noscript", "loading", "stale"}, Group: "server", Summary: "A container for latency diagnostics commands.", Since: "2.8.13", Complexity: "Depends on subcommand."},
+	{Name: "monitor", Arity: 1, Flags: []string{"admin", "noscript", "loading", "stale"}, Group: "server", Summary: "Listens for all requests received by the server in real-time.", Since: "1.0.0"},
+
+	//connection
+	{Name: "ping", Arity: -1, Flags: []string{"stale", "fast"}, Group: "connection", Summary: "Returns the server's liveliness response.", Since: "1.0.0", Complexity: "O(1)"},
+	{Name: "quit", Arity: -1, Flags: []string{"loading", "stale", "fast", "no_auth"}, Group: "connection", Summary: "Closes the connection.", Since: "1.0.0", Complexity: "O(1)"},
+	{Name: "hello", Arity: -1, Flags: []string{"noscript", "loading", "stale", "fast", "no_auth"}, Group: "connection", Summary: "Handshakes with the Redis server.", Since: "6.0.0", Complexity: "O(1)"},
+	{Name: "auth", Arity: -2, Flags: []string{"noscript", "loading", "stale", "skip_monitor", "skip_slowlog", "fast", "no_auth"}, Group: "connection", Summary: "Authenticates the connection.", Since: "1.0.0", Complexity: "O(N) where N is the number of passwords defined for the user"},
+	{Name: "client", Arity: -2, Flags: []string{"admin", "noscript", "random", "loading", "stale"}, Group: "connection", Summary: "A container for client connection commands.", Since: "2.4.0", Complexity: "Depends on subcommand."},
+
+	//pubsub
+	{Name: "subscribe", Arity: -2, Flags: []string{"pubsub", "noscript", "loading", "stale"}, Group: "pubsub", Summary: "Listens for messages published to channels.", Since: "2.0.0", Complexity: "O(N) where N is the number of channels to subscribe to."},
+	{Name: "unsubscribe", Arity: -1, Flags: []string{"pubsub", "noscript", "loading", "stale"}, Group: "pubsub", Summary: "Stops listening to messages posted to channels.", Since: "2.0.0", Complexity: "O(N) where N is the number of channels to unsubscribe."},
+	{Name: "publish", Arity: 3, Flags: []string{"pubsub", "loading", "stale", "fast"}, Group: "pubsub", Summary: "Posts a message to a channel.", Since: "2.0.0", Complexity: "O(N+M) where N is the number of clients subscribed to the receiving channel and M is the total number of subscribed patterns (by any client)."},
+
+	//keys
+	{Name: "ttl", Arity: 2, Flags: []string{"readonly", "random", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "generic", Summary: "Returns the expiration time in seconds of a key.", Since: "1.0.0", Complexity: "O(1)"},
+	{Name: "expire", Arity: 3, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "generic", Summary: "Sets the expiration time of a key in seconds.", Since: "1.0.0", Complexity: "O(1)"},
+	{Name: "expireat", Arity: 3, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "generic", Summary: "Sets the expiration time of a key to a Unix timestamp.", Since: "1.2.0", Complexity: "O(1)"},
+	{Name: "keys", Arity: 2, Flags: []string{"readonly", "sort_for_script"}, Group: "generic", Summary: "Returns all key names that match a pattern.", Since: "1.0.0", Complexity: "O(N) with N being the number of keys in the database, under the assumption that the key names in the database and the given pattern have limited length."},
+	{Name: "exists", Arity: -2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: -1, Step: 1, Group: "generic", Summary: "Determines whether one or more keys exist.", Since: "1.0.0", Complexity: "O(N) where N is the number of keys to check."},
+	{Name: "del", Arity: -2, Flags: []string{"write"}, FirstKey: 1, LastKey: -1, Step: 1, Group: "generic", Summary: "Deletes one or more keys.", Since: "1.0.0", Complexity: "O(N) where N is the number of keys that will be removed."},
+	{Name: "type", Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "generic", Summary: "Determines the type of value stored at a key.", Since: "1.0.0", Complexity: "O(1)"},
+	{Name: "object", Arity: -2, Flags: []string{"readonly", "random"}, FirstKey: 2, LastKey: 2, Step: 1, Group: "generic", Summary: "A container for object introspection commands.", Since: "2.2.3", Complexity: "Depends on subcommand."},
+	{Name: "unlink", Arity: -2, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: -1, Step: 1, Group: "generic", Summary: "Asynchronously deletes one or more keys.", Since: "4.0.0", Complexity: "O(1) for each key removed regardless of its size. Then the command does O(N) work in a different thread in order to reclaim memory, where N is the number of allocations the deleted objects where composed of."},
+	{Name: "touch", Arity: -2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: -1, Step: 1, Group: "generic", Summary: "Returns the number of existing keys out of those specified after updating the time they were last accessed.", Since: "3.2.1", Complexity: "O(N) where N is the number of keys that will be touched."},
+	{Name: "rename", Arity: 3, Flags: []string{"write"}, FirstKey: 1, LastKey: 2, Step: 1, Group: "generic", Summary: "Renames a key and overwrites the destination.", Since: "1.0.0", Complexity: "O(1)"},
+	{Name: "renamenx", Arity: 3, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 2, Step: 1, Group: "generic", Summary: "Renames a key only when the target key name doesn't exist.", Since: "1.0.0", Complexity: "O(1)"},
+	{Name: "copy", Arity: -3, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 2, Step: 1, Group: "generic", Summary: "Copies the value of a key to a new key.", Since: "6.2.0", Complexity: "O(N) worst case for collections, where N is the number of nested items. O(1) for string values."},
+	{Name: "randomkey", Arity: 1, Flags: []string{"readonly", "random"}, Group: "generic", Summary: "Returns a random key name from the database.", Since: "1.0.0", Complexity: "O(1)"},
+
+	//string
+	GetInfo,
+	SetInfo,
+	{Name: "getset", Arity: 3, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "string", Summary: "Returns the previous string value of a key after setting it to a new value.", Since: "1.0.0", Complexity: "O(1)"},
+	{Name: "setex", Arity: 4, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "string", Summary: "Sets the string value and expiration time of a key. Creates the key if it doesn't exist.", Since: "2.0.0", Complexity: "O(1)"},
+	{Name: "setnx", Arity: 3, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "string", Summary: "Set the string value of a key only when the key doesn't exist.", Since: "1.0.0", Complexity: "O(1)"},
+	{Name: "mget", Arity: -2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: -1, Step: 1, Group: "string", Summary: "Atomically returns the string values of one or more keys.", Since: "1.0.0", Complexity: "O(N) where N is the number of keys to retrieve."},
+	{Name: "mset", Arity: -3, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: -1, Step: 2, Group: "string", Summary: "Atomically creates or modifies the string values of one or more keys.", Since: "1.0.1", Complexity: "O(N) where N is the number of keys to set."},
+	{Name: "strlen", Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "string", Summary: "Returns the length of a string value.", Since: "2.2.0", Complexity: "O(1)"},
+	{Name: "incr", Arity: 2, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "string", Summary: "Increments the integer value of a key by one. Uses 0 as initial value if the key doesn't exist.", Since: "1.0.0", Complexity: "O(1)"},
+	{Name: "incrby", Arity: 3, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "string", Summary: "Increments the integer value of a key by a number. Uses 0 as initial value if the key doesn't exist.", Since: "1.0.0", Complexity: "O(1)"},
+	{Name: "decr", Arity: 2, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "string", Summary: "Decrements the integer value of a key by one. Uses 0 as initial value if the key doesn't exist.", Since: "1.0.0", Complexity: "O(1)"},
+	{Name: "decrby", Arity: 3, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "string", Summary: "Decrements a number from the integer value of a key. Uses 0 as initial value if the key doesn't exist.", Since: "1.0.0", Complexity: "O(1)"},
+	{Name: "append", Arity: 3, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "string", Summary: "Appends a string to the value of a key. Creates the key if it doesn't exist.", Since: "2.0.0", Complexity: "O(1)"},
+	{Name: "setrange", Arity: 4, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "string", Summary: "Overwrites a part of a string value with another by an offset. Creates the key if it doesn't exist.", Since: "2.2.0", Complexity: "O(1), not counting the time taken to copy the new string in place."},
+	{Name: "getrange", Arity: 4, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "string", Summary: "Returns a substring of the string stored at a key.", Since: "2.4.0", Complexity: "O(N) where N is the length of the returned string."},
+	{Name: "setbit", Arity: 4, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "bitmap", Summary: "Sets or clears the bit at offset of the string value. Creates the key if it doesn't exist.", Since: "2.2.0", Complexity: "O(1)"},
+	{Name: "getbit", Arity: 3, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "bitmap", Summary: "Returns a bit value by offset.", Since: "2.2.0", Complexity: "O(1)"},
+	{Name: "bitcount", Arity: -2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "bitmap", Summary: "Counts the number of set bits (population counting) in a string.", Since: "2.6.0", Complexity: "O(N)"},
+	{Name: "getex", Arity: -2, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "string", Summary: "Returns the string value of a key after setting its expiration time.", Since: "6.2.0", Complexity: "O(1)"},
+	{Name: "getdel", Arity: 2, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "string", Summary: "Returns the string value of a key after deleting the key.", Since: "6.2.0", Complexity: "O(1)"},
+	{Name: "incrbyfloat", Arity: 3, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "string", Summary: "Increment the floating point value of a key by a number. Uses 0 as initial value if the key doesn't exist.", Since: "2.6.0", Complexity: "O(1)"},
+	{Name: "msetnx", Arity: -3, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: -1, Step: 2, Group: "string", Summary: "Atomically modifies the string values of one or more keys only when all keys don't exist.", Since: "1.0.1", Complexity: "O(N) where N is the number of keys to set."},
+	{Name: "lcs", Arity: -3, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 2, Step: 1, Group: "string", Summary: "Finds the longest common substring.", Since: "7.0.0", Complexity: "O(N*M) where N and M are the lengths of s1 and s2, respectively"},
+
+	//hash
+	{Name: "hset", Arity: -4, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Summary: "Creates or modifies the value of a field in a hash.", Since: "2.0.0", Complexity: "O(1) for each field/value pair added, so O(N) to add N field/value pairs when the command is called with multiple field/value pairs."},
+	{Name: "hget", Arity: 3, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Summary: "Returns the value of a field in a hash.", Since: "2.0.0", Complexity: "O(1)"},
+	{Name: "hgetall", Arity: 2, Flags: []string{"readonly", "random"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Summary: "Returns all fields and values in a hash.", Since: "2.0.0", Complexity: "O(N) where N is the size of the hash."},
+	{Name: "hkeys", Arity: 2, Flags: []string{"readonly", "sort_for_script"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Summary: "Returns all fields in a hash.", Since: "2.0.0", Complexity: "O(N) where N is the size of the hash."},
+	{Name: "hlen", Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Summary: "Returns the number of fields in a hash.", Since: "2.0.0", Complexity: "O(1)"},
+	{Name: "hexists", Arity: 3, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Summary: "Determines whether a field exists in a hash.", Since: "2.0.0", Complexity: "O(1)"},
+	{Name: "hdel", Arity: -3, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Summary: "Deletes one or more fields and their values from a hash. Deletes the hash if no fields remain.", Since: "2.0.0", Complexity: "O(N) where N is the number of fields to be removed."},
+	{Name: "hmget", Arity: -3, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Summary: "Returns the values of all fields in a hash.", Since: "2.0.0", Complexity: "O(N) where N is the number of fields being requested."},
+	{Name: "hmset", Arity: -4, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Summary: "Sets the values of multiple fields.", Since: "2.0.0", Complexity: "O(N) where N is the number of fields being set."},
+	{Name: "hsetnx", Arity: 4, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Summary: "Sets the value of a field in a hash only when the field doesn't exist.", Since: "2.0.0", Complexity: "O(1)"},
+	{Name: "hstrlen", Arity: 3, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Summary: "Returns the length of the value of a field.", Since: "3.2.0", Complexity: "O(1)"},
+	{Name: "hvals", Arity: 2, Flags: []string{"readonly", "sort_for_script"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Summary: "Returns all values in a hash.", Since: "2.0.0", Complexity: "O(N) where N is the size of the hash."},
+	{Name: "hincrby", Arity: 4, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Summary: "Increments the integer value of a field in a hash by a number. Uses 0 as initial value if the field doesn't exist.", Since: "2.0.0", Complexity: "O(1)"},
+	{Name: "hincrbyfloat", Arity: 4, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Summary: "Increments the floating point value of a field by a number. Uses 0 as initial value if the field doesn't exist.", Since: "2.6.0", Complexity: "O(1)"},
+	{Name: "hscan", Arity: -3, Flags: []string{"readonly", "random"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Summary: "Iterates over fields and values of a hash.", Since: "2.8.0", Complexity: "O(1) for every call. O(N) for a complete iteration, including enough command calls for the cursor to return back to 0. N is the number of elements inside the collection."},
+	{Name: "hrandfield", Arity: -2, Flags: []string{"readonly", "random"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Summary: "Returns one or more random fields from a hash.", Since: "6.2.0", Complexity: "O(N) where N is the number of fields returned"},
+	{Name: "hgetdel", Arity: -5, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Summary: "Returns the value of a field and deletes it from the hash.", Since: "8.0.0", Complexity: "O(N) where N is the number of specified fields."},
+	{Name: "hgetex", Arity: -5, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Summary: "Get the value of one or more fields of a given hash key, and optionally set their expiration.", Since: "8.0.0", Complexity: "O(N) where N is the number of specified fields."},
+	{Name: "hexpire", Arity: -6, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Summary: "Set expiry for hash field using relative time to expire (seconds)", Since: "7.4.0", Complexity: "O(N) where N is the number of specified fields."},
+	{Name: "hpexpire", Arity: -6, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Summary: "Set expiry for hash field using relative time to expire (milliseconds)", Since: "7.4.0", Complexity: "O(N) where N is the number of specified fields."},
+	{Name: "hexpireat", Arity: -6, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Summary: "Set expiry for hash field using an absolute Unix timestamp (seconds)", Since: "7.4.0", Complexity: "O(N) where N is the number of specified fields."},
+	{Name: "hpexpireat", Arity: -6, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Summary: "Set expiry for hash field using an absolute Unix timestamp (milliseconds)", Since: "7.4.0", Complexity: "O(N) where N is the number of specified fields."},
+	{Name: "httl", Arity: -5, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Summary: "Returns the TTL in seconds of a hash field.", Since: "7.4.0", Complexity: "O(N) where N is the number of specified fields."},
+	{Name: "hpttl", Arity: -5, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Summary: "Returns the TTL in milliseconds of a hash field.", Since: "7.4.0", Complexity: "O(N) where N is the number of specified fields."},
+	{Name: "hexpiretime", Arity: -5, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Summary: "Returns the expiration time of a hash field as a Unix timestamp, in seconds.", Since: "7.4.0", Complexity: "O(N) where N is the number of specified fields."},
+	{Name: "hpexpiretime", Arity: -5, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Summary: "Returns the expiration time of a hash field as a Unix timestamp, in msec.", Since: "7.4.0", Complexity: "O(N) where N is the number of specified fields."},
+	{Name: "hpersist", Arity: -5, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "hash", Summary: "Removes the expiration time for each specified field", Since: "7.4.0", Complexity: "O(N) where N is the number of specified fields."},
+
+	//set
+	{Name: "sadd", Arity: -3, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "set", Summary: "Adds one or more members to a set. Creates the key if it doesn't exist.", Since: "1.0.0", Complexity: "O(1) for each element added, so O(N) to add N elements when the command is called with multiple arguments."},
+	{Name: "scard", Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "set", Summary: "Returns the number of members in a set.", Since: "1.0.0", Complexity: "O(1)"},
+	{Name: "sdiff", Arity: -2, Flags: []string{"readonly", "sort_for_script"}, FirstKey: 1, LastKey: -1, Step: 1, Group: "set", Summary: "Returns the difference of multiple sets.", Since: "1.0.0", Complexity: "O(N) where N is the total number of elements in all given sets."},
+	{Name: "sdiffstore", Arity: -3, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: -1, Step: 1, Group: "set", Summary: "Stores the difference of multiple sets in a key.", Since: "1.0.0", Complexity: "O(N) where N is the total number of elements in all given sets."},
+	{Name: "sinter", Arity: -2, Flags: []string{"readonly", "sort_for_script"}, FirstKey: 1, LastKey: -1, Step: 1, Group: "set", Summary: "Returns the intersect of multiple sets.", Since: "1.0.0", Complexity: "O(N*M) worst case where N is the cardinality of the smallest set and M is the number of sets."},
+	{Name: "sinterstore", Arity: -3, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: -1, Step: 1, Group: "set", Summary: "Stores the intersect of multiple sets in a key.", Since: "1.0.0", Complexity: "O(N*M) worst case where N is the cardinality of the smallest set and M is the number of sets."},
+	{Name: "sismember", Arity: 3, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "set", Summary: "Determines whether a member belongs to a set.", Since: "1.0.0", Complexity: "O(1)"},
+	{Name: "smembers", Arity: 2, Flags: []string{"readonly", "sort_for_script"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "set", Summary: "Returns all members of a set.", Since: "1.0.0", Complexity: "O(N) where N is the set cardinality."},
+	{Name: "smove", Arity: 4, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 2, Step: 1, Group: "set", Summary: "Moves a member from one set to another.", Since: "1.0.0", Complexity: "O(1)"},
+	{Name: "spop", Arity: -2, Flags: []string{"write", "random", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "set", Summary: "Returns one or more random members from a set after removing them. Deletes the set if the last member was popped.", Since: "1.0.0", Complexity: "Without the count argument O(1), otherwise O(N) where N is the value of the passed count."},
+	{Name: "srem", Arity: -3, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "set", Summary: "Removes one or more members from a set. Deletes the set if the last member was removed.", Since: "1.0.0", Complexity: "O(N) where N is the number of members to be removed."},
+	{Name: "sunion", Arity: -2, Flags: []string{"readonly", "sort_for_script"}, FirstKey: 1, LastKey: -1, Step: 1, Group: "set", Summary: "Returns the union of multiple sets.", Since: "1.0.0", Complexity: "O(N) where N is the total number of elements in all given sets."},
+	{Name: "sunionstore", Arity: -3, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: -1, Step: 1, Group: "set", Summary: "Stores the union of multiple sets in a key.", Since: "1.0.0", Complexity: "O(N) where N is the total number of elements in all given sets."},
+	{Name: "srandmember", Arity: -2, Flags: []string{"readonly", "random"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "set", Summary: "Get one or multiple random members from a set", Since: "1.0.0", Complexity: "Without the count argument O(1), otherwise O(N) where N is the absolute value of the passed count."},
+	{Name: "smismember", Arity: -3, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "set", Summary: "Determines whether multiple members belong to a set.", Since: "6.2.0", Complexity: "O(N) where N is the number of elements being checked for membership"},
+	{Name: "sintercard", Arity: -3, Flags: []string{"readonly", "movablekeys"}, KeyNum: 1, Group: "set", Summary: "Returns the number of members of the intersect of multiple sets.", Since: "7.0.0", Complexity: "O(N*M) worst case where N is the cardinality of the smallest set and M is the number of sets."},
+
+	//list
+	{Name: "lpush", Arity: -3, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "list", Summary: "Prepends one or more elements to a list. Creates the key if it doesn't exist.", Since: "1.0.0", Complexity: "O(1) for each element added, so O(N) to add N elements when the command is called with multiple arguments."},
+	{Name: "rpush", Arity: -3, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "list", Summary: "Appends one or more elements to a list. Creates the key if it doesn't exist.", Since: "1.0.0", Complexity: "O(1) for each element added, so O(N) to add N elements when the command is called with multiple arguments."},
+	{Name: "llen", Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "list", Summary: "Returns the length of a list.", Since: "1.0.0", Complexity: "O(1)"},
+	{Name: "lpop", Arity: -2, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "list", Summary: "Returns the first elements in a list after removing it. Deletes the list if the last element was popped.", Since: "1.0.0", Complexity: "O(N) where N is the number of elements returned"},
+	{Name: "rpop", Arity: -2, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "list", Summary: "Returns and removes the last elements of a list. Deletes the list if the last element was popped.", Since: "1.0.0", Complexity: "O(N) where N is the number of elements returned"},
+	{Name: "lindex", Arity: 3, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "list", Summary: "Returns an element from a list by its index.", Since: "1.0.0", Complexity: "O(N) where N is the number of elements to traverse to get to the element at index."},
+	{Name: "lrem", Arity: 4, Flags: []string{"write"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "list", Summary: "Removes elements from a list. Deletes the list if the last element was removed.", Since: "1.0.0", Complexity: "O(N+M) where N is the length of the list and M is the number of elements removed."},
+	{Name: "lset", Arity: 4, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "list", Summary: "Sets the value of an element in a list by its index.", Since: "1.0.0", Complexity: "O(N) where N is the length of the list."},
+	{Name: "rpushx", Arity: -3, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "list", Summary: "Appends an element to a list only when the list exists.", Since: "2.2.0", Complexity: "O(1) for each element added, so O(N) to add N elements when the command is called with multiple arguments."},
+	{Name: "lpushx", Arity: -3, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "list", Summary: "Prepends one or more elements to a list only when the list exists.", Since: "2.2.0", Complexity: "O(1) for each element added, so O(N) to add N elements when the command is called with multiple arguments."},
+	{Name: "lrange", Arity: 4, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "list", Summary: "Returns a range of elements from a list.", Since: "1.0.0", Complexity: "O(S+N) where S is the distance of start offset from HEAD for small lists, from nearest end (HEAD or TAIL) for large lists; and N is the number of elements in the specified range."},
+	{Name: "linsert", Arity: 5, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "list", Summary: "Inserts an element before or after another element in a list.", Since: "2.2.0", Complexity: "O(N) where N is the number of elements to traverse before seeing the value pivot. This means that inserting somewhere on the left end on the list (head) can be considered O(1) and inserting somewhere on the right end (tail) is O(N)."},
+	{Name: "ltrim", Arity: 4, Flags: []string{"write"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "list", Summary: "Removes elements from both ends a list. Deletes the list if all elements were trimmed.", Since: "1.0.0", Complexity: "O(N) where N is the number of elements to be removed by the operation."},
+	{Name: "lpos", Arity: -3, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "list", Summary: "Returns the index of matching elements in a list.", Since: "6.0.6", Complexity: "O(N) where N is the number of elements in the list, for the average case. When searching for elements near the head or the tail of the list, or when the MAXLEN option is provided, the command may run in constant time."},
+	{Name: "lmove", Arity: 5, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 2, Step: 1, Group: "list", Summary: "Returns an element after popping it from one list and pushing it to another. Deletes the list if the last element was moved.", Since: "6.2.0", Complexity: "O(1)"},
+	{Name: "rpoplpush", Arity: 3, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 2, Step: 1, Group: "list", Summary: "Returns the last element of a list after removing and pushing it to another list. Deletes the list if the last element was popped.", Since: "1.2.0", Complexity: "O(1)"},
+	{Name: "lmpop", Arity: -4, Flags: []string{"write", "movablekeys"}, KeyNum: 1, Group: "list", Summary: "Returns multiple elements from a list after removing them. Deletes the list if the last element was popped.", Since: "7.0.0", Complexity: "O(N+M) where N is the number of provided keys and M is the number of elements returned."},
+
+	//zset
+	{Name: "zadd", Arity: -4, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted_set", Summary: "Adds one or more members to a sorted set, or updates their scores. Creates the key if it doesn't exist.", Since: "1.2.0", Complexity: "O(log(N)) for each item added, where N is the number of elements in the sorted set."},
+	{Name: "zcard", Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted_set", Summary: "Returns the number of members in a sorted set.", Since: "1.2.0", Complexity: "O(1)"},
+	{Name: "zcount", Arity: 4, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted_set", Summary: "Returns the count of members in a sorted set that have scores within a range.", Since: "2.0.0", Complexity: "O(log(N)) with N being the number of elements in the sorted set."},
+	{Name: "zrange", Arity: -4, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted_set", Summary: "Returns members in a sorted set within a range of indexes.", Since: "1.2.0", Complexity: "O(log(N)+M) with N being the number of elements in the sorted set and M the number of elements returned."},
+	{Name: "zrangebyscore", Arity: -4, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted_set", Summary: "Returns members in a sorted set within a range of scores.", Since: "1.0.5", Complexity: "O(log(N)+M) with N being the number of elements in the sorted set and M the number of elements being returned."},
+	{Name: "zrank", Arity: 3, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted_set", Summary: "Returns the index of a member in a sorted set ordered by ascending scores.", Since: "2.0.0", Complexity: "O(log(N))"},
+	{Name: "zrem", Arity: -3, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted_set", Summary: "Removes one or more members from a sorted set. Deletes the sorted set if all members were removed.", Since: "1.2.0", Complexity: "O(M*log(N)) with N being the number of elements in the sorted set and M the number of elements to be removed."},
+	{Name: "zscore", Arity: 3, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted_set", Summary: "Returns the score of a member in a sorted set.", Since: "1.2.0", Complexity: "O(1)"},
+	{Name: "zrevrank", Arity: 3, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted_set", Summary: "Returns the index of a member in a sorted set ordered by descending scores.", Since: "2.0.0", Complexity: "O(log(N))"},
+	{Name: "zrangestore", Arity: -5, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 2, Step: 1, Group: "sorted_set", Summary: "Stores a range of members from sorted set in a key.", Since: "6.2.0", Complexity: "O(log(N)+M) with N being the number of elements in the sorted set and M the number of elements stored into the destination key."},
+	{Name: "zrevrange", Arity: -4, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted_set", Summary: "Returns members in a sorted set within a range of indexes in reverse order.", Since: "1.2.0", Complexity: "O(log(N)+M) with N being the number of elements in the sorted set and M the number of elements returned."},
+	{Name: "zrevrangebyscore", Arity: -4, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted_set", Summary: "Returns members in a sorted set within a range of scores in reverse order.", Since: "2.2.0", Complexity: "O(log(N)+M) with N being the number of elements in the sorted set and M the number of elements being returned."},
+	{Name: "zrangebylex", Arity: -4, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted_set", Summary: "Returns members in a sorted set within a lexicographical range.", Since: "2.8.9", Complexity: "O(log(N)+M) with N being the number of elements in the sorted set and M the number of elements being returned."},
+	{Name: "zrevrangebylex", Arity: -4, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted_set", Summary: "Returns members in a sorted set within a lexicographical range in reverse order.", Since: "2.8.9", Complexity: "O(log(N)+M) with N being the number of elements in the sorted set and M the number of elements being returned."},
+	{Name: "zlexcount", Arity: 4, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted_set", Summary: "Returns the number of members in a sorted set within a lexicographical range.", Since: "2.8.9", Complexity: "O(log(N)) with N being the number of elements in the sorted set."},
+	{Name: "zincrby", Arity: 4, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted_set", Summary: "Increments the score of a member in a sorted set.", Since: "1.2.0", Complexity: "O(log(N)) where N is the number of elements in the sorted set."},
+	{Name: "zremrangebyrank", Arity: 4, Flags: []string{"write"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted_set", Summary: "Removes members in a sorted set within a range of indexes. Deletes the sorted set if all members were removed.", Since: "2.0.0", Complexity: "O(log(N)+M) with N being the number of elements in the sorted set and M the number of elements removed by the operation."},
+	{Name: "zremrangebyscore", Arity: 4, Flags: []string{"write"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted_set", Summary: "Removes members in a sorted set within a range of scores. Deletes the sorted set if all members were removed.", Since: "1.2.0", Complexity: "O(log(N)+M) with N being the number of elements in the sorted set and M the number of elements removed by the operation."},
+	{Name: "zremrangebylex", Arity: 4, Flags: []string{"write"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted_set", Summary: "Removes members in a sorted set within a lexicographical range. Deletes the sorted set if all members were removed.", Since: "2.8.9", Complexity: "O(log(N)+M) with N being the number of elements in the sorted set and M the number of elements removed by the operation."},
+	{Name: "zpopmin", Arity: -2, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted_set", Summary: "Returns the lowest-scoring members from a sorted set after removing them. Deletes the sorted set if the last member was popped.", Since: "5.0.0", Complexity: "O(log(N)*M) with N being the number of elements in the sorted set, and M being the number of elements popped."},
+	{Name: "zpopmax", Arity: -2, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted_set", Summary: "Returns the highest-scoring members from a sorted set after removing them. Deletes the sorted set if the last member was popped.", Since: "5.0.0", Complexity: "O(log(N)*M) with N being the number of elements in the sorted set, and M being the number of elements popped."},
+	{Name: "bzpopmin", Arity: -3, Flags: []string{"write", "noscript", "blocking", "fast"}, FirstKey: 1, LastKey: -2, Step: 1, Group: "sorted_set", Summary: "Removes and returns the member with the lowest score from one or more sorted sets. Blocks until a member is available otherwise. Deletes the sorted set if the last element was popped.", Since: "5.0.0", Complexity: "O(log(N)) with N being the number of elements in the sorted set."},
+	{Name: "bzpopmax", Arity: -3, Flags: []string{"write", "noscript", "blocking", "fast"}, FirstKey: 1, LastKey: -2, Step: 1, Group: "sorted_set", Summary: "Removes and returns the member with the highest score from one or more sorted sets. Blocks until a member available otherwise. Deletes the sorted set if the last element was popped.", Since: "5.0.0", Complexity: "O(log(N)) with N being the number of elements in the sorted set."},
+	{Name: "zmpop", Arity: -4, Flags: []string{"write", "movablekeys"}, KeyNum: 1, Group: "sorted_set", Summary: "Returns the highest- or lowest-scoring members from one or more sorted sets after removing them. Deletes the sorted set if the last member was popped.", Since: "7.0.0", Complexity: "O(K) + O(M*log(N)) where K is the number of provided keys, N being the number of elements in the sorted set, and M being the number of elements popped."},
+	{Name: "bzmpop", Arity: -5, Flags: []string{"write", "blocking", "movablekeys"}, KeyNum: 2, Group: "sorted_set", Summary: "Removes and returns a member by score from one or more sorted sets. Blocks until a member is available otherwise. Deletes the sorted set if the last element was popped.", Since: "7.0.0", Complexity: "O(K) + O(M*log(N)) where K is the number of provided keys, N being the number of elements in the sorted set, and M being the number of elements popped."},
+	{Name: "zmscore", Arity: -3, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted_set", Summary: "Returns the score of one or more members in a sorted set.", Since: "6.2.0", Complexity: "O(N) where N is the number of members being requested."},
+	{Name: "zrandmember", Arity: -2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, Step: 1, Group: "sorted_set", Summary: "Returns one or more random members from a sorted set.", Since: "6.2.0", Complexity: "O(N) where N is the number of members returned"},
+	{Name: "zunion", Arity: -3, Flags: []string{"readonly", "movablekeys"}, KeyNum: 1, Group: "sorted_set", Summary: "Returns the union of multiple sorted sets.", Since: "6.2.0", Complexity: "O(N)+O(M*log(M)) with N being the sum of the sizes of the input sorted sets, and M being the number of elements in the resulting sorted set."},
+	{Name: "zunionstore", Arity: -4, Flags: []string{"write", "denyoom", "movablekeys"}, FirstKey: 1, LastKey: 1, Step: 1, KeyNum: 2, Group: "sorted_set", Summary: "Stores the union of multiple sorted sets in a key.", Since: "2.0.0", Complexity: "O(N)+O(M log(M)) with N being the sum of the sizes of the input sorted sets, and M being the number of elements in the resulting sorted set."},
+	{Name: "zinter", Arity: -3, Flags: []string{"readonly", "movablekeys"}, KeyNum: 1, Group: "sorted_set", Summary: "Returns the intersect of multiple sorted sets.", Since: "6.2.0", Complexity: "O(N*K)+O(M*log(M)) worst case with N being the smallest input sorted set, K being the number of input sorted sets and M being the number of elements in the resulting sorted set."},
+	{Name: "zinterstore", Arity: -4, Flags: []string{"write", "denyoom", "movablekeys"}, FirstKey: 1, LastKey: 1, Step: 1, KeyNum: 2, Group: "sorted_set", Summary: "Stores the intersect of multiple sorted sets in a key.", Since: "2.0.0", Complexity: "O(N*K)+O(M*log(M)) worst case with N being the smallest input sorted set, K being the number of input sorted sets and M being the number of elements in the resulting sorted set."},
+	{Name: "zdiff", Arity: -3, Flags: []string{"readonly", "movablekeys"}, KeyNum: 1, Group: "sorted_set", Summary: "Returns the difference between multiple sorted sets.", Since: "6.2.0", Complexity: "O(L + (N-K)log(N)) worst case where L is the total number of elements in all the sets, N is the size of the first set, and K is the size of the result set."},
+	{Name: "zdiffstore", Arity: -4, Flags: []string{"write", "denyoom", "movablekeys"}, FirstKey: 1, LastKey: 1, Step: 1, KeyNum: 2, Group: "sorted_set", Summary: "Stores the difference of multiple sorted sets in a key.", Since: "6.2.0", Complexity: "O(L + (N-K)log(N)) worst case where L is the total number of elements in all the sets, N is the size of the first set, and K is the size of the result set."},
+	{Name: "zintercard", Arity: -3, Flags: []string{"readonly", "movablekeys"}, KeyNum: 1, Group: "sorted_set", Summary: "Returns the number of members of the intersect of multiple sorted sets.", Since: "7.0.0", Complexity: "O(N*K) worst case with N being the smallest input sorted set, K being the number of input sorted sets."},
+}
@@ -0,0 +1,170 @@
+package command
+
+import (
+	"fmt"
+	"github.com/medusar/lucas/protocol"
+	"github.com/medusar/lucas/store"
+	"github.com/medusar/lucas/util"
+	"strings"
+)
+
+//trackingChannel is the channel a RESP2 client subscribes to when it's the target of a REDIRECT
+const trackingChannel = "__redis__:invalidate"
+
+var (
+	//trackingTables remember, for every key, the ids of the clients that may have cached it.
+	//There is a table per shard, guarded by the lock of the shard like the keys.
+	trackingTables = newTrackingTables(store.ShardCount)
+	//prefixTable remembers, for every prefix, the ids of the clients in BCAST mode interested in it
+	prefixTable = make(map[string]map[int64]struct{})
+)
+
+func newTrackingTables(n int) []map[string]map[int64]struct{} {
+	tables := make([]map[string]map[int64]struct{}, n)
+	for i := range tables {
+		tables[i] = make(map[string]map[int64]struct{})
+	}
+	return tables
+}
+
+func enableTracking(c *client, redirect int64, bcast, optin, optout, noloop bool, prefixes []string) {
+	c.tracking = true
+	c.redirect = redirect
+	c.bcast = bcast
+	c.optin = optin
+	c.optout = optout
+	c.noloop = noloop
+	c.caching = false
+	c.startPushes()
+	if target := clientsByID[redirect]; target != nil {
+		target.startPushes()
+	}
+
+	if !bcast {
+		return
+	}
+	//without any prefix the client is notified about every key
+	if len(prefixes) == 0 && len(c.prefixes) == 0 {
+		prefixes = []string{""}
+	}
+	for _, p := range prefixes {
+		ids, ok := prefixTable[p]
+		if !ok {
+			ids = make(map[int64]struct{})
+			prefixTable[p] = ids
+		}
+		ids[c.id] = struct{}{}
+		c.prefixes = append(c.prefixes, p)
+	}
+}
+
+//disableTracking turns tracking off, keys remembered in trackingTables are dropped lazily
+func disableTracking(c *client) {
+	if !c.tracking {
+		return
+	}
+	for _, p := range c.prefixes {
+		ids := prefixTable[p]
+		delete(ids, c.id)
+		if len(ids) == 0 {
+			delete(prefixTable, p)
+		}
+	}
+	c.tracking, c.bcast, c.optin, c.optout, c.noloop, c.caching = false, false, false, false, false, false
+	c.redirect = 0
+	c.prefixes = nil
+}
+
+//checkPrefixCollisions makes sure none of the prefixes is a prefix of another one of the same client
+func checkPrefixCollisions(c *client, prefixes []string) error {
+	for i, p := range prefixes {
+		for _, old := range c.prefixes {
+			if strings.HasPrefix(p, old) || strings.HasPrefix(old, p) {
+				return fmt.Errorf("ERR Prefix '%s' overlaps with an existing prefix '%s'. Prefixes for a single client must not overlap.", p, old)
+			}
+		}
+		for j, other := range prefixes {
+			if i != j && (strings.HasPrefix(p, other) || strings.HasPrefix(other, p)) {
+				return fmt.Errorf("ERR Prefix '%s' overlaps with another provided prefix '%s'. Prefixes for a single client must not overlap.", p, other)
+			}
+		}
+	}
+	return nil
+}
+
+//trackingRememberKeys is called after a read only command, so the client is notified once the keys change
+func trackingRememberKeys(c *client, keys []string) {
+	if c.bcast {
+		return
+	}
+	if c.optin && !c.caching {
+		return
+	}
+	if c.optout && c.caching {
+		return
+	}
+	for _, key := range keys {
+		table := trackingTables[store.ShardIndex(key)]
+		ids, ok := table[key]
+		if !ok {
+			ids = make(map[int64]struct{})
+			table[util.Clone(key)] = ids
+		}
+		ids[c.id] = struct{}{}
+	}
+}
+
+//trackingInvalidateKey is called every time a key is modified, it notifies the clients that may have cached it
+func trackingInvalidateKey(key string) {
+	table := trackingTables[store.ShardIndex(key)]
+	if ids, ok := table[key]; ok {
+		delete(table, key)
+		for id := range ids {
+			c := clientsByID[id]
+			if c == nil || !c.tracking || c.bcast {
+				continue
+			}
+			sendTrackingMessage(c, key)
+		}
+	}
+	for prefix, ids := range prefixTable {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		for id := range ids {
+			if c := clientsByID[id]; c != nil {
+				sendTrackingMessage(c, key)
+			}
+		}
+	}
+}
+
+//sendTrackingMessage queues an invalidation message of key for the client,
+//or for the client it redirects to. The key is copied, it may be a view into the buffer of a connection.
+func sendTrackingMessage(c *client, key string) {
+	if c.noloop && c == shardClients[store.ShardIndex(key)] {
+		return
+	}
+	target := c
+	if c.redirect != 0 {
+		target = clientsByID[c.redirect]
+		if target == nil {
+			if c.con.Protocol() == protocol.Resp3 {
+				c.push(protocol.NewPush([]*protocol.Resp{protocol.NewBulk("tracking-redir-broken"), protocol.NewInteger(int(c.redirect))}))
+			}
+			return
+		}
+	}
+
+	keys := protocol.NewArray([]*protocol.Resp{protocol.NewBulk(util.Clone(key))})
+	if target.con.Protocol() == protocol.Resp3 {
+		target.push(protocol.NewPush([]*protocol.Resp{protocol.NewBulk("invalidate"), keys}))
+		return
+	}
+	//RESP2 connections can't receive push messages,
+	//only a client subscribed to the invalidation channel gets the message
+	if _, ok := target.channels[trackingChannel]; !ok || c.redirect == 0 {
+		return
+	}
+	target.push(protocol.NewArray([]*protocol.Resp{protocol.NewBulk("message"), protocol.NewBulk(trackingChannel), keys}))
+}
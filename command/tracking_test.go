@@ -0,0 +1,74 @@
+package command
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+)
+
+func TestTrackingInvalidation(t *testing.T) {
+	writer := newTestClient(t)
+	reader := newTestClient(t)
+	reader.do("hello", "3")
+	assert.Equal(t, "+OK\r\n", reader.do("client", "tracking", "on"))
+
+	reader.do("get", "tracking:k")
+	writer.do("set", "tracking:k", "1")
+	assert.Equal(t, ">2\r\n$10\r\ninvalidate\r\n*1\r\n$10\r\ntracking:k\r\n+PONG\r\n", reader.do("ping"))
+
+	//the key is forgotten once invalidated, until it's read again
+	writer.do("set", "tracking:k", "2")
+	assert.Equal(t, "+PONG\r\n", reader.do("ping"))
+
+	//the keys written by the client itself are invalidated too, unless NOLOOP is given
+	reader.do("get", "tracking:k")
+	reader.do("set", "tracking:k", "3")
+	assert.Equal(t, ">2\r\n$10\r\ninvalidate\r\n*1\r\n$10\r\ntracking:k\r\n+PONG\r\n", reader.do("ping"))
+	reader.do("client", "tracking", "on", "noloop")
+	reader.do("get", "tracking:k")
+	assert.Equal(t, "+OK\r\n", reader.do("set", "tracking:k", "4"))
+	assert.Equal(t, "+PONG\r\n", reader.do("ping"))
+}
+
+func TestTrackingOptin(t *testing.T) {
+	writer := newTestClient(t)
+	reader := newTestClient(t)
+	reader.do("hello", "3")
+	reader.do("client", "tracking", "on", "optin")
+
+	reader.do("get", "optin:a")
+	reader.do("client", "caching", "yes")
+	reader.do("get", "optin:b")
+	writer.do("mset", "optin:a", "1", "optin:b", "1")
+	assert.Equal(t, ">2\r\n$10\r\ninvalidate\r\n*1\r\n$7\r\noptin:b\r\n+PONG\r\n", reader.do("ping"))
+}
+
+func TestTrackingBcast(t *testing.T) {
+	writer := newTestClient(t)
+	reader := newTestClient(t)
+	reader.do("hello", "3")
+	reader.do("client", "tracking", "on", "bcast", "prefix", "bcast:")
+
+	writer.do("set", "bcast:1", "1")
+	writer.do("set", "other:1", "1")
+	assert.Equal(t, ">2\r\n$10\r\ninvalidate\r\n*1\r\n$7\r\nbcast:1\r\n+PONG\r\n", reader.do("ping"))
+
+	assert.Equal(t, "-ERR Prefix 'bcast:x' overlaps with an existing prefix 'bcast:'. Prefixes for a single client must not overlap.\r\n",
+		reader.do("client", "tracking", "on", "bcast", "prefix", "bcast:x"))
+}
+
+func TestTrackingRedirect(t *testing.T) {
+	writer := newTestClient(t)
+	reader := newTestClient(t)
+	target := newTestClient(t)
+	target.do("subscribe", "__redis__:invalidate")
+	reader.do("client", "tracking", "on", "redirect", strconv.FormatInt(target.id(), 10))
+
+	//a RESP2 target gets the invalidation as a message of the channel
+	reader.do("get", "redirect:k")
+	writer.do("set", "redirect:k", "1")
+	assert.Equal(t, "*3\r\n$7\r\nmessage\r\n$20\r\n__redis__:invalidate\r\n*1\r\n$10\r\nredirect:k\r\n+PONG\r\n", target.do("ping"))
+	assert.Equal(t, "+PONG\r\n", reader.do("ping"))
+
+	assert.Equal(t, "-ERR The client ID you want redirect to does not exist\r\n", reader.do("client", "tracking", "on", "redirect", "999999"))
+}
@@ -1,33 +1,115 @@
 package command
 
 import (
+	"fmt"
 	"github.com/medusar/lucas/protocol"
 	"github.com/medusar/lucas/store"
+	"math"
 	"strconv"
 	"strings"
+	"time"
 )
 
+//error replies of ZADD
+const (
+	errZaddXXNX = "ERR XX and NX options at the same time are not compatible"
+	errZaddGTLT = "ERR GT, LT, and/or NX options at the same time are not compatible"
+	errZaddIncr = "ERR INCR option supports a single increment-element pair"
+)
+
+//error replies of the ZRANGE family
+const (
+	errLexRange        = "ERR min or max not valid string range item"
+	errZrangeLimit     = "ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX"
+	errZrangeLexScores = "ERR syntax error, WITHSCORES not supported in combination with BYLEX"
+)
+
+//parseScore parses a score like redis does, +inf and -inf are valid but NaN is not
+func parseScore(s string) (float64, bool) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, false
+	}
+	return f, true
+}
+
+//the flags of ZADD, their conflicts have their own error replies
+var zaddOptions = []argOption{{name: "nx"}, {name: "xx"}, {name: "gt"}, {name: "lt"}, {name: "ch"}, {name: "incr"}}
+
+//zaddFlags maps the flags of ZADD to the ones of the store
+var zaddFlags = map[string]int{
+	"nx":   store.ZaddNX,
+	"xx":   store.ZaddXX,
+	"gt":   store.ZaddGT,
+	"lt":   store.ZaddLT,
+	"incr": store.ZaddIncr,
+}
+
 //https://redis.io/commands/zadd
+//ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
 var zaddFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) < 3 {
-		return r.WriteError("ERR wrong number of arguments for 'zadd' command")
-	}
-	score, err := strconv.ParseFloat(args[1], 64)
+	opts, n, err := parseLeadingOptions(args[1:], zaddOptions)
 	if err != nil {
-		return r.WriteError("ERR value is not a valid float")
+		return r.WriteError(err.Error())
+	}
+	flags := 0
+	for name, flag := range zaddFlags {
+		if opts.has(name) {
+			flags |= flag
+		}
+	}
+	ch := opts.has("ch")
+
+	pairs := args[1+n:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return r.WriteError(errSyntax)
+	}
+	nx, gt, lt := flags&store.ZaddNX != 0, flags&store.ZaddGT != 0, flags&store.ZaddLT != 0
+	if nx && flags&store.ZaddXX != 0 {
+		return r.WriteError(errZaddXXNX)
+	}
+	if (gt && nx) || (lt && nx) || (gt && lt) {
+		return r.WriteError(errZaddGTLT)
+	}
+	incr := flags&store.ZaddIncr != 0
+	if incr && len(pairs) > 2 {
+		return r.WriteError(errZaddIncr)
 	}
-	i, err := store.Zadd(args[0], score, args[2])
+
+	//all the scores are parsed before any change, so the pairs are applied all or none
+	scores := make([]float64, len(pairs)/2)
+	members := make([]string, len(pairs)/2)
+	for j := range scores {
+		score, ok := parseScore(pairs[2*j])
+		if !ok {
+			return r.WriteError(errNotFloat)
+		}
+		scores[j], members[j] = score, pairs[2*j+1]
+	}
+
+	if incr {
+		score, err := store.Zincrby(args[0], scores[0], members[0], flags)
+		if err != nil {
+			return r.WriteError(err.Error())
+		}
+		if score == nil {
+			return r.WriteNil()
+		}
+		return r.WriteDouble(*score)
+	}
+
+	added, updated, err := store.Zadd(args[0], scores, members, flags)
 	if err != nil {
 		return r.WriteError(err.Error())
 	}
-	return r.WriteInteger(i)
+	if ch {
+		return r.WriteInteger(added + updated)
+	}
+	return r.WriteInteger(added)
 }
 
 //https://redis.io/commands/zcard
 var zcardFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 1 {
-		return r.WriteError("ERR wrong number of arguments for 'zcard' command")
-	}
 	n, e := store.Zcard(args[0])
 	if e != nil {
 		return r.WriteError(e.Error())
@@ -37,92 +119,239 @@ var zcardFunc = func(args []string, r protocol.RedisRW) error {
 
 //https://redis.io/commands/zcount
 var zcountFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 3 {
-		return r.WriteError("ERR wrong number of arguments for 'zcount' command")
-	}
-
-	min, err := strconv.ParseFloat(args[1], 64)
-	if err != nil {
-		return r.WriteError("ERR value is not a valid float")
+	sr, ok := parseScoreRange(args[1], args[2])
+	if !ok {
+		return r.WriteError(errMinMaxFloat)
 	}
-	max, err := strconv.ParseFloat(args[2], 64)
+	n, err := store.Zcount(args[0], sr)
 	if err != nil {
-		return r.WriteError("ERR value is not a valid float")
+		return r.WriteError(err.Error())
 	}
+	return r.WriteInteger(n)
+}
 
-	n, err := store.Zcount(args[0], min, max)
+//https://redis.io/commands/zlexcount
+var zlexcountFunc = func(args []string, r protocol.RedisRW) error {
+	lr, ok := parseLexRange(args[1], args[2])
+	if !ok {
+		return r.WriteError(errLexRange)
+	}
+	n, err := store.Zlexcount(args[0], lr)
 	if err != nil {
 		return r.WriteError(err.Error())
 	}
 	return r.WriteInteger(n)
 }
 
-//https://redis.io/commands/zrange
-var zrangeFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 3 && len(args) != 4 {
-		return r.WriteError("ERR wrong number of arguments for 'zrange' command")
+//parseScoreBound parses min or max of a score range, ( makes it exclusive
+func parseScoreBound(s string) (float64, bool, bool) {
+	ex := strings.HasPrefix(s, "(")
+	if ex {
+		s = s[1:]
 	}
+	f, ok := parseScore(s)
+	return f, ex, ok
+}
 
-	start, err := strconv.Atoi(args[1])
-	if err != nil {
-		return r.WriteError("ERR value is not an integer or out of range")
+func parseScoreRange(min, max string) (*store.ScoreRange, bool) {
+	var sr store.ScoreRange
+	var ok bool
+	if sr.Min, sr.MinEx, ok = parseScoreBound(min); !ok {
+		return nil, false
 	}
-	stop, err := strconv.Atoi(args[2])
-	if err != nil {
-		return r.WriteError("ERR value is not an integer or out of range")
+	if sr.Max, sr.MaxEx, ok = parseScoreBound(max); !ok {
+		return nil, false
 	}
+	return &sr, true
+}
 
-	var array []string
-	if len(args) == 4 {
-		if strings.ToUpper(args[3]) != "WITHSCORES" {
-			return r.WriteError("ERR syntax error")
-		}
-		array, err = store.ZrangeWithScore(args[0], start, stop)
-	} else {
-		array, err = store.Zrange(args[0], start, stop)
+//parseLexBound parses min or max of a lex range: [member is inclusive, (member exclusive, - and + are the infinities
+func parseLexBound(s string) (store.LexBound, bool) {
+	switch {
+	case s == "-":
+		return store.LexBound{Inf: -1}, true
+	case s == "+":
+		return store.LexBound{Inf: 1}, true
+	case strings.HasPrefix(s, "["):
+		return store.LexBound{Member: s[1:]}, true
+	case strings.HasPrefix(s, "("):
+		return store.LexBound{Member: s[1:], Ex: true}, true
 	}
+	return store.LexBound{}, false
+}
 
-	if err != nil {
-		return r.WriteError(err.Error())
+func parseLexRange(min, max string) (*store.LexRange, bool) {
+	var lr store.LexRange
+	var ok bool
+	if lr.Min, ok = parseLexBound(min); !ok {
+		return nil, false
+	}
+	if lr.Max, ok = parseLexBound(max); !ok {
+		return nil, false
 	}
-	return r.WriteArray(toBulkArray(array))
+	return &lr, true
+}
+
+//kinds of ZRANGE queries
+const (
+	zrangeAuto = iota //chosen by BYSCORE or BYLEX, by rank otherwise
+	zrangeRank
+	zrangeScore
+	zrangeLex
+)
+
+//https://redis.io/commands/zrange
+//ZRANGE key min max [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+var zrangeFunc = func(args []string, r protocol.RedisRW) error {
+	return zrangeGeneric(args, r, zrangeAuto, false, false)
+}
+
+//https://redis.io/commands/zrangestore
+var zrangestoreFunc = func(args []string, r protocol.RedisRW) error {
+	return zrangeGeneric(args, r, zrangeAuto, false, true)
+}
+
+//https://redis.io/commands/zrevrange
+var zrevrangeFunc = func(args []string, r protocol.RedisRW) error {
+	return zrangeGeneric(args, r, zrangeRank, true, false)
 }
 
 //https://redis.io/commands/zrangebyscore
-//TODO:support flags
 var zrangeByScoreFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 3 && len(args) != 4 {
-		return r.WriteError("ERR wrong number of arguments for 'zrangebyscore' command")
+	return zrangeGeneric(args, r, zrangeScore, false, false)
+}
+
+//https://redis.io/commands/zrevrangebyscore
+var zrevrangeByScoreFunc = func(args []string, r protocol.RedisRW) error {
+	return zrangeGeneric(args, r, zrangeScore, true, false)
+}
+
+//https://redis.io/commands/zrangebylex
+var zrangeByLexFunc = func(args []string, r protocol.RedisRW) error {
+	return zrangeGeneric(args, r, zrangeLex, false, false)
+}
+
+//https://redis.io/commands/zrevrangebylex
+var zrevrangeByLexFunc = func(args []string, r protocol.RedisRW) error {
+	return zrangeGeneric(args, r, zrangeLex, true, false)
+}
+
+//zrangeGeneric implements the ZRANGE family like zrangeGenericCommand of redis.
+//The older commands fix the kind and the direction, ZRANGE and ZRANGESTORE read them from the options.
+//The arguments of ZRANGESTORE start with the destination, and it doesn't accept WITHSCORES.
+func zrangeGeneric(args []string, r protocol.RedisRW, kind int, rev, storing bool) error {
+	dest := ""
+	if storing {
+		dest, args = args[0], args[1:]
 	}
+	key, min, max := args[0], args[1], args[2]
 
-	min, err := strconv.ParseFloat(args[1], 64)
-	if err != nil {
-		return r.WriteError("ERR value is not an integer or out of range")
+	//the direction and the kind are options of ZRANGE and ZRANGESTORE only, each of them is given once
+	options := []argOption{{name: "limit", nargs: 2}}
+	if !storing {
+		options = append(options, argOption{name: "withscores"})
 	}
-	max, err := strconv.ParseFloat(args[2], 64)
+	if kind == zrangeAuto {
+		options = append(options,
+			argOption{name: "rev", conflicts: []string{"rev"}},
+			argOption{name: "byscore", conflicts: []string{"byscore", "bylex"}},
+			argOption{name: "bylex", conflicts: []string{"byscore", "bylex"}})
+	}
+	opts, err := parseOptions(args[3:], options)
 	if err != nil {
-		return r.WriteError("ERR value is not an integer or out of range")
+		return r.WriteError(err.Error())
+	}
+	q := &store.ZrangeQuery{Count: -1}
+	if opts.has("limit") {
+		if q.Offset, err = opts.int("limit", 0); err != nil {
+			return r.WriteError(err.Error())
+		}
+		if q.Count, err = opts.int("limit", 1); err != nil {
+			return r.WriteError(err.Error())
+		}
+	}
+	withScores := opts.has("withscores")
+	if opts.has("rev") {
+		rev = true
+	}
+	if opts.has("byscore") {
+		kind = zrangeScore
+	} else if opts.has("bylex") {
+		kind = zrangeLex
+	}
+	if kind == zrangeAuto {
+		kind = zrangeRank
+	}
+	if (q.Offset != 0 || q.Count != -1) && kind == zrangeRank {
+		return r.WriteError(errZrangeLimit)
+	}
+	if withScores && kind == zrangeLex {
+		return r.WriteError(errZrangeLexScores)
+	}
+	//the range is given as max min when reversed
+	if rev && kind != zrangeRank {
+		min, max = max, min
 	}
 
-	var array []string
-	if len(args) == 4 {
-		if strings.ToUpper(args[3]) != "WITHSCORES" {
-			return r.WriteError("ERR syntax error")
+	q.Rev = rev
+	var ok bool
+	switch kind {
+	case zrangeRank:
+		if q.Start, err = strconv.Atoi(min); err != nil {
+			return r.WriteError(errNotInteger)
+		}
+		if q.Stop, err = strconv.Atoi(max); err != nil {
+			return r.WriteError(errNotInteger)
+		}
+	case zrangeScore:
+		if q.Score, ok = parseScoreRange(min, max); !ok {
+			return r.WriteError(errMinMaxFloat)
+		}
+	case zrangeLex:
+		if q.Lex, ok = parseLexRange(min, max); !ok {
+			return r.WriteError(errLexRange)
 		}
-		array, err = store.ZRangeByScoreWithScore(args[0], min, max)
-	} else {
-		array, err = store.ZRangeByScore(args[0], min, max)
 	}
+
+	if storing {
+		n, err := store.ZrangeStore(dest, key, q)
+		if err != nil {
+			return r.WriteError(err.Error())
+		}
+		return r.WriteInteger(n)
+	}
+	members, err := store.Zrange(key, q)
 	if err != nil {
 		return r.WriteError(err.Error())
 	}
-	return r.WriteArray(toBulkArray(array))
+	return writeZsetMembers(r, members, withScores)
 }
 
-var zrankFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 2 {
-		return r.WriteError("ERR wrong number of arguments for 'zrank' command")
+//writeZsetMembers writes the members of a sorted set,
+//with their scores they are member and score pairs in RESP3 and a flat array in RESP2 like redis does.
+func writeZsetMembers(r protocol.RedisRW, members []store.ZsetMember, withScores bool) error {
+	if !withScores {
+		arr := make([]*protocol.Resp, len(members))
+		for i, m := range members {
+			arr[i] = protocol.NewBulk(m.Member)
+		}
+		return r.WriteArray(arr)
+	}
+	if r.Protocol() == protocol.Resp3 {
+		arr := make([]*protocol.Resp, len(members))
+		for i, m := range members {
+			arr[i] = protocol.NewArray([]*protocol.Resp{protocol.NewBulk(m.Member), protocol.NewDouble(m.Score)})
+		}
+		return r.WriteArray(arr)
+	}
+	arr := make([]*protocol.Resp, 0, 2*len(members))
+	for _, m := range members {
+		arr = append(arr, protocol.NewBulk(m.Member), protocol.NewDouble(m.Score))
 	}
+	return r.WriteArray(arr)
+}
+
+var zrankFunc = func(args []string, r protocol.RedisRW) error {
 	rank, err := store.Zrank(args[0], args[1])
 	if err != nil {
 		return r.WriteError(err.Error())
@@ -134,9 +363,6 @@ var zrankFunc = func(args []string, r protocol.RedisRW) error {
 }
 
 var zremFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) < 2 {
-		return r.WriteError("ERR wrong number of arguments for 'zrem' command")
-	}
 	n, err := store.Zrem(args[0], args[1:])
 	if err != nil {
 		return r.WriteError(err.Error())
@@ -145,9 +371,6 @@ var zremFunc = func(args []string, r protocol.RedisRW) error {
 }
 
 var zscoreFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 2 {
-		return r.WriteError("ERR wrong number of arguments for 'zscore' command")
-	}
 	score, err := store.Zscore(args[0], args[1])
 	if err != nil {
 		return r.WriteError(err.Error())
@@ -155,13 +378,10 @@ var zscoreFunc = func(args []string, r protocol.RedisRW) error {
 	if score == nil {
 		return r.WriteNil()
 	}
-	return r.WriteBulk(*score)
+	return r.WriteDouble(*score)
 }
 
 var zrevrankFunc = func(args []string, r protocol.RedisRW) error {
-	if len(args) != 2 {
-		return r.WriteError("ERR wrong number of arguments for 'zrevrank' command")
-	}
 	rank, err := store.Zrevrank(args[0], args[1])
 	if err != nil {
 		return r.WriteError(err.Error())
@@ -171,3 +391,363 @@ var zrevrankFunc = func(args []string, r protocol.RedisRW) error {
 	}
 	return r.WriteInteger(*rank)
 }
+
+//error replies of the ZPOP family
+const (
+	errZpopCount = "ERR value is out of range, must be positive"
+	errNumkeys   = "ERR numkeys should be greater than 0"
+	errMpopCount = "ERR count should be greater than 0"
+	errRandRange = "ERR value is out of range"
+)
+
+//randCountLimit bounds the count of ZRANDMEMBER and SRANDMEMBER like redis does
+const randCountLimit = math.MaxInt64 / 2
+
+//https://redis.io/commands/zincrby
+var zincrbyFunc = func(args []string, r protocol.RedisRW) error {
+	incr, ok := parseScore(args[1])
+	if !ok {
+		return r.WriteError(errNotFloat)
+	}
+	score, err := store.Zincrby(args[0], incr, args[2], 0)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	return r.WriteDouble(*score)
+}
+
+//https://redis.io/commands/zremrangebyrank
+var zremrangebyrankFunc = func(args []string, r protocol.RedisRW) error {
+	start, err := strconv.Atoi(args[1])
+	if err != nil {
+		return r.WriteError(errNotInteger)
+	}
+	stop, err := strconv.Atoi(args[2])
+	if err != nil {
+		return r.WriteError(errNotInteger)
+	}
+	n, err := store.ZremRangeByRank(args[0], start, stop)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	return r.WriteInteger(n)
+}
+
+//https://redis.io/commands/zremrangebyscore
+var zremrangebyscoreFunc = func(args []string, r protocol.RedisRW) error {
+	sr, ok := parseScoreRange(args[1], args[2])
+	if !ok {
+		return r.WriteError(errMinMaxFloat)
+	}
+	n, err := store.ZremRangeByScore(args[0], sr)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	return r.WriteInteger(n)
+}
+
+//https://redis.io/commands/zremrangebylex
+var zremrangebylexFunc = func(args []string, r protocol.RedisRW) error {
+	lr, ok := parseLexRange(args[1], args[2])
+	if !ok {
+		return r.WriteError(errLexRange)
+	}
+	n, err := store.ZremRangeByLex(args[0], lr)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	return r.WriteInteger(n)
+}
+
+//https://redis.io/commands/zpopmin
+var zpopminFunc = func(args []string, r protocol.RedisRW) error {
+	return zpopGeneric(args, r, false)
+}
+
+//https://redis.io/commands/zpopmax
+var zpopmaxFunc = func(args []string, r protocol.RedisRW) error {
+	return zpopGeneric(args, r, true)
+}
+
+//zpopGeneric implements ZPOPMIN and ZPOPMAX, without count the reply is a flat member and score
+func zpopGeneric(args []string, r protocol.RedisRW, max bool) error {
+	if len(args) > 2 {
+		return r.WriteError(errSyntax)
+	}
+	count := 1
+	if len(args) == 2 {
+		var err error
+		if count, err = strconv.Atoi(args[1]); err != nil {
+			return r.WriteError(errNotInteger)
+		}
+		if count < 0 {
+			return r.WriteError(errZpopCount)
+		}
+	}
+	members, err := store.Zpop(args[0], count, max)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	if len(args) == 2 {
+		return writeZsetMembers(r, members, true)
+	}
+	arr := make([]*protocol.Resp, 0, 2)
+	for _, m := range members {
+		arr = append(arr, protocol.NewBulk(m.Member), protocol.NewDouble(m.Score))
+	}
+	return r.WriteArray(arr)
+}
+
+//https://redis.io/commands/bzpopmin
+var bzpopminFunc = func(args []string, r protocol.RedisRW) error {
+	return bzpopGeneric(args, r, false)
+}
+
+//https://redis.io/commands/bzpopmax
+var bzpopmaxFunc = func(args []string, r protocol.RedisRW) error {
+	return bzpopGeneric(args, r, true)
+}
+
+//bzpopGeneric implements BZPOPMIN and BZPOPMAX, the reply is the key, the member and its score
+func bzpopGeneric(args []string, r protocol.RedisRW, max bool) error {
+	timeout, msg := parseTimeout(args[len(args)-1])
+	if msg != "" {
+		return r.WriteError(msg)
+	}
+	keys := args[:len(args)-1]
+	key, members, err := zpopFirst(keys, 1, max)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	if len(members) == 0 {
+		block(r, keys, "zset", timeout)
+		return nil
+	}
+	return r.WriteArray([]*protocol.Resp{protocol.NewBulk(key), protocol.NewBulk(members[0].Member), protocol.NewDouble(members[0].Score)})
+}
+
+//zpopFirst pops up to count members from the first key holding a sorted set
+func zpopFirst(keys []string, count int, max bool) (string, []store.ZsetMember, error) {
+	for _, key := range keys {
+		members, err := store.Zpop(key, count, max)
+		if err != nil || len(members) > 0 {
+			return key, members, err
+		}
+	}
+	return "", nil, nil
+}
+
+//https://redis.io/commands/zmpop
+//ZMPOP numkeys key [key ...] MIN|MAX [COUNT count]
+var zmpopFunc = func(args []string, r protocol.RedisRW) error {
+	return zmpopGeneric(args, r, -1)
+}
+
+//https://redis.io/commands/bzmpop
+//BZMPOP timeout numkeys key [key ...] MIN|MAX [COUNT count]
+var bzmpopFunc = func(args []string, r protocol.RedisRW) error {
+	timeout, msg := parseTimeout(args[0])
+	if msg != "" {
+		return r.WriteError(msg)
+	}
+	return zmpopGeneric(args[1:], r, timeout)
+}
+
+//zmpopGeneric implements ZMPOP and BZMPOP, which blocks with a timeout that is not negative.
+//The reply is the key and its members popped, each with its score.
+func zmpopGeneric(args []string, r protocol.RedisRW, timeout time.Duration) error {
+	keys, max, count, msg := parseMpopArgs(args, [2]string{"max", "min"})
+	if msg != "" {
+		return r.WriteError(msg)
+	}
+
+	key, members, err := zpopFirst(keys, count, max)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	if len(members) == 0 {
+		if timeout < 0 {
+			return r.WriteResp(protocol.NewNilArray())
+		}
+		block(r, keys, "zset", timeout)
+		return nil
+	}
+	pairs := make([]*protocol.Resp, len(members))
+	for i, m := range members {
+		pairs[i] = protocol.NewArray([]*protocol.Resp{protocol.NewBulk(m.Member), protocol.NewDouble(m.Score)})
+	}
+	return r.WriteArray([]*protocol.Resp{protocol.NewBulk(key), protocol.NewArray(pairs)})
+}
+
+//https://redis.io/commands/zmscore
+var zmscoreFunc = func(args []string, r protocol.RedisRW) error {
+	scores, err := store.Zmscore(args[0], args[1:])
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	arr := make([]*protocol.Resp, len(scores))
+	for i, s := range scores {
+		if s == nil {
+			arr[i] = protocol.NewNil()
+		} else {
+			arr[i] = protocol.NewDouble(*s)
+		}
+	}
+	return r.WriteArray(arr)
+}
+
+//https://redis.io/commands/zrandmember
+//ZRANDMEMBER key [count [WITHSCORES]]
+var zrandmemberFunc = func(args []string, r protocol.RedisRW) error {
+	if len(args) == 1 {
+		members, err := store.Zrandmember(args[0], 1)
+		if err != nil {
+			return r.WriteError(err.Error())
+		}
+		if len(members) == 0 {
+			return r.WriteNil()
+		}
+		return r.WriteBulk(members[0].Member)
+	}
+	count, err := strconv.Atoi(args[1])
+	if err != nil {
+		return r.WriteError(errNotInteger)
+	}
+	withScores := false
+	if len(args) == 3 && strings.ToLower(args[2]) == "withscores" {
+		withScores = true
+	} else if len(args) > 2 {
+		return r.WriteError(errSyntax)
+	}
+	if count < -randCountLimit || count > randCountLimit {
+		return r.WriteError(errRandRange)
+	}
+	members, err := store.Zrandmember(args[0], count)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	return writeZsetMembers(r, members, withScores)
+}
+
+//error replies of ZUNION, ZINTER and ZDIFF
+const (
+	errZcombineKeysFmt = "ERR at least 1 input key is needed for '%s' command"
+	errZcombineWeight  = "ERR weight value is not a float"
+)
+
+//https://redis.io/commands/zunion
+//ZUNION numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+var zunionFunc = func(args []string, r protocol.RedisRW) error {
+	return zcombineGeneric(args, r, "zunion", store.ZsetUnion, false)
+}
+
+//https://redis.io/commands/zunionstore
+//ZUNIONSTORE destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]
+var zunionstoreFunc = func(args []string, r protocol.RedisRW) error {
+	return zcombineGeneric(args, r, "zunionstore", store.ZsetUnion, true)
+}
+
+//https://redis.io/commands/zinter
+var zinterFunc = func(args []string, r protocol.RedisRW) error {
+	return zcombineGeneric(args, r, "zinter", store.ZsetInter, false)
+}
+
+//https://redis.io/commands/zinterstore
+var zinterstoreFunc = func(args []string, r protocol.RedisRW) error {
+	return zcombineGeneric(args, r, "zinterstore", store.ZsetInter, true)
+}
+
+//https://redis.io/commands/zdiff
+//ZDIFF numkeys key [key ...] [WITHSCORES]
+var zdiffFunc = func(args []string, r protocol.RedisRW) error {
+	return zcombineGeneric(args, r, "zdiff", store.ZsetDiff, false)
+}
+
+//https://redis.io/commands/zdiffstore
+//ZDIFFSTORE destination numkeys key [key ...]
+var zdiffstoreFunc = func(args []string, r protocol.RedisRW) error {
+	return zcombineGeneric(args, r, "zdiffstore", store.ZsetDiff, true)
+}
+
+//zcombineGeneric implements ZUNION, ZINTER, ZDIFF and their STORE forms like zunionInterDiffGenericCommand of redis
+func zcombineGeneric(args []string, r protocol.RedisRW, name string, op int, storing bool) error {
+	var dest string
+	if storing {
+		dest, args = args[0], args[1:]
+	}
+	numkeys, err := strconv.Atoi(args[0])
+	if err != nil {
+		return r.WriteError(errNotInteger)
+	}
+	if numkeys < 1 {
+		return r.WriteError(fmt.Sprintf(errZcombineKeysFmt, name))
+	}
+	if numkeys > len(args)-1 {
+		return r.WriteError(errSyntax)
+	}
+	keys := args[1 : 1+numkeys]
+
+	//WEIGHTS takes a weight for each key
+	var options []argOption
+	if op != store.ZsetDiff {
+		options = append(options, argOption{name: "weights", nargs: numkeys}, argOption{name: "aggregate", nargs: 1})
+	}
+	if !storing {
+		options = append(options, argOption{name: "withscores"})
+	}
+	opts, err := parseOptions(args[1+numkeys:], options)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	var weights []float64
+	if opts.has("weights") {
+		weights = make([]float64, numkeys)
+		for j, arg := range opts["weights"] {
+			w, ok := parseScore(arg)
+			if !ok {
+				return r.WriteError(errZcombineWeight)
+			}
+			weights[j] = w
+		}
+	}
+	how := store.AggregateSum
+	if opts.has("aggregate") {
+		switch strings.ToLower(opts["aggregate"][0]) {
+		case "sum":
+		case "min":
+			how = store.AggregateMin
+		case "max":
+			how = store.AggregateMax
+		default:
+			return r.WriteError(errSyntax)
+		}
+	}
+	withScores := opts.has("withscores")
+
+	if storing {
+		n, err := store.ZcombineStore(dest, op, keys, weights, how)
+		if err != nil {
+			return r.WriteError(err.Error())
+		}
+		return r.WriteInteger(n)
+	}
+	members, err := store.Zcombine(op, keys, weights, how)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	return writeZsetMembers(r, members, withScores)
+}
+
+//https://redis.io/commands/zintercard
+//ZINTERCARD numkeys key [key ...] [LIMIT limit]
+var zintercardFunc = func(args []string, r protocol.RedisRW) error {
+	keys, limit, msg := parseIntercardArgs(args)
+	if msg != "" {
+		return r.WriteError(msg)
+	}
+	n, err := store.Zintercard(keys, limit)
+	if err != nil {
+		return r.WriteError(err.Error())
+	}
+	return r.WriteInteger(n)
+}
@@ -5,4 +5,4 @@ require (
 	github.com/stretchr/testify v1.4.0
 )
 
-go 1.13
+go 1.16
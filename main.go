@@ -1,31 +1,153 @@
 package main
 
 import (
+	"crypto/tls"
+	"flag"
+	"fmt"
 	"github.com/medusar/lucas/command"
 	"github.com/medusar/lucas/protocol"
+	"github.com/medusar/lucas/reactor"
+	"github.com/medusar/lucas/store"
 	"log"
 	"net"
 	"net/http"
 	_ "net/http/pprof"
+	"os"
+	"strconv"
+	"time"
+)
+
+var (
+	requirePass = flag.String("requirepass", "", "password of the default user")
+	aclFile     = flag.String("aclfile", "", "file to load users from, also used by ACL LOAD and ACL SAVE")
+
+	latencyThreshold = flag.Int("latency-monitor-threshold", 0, "minimum latency in milliseconds recorded by the latency monitor, 0 disables it")
+	slowlogThreshold = flag.Int64("slowlog-log-slower-than", 10000, "minimum execution time in microseconds of a command added to the slow log, a negative value disables it")
+
+	hashMaxListpackEntries = flag.Int("hash-max-listpack-entries", store.DefaultEncodingLimits.HashMaxListpackEntries, "max number of fields of a hash encoded as a listpack")
+	hashMaxListpackValue   = flag.Int("hash-max-listpack-value", store.DefaultEncodingLimits.HashMaxListpackValue, "max length of the fields and values of a hash encoded as a listpack")
+	setMaxIntsetEntries    = flag.Int("set-max-intset-entries", store.DefaultEncodingLimits.SetMaxIntsetEntries, "max number of members of a set of integers encoded as an intset")
+	setMaxListpackEntries  = flag.Int("set-max-listpack-entries", store.DefaultEncodingLimits.SetMaxListpackEntries, "max number of members of a set encoded as a listpack")
+	setMaxListpackValue    = flag.Int("set-max-listpack-value", store.DefaultEncodingLimits.SetMaxListpackValue, "max length of the members of a set encoded as a listpack")
+	zsetMaxListpackEntries = flag.Int("zset-max-listpack-entries", store.DefaultEncodingLimits.ZsetMaxListpackEntries, "max number of members of a sorted set encoded as a listpack")
+	zsetMaxListpackValue   = flag.Int("zset-max-listpack-value", store.DefaultEncodingLimits.ZsetMaxListpackValue, "max length of the members of a sorted set encoded as a listpack")
+
+	port           = flag.Int("port", 6380, "port of the TCP listener, 0 disables TCP")
+	reactorMode    = flag.Bool("reactor", false, "serve the TCP port with epoll event loops instead of a goroutine per connection, Linux only")
+	unixSocket     = flag.String("unixsocket", "", "path of the unix socket listener")
+	unixSocketPerm = flag.String("unixsocketperm", "700", "permissions of the unix socket, in octal")
+
+	tlsPort        = flag.Int("tls-port", 0, "port of the TLS listener, 0 disables TLS")
+	tlsCertFile    = flag.String("tls-cert-file", "", "certificate of the server")
+	tlsKeyFile     = flag.String("tls-key-file", "", "private key of the server")
+	tlsCACertFile  = flag.String("tls-ca-cert-file", "", "CA certificates used to verify the clients")
+	tlsAuthClients = flag.String("tls-auth-clients", "yes", "verify client certificates: yes, no or optional, yes requires tls-ca-cert-file")
+	tlsMinVersion  = flag.String("tls-min-version", "1.2", "minimum TLS version: 1.0, 1.1, 1.2 or 1.3")
+	tlsCiphers     = flag.String("tls-ciphersuites", "", "comma separated cipher suites allowed for TLS 1.2 and below")
 )
 
 func main() {
-	l, err := net.Listen("tcp", ":6380")
-	if err != nil {
-		log.Fatal(err)
+	flag.Parse()
+	if *aclFile != "" {
+		if err := command.LoadACLFile(*aclFile); err != nil {
+			log.Fatal(err)
+		}
 	}
-	defer l.Close()
-	log.Println("server stared on address:", l.Addr())
+	if *requirePass != "" {
+		command.SetRequirePass(*requirePass)
+	}
+	store.SetLatencyThreshold(time.Duration(*latencyThreshold) * time.Millisecond)
+	store.SetSlowlogThreshold(*slowlogThreshold)
+	store.SetEncodingLimits(store.EncodingLimits{
+		HashMaxListpackEntries: *hashMaxListpackEntries,
+		HashMaxListpackValue:   *hashMaxListpackValue,
+		SetMaxIntsetEntries:    *setMaxIntsetEntries,
+		SetMaxListpackEntries:  *setMaxListpackEntries,
+		SetMaxListpackValue:    *setMaxListpackValue,
+		ZsetMaxListpackEntries: *zsetMaxListpackEntries,
+		ZsetMaxListpackValue:   *zsetMaxListpackValue,
+	})
 
 	go http.ListenAndServe(":8080", http.DefaultServeMux)
 
 	//start server
 	go command.LoopAndInvoke()
 
+	var listeners []net.Listener
+	if *port != 0 && !*reactorMode {
+		l, err := net.Listen("tcp", fmt.Sprintf(":%d", *port))
+		if err != nil {
+			log.Fatal(err)
+		}
+		listeners = append(listeners, l)
+	}
+	if *tlsPort != 0 {
+		reloader, err := newTLSReloader(*tlsCertFile, *tlsKeyFile, *tlsCACertFile, *tlsAuthClients, *tlsMinVersion, *tlsCiphers)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go reloader.reloadOnSignal()
+		l, err := tls.Listen("tcp", fmt.Sprintf(":%d", *tlsPort), reloader.tlsConfig())
+		if err != nil {
+			log.Fatal(err)
+		}
+		listeners = append(listeners, l)
+	}
+	if *unixSocket != "" {
+		l, err := listenUnix(*unixSocket, *unixSocketPerm)
+		if err != nil {
+			log.Fatal(err)
+		}
+		listeners = append(listeners, l)
+	}
+	if len(listeners) == 0 && (*port == 0 || !*reactorMode) {
+		log.Fatal("no listener configured, set port, tls-port or unixsocket")
+	}
+
+	errs := make(chan error, len(listeners)+1)
+	if *port != 0 && *reactorMode {
+		log.Println("server stared on port", *port, "in reactor mode")
+		go func() {
+			errs <- reactor.Serve(*port)
+		}()
+	}
+	for _, l := range listeners {
+		defer l.Close()
+		log.Println("server stared on address:", l.Addr())
+		go func(l net.Listener) {
+			errs <- accept(l)
+		}(l)
+	}
+	log.Fatal(<-errs)
+}
+
+//listenUnix listens on a unix socket, a stale socket file left by a previous run is removed
+func listenUnix(path, perm string) (net.Listener, error) {
+	mode, err := strconv.ParseUint(perm, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid unixsocketperm '%s'", perm)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+//accept serves every connection of the listener until it fails,
+//connections of every listener share the same serve pipeline.
+func accept(l net.Listener) error {
 	for {
 		con, err := l.Accept()
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 		go serve(con)
 	}
@@ -35,22 +157,31 @@ func serve(con net.Conn) {
 	defer con.Close()
 	//r := protocol.NewRedisConn(con)
 	r := protocol.NewBufRedisConn(con)
+	command.Connect(r)
+	defer command.Disconnect(r)
 	for {
 		req, err := r.ReadRequest()
-		log.Println("req:", req)
 		if err != nil {
 			log.Println("Failed to read,", err)
 			break
 		}
-		redisCmd, err := command.ParseRequest(req)
-		if err != nil {
+		r.SetBatching(true)
+		if redisCmd, err := command.ParseRequest(req); err != nil {
 			r.WriteError(err.Error())
-			continue
+		} else {
+			err := command.Execute(r, redisCmd)
+			redisCmd.Release()
+			if err != nil {
+				log.Println("Failecd to write", err)
+				break
+			}
 		}
-		err = command.Execute(r, redisCmd)
-		if err != nil {
-			log.Println("Failecd to write", err)
-			break
+		//replies are held while more requests are buffered, so a pipeline is answered with one write
+		if !r.Pipelined() {
+			if err := r.SetBatching(false); err != nil {
+				log.Println("Failed to write", err)
+				break
+			}
 		}
 	}
 }
@@ -7,6 +7,9 @@ import (
 	"net"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
@@ -14,6 +17,12 @@ var (
 	Nil       = []byte("$-1\r\n")
 )
 
+//replyBufferSize is the size of the write buffer of BufRedisConn, the most bytes of replies held while batching
+const replyBufferSize = 16 * 1024
+
+//maxScratchSize is the largest encoding buffer kept by a connection for the next reply
+const maxScratchSize = 64 * 1024
+
 type Resp struct {
 	Type byte
 	Val  interface{}
@@ -35,10 +44,32 @@ func NewNil() *Resp {
 	return &Resp{Type: '$', Nil: true}
 }
 
+//NewNilArray creates the nil array of RESP2, such as the reply of a blocking command which timed out.
+//It's the null in RESP3.
+func NewNilArray() *Resp {
+	return &Resp{Type: '*', Nil: true}
+}
+
 func NewBulk(val string) *Resp {
 	return &Resp{Type: '$', Val: val, Nil: false}
 }
 
+func NewString(val string) *Resp {
+	return &Resp{Type: '+', Val: val}
+}
+
+func NewError(val string) *Resp {
+	return &Resp{Type: '-', Val: val}
+}
+
+func NewInteger(val int) *Resp {
+	return &Resp{Type: ':', Val: val}
+}
+
+func NewArray(val []*Resp) *Resp {
+	return &Resp{Type: '*', Val: val}
+}
+
 type RedisRW interface {
 	ReadByte() (byte, error)
 	ReadLine() (string, error)
@@ -55,23 +86,42 @@ type RedisRW interface {
 	WriteError(val string) error
 	WriteArray(val []*Resp) error
 	WriteNil() error
+	WriteMap(val []*Resp) error
+	WriteSet(val []*Resp) error
+	WritePush(val []*Resp) error
+	WriteDouble(val float64) error
+	WriteBoolean(val bool) error
+	WriteBigNumber(val string) error
+	WriteVerbatim(format, val string) error
+	WriteAttribute(val []*Resp) error
+	WriteResp(val *Resp) error
+
+	//Protocol returns the RESP version negotiated by HELLO, it decides how replies are written
+	Protocol() int
+	SetProtocol(proto int)
 
 	Close()
 	IsClosed() bool
+	RemoteAddr() net.Addr
+	LocalAddr() net.Addr
 }
 
 //RedisConn represents a connection establish between client and server
 type RedisConn struct {
-	con net.Conn
+	con   net.Conn
+	proto int
 
 	buf       []byte
 	limit     int
 	readIndex int
-	closed    bool
+	//closed is set atomically, the connection may be closed by another goroutine
+	closed int32
+	//wmu serializes the writes, replies of other connections are pushed by pubsub, tracking and monitor
+	wmu sync.Mutex
 }
 
 func NewRedisConn(con net.Conn) *RedisConn {
-	return &RedisConn{con: con, buf: make([]byte, 1024), limit: 0, readIndex: 0}
+	return &RedisConn{con: con, proto: Resp2, buf: make([]byte, 1024), limit: 0, readIndex: 0}
 }
 
 func (r *RedisConn) Write(data [][]byte) error {
@@ -82,6 +132,8 @@ func (r *RedisConn) Write(data [][]byte) error {
 }
 
 func (r *RedisConn) WriteBytes(data ...[]byte) error {
+	r.wmu.Lock()
+	defer r.wmu.Unlock()
 	for _, d := range data {
 		if _, err := r.con.Write(d); err != nil {
 			return err
@@ -242,13 +294,60 @@ func (r *RedisConn) ReadReply() (interface{}, error) {
 		return r.ReadInt()
 	case '$':
 		return r.ReadBulk()
-	case '*':
+	case '*', '~', '>':
 		return r.ReadArray()
+	case '%':
+		return r.readMap()
+	case '_':
+		_, err := r.ReadLine()
+		return NewNull(), err
+	case ',':
+		line, err := r.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		return strconv.ParseFloat(line, 64)
+	case '#':
+		line, err := r.ReadLine()
+		return line == "t", err
+	case '(':
+		return r.ReadLine()
+	case '=':
+		rp, err := r.ReadBulk()
+		if err != nil {
+			return nil, err
+		}
+		//skip the format such as `txt:`
+		if s, ok := rp.Val.(string); ok && len(s) >= 4 {
+			rp.Val = s[4:]
+		}
+		return rp, nil
+	case '|':
+		//attributes are auxiliary data, read and drop them before the real reply
+		if _, err := r.readMap(); err != nil {
+			return nil, err
+		}
+		return r.ReadReply()
 	default:
 		return nil, fmt.Errorf("unknown type:%c", b)
 	}
 }
 
+//readMap reads a RESP3 map or attribute, keys and values are returned alternately
+func (r *RedisConn) readMap() ([]interface{}, error) {
+	n, err := r.ReadInt()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]interface{}, 2*n)
+	for i := range ret {
+		if ret[i], err = r.ReadReply(); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
 //ReadRequest read commands sent from a client
 func (r *RedisConn) ReadRequest() ([]string, error) {
 	b, err := r.ReadByte()
@@ -351,95 +450,166 @@ func (r *RedisConn) WriteError(val string) error {
 }
 
 func (r *RedisConn) WriteArray(val []*Resp) error {
-	err := r.WriteBytes([]byte("*"), []byte(strconv.Itoa(len(val))), Delimiter)
+	return r.WriteResp(NewArray(val))
+}
+
+func (r *RedisConn) WriteMap(val []*Resp) error {
+	return r.WriteResp(NewMap(val))
+}
+
+func (r *RedisConn) WriteSet(val []*Resp) error {
+	return r.WriteResp(NewSet(val))
+}
+
+func (r *RedisConn) WritePush(val []*Resp) error {
+	return r.WriteResp(NewPush(val))
+}
+
+func (r *RedisConn) WriteDouble(val float64) error {
+	return r.WriteResp(NewDouble(val))
+}
+
+func (r *RedisConn) WriteBoolean(val bool) error {
+	return r.WriteResp(NewBoolean(val))
+}
+
+func (r *RedisConn) WriteBigNumber(val string) error {
+	return r.WriteResp(NewBigNumber(val))
+}
+
+func (r *RedisConn) WriteVerbatim(format, val string) error {
+	return r.WriteResp(NewVerbatim(format, val))
+}
+
+func (r *RedisConn) WriteAttribute(val []*Resp) error {
+	return r.WriteResp(NewAttribute(val))
+}
+
+func (r *RedisConn) WriteResp(val *Resp) error {
+	data, err := appendResp(nil, r.proto, val)
 	if err != nil {
 		return err
 	}
+	return r.WriteBytes(data)
+}
 
-	for _, v := range val {
-		t := v.Type
-		switch t {
-		case '+':
-			if s, ok := v.Val.(string); ok {
-				err = r.WriteString(s)
-			} else {
-				return fmt.Errorf("illegal simple string type")
-			}
-		case '-':
-			if s, ok := v.Val.(string); ok {
-				err = r.WriteString(s)
-			} else {
-				return fmt.Errorf("illegal simple error type")
-			}
-		case ':':
-			if s, ok := v.Val.(int); ok {
-				err = r.WriteInteger(s)
-			} else {
-				return fmt.Errorf("illegal simple integer type")
-			}
-		case '$':
-			if v.Nil {
-				err = r.WriteNil()
-			} else if s, ok := v.Val.(string); ok {
-				err = r.WriteBulk(s)
-			} else {
-				return fmt.Errorf("illegal simple bulk string type")
-			}
-		case '*':
-			if s, ok := v.Val.([]*Resp); ok {
-				err = r.WriteArray(s)
-			}
-			return fmt.Errorf("illegal simple array type")
-		default:
-			return fmt.Errorf("unknown type:%c", t)
-		}
+func (r *RedisConn) Protocol() int {
+	return r.proto
+}
 
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+func (r *RedisConn) SetProtocol(proto int) {
+	r.proto = proto
 }
 
 func (r *RedisConn) Close() {
-	r.closed = true
+	atomic.StoreInt32(&r.closed, 1)
 	r.con.Close()
 }
 
 func (r *RedisConn) IsClosed() bool {
-	return r.closed
+	return atomic.LoadInt32(&r.closed) == 1
+}
+
+func (r *RedisConn) RemoteAddr() net.Addr {
+	return r.con.RemoteAddr()
+}
+
+func (r *RedisConn) LocalAddr() net.Addr {
+	return r.con.LocalAddr()
 }
 
 func (r *RedisConn) WriteNil() error {
-	b := []byte("$-1\r\n")
-	return r.WriteBytes(b)
+	return r.WriteBytes(appendNull(nil, r.proto))
 }
 
 type BufRedisConn struct {
 	con    net.Conn
 	reader *bufio.Reader
 	writer *bufio.Writer
-	closed bool
+	//closed is set atomically, the connection may be closed by another goroutine
+	closed int32
+	proto  int
+	//wmu serializes the writes, replies of other connections are pushed by pubsub, tracking and monitor.
+	//It guards writer and scratch.
+	wmu sync.Mutex
+	//batching is set atomically while replies are held in writer, see SetBatching
+	batching int32
+	//scratch is reused to encode replies
+	scratch []byte
+
+	//argBuf holds the arguments of the last request, args are views into it and ends are where they end
+	argBuf []byte
+	args   []string
+	ends   []int
 }
 
 func (c *BufRedisConn) Write(data [][]byte) error {
+	return c.writeBytes(data...)
+}
+
+func (c *BufRedisConn) writeBytes(data ...[]byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.writeLocked(data...)
+}
+
+func (c *BufRedisConn) writeLocked(data ...[]byte) error {
 	for i := range data {
 		if _, err := c.writer.Write(data[i]); err != nil {
 			return err
 		}
 	}
+	if atomic.LoadInt32(&c.batching) == 1 {
+		return nil
+	}
 	return c.writer.Flush()
 }
 
-func (c *BufRedisConn) writeBytes(data ...[]byte) error {
-	for i := range data {
-		if _, err := c.writer.Write(data[i]); err != nil {
-			return err
-		}
+//SetBatching holds the replies in the write buffer, they are written once batching is unset
+//or when the buffer is full. A client pipelining requests is answered with one write instead of one per reply.
+func (c *BufRedisConn) SetBatching(batching bool) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if batching {
+		atomic.StoreInt32(&c.batching, 1)
+		return nil
 	}
+	atomic.StoreInt32(&c.batching, 0)
 	return c.writer.Flush()
 }
 
+//Pipelined reports whether a whole request is already buffered, so its reply can be written with the previous ones
+func (c *BufRedisConn) Pipelined() bool {
+	n := c.reader.Buffered()
+	if n == 0 {
+		return false
+	}
+	buf, _ := c.reader.Peek(n)
+	used, err := RequestLen(buf)
+	return used > 0 || err != nil
+}
+
+//WatchHangup calls fn when the peer closes the connection before stop is called.
+//It's used while the goroutine of the connection doesn't read, like when a command blocks:
+//a request sent meanwhile stays buffered and ends the watch.
+func (c *BufRedisConn) WatchHangup(fn func()) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := c.reader.Peek(1); err != nil {
+			if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+				fn()
+			}
+		}
+	}()
+	return func() {
+		//a deadline in the past ends the Peek, the reader returns the timeout once and stays usable
+		c.con.SetReadDeadline(time.Now())
+		<-done
+		c.con.SetReadDeadline(time.Time{})
+	}
+}
+
 func (c *BufRedisConn) ReadByte() (byte, error) {
 	return c.reader.ReadByte()
 }
@@ -459,14 +629,16 @@ func (c *BufRedisConn) ReadLine() (string, error) {
 }
 
 func (c *BufRedisConn) ReadInt() (int, error) {
-	bytes, err := c.reader.ReadBytes('\r')
+	line, err := c.reader.ReadSlice('\n')
 	if err != nil {
 		return -1, err
 	}
-	c.reader.Discard(1)
-	n := 0
-	for i := 0; i < len(bytes)-1; i++ {
-		n = n*10 + int(bytes[i]-'0')
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return -1, fmt.Errorf("illegal line, expect \\r\\n")
+	}
+	n, ok := parseInt(line[:len(line)-2])
+	if !ok {
+		return -1, fmt.Errorf("illegal integer '%s'", line[:len(line)-2])
 	}
 	return n, nil
 }
@@ -521,13 +693,62 @@ func (c *BufRedisConn) ReadReply() (interface{}, error) {
 		return c.ReadInt()
 	case '$':
 		return c.ReadBulk()
-	case '*':
+	case '*', '~', '>':
 		return c.ReadArray()
+	case '%':
+		return c.readMap()
+	case '_':
+		_, err := c.ReadLine()
+		return NewNull(), err
+	case ',':
+		line, err := c.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		return strconv.ParseFloat(line, 64)
+	case '#':
+		line, err := c.ReadLine()
+		return line == "t", err
+	case '(':
+		return c.ReadLine()
+	case '=':
+		rp, err := c.ReadBulk()
+		if err != nil {
+			return nil, err
+		}
+		//skip the format such as `txt:`
+		if s, ok := rp.Val.(string); ok && len(s) >= 4 {
+			rp.Val = s[4:]
+		}
+		return rp, nil
+	case '|':
+		//attributes are auxiliary data, read and drop them before the real reply
+		if _, err := c.readMap(); err != nil {
+			return nil, err
+		}
+		return c.ReadReply()
 	default:
 		return nil, fmt.Errorf("unknown type:%c", b)
 	}
 }
 
+//readMap reads a RESP3 map or attribute, keys and values are returned alternately
+func (c *BufRedisConn) readMap() ([]interface{}, error) {
+	n, err := c.ReadInt()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]interface{}, 2*n)
+	for i := range ret {
+		if ret[i], err = c.ReadReply(); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+//ReadRequest reads a request without allocating: the arguments are views into a buffer of the connection,
+//they are only valid until the next request is read and must be cloned to be kept.
 func (c *BufRedisConn) ReadRequest() ([]string, error) {
 	bs, err := c.reader.Peek(1)
 	if err != nil {
@@ -542,34 +763,54 @@ func (c *BufRedisConn) ReadRequest() ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
+	if n < 0 || n > maxMultiBulkLen {
+		return nil, errMultiBulkLen
+	}
 
-	ret := make([]string, n)
+	//a buffer grown by a large request is not kept
+	if cap(c.argBuf) > maxScratchSize {
+		c.argBuf = nil
+	}
+	buf, ends := c.argBuf[:0], c.ends[:0]
 	for i := 0; i < n; i++ {
 		b, err := c.ReadByte()
 		if err != nil {
 			return nil, err
 		}
-
 		if b != '$' {
 			return nil, fmt.Errorf("illegal request, type:%c", b)
 		}
-
-		rp, err := c.ReadBulk()
+		size, err := c.ReadInt()
 		if err != nil {
 			return nil, err
 		}
 		//request should not contain 'nil'
-		if rp.Nil == true {
-			return nil, fmt.Errorf("illegal request, should not contain nil")
+		if size < 0 || size > maxBulkLen {
+			return nil, errBulkLen
 		}
-		v, ok := rp.Val.(string)
-		if !ok {
-			return nil, fmt.Errorf("illegal request, bulk is not string")
+		start := len(buf)
+		if cap(buf)-start < size+2 {
+			grown := make([]byte, start, 2*cap(buf)+size+2)
+			copy(grown, buf)
+			buf = grown
 		}
-		ret[i] = v
+		buf = buf[:start+size+2]
+		if _, err := io.ReadFull(c.reader, buf[start:]); err != nil {
+			return nil, err
+		}
+		buf = buf[:start+size]
+		ends = append(ends, start+size)
 	}
 
-	return ret, nil
+	//views are only made once buf doesn't grow anymore
+	args := c.args[:0]
+	start := 0
+	for _, end := range ends {
+		args = append(args, bytesToString(buf[start:end]))
+		start = end
+	}
+	c.argBuf, c.ends, c.args = buf, ends, args
+	return args, nil
 }
 
 func (c *BufRedisConn) ReadInlineRequest() ([]string, error) {
@@ -600,99 +841,111 @@ func (c *BufRedisConn) ReadInlineRequest() ([]string, error) {
 }
 
 func (c *BufRedisConn) WriteString(val string) error {
-	c.writeBytes([]byte("+"), []byte(val), Delimiter)
-	return c.writer.Flush()
+	return c.writeBytes([]byte("+"), []byte(val), Delimiter)
 }
 
 func (c *BufRedisConn) WriteInteger(val int) error {
-	c.writeBytes([]byte(":"), []byte(strconv.Itoa(val)), Delimiter)
-	return c.writer.Flush()
+	return c.writeBytes([]byte(":"), []byte(strconv.Itoa(val)), Delimiter)
 }
 
 func (c *BufRedisConn) WriteBulk(val string) error {
 	data := []byte(val)
-	c.writeBytes([]byte("$"), []byte(strconv.Itoa(len(data))), Delimiter, data, Delimiter)
-	return c.writer.Flush()
+	return c.writeBytes([]byte("$"), []byte(strconv.Itoa(len(data))), Delimiter, data, Delimiter)
 }
 
 func (c *BufRedisConn) WriteError(val string) error {
-	c.writeBytes([]byte("-"), []byte(val), Delimiter)
-	return c.writer.Flush()
+	return c.writeBytes([]byte("-"), []byte(val), Delimiter)
 }
 
 func (c *BufRedisConn) WriteNil() error {
-	c.writeBytes(Nil)
-	return c.writer.Flush()
+	return c.writeBytes(appendNull(nil, c.proto))
 }
 
 func (c *BufRedisConn) WriteArray(val []*Resp) error {
-	c.writeArray(val)
-	return c.writer.Flush()
+	return c.WriteResp(NewArray(val))
 }
 
-func (c *BufRedisConn) writeArray(val []*Resp) error {
-	err := c.writeBytes([]byte("*"), []byte(strconv.Itoa(len(val))), Delimiter)
+func (c *BufRedisConn) WriteMap(val []*Resp) error {
+	return c.WriteResp(NewMap(val))
+}
+
+func (c *BufRedisConn) WriteSet(val []*Resp) error {
+	return c.WriteResp(NewSet(val))
+}
+
+func (c *BufRedisConn) WritePush(val []*Resp) error {
+	return c.WriteResp(NewPush(val))
+}
+
+func (c *BufRedisConn) WriteDouble(val float64) error {
+	return c.WriteResp(NewDouble(val))
+}
+
+func (c *BufRedisConn) WriteBoolean(val bool) error {
+	return c.WriteResp(NewBoolean(val))
+}
+
+func (c *BufRedisConn) WriteBigNumber(val string) error {
+	return c.WriteResp(NewBigNumber(val))
+}
+
+func (c *BufRedisConn) WriteVerbatim(format, val string) error {
+	return c.WriteResp(NewVerbatim(format, val))
+}
+
+func (c *BufRedisConn) WriteAttribute(val []*Resp) error {
+	return c.WriteResp(NewAttribute(val))
+}
+
+func (c *BufRedisConn) WriteResp(val *Resp) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	data, err := appendResp(c.scratch[:0], c.proto, val)
 	if err != nil {
 		return err
 	}
-	for _, v := range val {
-		t := v.Type
-		switch t {
-		case '+':
-			if s, ok := v.Val.(string); ok {
-				err = c.writeBytes([]byte("+"), []byte(s), Delimiter)
-			} else {
-				return fmt.Errorf("illegal simple string type")
-			}
-		case '-':
-			if s, ok := v.Val.(string); ok {
-				err = c.writeBytes([]byte("+"), []byte(s), Delimiter)
-			} else {
-				return fmt.Errorf("illegal simple error type")
-			}
-		case ':':
-			if s, ok := v.Val.(int); ok {
-				err = c.writeBytes([]byte(":"), []byte(strconv.Itoa(s)), Delimiter)
-			} else {
-				return fmt.Errorf("illegal simple integer type")
-			}
-		case '$':
-			if v.Nil {
-				err = c.writeBytes(Nil)
-			} else if s, ok := v.Val.(string); ok {
-				data := []byte(s)
-				err = c.writeBytes([]byte("$"), []byte(strconv.Itoa(len(data))), Delimiter, data, Delimiter)
-			} else {
-				return fmt.Errorf("illegal simple bulk string type")
-			}
-		case '*':
-			if s, ok := v.Val.([]*Resp); ok {
-				err = c.writeArray(s)
-				if err != nil {
-					return err
-				}
-			}
-			return fmt.Errorf("illegal simple array type")
-		default:
-			return fmt.Errorf("unknown type:%c", t)
-		}
-
-		if err != nil {
-			return err
-		}
+	if cap(data) <= maxScratchSize {
+		c.scratch = data
 	}
-	return nil
+	return c.writeLocked(data)
+}
+
+func (c *BufRedisConn) Protocol() int {
+	return c.proto
+}
+
+func (c *BufRedisConn) SetProtocol(proto int) {
+	c.proto = proto
 }
 
 func (c *BufRedisConn) Close() {
-	c.closed = true
+	//replies held while batching, like the +OK of QUIT, are still sent
+	if atomic.LoadInt32(&c.batching) == 1 {
+		c.SetBatching(false)
+	}
+	atomic.StoreInt32(&c.closed, 1)
+	c.con.Close()
+}
+
+//Abort closes the connection without flushing the buffered replies,
+//a writer blocked on the socket returns with an error.
+func (c *BufRedisConn) Abort() {
+	atomic.StoreInt32(&c.closed, 1)
 	c.con.Close()
 }
 
 func (c *BufRedisConn) IsClosed() bool {
-	return c.closed
+	return atomic.LoadInt32(&c.closed) == 1
+}
+
+func (c *BufRedisConn) RemoteAddr() net.Addr {
+	return c.con.RemoteAddr()
+}
+
+func (c *BufRedisConn) LocalAddr() net.Addr {
+	return c.con.LocalAddr()
 }
 
 func NewBufRedisConn(con net.Conn) *BufRedisConn {
-	return &BufRedisConn{con: con, reader: bufio.NewReader(con), writer: bufio.NewWriter(con)}
+	return &BufRedisConn{con: con, reader: bufio.NewReader(con), writer: bufio.NewWriterSize(con, replyBufferSize), proto: Resp2}
 }
@@ -0,0 +1,152 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+const (
+	//maxMultiBulkLen is the max number of arguments of a request
+	maxMultiBulkLen = 1024 * 1024
+	//maxBulkLen is the max size of an argument
+	maxBulkLen = 512 * 1024 * 1024
+)
+
+var (
+	errMultiBulkLen = errors.New("Protocol error: invalid multibulk length")
+	errBulkLen      = errors.New("Protocol error: invalid bulk length")
+)
+
+//ParseRequest parses the request at the beginning of buf, in the multi bulk format or inline,
+//so requests can be parsed from the bytes read by an event loop.
+//It returns the arguments and the number of bytes used, 0 if the request is not complete yet.
+//An empty inline request has no arguments but still uses its bytes.
+func ParseRequest(buf []byte) ([]string, int, error) {
+	return parseRequest(buf, nil, func(b []byte) string {
+		return string(b)
+	})
+}
+
+//ParseRequestViews is like ParseRequest but the arguments, appended to args[:0], are views into buf:
+//they don't allocate but are only valid until buf is changed, and must be cloned to be kept.
+func ParseRequestViews(buf []byte, args []string) ([]string, int, error) {
+	return parseRequest(buf, args[:0], bytesToString)
+}
+
+//RequestLen returns the size of the request at the beginning of buf, 0 if it's not complete yet.
+//Unlike ParseRequest it doesn't allocate the arguments.
+func RequestLen(buf []byte) (int, error) {
+	_, n, err := parseRequest(buf, nil, nil)
+	return n, err
+}
+
+//parseRequest parses a request, the arguments converted by conv are appended to args unless conv is nil
+func parseRequest(buf []byte, args []string, conv func([]byte) string) ([]string, int, error) {
+	if len(buf) == 0 {
+		return nil, 0, nil
+	}
+	if buf[0] != '*' {
+		return parseInlineRequest(buf, args, conv)
+	}
+
+	n, pos, err := parseLength(buf, 1)
+	if err != nil || pos == 0 {
+		return nil, 0, err
+	}
+	if n > maxMultiBulkLen {
+		return nil, 0, errMultiBulkLen
+	}
+	if n <= 0 {
+		return nil, pos, nil
+	}
+	if conv != nil && args == nil {
+		args = make([]string, 0, n)
+	}
+	for i := 0; i < n; i++ {
+		if pos >= len(buf) {
+			return nil, 0, nil
+		}
+		if buf[pos] != '$' {
+			return nil, 0, fmt.Errorf("Protocol error: expected '$', got '%c'", buf[pos])
+		}
+		size, next, err := parseLength(buf, pos+1)
+		if err != nil || next == 0 {
+			return nil, 0, err
+		}
+		if size < 0 || size > maxBulkLen {
+			return nil, 0, errBulkLen
+		}
+		if next+size+2 > len(buf) {
+			return nil, 0, nil
+		}
+		if conv != nil {
+			args = append(args, conv(buf[next:next+size]))
+		}
+		pos = next + size + 2
+	}
+	return args, pos, nil
+}
+
+//parseLength parses the number of a line starting at pos, like the 3 of "*3\r\n".
+//It returns the position after the line, 0 if the line is not complete yet.
+func parseLength(buf []byte, pos int) (int, int, error) {
+	end := bytes.IndexByte(buf[pos:], '\n')
+	if end < 0 {
+		return 0, 0, nil
+	}
+	end += pos
+	line := buf[pos:end]
+	if len(line) == 0 || line[len(line)-1] != '\r' {
+		return 0, 0, errMultiBulkLen
+	}
+	n, ok := parseInt(line[:len(line)-1])
+	if !ok {
+		if buf[pos-1] == '$' {
+			return 0, 0, errBulkLen
+		}
+		return 0, 0, errMultiBulkLen
+	}
+	return n, end + 1, nil
+}
+
+func parseInlineRequest(buf []byte, args []string, conv func([]byte) string) ([]string, int, error) {
+	end := bytes.IndexByte(buf, '\n')
+	if end < 0 {
+		return nil, 0, nil
+	}
+	if conv == nil {
+		return nil, end + 1, nil
+	}
+	return append(args, strings.Fields(conv(buf[:end]))...), end + 1, nil
+}
+
+//parseInt parses a decimal integer without allocating, unlike strconv.Atoi(string(b))
+func parseInt(b []byte) (int, bool) {
+	neg := len(b) > 0 && b[0] == '-'
+	if neg {
+		b = b[1:]
+	}
+	//more digits could overflow, no length of the protocol is that large
+	if len(b) == 0 || len(b) > 18 {
+		return 0, false
+	}
+	n := 0
+	for _, d := range b {
+		if d < '0' || d > '9' {
+			return 0, false
+		}
+		n = n*10 + int(d-'0')
+	}
+	if neg {
+		return -n, true
+	}
+	return n, true
+}
+
+//bytesToString returns a string sharing the memory of b, b must not change while the string is used
+func bytesToString(b []byte) string {
+	return *(*string)(unsafe.Pointer(&b))
+}
@@ -0,0 +1,111 @@
+package protocol
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func TestParseRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		buf  string
+		args []string
+		n    int
+		err  string
+	}{
+		{"empty", "", nil, 0, ""},
+		{"multi bulk", "*2\r\n$3\r\nGET\r\n$1\r\nk\r\n", []string{"GET", "k"}, 20, ""},
+		{"pipelined", "*1\r\n$4\r\nPING\r\n*1\r\n$4\r\nPING\r\n", []string{"PING"}, 14, ""},
+		{"empty bulk", "*2\r\n$3\r\nGET\r\n$0\r\n\r\n", []string{"GET", ""}, 19, ""},
+		{"partial header", "*2\r", nil, 0, ""},
+		{"partial bulk", "*2\r\n$3\r\nGET\r\n$5\r\nab", nil, 0, ""},
+		{"partial delimiter", "*1\r\n$4\r\nPING\r", nil, 0, ""},
+		{"inline", "SET k v\r\n", []string{"SET", "k", "v"}, 9, ""},
+		{"inline partial", "SET k", nil, 0, ""},
+		{"inline empty", "\r\n", nil, 2, ""},
+		{"no dollar", "*1\r\n:4\r\n", nil, 0, "Protocol error: expected '$', got ':'"},
+		{"bad multi bulk length", "*x\r\n", nil, 0, "Protocol error: invalid multibulk length"},
+		{"bad bulk length", "*1\r\n$-3\r\n", nil, 0, "Protocol error: invalid bulk length"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, n, err := ParseRequest([]byte(tt.buf))
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.args, args)
+			assert.Equal(t, tt.n, n)
+
+			n, err = RequestLen([]byte(tt.buf))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.n, n)
+
+			views, n, err := ParseRequestViews([]byte(tt.buf), make([]string, 4))
+			assert.NoError(t, err)
+			assert.Equal(t, len(tt.args), len(views))
+			for i := range views {
+				assert.Equal(t, tt.args[i], views[i])
+			}
+			assert.Equal(t, tt.n, n)
+		})
+	}
+}
+
+func TestParseInt(t *testing.T) {
+	for s, want := range map[string]int{"0": 0, "42": 42, "-1": -1, "123456789012345678": 123456789012345678} {
+		n, ok := parseInt([]byte(s))
+		assert.True(t, ok, s)
+		assert.Equal(t, want, n)
+	}
+	for _, s := range []string{"", "-", "1a", "+1", "1234567890123456789"} {
+		_, ok := parseInt([]byte(s))
+		assert.False(t, ok, s)
+	}
+}
+
+var setRequest = []byte("*3\r\n$3\r\nSET\r\n$8\r\nkey:1234\r\n$16\r\nvalue:1234567890\r\n")
+
+func BenchmarkParseRequest(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ParseRequest(setRequest)
+	}
+}
+
+func BenchmarkParseRequestViews(b *testing.B) {
+	b.ReportAllocs()
+	var args []string
+	for i := 0; i < b.N; i++ {
+		args, _, _ = ParseRequestViews(setRequest, args)
+	}
+}
+
+//repeatConn is a connection reading the same bytes forever
+type repeatConn struct {
+	net.Conn
+	data []byte
+	pos  int
+}
+
+func (c *repeatConn) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		m := copy(p[n:], c.data[c.pos:])
+		n += m
+		c.pos = (c.pos + m) % len(c.data)
+	}
+	return n, nil
+}
+
+func BenchmarkReadRequest(b *testing.B) {
+	b.ReportAllocs()
+	c := NewBufRedisConn(&repeatConn{data: setRequest})
+	for i := 0; i < b.N; i++ {
+		if _, err := c.ReadRequest(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
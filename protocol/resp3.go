@@ -0,0 +1,213 @@
+package protocol
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+//https://github.com/antirez/RESP3/blob/master/spec.md
+const (
+	Resp2 = 2
+	Resp3 = 3
+)
+
+//NewMap creates a map reply, val holds keys and values alternately
+func NewMap(val []*Resp) *Resp {
+	return &Resp{Type: '%', Val: val}
+}
+
+func NewSet(val []*Resp) *Resp {
+	return &Resp{Type: '~', Val: val}
+}
+
+//NewPush creates an out of band message, such as a pub/sub message or an invalidation message
+func NewPush(val []*Resp) *Resp {
+	return &Resp{Type: '>', Val: val}
+}
+
+func NewDouble(val float64) *Resp {
+	return &Resp{Type: ',', Val: val}
+}
+
+func NewBoolean(val bool) *Resp {
+	return &Resp{Type: '#', Val: val}
+}
+
+//NewNull creates the RESP3 null, which replaces both the nil bulk string and the nil array of RESP2
+func NewNull() *Resp {
+	return &Resp{Type: '_', Nil: true}
+}
+
+//NewBigNumber creates a big number reply, val is the decimal representation of the number
+func NewBigNumber(val string) *Resp {
+	return &Resp{Type: '(', Val: val}
+}
+
+//NewVerbatim creates a verbatim string, format is a three bytes type such as `txt` or `mkd`
+func NewVerbatim(format, val string) *Resp {
+	return &Resp{Type: '=', Val: format + ":" + val}
+}
+
+//NewAttribute creates auxiliary data attached to the reply written after it, val holds keys and values alternately
+func NewAttribute(val []*Resp) *Resp {
+	return &Resp{Type: '|', Val: val}
+}
+
+//FormatDouble formats a double the way it is written in RESP3
+func FormatDouble(val float64) string {
+	switch {
+	case math.IsInf(val, 1):
+		return "inf"
+	case math.IsInf(val, -1):
+		return "-inf"
+	case math.IsNaN(val):
+		return "nan"
+	}
+	return strconv.FormatFloat(val, 'g', -1, 64)
+}
+
+//formatDouble2 formats a double as the bulk string written to RESP2 connections,
+//with the 17 significant digits of %.17g like redis so the value is not rounded
+func formatDouble2(val float64) string {
+	if math.IsInf(val, 0) || math.IsNaN(val) {
+		return FormatDouble(val)
+	}
+	return strconv.FormatFloat(val, 'g', 17, 64)
+}
+
+func appendHeader(buf []byte, t byte, n int) []byte {
+	buf = append(buf, t)
+	buf = strconv.AppendInt(buf, int64(n), 10)
+	return append(buf, Delimiter...)
+}
+
+func appendBulk(buf []byte, s string) []byte {
+	buf = appendHeader(buf, '$', len(s))
+	buf = append(buf, s...)
+	return append(buf, Delimiter...)
+}
+
+func appendNull(buf []byte, proto int) []byte {
+	if proto == Resp3 {
+		return append(buf, "_\r\n"...)
+	}
+	return append(buf, Nil...)
+}
+
+//AppendResp appends the encoded v to buf the way a connection speaking proto writes it,
+//it's used by the connections implemented outside of this package.
+func AppendResp(buf []byte, proto int, v *Resp) ([]byte, error) {
+	return appendResp(buf, proto, v)
+}
+
+//appendResp appends the encoded v to buf,
+//types which don't exist in RESP2 are converted to their RESP2 equivalent unless proto is Resp3.
+func appendResp(buf []byte, proto int, v *Resp) ([]byte, error) {
+	switch v.Type {
+	case '+', '-':
+		s, ok := v.Val.(string)
+		if !ok {
+			return buf, fmt.Errorf("illegal simple string type")
+		}
+		buf = append(buf, v.Type)
+		buf = append(buf, s...)
+		buf = append(buf, Delimiter...)
+	case ':':
+		i, ok := v.Val.(int)
+		if !ok {
+			return buf, fmt.Errorf("illegal simple integer type")
+		}
+		buf = appendHeader(buf, ':', i)
+	case '$':
+		if v.Nil {
+			return appendNull(buf, proto), nil
+		}
+		s, ok := v.Val.(string)
+		if !ok {
+			return buf, fmt.Errorf("illegal simple bulk string type")
+		}
+		buf = appendBulk(buf, s)
+	case '_':
+		buf = appendNull(buf, proto)
+	case '*', '~', '>', '%', '|':
+		if v.Nil {
+			if proto == Resp3 {
+				return append(buf, "_\r\n"...), nil
+			}
+			return append(buf, "*-1\r\n"...), nil
+		}
+		array, ok := v.Val.([]*Resp)
+		if !ok && v.Val != nil {
+			return buf, fmt.Errorf("illegal aggregate type:%c", v.Type)
+		}
+		t, n := v.Type, len(array)
+		if proto != Resp3 {
+			if t == '|' {
+				//attributes are simply dropped in RESP2
+				return buf, nil
+			}
+			t = '*'
+		} else if t == '%' || t == '|' {
+			n = n / 2
+		}
+		buf = appendHeader(buf, t, n)
+		var err error
+		for _, e := range array {
+			if buf, err = appendResp(buf, proto, e); err != nil {
+				return buf, err
+			}
+		}
+	case ',':
+		f, ok := v.Val.(float64)
+		if !ok {
+			return buf, fmt.Errorf("illegal double type")
+		}
+		if proto != Resp3 {
+			return appendBulk(buf, formatDouble2(f)), nil
+		}
+		buf = append(buf, ',')
+		buf = append(buf, FormatDouble(f)...)
+		buf = append(buf, Delimiter...)
+	case '#':
+		b, ok := v.Val.(bool)
+		if !ok {
+			return buf, fmt.Errorf("illegal boolean type")
+		}
+		if proto != Resp3 {
+			if b {
+				return append(buf, ":1\r\n"...), nil
+			}
+			return append(buf, ":0\r\n"...), nil
+		}
+		if b {
+			return append(buf, "#t\r\n"...), nil
+		}
+		return append(buf, "#f\r\n"...), nil
+	case '(':
+		s, ok := v.Val.(string)
+		if !ok {
+			return buf, fmt.Errorf("illegal big number type")
+		}
+		if proto != Resp3 {
+			return appendBulk(buf, s), nil
+		}
+		buf = append(buf, '(')
+		buf = append(buf, s...)
+		buf = append(buf, Delimiter...)
+	case '=':
+		s, ok := v.Val.(string)
+		if !ok || len(s) < 4 {
+			return buf, fmt.Errorf("illegal verbatim string type")
+		}
+		if proto != Resp3 {
+			return appendBulk(buf, s[4:]), nil
+		}
+		buf = appendHeader(buf, '=', len(s))
+		buf = append(buf, s...)
+		buf = append(buf, Delimiter...)
+	default:
+		return buf, fmt.Errorf("unknown type:%c", v.Type)
+	}
+	return buf, nil
+}
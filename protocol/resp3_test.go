@@ -0,0 +1,42 @@
+package protocol
+
+import (
+	"math"
+	"testing"
+)
+
+func Test_appendResp(t *testing.T) {
+	tests := []struct {
+		name  string
+		val   *Resp
+		resp2 string
+		resp3 string
+	}{
+		{"nil", NewNil(), "$-1\r\n", "_\r\n"},
+		{"nil array", NewNilArray(), "*-1\r\n", "_\r\n"},
+		{"map", NewMap([]*Resp{NewBulk("k"), NewInteger(1)}), "*2\r\n$1\r\nk\r\n:1\r\n", "%1\r\n$1\r\nk\r\n:1\r\n"},
+		{"set", NewSet([]*Resp{NewBulk("a")}), "*1\r\n$1\r\na\r\n", "~1\r\n$1\r\na\r\n"},
+		{"push", NewPush([]*Resp{NewBulk("a")}), "*1\r\n$1\r\na\r\n", ">1\r\n$1\r\na\r\n"},
+		{"double", NewDouble(1.5), "$3\r\n1.5\r\n", ",1.5\r\n"},
+		{"integral double", NewDouble(1), "$1\r\n1\r\n", ",1\r\n"},
+		{"small double", NewDouble(0.0000001), "$22\r\n9.9999999999999995e-08\r\n", ",1e-07\r\n"},
+		{"inf", NewDouble(math.Inf(-1)), "$4\r\n-inf\r\n", ",-inf\r\n"},
+		{"boolean", NewBoolean(true), ":1\r\n", "#t\r\n"},
+		{"big number", NewBigNumber("123"), "$3\r\n123\r\n", "(123\r\n"},
+		{"verbatim", NewVerbatim("txt", "hi"), "$2\r\nhi\r\n", "=6\r\ntxt:hi\r\n"},
+		{"attribute", NewAttribute([]*Resp{NewBulk("k"), NewBulk("v")}), "", "|1\r\n$1\r\nk\r\n$1\r\nv\r\n"},
+		{"nested", NewArray([]*Resp{NewArray([]*Resp{NewInteger(1)}), &Resp{Type: '-', Val: "ERR"}}), "*2\r\n*1\r\n:1\r\n-ERR\r\n", "*2\r\n*1\r\n:1\r\n-ERR\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := appendResp(nil, Resp2, tt.val)
+			if err != nil || string(got) != tt.resp2 {
+				t.Errorf("appendResp() RESP2 = %q, %v, want %q", got, err, tt.resp2)
+			}
+			got, err = appendResp(nil, Resp3, tt.val)
+			if err != nil || string(got) != tt.resp3 {
+				t.Errorf("appendResp() RESP3 = %q, %v, want %q", got, err, tt.resp3)
+			}
+		})
+	}
+}
@@ -0,0 +1,332 @@
+//go:build linux
+// +build linux
+
+package reactor
+
+import (
+	"errors"
+	"github.com/medusar/lucas/protocol"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	//chunkSize is the size of the buffers replies are appended to, every buffer is an iovec of writev
+	chunkSize = 16 * 1024
+	//maxIovecs is the max number of buffers written by one writev, IOV_MAX on Linux
+	maxIovecs = 1024
+	//maxBatchChunks is the most buffers held while batching, the replies are written once there are more
+	maxBatchChunks = 16
+)
+
+var (
+	errClosed = errors.New("use of closed connection")
+	//errReactorRead is returned by the read methods, the requests are read by the event loop
+	errReactorRead = errors.New("requests of a reactor connection are read by its event loop")
+)
+
+//conn is a connection served by an event loop.
+//Replies are appended to buffers and written with writev, at the end of a read or right away
+//when another goroutine writes to the connection, for pubsub, tracking or monitor.
+type conn struct {
+	fd    int
+	loop  *loop
+	laddr net.Addr
+	raddr net.Addr
+	//in holds the bytes read and not parsed yet, it's only accessed by the event loop
+	in []byte
+	//args are the views into in of the request being executed, the slice is reused
+	args []string
+	//blocked is set while a command of the connection waits for its keys, it's only accessed by the event loop
+	blocked bool
+	//closed is set atomically, the connection may be closed by another goroutine
+	closed int32
+
+	//mu guards the fields below
+	mu    sync.Mutex
+	proto int
+	out   [][]byte
+	//free is a written buffer kept for the next replies
+	free []byte
+	iovs []syscall.Iovec
+	//batching is set while the event loop executes the requests of the connection
+	batching bool
+	//waitWritable is set while EPOLLOUT is watched because the socket can't take more bytes
+	waitWritable bool
+}
+
+func newConn(fd int, l *loop, local, remote syscall.Sockaddr) *conn {
+	return &conn{fd: fd, loop: l, laddr: tcpAddr(local), raddr: tcpAddr(remote), proto: protocol.Resp2}
+}
+
+func tcpAddr(sa syscall.Sockaddr) net.Addr {
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return &net.TCPAddr{IP: append(net.IP(nil), sa.Addr[:]...), Port: sa.Port}
+	case *syscall.SockaddrInet6:
+		return &net.TCPAddr{IP: append(net.IP(nil), sa.Addr[:]...), Port: sa.Port}
+	}
+	return &net.TCPAddr{}
+}
+
+func (c *conn) WriteResp(val *protocol.Resp) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.IsClosed() {
+		return errClosed
+	}
+	i := c.tail()
+	buf, err := protocol.AppendResp(c.out[i], c.proto, val)
+	if err != nil {
+		return err
+	}
+	c.out[i] = buf
+	if c.waitWritable || (c.batching && len(c.out) <= maxBatchChunks) {
+		return nil
+	}
+	return c.flushLocked()
+}
+
+//tail returns the index of the buffer the next reply is appended to
+func (c *conn) tail() int {
+	if n := len(c.out); n > 0 && len(c.out[n-1]) < chunkSize {
+		return n - 1
+	}
+	buf := c.free
+	c.free = nil
+	if buf == nil {
+		buf = make([]byte, 0, chunkSize)
+	}
+	c.out = append(c.out, buf)
+	return len(c.out) - 1
+}
+
+//flushLocked writes the pending replies with writev.
+//When the socket can't take more bytes the rest is written once the loop sees EPOLLOUT.
+func (c *conn) flushLocked() error {
+	for len(c.out) > 0 {
+		iovs := c.iovs[:0]
+		for _, buf := range c.out {
+			if len(iovs) == maxIovecs {
+				break
+			}
+			if len(buf) == 0 {
+				continue
+			}
+			iov := syscall.Iovec{Base: &buf[0]}
+			iov.SetLen(len(buf))
+			iovs = append(iovs, iov)
+		}
+		c.iovs = iovs
+		if len(iovs) == 0 {
+			c.consume(0)
+			break
+		}
+		n, err := writev(c.fd, iovs)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EAGAIN {
+			if !c.waitWritable {
+				c.waitWritable = true
+				return c.loop.watch(c.fd, true)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		c.consume(n)
+	}
+	if c.waitWritable {
+		c.waitWritable = false
+		return c.loop.watch(c.fd, false)
+	}
+	return nil
+}
+
+//Pending returns the number of bytes of the replies the socket didn't take yet
+func (c *conn) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, buf := range c.out {
+		n += len(buf)
+	}
+	return n
+}
+
+//consume drops the n bytes written from the pending buffers
+func (c *conn) consume(n int) {
+	i := 0
+	for ; i < len(c.out) && n >= len(c.out[i]); i++ {
+		n -= len(c.out[i])
+		if cap(c.out[i]) == chunkSize {
+			c.free = c.out[i][:0]
+		}
+		c.out[i] = nil
+	}
+	c.out = c.out[:copy(c.out, c.out[i:])]
+	if len(c.out) > 0 {
+		c.out[0] = c.out[0][n:]
+	}
+}
+
+func writev(fd int, iovs []syscall.Iovec) (int, error) {
+	n, _, errno := syscall.Syscall(syscall.SYS_WRITEV, uintptr(fd), uintptr(unsafe.Pointer(&iovs[0])), uintptr(len(iovs)))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
+
+//setBatching holds the replies while the loop executes the requests of a read, they are written once it's unset
+func (c *conn) setBatching(batching bool) {
+	c.mu.Lock()
+	c.batching = batching
+	var err error
+	if !batching && !c.waitWritable && !c.IsClosed() {
+		err = c.flushLocked()
+	}
+	c.mu.Unlock()
+	if err != nil {
+		c.Close()
+	}
+}
+
+//writable is called by the loop when the socket can take more bytes
+func (c *conn) writable() {
+	c.mu.Lock()
+	err := c.flushLocked()
+	c.mu.Unlock()
+	if err != nil {
+		c.Close()
+	}
+}
+
+//Close writes the pending replies, like the +OK of QUIT, and shuts the socket down.
+//The loop sees the shutdown and releases the connection.
+func (c *conn) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return
+	}
+	c.flushLocked()
+	syscall.Shutdown(c.fd, syscall.SHUT_RDWR)
+}
+
+func (c *conn) IsClosed() bool {
+	return atomic.LoadInt32(&c.closed) == 1
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	return c.laddr
+}
+
+func (c *conn) Protocol() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.proto
+}
+
+func (c *conn) SetProtocol(proto int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.proto = proto
+}
+
+func (c *conn) WriteString(val string) error {
+	return c.WriteResp(protocol.NewString(val))
+}
+
+func (c *conn) WriteInteger(val int) error {
+	return c.WriteResp(protocol.NewInteger(val))
+}
+
+func (c *conn) WriteBulk(val string) error {
+	return c.WriteResp(protocol.NewBulk(val))
+}
+
+func (c *conn) WriteError(val string) error {
+	return c.WriteResp(protocol.NewError(val))
+}
+
+func (c *conn) WriteNil() error {
+	return c.WriteResp(protocol.NewNil())
+}
+
+func (c *conn) WriteArray(val []*protocol.Resp) error {
+	return c.WriteResp(protocol.NewArray(val))
+}
+
+func (c *conn) WriteMap(val []*protocol.Resp) error {
+	return c.WriteResp(protocol.NewMap(val))
+}
+
+func (c *conn) WriteSet(val []*protocol.Resp) error {
+	return c.WriteResp(protocol.NewSet(val))
+}
+
+func (c *conn) WritePush(val []*protocol.Resp) error {
+	return c.WriteResp(protocol.NewPush(val))
+}
+
+func (c *conn) WriteDouble(val float64) error {
+	return c.WriteResp(protocol.NewDouble(val))
+}
+
+func (c *conn) WriteBoolean(val bool) error {
+	return c.WriteResp(protocol.NewBoolean(val))
+}
+
+func (c *conn) WriteBigNumber(val string) error {
+	return c.WriteResp(protocol.NewBigNumber(val))
+}
+
+func (c *conn) WriteVerbatim(format, val string) error {
+	return c.WriteResp(protocol.NewVerbatim(format, val))
+}
+
+func (c *conn) WriteAttribute(val []*protocol.Resp) error {
+	return c.WriteResp(protocol.NewAttribute(val))
+}
+
+func (c *conn) ReadByte() (byte, error) {
+	return 0, errReactorRead
+}
+
+func (c *conn) ReadLine() (string, error) {
+	return "", errReactorRead
+}
+
+func (c *conn) ReadInt() (int, error) {
+	return 0, errReactorRead
+}
+
+func (c *conn) ReadBulk() (*protocol.Resp, error) {
+	return nil, errReactorRead
+}
+
+func (c *conn) ReadArray() ([]interface{}, error) {
+	return nil, errReactorRead
+}
+
+func (c *conn) ReadReply() (interface{}, error) {
+	return nil, errReactorRead
+}
+
+func (c *conn) ReadRequest() ([]string, error) {
+	return nil, errReactorRead
+}
+
+func (c *conn) ReadInlineRequest() ([]string, error) {
+	return nil, errReactorRead
+}
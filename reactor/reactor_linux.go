@@ -0,0 +1,284 @@
+//go:build linux
+// +build linux
+
+package reactor
+
+import (
+	"github.com/medusar/lucas/command"
+	"github.com/medusar/lucas/protocol"
+	"log"
+	"os"
+	"runtime"
+	"sync"
+	"syscall"
+)
+
+const (
+	//epollExclusive wakes up only one of the loops waiting for the listener, it's missing in syscall
+	epollExclusive = 1 << 28
+	//readBufferSize is the max number of bytes read from a socket at once
+	readBufferSize = 64 * 1024
+	//maxEvents is the max number of events handled by one epoll_wait
+	maxEvents = 256
+)
+
+//Serve serves the TCP port with one epoll event loop per CPU instead of a goroutine per connection.
+//A loop reads the requests of its connections, executes them inline and writes the replies of every read with one writev.
+//It only returns on error.
+func Serve(port int) error {
+	listener, err := listen(port)
+	if err != nil {
+		return err
+	}
+	errs := make(chan error, runtime.NumCPU())
+	for i := 0; i < runtime.NumCPU(); i++ {
+		l, err := newLoop(listener)
+		if err != nil {
+			return err
+		}
+		go func() {
+			errs <- l.run()
+		}()
+	}
+	return <-errs
+}
+
+//listen opens a non blocking dual stack socket listening on port
+func listen(port int) (int, error) {
+	fd, err := syscall.Socket(syscall.AF_INET6, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return -1, os.NewSyscallError("socket", err)
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+		syscall.Close(fd)
+		return -1, os.NewSyscallError("setsockopt", err)
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_V6ONLY, 0); err != nil {
+		syscall.Close(fd)
+		return -1, os.NewSyscallError("setsockopt", err)
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrInet6{Port: port}); err != nil {
+		syscall.Close(fd)
+		return -1, os.NewSyscallError("bind", err)
+	}
+	if err := syscall.Listen(fd, syscall.SOMAXCONN); err != nil {
+		syscall.Close(fd)
+		return -1, os.NewSyscallError("listen", err)
+	}
+	return fd, nil
+}
+
+//loop is an event loop, the connections it accepts are only read by it
+type loop struct {
+	epfd     int
+	listener int
+	conns    map[int]*conn
+	buf      []byte
+	//wakeR and wakeW are a pipe waking the loop up when a blocked command returns
+	wakeR, wakeW int
+
+	//mu guards resumed, the connections whose blocked command returned
+	mu      sync.Mutex
+	resumed []*conn
+}
+
+//newLoop creates a loop, every loop waits for the listener and accepts connections by itself
+func newLoop(listener int) (*loop, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, os.NewSyscallError("epoll_create1", err)
+	}
+	var pipe [2]int
+	if err := syscall.Pipe2(pipe[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		syscall.Close(epfd)
+		return nil, os.NewSyscallError("pipe2", err)
+	}
+	l := &loop{epfd: epfd, listener: listener, conns: make(map[int]*conn), buf: make([]byte, readBufferSize),
+		wakeR: pipe[0], wakeW: pipe[1]}
+	event := &syscall.EpollEvent{Events: syscall.EPOLLIN | epollExclusive, Fd: int32(listener)}
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, listener, event); err == nil {
+		event = &syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(l.wakeR)}
+		err = syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, l.wakeR, event)
+	}
+	if err != nil {
+		syscall.Close(epfd)
+		syscall.Close(l.wakeR)
+		syscall.Close(l.wakeW)
+		return nil, os.NewSyscallError("epoll_ctl", err)
+	}
+	return l, nil
+}
+
+func (l *loop) run() error {
+	events := make([]syscall.EpollEvent, maxEvents)
+	for {
+		n, err := syscall.EpollWait(l.epfd, events, -1)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return os.NewSyscallError("epoll_wait", err)
+		}
+		for i := 0; i < n; i++ {
+			fd := int(events[i].Fd)
+			if fd == l.listener {
+				l.accept()
+				continue
+			}
+			if fd == l.wakeR {
+				l.wake()
+				continue
+			}
+			c, ok := l.conns[fd]
+			if !ok {
+				continue
+			}
+			if events[i].Events&syscall.EPOLLOUT != 0 {
+				c.writable()
+			}
+			if events[i].Events&(syscall.EPOLLIN|syscall.EPOLLRDHUP|syscall.EPOLLHUP|syscall.EPOLLERR) != 0 {
+				l.read(c)
+			}
+		}
+	}
+}
+
+//watch changes the events of a connection, EPOLLOUT is only watched while replies are pending
+func (l *loop) watch(fd int, writable bool) error {
+	events := uint32(syscall.EPOLLIN | syscall.EPOLLRDHUP)
+	if writable {
+		events |= syscall.EPOLLOUT
+	}
+	return syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_MOD, fd, &syscall.EpollEvent{Events: events, Fd: int32(fd)})
+}
+
+func (l *loop) accept() {
+	for {
+		fd, sa, err := syscall.Accept4(l.listener, syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC)
+		if err != nil {
+			//EAGAIN means another loop took the connection
+			if err != syscall.EAGAIN && err != syscall.EINTR {
+				log.Println("Failed to accept,", err)
+			}
+			return
+		}
+		syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_NODELAY, 1)
+		local, _ := syscall.Getsockname(fd)
+		c := newConn(fd, l, local, sa)
+		event := &syscall.EpollEvent{Events: syscall.EPOLLIN | syscall.EPOLLRDHUP, Fd: int32(fd)}
+		if err := syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_ADD, fd, event); err != nil {
+			log.Println("Failed to watch connection,", err)
+			syscall.Close(fd)
+			continue
+		}
+		l.conns[fd] = c
+		command.Connect(c)
+	}
+}
+
+//read executes the requests read from a connection, the replies are written together once they are all executed
+func (l *loop) read(c *conn) {
+	n, err := syscall.Read(c.fd, l.buf)
+	if err == syscall.EAGAIN || err == syscall.EINTR {
+		return
+	}
+	if n <= 0 {
+		l.release(c)
+		return
+	}
+	c.in = append(c.in, l.buf[:n]...)
+	if c.blocked {
+		//the requests are executed once the blocked command returns
+		return
+	}
+	l.process(c)
+}
+
+//process executes the requests buffered by a connection until one blocks
+func (l *loop) process(c *conn) {
+	c.setBatching(true)
+	pos := 0
+	for !c.IsClosed() {
+		args, used, err := protocol.ParseRequestViews(c.in[pos:], c.args)
+		if err != nil {
+			c.WriteError("ERR " + err.Error())
+			c.Close()
+			break
+		}
+		if used == 0 {
+			break
+		}
+		pos += used
+		if len(args) == 0 {
+			continue
+		}
+		c.args = args
+		cmd, err := command.ParseRequest(args)
+		if err != nil {
+			c.WriteError(err.Error())
+			continue
+		}
+		blocked, err := command.Invoke(c, cmd)
+		if blocked != nil {
+			c.blocked = true
+			go l.wait(c, cmd, blocked)
+			break
+		}
+		cmd.Release()
+		if err != nil {
+			c.Close()
+		}
+	}
+	c.in = c.in[:copy(c.in, c.in[pos:])]
+	if len(c.in) == 0 && cap(c.in) > readBufferSize {
+		c.in = nil
+	}
+	c.setBatching(false)
+
+	if c.IsClosed() {
+		l.release(c)
+	}
+}
+
+//wait waits for a blocked command in its own goroutine, so that the loop keeps serving the other connections
+func (l *loop) wait(c *conn, cmd *command.RedisCmd, blocked *command.Blocked) {
+	if err := blocked.Wait(); err != nil {
+		c.Close()
+	}
+	cmd.Release()
+
+	l.mu.Lock()
+	l.resumed = append(l.resumed, c)
+	l.mu.Unlock()
+	//a full pipe already wakes the loop up
+	syscall.Write(l.wakeW, []byte{0})
+}
+
+//wake executes the requests received by the connections while their command was blocked
+func (l *loop) wake() {
+	for {
+		if n, _ := syscall.Read(l.wakeR, l.buf); n <= 0 {
+			break
+		}
+	}
+	l.mu.Lock()
+	resumed := l.resumed
+	l.resumed = nil
+	l.mu.Unlock()
+	for _, c := range resumed {
+		c.blocked = false
+		//the connection may have been released while blocked
+		if l.conns[c.fd] == c {
+			l.process(c)
+		}
+	}
+}
+
+//release forgets a closed connection, the descriptor is closed once no command can write to it
+func (l *loop) release(c *conn) {
+	syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_DEL, c.fd, nil)
+	delete(l.conns, c.fd)
+	c.Close()
+	command.Disconnect(c)
+	syscall.Close(c.fd)
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package reactor
+
+import "errors"
+
+//Serve is only implemented on Linux, where epoll is available
+func Serve(port int) error {
+	return errors.New("reactor mode is only supported on Linux")
+}
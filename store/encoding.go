@@ -0,0 +1,73 @@
+package store
+
+import (
+	"strconv"
+)
+
+//encodings reported by OBJECT ENCODING, named like the ones of redis
+const (
+	encodingRaw        = "raw"
+	encodingInt        = "int"
+	encodingEmbstr     = "embstr"
+	encodingQuicklist  = "quicklist"
+	encodingListpack   = "listpack"
+	encodingListpackEx = "listpackex" //a listpack hash with field TTLs
+	encodingIntset     = "intset"
+	encodingHashtable  = "hashtable"
+	encodingSkiplist   = "skiplist"
+)
+
+//embstrSizeLimit is the longest string redis embeds in its object
+const embstrSizeLimit = 44
+
+//EncodingLimits are the sizes up to which hashes, sets and sorted sets keep a compact encoding.
+//A value is converted to its regular encoding once it has more entries or a longer entry, it's never converted back.
+type EncodingLimits struct {
+	HashMaxListpackEntries int
+	HashMaxListpackValue   int
+	SetMaxIntsetEntries    int
+	SetMaxListpackEntries  int
+	SetMaxListpackValue    int
+	ZsetMaxListpackEntries int
+	ZsetMaxListpackValue   int
+}
+
+//DefaultEncodingLimits are the defaults of redis
+var DefaultEncodingLimits = EncodingLimits{
+	HashMaxListpackEntries: 128,
+	HashMaxListpackValue:   64,
+	SetMaxIntsetEntries:    512,
+	SetMaxListpackEntries:  128,
+	SetMaxListpackValue:    64,
+	ZsetMaxListpackEntries: 128,
+	ZsetMaxListpackValue:   64,
+}
+
+//limits is only set at startup, it's read by the executors without locking
+var limits = DefaultEncodingLimits
+
+//SetEncodingLimits sets the sizes of the compact encodings, the values already stored keep their encoding
+func SetEncodingLimits(l EncodingLimits) {
+	limits = l
+}
+
+//ObjectEncoding returns the encoding of the value at key, it reports false when the key doesn't exist
+func ObjectEncoding(key string) (string, bool) {
+	v, ok := peek(key)
+	if !ok || !v.isAlive() {
+		return "", false
+	}
+	return v.encoding(), true
+}
+
+//intOf parses s when it's the canonical form of a 64 bit integer, so that formatting the integer gives s back
+func intOf(s string) (int64, bool) {
+	if len(s) == 0 || len(s) > 20 {
+		return 0, false
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || strconv.FormatInt(i, 10) != s {
+		return 0, false
+	}
+	return i, true
+}
@@ -5,88 +5,307 @@ import (
 	"github.com/medusar/lucas/util"
 	"math"
 	"strconv"
-	"time"
 )
 
+//hashVal keeps the fields and their values one after the other in pack.
+//A small hash finds a field by a scan of pack until it has more entries or longer ones than the limits,
+//then fields maps every field to its position in pack, it's nil before.
 type hashVal struct {
-	val      map[string]string
-	expireAt int64
+	pack   []string
+	fields map[string]int
+	//fieldExpireAt maps the fields with a TTL to the unix time in milliseconds they expire at, it's nil when there's none
+	fieldExpireAt map[string]int64
+	//nextFieldExpire is the earliest time in fieldExpireAt, no field expires before
+	nextFieldExpire int64
+	expireAt        int64
 }
 
+//HashField is a field of a hash and its value
+type HashField struct {
+	Field string
+	Value string
+}
+
+//HEXPIRE conditions, they exclude each other
+const (
+	HexpireNX = 1 << iota //only set the TTL of fields without one
+	HexpireXX             //only set the TTL of fields with one
+	HexpireGT             //only set a TTL greater than the current one, no TTL is infinite
+	HexpireLT             //only set a TTL less than the current one, no TTL is infinite
+)
+
+//results of Hexpire and Hpersist for every field, they are the replies of redis
+const (
+	HfieldNotFound = -2 //the field doesn't exist
+	HfieldNoTTL    = -1 //the field has no TTL to remove
+	HfieldNotSet   = 0  //the condition isn't met
+	HfieldSet      = 1  //the TTL is set or removed
+	HfieldDeleted  = 2  //the time is already past, the field is deleted
+)
+
+func newHashVal() *hashVal {
+	return &hashVal{expireAt: -1}
+}
+
+//isAlive reports false once every field is expired too, even before they are deleted
 func (s *hashVal) isAlive() bool {
-	if s.expireAt == -1 {
-		return true
-	}
-	ttl := s.expireAt - time.Now().Unix()
-	return ttl >= 0
+	return aliveUntil(s.expireAt) && !s.fieldsExpired()
 }
 
 func (s *hashVal) ttl() int {
-	//returns -1 if the key exists but has no associated expire
-	if s.expireAt == -1 {
-		return -1
-	}
-	ttl := s.expireAt - time.Now().Unix()
-	if ttl > 0 {
-		return int(ttl)
-	}
-	//returns -2 if the key does not exist.
-	return -2
+	return ttlUntil(s.expireAt)
 }
 
 func (s *hashVal) setExpireAt(at int64) {
 	s.expireAt = at
 }
 
+func (s *hashVal) expiration() int64 {
+	return s.expireAt
+}
+
 func (s *hashVal) dataType() string {
 	return "hash"
 }
 
-//Hset set a field to a hash, return true if the field doesn't exist before
-func Hset(key, field, val string) (bool, error) {
-	v, ok := values[key]
-	if !ok || !v.isAlive() {
-		m := &hashVal{val: make(map[string]string), expireAt: -1}
-		m.val[field] = val
-		values[key] = m
-		return true, nil
+func (s *hashVal) encoding() string {
+	if s.fields != nil {
+		return encodingHashtable
 	}
-	h, ok := v.(*hashVal)
-	if !ok {
-		return false, errorWrongType
+	if s.fieldExpireAt != nil {
+		return encodingListpackEx
 	}
-	_, exists := h.val[field]
-	h.val[field] = val
-	return !exists, nil
+	return encodingListpack
 }
 
-func Hget(key, field string) (string, bool, error) {
-	v, ok := values[key]
-	if !ok || !v.isAlive() {
-		return "", false, nil
+func (s *hashVal) clone() expired {
+	c := *s
+	c.pack = append([]string(nil), s.pack...)
+	if s.fields != nil {
+		c.fields = make(map[string]int, len(s.fields))
+		for f, i := range s.fields {
+			c.fields[f] = i
+		}
 	}
-	h, ok := v.(*hashVal)
-	if !ok {
-		return "", false, errorWrongType
+	if s.fieldExpireAt != nil {
+		c.fieldExpireAt = make(map[string]int64, len(s.fieldExpireAt))
+		for f, at := range s.fieldExpireAt {
+			c.fieldExpireAt[f] = at
+		}
 	}
-	val, exist := h.val[field]
-	return val, exist, nil
+	return &c
 }
 
-func Hgetall(key string) (map[string]string, error) {
-	v, ok := values[key]
-	if !ok || !v.isAlive() {
-		return nil, nil
+func (s *hashVal) len() int {
+	return len(s.pack) / 2
+}
+
+//index returns the position of field in pack, or -1
+func (s *hashVal) index(field string) int {
+	if s.fields != nil {
+		if i, ok := s.fields[field]; ok {
+			return i
+		}
+		return -1
 	}
-	h, ok := v.(*hashVal)
-	if !ok {
-		return nil, errorWrongType
+	for i := 0; i < len(s.pack); i += 2 {
+		if s.pack[i] == field {
+			return i
+		}
 	}
-	return h.val, nil
+	return -1
 }
 
-func Hkeys(key string) ([]string, error) {
-	v, ok := values[key]
+func (s *hashVal) get(field string) (string, bool) {
+	if i := s.index(field); i >= 0 {
+		return s.pack[i+1], true
+	}
+	return "", false
+}
+
+//at returns the i-th field
+func (s *hashVal) at(i int) HashField {
+	return HashField{Field: s.pack[2*i], Value: s.pack[2*i+1]}
+}
+
+//set sets a copy of the field and of the value, it reports true when the field is new.
+//The TTL of an existing field is kept.
+func (s *hashVal) set(field, value string) bool {
+	if s.fields == nil && (len(field) > limits.HashMaxListpackValue || len(value) > limits.HashMaxListpackValue) {
+		s.unpack()
+	}
+	if i := s.index(field); i >= 0 {
+		s.pack[i+1] = util.Clone(value)
+		return false
+	}
+	if s.fields == nil && s.len() >= limits.HashMaxListpackEntries {
+		s.unpack()
+	}
+	field = util.Clone(field)
+	if s.fields != nil {
+		s.fields[field] = len(s.pack)
+	}
+	s.pack = append(s.pack, field, util.Clone(value))
+	return true
+}
+
+func (s *hashVal) del(field string) bool {
+	i := s.index(field)
+	if i < 0 {
+		return false
+	}
+	s.persistField(field)
+	last := len(s.pack) - 2
+	if s.fields != nil {
+		//the last field takes the place of the deleted one, scan relies on it
+		delete(s.fields, field)
+		if i != last {
+			s.pack[i], s.pack[i+1] = s.pack[last], s.pack[last+1]
+			s.fields[s.pack[i]] = i
+		}
+	} else {
+		copy(s.pack[i:], s.pack[i+2:])
+	}
+	s.pack[last], s.pack[last+1] = "", ""
+	s.pack = s.pack[:last]
+	return true
+}
+
+//each calls fn with every field and its value until it returns false
+func (s *hashVal) each(fn func(field, value string) bool) {
+	for i := 0; i < len(s.pack); i += 2 {
+		if !fn(s.pack[i], s.pack[i+1]) {
+			return
+		}
+	}
+}
+
+//unpack indexes the fields
+func (s *hashVal) unpack() {
+	s.fields = make(map[string]int, s.len()+1)
+	for i := 0; i < len(s.pack); i += 2 {
+		s.fields[s.pack[i]] = i
+	}
+}
+
+//fieldExpiration returns the unix time in milliseconds a field expires at, -1 when it has no TTL
+func (s *hashVal) fieldExpiration(field string) int64 {
+	if at, ok := s.fieldExpireAt[field]; ok {
+		return at
+	}
+	return -1
+}
+
+//expireField sets the expiration time of the field at position i of pack
+func (s *hashVal) expireField(i int, at int64) {
+	if s.fieldExpireAt == nil {
+		s.fieldExpireAt = make(map[string]int64)
+		s.nextFieldExpire = at
+	} else if at < s.nextFieldExpire {
+		s.nextFieldExpire = at
+	}
+	s.fieldExpireAt[s.pack[i]] = at
+}
+
+//persistField removes the TTL of a field, it reports false when the field has none
+func (s *hashVal) persistField(field string) bool {
+	if _, ok := s.fieldExpireAt[field]; !ok {
+		return false
+	}
+	delete(s.fieldExpireAt, field)
+	if len(s.fieldExpireAt) == 0 {
+		s.fieldExpireAt = nil
+	}
+	return true
+}
+
+//fieldsExpired reports whether every field has a TTL which is over
+func (s *hashVal) fieldsExpired() bool {
+	if len(s.fieldExpireAt) < s.len() || s.len() == 0 {
+		return false
+	}
+	now := nowMs()
+	if s.nextFieldExpire >= now {
+		return false
+	}
+	for _, at := range s.fieldExpireAt {
+		if at >= now {
+			return false
+		}
+	}
+	return true
+}
+
+//expireFields deletes the fields whose TTL is over, it returns how many were deleted.
+//It's cheap until the earliest TTL is over.
+func (s *hashVal) expireFields() int {
+	if s.fieldExpireAt == nil {
+		return 0
+	}
+	now := nowMs()
+	if s.nextFieldExpire >= now {
+		return 0
+	}
+	var expired []string
+	next := int64(math.MaxInt64)
+	for field, at := range s.fieldExpireAt {
+		if at < now {
+			expired = append(expired, field)
+		} else if at < next {
+			next = at
+		}
+	}
+	for _, field := range expired {
+		s.del(field)
+	}
+	s.nextFieldExpire = next
+	return len(expired)
+}
+
+//random returns count random fields: distinct ones when count is positive,
+//-count ones which may be repeated when it's negative
+func (s *hashVal) random(count int) []HashField {
+	indexes := randomIndexes(s.len(), count)
+	if indexes == nil {
+		return nil
+	}
+	ret := make([]HashField, len(indexes))
+	for i, j := range indexes {
+		ret[i] = s.at(j)
+	}
+	return ret
+}
+
+//scan returns about count fields before the cursor and the cursor of the next call, 0 once every field is returned.
+//The cursor counts the fields left to scan, 0 starts from the last one. Going down means a field is never skipped:
+//a deleted field is replaced by the last one, which is already returned when it's moved below the cursor.
+//A small hash is returned at once.
+func (s *hashVal) scan(cursor uint64, count int) (uint64, []HashField) {
+	if s.fields == nil {
+		all := make([]HashField, s.len())
+		for i := range all {
+			all[i] = s.at(i)
+		}
+		return 0, all
+	}
+	n := uint64(s.len())
+	if cursor == 0 || cursor > n {
+		cursor = n
+	}
+	next := uint64(0)
+	if cursor > uint64(count) {
+		next = cursor - uint64(count)
+	}
+	ret := make([]HashField, 0, cursor-next)
+	for i := cursor; i > next; i-- {
+		ret = append(ret, s.at(int(i-1)))
+	}
+	return next, ret
+}
+
+//hashOf returns the hash at key, nil when it doesn't exist. The fields whose TTL is over are deleted first.
+func hashOf(key string) (*hashVal, error) {
+	v, ok := lookup(key)
 	if !ok || !v.isAlive() {
 		return nil, nil
 	}
@@ -94,42 +313,108 @@ func Hkeys(key string) ([]string, error) {
 	if !ok {
 		return nil, errorWrongType
 	}
-	keys := make([]string, len(h.val))
-	i := 0
-	for k := range h.val {
-		keys[i] = k
-		i++
+	if h.expireFields() > 0 {
+		hashModified(key, h)
+		if h.len() == 0 {
+			return nil, nil
+		}
+	}
+	return h, nil
+}
+
+func getOrCreateHash(key string) (*hashVal, error) {
+	h, err := hashOf(key)
+	if err != nil {
+		return nil, err
+	}
+	if h == nil {
+		h = newHashVal()
+		setValue(key, h)
+	}
+	return h, nil
+}
+
+//hashModified deletes the hash once its last field is removed
+func hashModified(key string, h *hashVal) {
+	if h.len() == 0 {
+		deleteValue(key)
+	}
+	signalModifiedKey(key)
+}
+
+//Hset set a field to a hash, return true if the field doesn't exist before
+func Hset(key, field, val string) (bool, error) {
+	h, err := getOrCreateHash(key)
+	if err != nil {
+		return false, err
+	}
+	added := h.set(field, val)
+	if !added {
+		h.persistField(field)
+	}
+	signalModifiedKey(key)
+	return added, nil
+}
+
+func Hget(key, field string) (string, bool, error) {
+	h, err := hashOf(key)
+	if err != nil || h == nil {
+		return "", false, err
+	}
+	val, exist := h.get(field)
+	return val, exist, nil
+}
+
+func Hgetall(key string) (map[string]string, error) {
+	h, err := hashOf(key)
+	if err != nil || h == nil {
+		return nil, err
+	}
+	m := make(map[string]string, h.len())
+	h.each(func(field, value string) bool {
+		m[field] = value
+		return true
+	})
+	return m, nil
+}
+
+func Hkeys(key string) ([]string, error) {
+	h, err := hashOf(key)
+	if err != nil || h == nil {
+		return nil, err
 	}
+	keys := make([]string, 0, h.len())
+	h.each(func(field, _ string) bool {
+		keys = append(keys, field)
+		return true
+	})
 	return keys, nil
 }
 
 //Hlen return number of fields in the hash, or 0 when key does not exist.
 func Hlen(key string) (int, error) {
-	v, ok := values[key]
-	if !ok || !v.isAlive() {
-		return 0, nil
+	h, err := hashOf(key)
+	if err != nil {
+		return -1, err
 	}
-	h, ok := v.(*hashVal)
-	if !ok {
-		return -1, errorWrongType
+	if h == nil {
+		return 0, nil
 	}
-	return len(h.val), nil
+	return h.len(), nil
 }
 
 //Returns if field is an existing field in the hash stored at key.
 //1 if the hash contains field.
 //0 if the hash does not contain field, or key does not exist.
 func Hexists(key, field string) (int, error) {
-	v, ok := values[key]
-	if !ok || !v.isAlive() {
-		return 0, nil
+	h, err := hashOf(key)
+	if err != nil {
+		return -1, err
 	}
-	h, ok := v.(*hashVal)
-	if !ok {
-		return -1, errorWrongType
+	if h == nil {
+		return 0, nil
 	}
-	_, exists := h.val[field]
-	if exists {
+	if _, exists := h.get(field); exists {
 		return 1, nil
 	}
 	return 0, nil
@@ -138,22 +423,23 @@ func Hexists(key, field string) (int, error) {
 //Hdel return the number of fields that were removed from the hash, not including specified but non existing fields.
 // If key does not exist, it is treated as an empty hash and this command returns 0.
 func Hdel(key string, fields []string) (int, error) {
-	v, ok := values[key]
-	if !ok || !v.isAlive() {
-		return 0, nil
+	h, err := hashOf(key)
+	if err != nil {
+		return -1, err
 	}
-	h, ok := v.(*hashVal)
-	if !ok {
-		return -1, errorWrongType
+	if h == nil {
+		return 0, nil
 	}
 
 	t := 0
 	for _, f := range fields {
-		if _, exists := h.val[f]; exists {
-			delete(h.val, f)
+		if h.del(f) {
 			t++
 		}
 	}
+	if t > 0 {
+		hashModified(key, h)
+	}
 	return t, nil
 }
 
@@ -161,58 +447,42 @@ func Hdel(key string, fields []string) (int, error) {
 // If key does not exist, a new key holding a hash is created.
 // If field already exists, this operation has no effect.
 func HsetNX(key, field, val string) (int, error) {
-	v, ok := values[key]
-	if !ok || !v.isAlive() {
-		m := &hashVal{val: make(map[string]string), expireAt: -1}
-		m.val[field] = val
-		values[key] = m
-		return 1, nil
-	}
-
-	h, ok := v.(*hashVal)
-	if !ok {
-		return -1, errorWrongType
+	h, err := getOrCreateHash(key)
+	if err != nil {
+		return -1, err
 	}
-
-	_, exists := h.val[field]
-	if exists {
+	if _, exists := h.get(field); exists {
 		return 0, nil
 	}
-	h.val[field] = val
+	h.set(field, val)
+	signalModifiedKey(key)
 	return 1, nil
 }
 
 //HstrLen return the string length of the value associated with field,
 // or zero when field is not present in the hash or key does not exist at all.
 func HstrLen(key, field string) (int, error) {
-	v, ok := values[key]
-	if !ok || !v.isAlive() {
-		return 0, nil
-	}
-	h, ok := v.(*hashVal)
-	if !ok {
-		return -1, errorWrongType
+	h, err := hashOf(key)
+	if err != nil {
+		return -1, err
 	}
-	val, exists := h.val[field]
-	if !exists {
+	if h == nil {
 		return 0, nil
 	}
+	val, _ := h.get(field)
 	return len(val), nil
 }
 
 func Hvals(key string) ([]string, error) {
-	v, ok := values[key]
-	if !ok || !v.isAlive() {
-		return nil, nil
-	}
-	h, ok := v.(*hashVal)
-	if !ok {
-		return nil, errorWrongType
-	}
-	ret := make([]string, 0)
-	for _, val := range h.val {
-		ret = append(ret, val)
+	h, err := hashOf(key)
+	if err != nil || h == nil {
+		return nil, err
 	}
+	ret := make([]string, 0, h.len())
+	h.each(func(_, value string) bool {
+		ret = append(ret, value)
+		return true
+	})
 	return ret, nil
 }
 
@@ -224,21 +494,14 @@ func HincrBy(key, field, delta string) (int, error) {
 		return -1, errorInvalidInt
 	}
 
-	v, ok := values[key]
-	if !ok || !v.isAlive() {
-		m := &hashVal{val: make(map[string]string), expireAt: -1}
-		m.val[field] = delta
-		values[key] = m
-		return incr, nil
-	}
-
-	h, ok := v.(*hashVal)
-	if !ok {
-		return -1, errorWrongType
+	h, err := getOrCreateHash(key)
+	if err != nil {
+		return -1, err
 	}
-	val, exists := h.val[field]
+	val, exists := h.get(field)
 	if !exists {
-		h.val[field] = delta
+		h.set(field, delta)
+		signalModifiedKey(key)
 		return incr, nil
 	}
 	old, err := strconv.Atoi(val)
@@ -251,7 +514,8 @@ func HincrBy(key, field, delta string) (int, error) {
 		return -1, fmt.Errorf("ERR increment or decrement would overflow")
 	}
 
-	h.val[field] = strconv.Itoa(newVal)
+	h.set(field, strconv.Itoa(newVal))
+	signalModifiedKey(key)
 	return newVal, nil
 }
 
@@ -261,22 +525,15 @@ func HincrByFloat(key, field, delta string) (string, error) {
 		return "", errorInvalidFloat
 	}
 
-	v, ok := values[key]
-	if !ok || !v.isAlive() {
-		m := &hashVal{val: make(map[string]string), expireAt: -1}
-		m.val[field] = delta
-		values[key] = m
-		return delta, nil
-	}
-
-	h, ok := v.(*hashVal)
-	if !ok {
-		return "", errorWrongType
+	h, err := getOrCreateHash(key)
+	if err != nil {
+		return "", err
 	}
 
-	val, exists := h.val[field]
+	val, exists := h.get(field)
 	if !exists {
-		h.val[field] = delta
+		h.set(field, delta)
+		signalModifiedKey(key)
 		return delta, nil
 	}
 
@@ -292,6 +549,182 @@ func HincrByFloat(key, field, delta string) (string, error) {
 	}
 
 	fieldVal := fmt.Sprintf("%f", newVal)
-	h.val[field] = fieldVal
+	h.set(field, fieldVal)
+	signalModifiedKey(key)
 	return fieldVal, nil
 }
+
+//Hexpire sets the expiration time of fields to a unix time in milliseconds when the condition of flags is met,
+//it returns the result of every field. A field is deleted when the time is already past.
+func Hexpire(key string, fields []string, at int64, flags int) ([]int, error) {
+	h, err := hashOf(key)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]int, len(fields))
+	if h == nil {
+		for i := range ret {
+			ret[i] = HfieldNotFound
+		}
+		return ret, nil
+	}
+	now, modified := nowMs(), false
+	for i, field := range fields {
+		pos := h.index(field)
+		if pos < 0 {
+			ret[i] = HfieldNotFound
+			continue
+		}
+		cur := h.fieldExpiration(field)
+		if (flags&HexpireNX != 0 && cur != -1) || (flags&HexpireXX != 0 && cur == -1) ||
+			(flags&HexpireGT != 0 && (cur == -1 || at <= cur)) || (flags&HexpireLT != 0 && cur != -1 && at >= cur) {
+			ret[i] = HfieldNotSet
+			continue
+		}
+		modified = true
+		if at <= now {
+			h.del(field)
+			ret[i] = HfieldDeleted
+			continue
+		}
+		h.expireField(pos, at)
+		ret[i] = HfieldSet
+	}
+	if modified {
+		hashModified(key, h)
+	}
+	return ret, nil
+}
+
+//HfieldExpireAt returns the unix time in milliseconds every field expires at,
+//-1 when the field has no TTL and -2 when it doesn't exist.
+func HfieldExpireAt(key string, fields []string) ([]int64, error) {
+	h, err := hashOf(key)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]int64, len(fields))
+	for i, field := range fields {
+		if h == nil || h.index(field) < 0 {
+			ret[i] = HfieldNotFound
+		} else {
+			ret[i] = h.fieldExpiration(field)
+		}
+	}
+	return ret, nil
+}
+
+//Hpersist removes the TTL of fields, it returns the result of every field
+func Hpersist(key string, fields []string) ([]int, error) {
+	h, err := hashOf(key)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]int, len(fields))
+	modified := false
+	for i, field := range fields {
+		switch {
+		case h == nil || h.index(field) < 0:
+			ret[i] = HfieldNotFound
+		case h.persistField(field):
+			ret[i] = HfieldSet
+			modified = true
+		default:
+			ret[i] = HfieldNoTTL
+		}
+	}
+	if modified {
+		signalModifiedKey(key)
+	}
+	return ret, nil
+}
+
+//Hgetdel returns the values of fields and deletes them, a missing field has a nil value.
+//The hash is deleted with its last field.
+func Hgetdel(key string, fields []string) ([]*string, error) {
+	h, err := hashOf(key)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*string, len(fields))
+	if h == nil {
+		return ret, nil
+	}
+	deleted := false
+	for i, field := range fields {
+		if v, ok := h.get(field); ok {
+			ret[i] = &v
+			h.del(field)
+			deleted = true
+		}
+	}
+	if deleted {
+		hashModified(key, h)
+	}
+	return ret, nil
+}
+
+//Hgetex returns the values of fields and sets their expiration time to a unix time in milliseconds,
+//0 keeps it and -1 removes it. The fields are deleted when the time is already past.
+func Hgetex(key string, fields []string, at int64) ([]*string, error) {
+	h, err := hashOf(key)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*string, len(fields))
+	if h == nil {
+		return ret, nil
+	}
+	now, modified := nowMs(), false
+	for i, field := range fields {
+		pos := h.index(field)
+		if pos < 0 {
+			continue
+		}
+		v := h.pack[pos+1]
+		ret[i] = &v
+		switch {
+		case at == -1:
+			modified = h.persistField(field) || modified
+		case at > 0 && at <= now:
+			h.del(field)
+			modified = true
+		case at > 0:
+			h.expireField(pos, at)
+			modified = true
+		}
+	}
+	if modified {
+		hashModified(key, h)
+	}
+	return ret, nil
+}
+
+//Hrandfield returns count random fields, see HRANDFIELD
+func Hrandfield(key string, count int) ([]HashField, error) {
+	h, err := hashOf(key)
+	if err != nil || h == nil {
+		return nil, err
+	}
+	return h.random(count), nil
+}
+
+//Hscan returns about count fields matching the pattern from the cursor and the cursor of the next call,
+//which is 0 once the scan is over. An empty pattern matches every field.
+func Hscan(key string, cursor uint64, pattern string, count int) (uint64, []HashField, error) {
+	h, err := hashOf(key)
+	if err != nil || h == nil {
+		return 0, nil, err
+	}
+	next, fields := h.scan(cursor, count)
+	if pattern == "" {
+		return next, fields, nil
+	}
+	matched := fields[:0]
+	for _, f := range fields {
+		if patternMatch(f.Field, pattern) {
+			matched = append(matched, f)
+		}
+	}
+	return next, matched, nil
+}
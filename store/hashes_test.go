@@ -10,7 +10,7 @@ import (
 )
 
 func TestHset(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Set("str1", "str1")
 
 	type args struct {
@@ -46,7 +46,7 @@ func TestHset(t *testing.T) {
 }
 
 func TestHget(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Hset("hash1", "f1", "v1")
 	Hset("hash1", "f2", "v2")
 	Set("str1", "string1")
@@ -87,7 +87,7 @@ func TestHget(t *testing.T) {
 }
 
 func TestHgetall(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Hset("hash1", "f1", "v1")
 	Hset("hash1", "f2", "v2")
 	Hset("hash2", "f2", "v2")
@@ -122,7 +122,7 @@ func TestHgetall(t *testing.T) {
 }
 
 func TestHkeys(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Hset("hash1", "f1", "v1")
 	Hset("hash1", "f2", "v2")
 	Hset("hash2", "f2", "v2")
@@ -155,7 +155,7 @@ func TestHkeys(t *testing.T) {
 }
 
 func TestHlen(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Hset("hash1", "f1", "v1")
 	Hset("hash1", "f2", "v2")
 	Hset("hash2", "f2", "v2")
@@ -190,7 +190,7 @@ func TestHlen(t *testing.T) {
 }
 
 func TestHexists(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Hset("hash1", "f1", "v1")
 	Hset("hash1", "f2", "v2")
 	Hset("hash2", "f2", "v2")
@@ -228,7 +228,7 @@ func TestHexists(t *testing.T) {
 }
 
 func TestHdel(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Hset("hash1", "f1", "v1")
 	Hset("hash1", "f2", "v2")
 
@@ -270,7 +270,7 @@ func TestHdel(t *testing.T) {
 }
 
 func TestHsetNX(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Hset("hash1", "f1", "v1")
 	Hset("hash1", "f2", "v2")
 	Set("str1", "string1")
@@ -307,7 +307,7 @@ func TestHsetNX(t *testing.T) {
 }
 
 func TestHstrLen(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Hset("hash1", "f1", "v1")
 	Hset("hash1", "f2", "123456789")
 	Hset("hash1", "f3", "你好")
@@ -345,7 +345,7 @@ func TestHstrLen(t *testing.T) {
 }
 
 func TestHvals(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Hset("hash1", "f1", "v1")
 	Hset("hash1", "f2", "v2")
 
@@ -382,7 +382,7 @@ func TestHvals(t *testing.T) {
 }
 
 func TestHincrBy(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Set("s1", "s1")
 
 	type args struct {
@@ -419,7 +419,7 @@ func TestHincrBy(t *testing.T) {
 }
 
 func TestHincrByFloat(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Set("s1", "s1")
 
 	maxFloat := fmt.Sprintf("%f", math.MaxFloat64)
@@ -458,3 +458,239 @@ func TestHincrByFloat(t *testing.T) {
 		})
 	}
 }
+
+func Test_hashVal_encoding(t *testing.T) {
+	defer SetEncodingLimits(DefaultEncodingLimits)
+	SetEncodingLimits(EncodingLimits{HashMaxListpackEntries: 3, HashMaxListpackValue: 5})
+
+	h := newHashVal()
+	assert.True(t, h.set("f1", "v1"))
+	assert.True(t, h.set("f2", "v2"))
+	assert.False(t, h.set("f1", "v3"))
+	assert.True(t, h.set("f3", "v3"))
+	assert.Equal(t, encodingListpack, h.encoding())
+	assert.True(t, h.del("f2"))
+	assert.False(t, h.del("f2"))
+	v, ok := h.get("f1")
+	assert.True(t, ok)
+	assert.Equal(t, "v3", v)
+	assert.Equal(t, 2, h.len())
+
+	//a long value
+	h.set("f1", "abcdef")
+	assert.Equal(t, encodingHashtable, h.encoding())
+	v, _ = h.get("f3")
+	assert.Equal(t, "v3", v)
+
+	//too many fields
+	h = newHashVal()
+	for i := 0; i < 4; i++ {
+		h.set(strconv.Itoa(i), "v")
+	}
+	assert.Equal(t, encodingHashtable, h.encoding())
+	assert.Equal(t, 4, h.len())
+}
+
+func TestObjectEncoding(t *testing.T) {
+	flushAll()
+	Set("int", "12345")
+	Set("embstr", "hello")
+	Set("raw", "hello world, this string is longer than 44 bytes")
+	Rpush("list", []string{"a"})
+	Sadd("intset", []string{"1", "2"})
+	Sadd("set", []string{"a"})
+	Hset("hash", "f", "v")
+	Zadd("zset", []float64{1}, []string{"a"}, 0)
+
+	for key, want := range map[string]string{
+		"int": encodingInt, "embstr": encodingEmbstr, "raw": encodingRaw, "list": encodingQuicklist,
+		"intset": encodingIntset, "set": encodingListpack, "hash": encodingListpack, "zset": encodingListpack,
+	} {
+		got, ok := ObjectEncoding(key)
+		assert.True(t, ok)
+		assert.Equal(t, want, got, key)
+	}
+	_, ok := ObjectEncoding("noexists")
+	assert.False(t, ok)
+}
+
+func TestHexpire(t *testing.T) {
+	flushAll()
+	Hset("hash", "f1", "v1")
+	Hset("hash", "f2", "v2")
+	Hset("hash", "f3", "v3")
+	Set("str", "v")
+	later := nowMs() + 10000
+
+	got, err := Hexpire("hash", []string{"f1", "none"}, later, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{HfieldSet, HfieldNotFound}, got)
+	got, _ = Hexpire("hash", []string{"f1", "f2"}, later, HexpireNX)
+	assert.Equal(t, []int{HfieldNotSet, HfieldSet}, got)
+	got, _ = Hexpire("hash", []string{"f1", "f3"}, later+1, HexpireXX)
+	assert.Equal(t, []int{HfieldSet, HfieldNotSet}, got)
+	got, _ = Hexpire("hash", []string{"f1", "f2", "f3"}, later, HexpireGT)
+	assert.Equal(t, []int{HfieldNotSet, HfieldNotSet, HfieldNotSet}, got)
+	got, _ = Hexpire("hash", []string{"f1", "f3"}, later, HexpireLT)
+	assert.Equal(t, []int{HfieldSet, HfieldSet}, got)
+	times, _ := HfieldExpireAt("hash", []string{"f1", "f2", "none"})
+	assert.Equal(t, []int64{later, later, HfieldNotFound}, times)
+	v, _ := ObjectEncoding("hash")
+	assert.Equal(t, encodingListpackEx, v)
+
+	got, _ = Hpersist("hash", []string{"f1", "f1", "none"})
+	assert.Equal(t, []int{HfieldSet, HfieldNoTTL, HfieldNotFound}, got)
+	//HSET removes the TTL, HINCRBY keeps it
+	Hset("hash", "f2", "1")
+	HincrBy("hash", "f3", "1")
+	times, _ = HfieldExpireAt("hash", []string{"f2", "f3"})
+	assert.Equal(t, []int64{-1, later}, times)
+
+	//a time in the past deletes the fields, and the hash with the last one
+	got, _ = Hexpire("hash", []string{"f1", "f2"}, 1, 0)
+	assert.Equal(t, []int{HfieldDeleted, HfieldDeleted}, got)
+	assert.True(t, Exists("hash"))
+	Hexpire("hash", []string{"f3"}, 1, 0)
+	assert.False(t, Exists("hash"))
+
+	got, _ = Hexpire("none", []string{"f1", "f2"}, later, 0)
+	assert.Equal(t, []int{HfieldNotFound, HfieldNotFound}, got)
+	_, err = Hexpire("str", []string{"f1"}, later, 0)
+	assert.Equal(t, errorWrongType, err)
+}
+
+func TestHashFieldExpiry(t *testing.T) {
+	flushAll()
+	Hset("hash", "f1", "v1")
+	Hset("hash", "f2", "v2")
+	Hset("other", "f1", "v1")
+	expireNow := func(key, field string) {
+		h, _ := hashOf(key)
+		h.expireField(h.index(field), nowMs()-1)
+	}
+
+	//lazily
+	expireNow("hash", "f1")
+	n, _ := Hlen("hash")
+	assert.Equal(t, 1, n)
+	_, ok, _ := Hget("hash", "f1")
+	assert.False(t, ok)
+	expireNow("hash", "f2")
+	assert.Equal(t, "none", Type("hash"))
+	assert.False(t, Exists("hash"))
+	n, _ = Hlen("hash")
+	assert.Equal(t, 0, n)
+
+	//actively
+	expireNow("other", "f1")
+	Hset("other", "f2", "v2")
+	ActiveExpireCycle(ShardIndex("other"), math.MaxInt32)
+	h, _ := lookup("other")
+	assert.Equal(t, 1, h.(*hashVal).len())
+	expireNow("other", "f2")
+	ActiveExpireCycle(ShardIndex("other"), math.MaxInt32)
+	_, ok = lookup("other")
+	assert.False(t, ok)
+}
+
+func TestHgetdel(t *testing.T) {
+	flushAll()
+	Hset("hash", "f1", "v1")
+	Hset("hash", "f2", "v2")
+	vals, err := Hgetdel("hash", []string{"f1", "none", "f1"})
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", *vals[0])
+	assert.Nil(t, vals[1])
+	assert.Nil(t, vals[2])
+	vals, _ = Hgetdel("hash", []string{"f2"})
+	assert.Equal(t, "v2", *vals[0])
+	assert.False(t, Exists("hash"))
+	vals, _ = Hgetdel("hash", []string{"f1"})
+	assert.Equal(t, []*string{nil}, vals)
+}
+
+func TestHgetex(t *testing.T) {
+	flushAll()
+	Hset("hash", "f1", "v1")
+	Hset("hash", "f2", "v2")
+	later := nowMs() + 10000
+	vals, err := Hgetex("hash", []string{"f1", "none"}, later)
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", *vals[0])
+	assert.Nil(t, vals[1])
+	times, _ := HfieldExpireAt("hash", []string{"f1", "f2"})
+	assert.Equal(t, []int64{later, -1}, times)
+
+	Hgetex("hash", []string{"f1"}, 0)
+	times, _ = HfieldExpireAt("hash", []string{"f1"})
+	assert.Equal(t, []int64{later}, times)
+	Hgetex("hash", []string{"f1"}, -1)
+	times, _ = HfieldExpireAt("hash", []string{"f1"})
+	assert.Equal(t, []int64{-1}, times)
+
+	vals, _ = Hgetex("hash", []string{"f1", "f2"}, 1)
+	assert.Equal(t, "v2", *vals[1])
+	assert.False(t, Exists("hash"))
+}
+
+func Test_hashVal_random(t *testing.T) {
+	h := newHashVal()
+	for i := 0; i < 10; i++ {
+		h.set(strconv.Itoa(i), "v"+strconv.Itoa(i))
+	}
+	for _, count := range []int{1, 3, 5, 10, 20} {
+		got := h.random(count)
+		want := count
+		if want > 10 {
+			want = 10
+		}
+		assert.Len(t, got, want)
+		seen := make(map[string]bool)
+		for _, f := range got {
+			assert.False(t, seen[f.Field], "repeated field %s", f.Field)
+			seen[f.Field] = true
+			assert.Equal(t, "v"+f.Field, f.Value)
+		}
+	}
+	assert.Len(t, h.random(-30), 30)
+	assert.Nil(t, newHashVal().random(3))
+}
+
+func Test_hashVal_scan(t *testing.T) {
+	defer SetEncodingLimits(DefaultEncodingLimits)
+	SetEncodingLimits(EncodingLimits{HashMaxListpackEntries: 4, HashMaxListpackValue: 64})
+
+	//a small hash is returned at once
+	h := newHashVal()
+	h.set("a", "1")
+	h.set("b", "2")
+	next, fields := h.scan(0, 1)
+	assert.Equal(t, uint64(0), next)
+	assert.Len(t, fields, 2)
+
+	//the fields present during the whole scan are returned even when other fields are deleted
+	h = newHashVal()
+	for i := 0; i < 100; i++ {
+		h.set(strconv.Itoa(i), "v")
+	}
+	assert.Equal(t, encodingHashtable, h.encoding())
+	seen := make(map[string]bool)
+	cursor := uint64(0)
+	for i := 0; ; i++ {
+		cursor, fields = h.scan(cursor, 7)
+		for _, f := range fields {
+			seen[f.Field] = true
+		}
+		if cursor == 0 {
+			break
+		}
+		//delete a field scanned already and one not scanned yet
+		h.del(fields[0].Field)
+		h.del(strconv.Itoa(i))
+		delete(seen, strconv.Itoa(i))
+	}
+	h.each(func(field, _ string) bool {
+		assert.True(t, seen[field], "field %s not scanned", field)
+		return true
+	})
+}
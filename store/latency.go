@@ -0,0 +1,193 @@
+package store
+
+import (
+	"math/bits"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	//latencyHistoryLen is the number of samples kept for every latency event
+	latencyHistoryLen = 160
+	//latencyBuckets is the number of power of two buckets of a histogram, the last one holds anything above 2^30 usec
+	latencyBuckets = 32
+)
+
+var (
+	//latencyThreshold is the minimum latency recorded by the latency monitor, 0 disables it
+	latencyThreshold time.Duration
+	//latencyMu guards the events, it's only taken by the samples above the threshold
+	latencyMu     sync.Mutex
+	latencyEvents = make(map[string]*latencyEvent)
+	//latencyHistograms maps a command to its *LatencyHistogram. Every command of every executor is recorded,
+	//so the histograms are found without a lock and counted atomically.
+	latencyHistograms sync.Map
+)
+
+//https://redis.io/topics/latency-monitor
+type LatencySample struct {
+	//The unix timestamp of the sample
+	Time int64
+	//The latency in milliseconds
+	Latency int64
+}
+
+type LatencyStats struct {
+	Event string
+	//Time and Latency of the latest sample
+	Time    int64
+	Latency int64
+	//Max is the max latency of the event ever recorded
+	Max int64
+}
+
+type latencyEvent struct {
+	samples [latencyHistoryLen]LatencySample
+	//idx is where the next sample is stored
+	idx int
+	max int64
+}
+
+//SetLatencyThreshold sets the minimum latency recorded by the latency monitor, 0 disables the monitor
+func SetLatencyThreshold(threshold time.Duration) {
+	latencyThreshold = threshold
+}
+
+func LatencyThreshold() time.Duration {
+	return latencyThreshold
+}
+
+//AddLatencySample records the latency of an event if it's above the threshold,
+//samples within the same second are merged keeping the highest latency.
+func AddLatencySample(event string, latency time.Duration) {
+	if latencyThreshold == 0 || latency < latencyThreshold {
+		return
+	}
+	latencyMu.Lock()
+	defer latencyMu.Unlock()
+	e, ok := latencyEvents[event]
+	if !ok {
+		e = &latencyEvent{}
+		latencyEvents[event] = e
+	}
+	now := time.Now().Unix()
+	ms := int64(latency / time.Millisecond)
+	if ms > e.max {
+		e.max = ms
+	}
+	prev := &e.samples[(e.idx+latencyHistoryLen-1)%latencyHistoryLen]
+	if prev.Time == now {
+		if ms > prev.Latency {
+			prev.Latency = ms
+		}
+		return
+	}
+	e.samples[e.idx] = LatencySample{Time: now, Latency: ms}
+	e.idx = (e.idx + 1) % latencyHistoryLen
+}
+
+//LatencyLatest returns the latest sample of every event, sorted by the name of the event
+func LatencyLatest() []*LatencyStats {
+	latencyMu.Lock()
+	defer latencyMu.Unlock()
+	stats := make([]*LatencyStats, 0, len(latencyEvents))
+	for name, e := range latencyEvents {
+		last := e.samples[(e.idx+latencyHistoryLen-1)%latencyHistoryLen]
+		stats = append(stats, &LatencyStats{Event: name, Time: last.Time, Latency: last.Latency, Max: e.max})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Event < stats[j].Event
+	})
+	return stats
+}
+
+//LatencyHistory returns the samples of an event from the oldest to the latest
+func LatencyHistory(event string) []LatencySample {
+	latencyMu.Lock()
+	defer latencyMu.Unlock()
+	e, ok := latencyEvents[event]
+	if !ok {
+		return nil
+	}
+	var samples []LatencySample
+	for i := 0; i < latencyHistoryLen; i++ {
+		s := e.samples[(e.idx+i)%latencyHistoryLen]
+		if s.Time != 0 {
+			samples = append(samples, s)
+		}
+	}
+	return samples
+}
+
+//ResetLatency deletes the samples of the events, or of all the events if none is given.
+//It returns the number of events reset.
+func ResetLatency(events []string) int {
+	latencyMu.Lock()
+	defer latencyMu.Unlock()
+	if len(events) == 0 {
+		n := len(latencyEvents)
+		latencyEvents = make(map[string]*latencyEvent)
+		return n
+	}
+	n := 0
+	for _, event := range events {
+		if _, ok := latencyEvents[event]; ok {
+			delete(latencyEvents, event)
+			n++
+		}
+	}
+	return n
+}
+
+//LatencyHistogram counts the calls of a command in power of two buckets of microseconds
+type LatencyHistogram struct {
+	Calls   int64
+	buckets [latencyBuckets]int64
+}
+
+//RecordCommandLatency adds a call of the command to its histogram
+func RecordCommandLatency(cmd string, latency time.Duration) {
+	v, ok := latencyHistograms.Load(cmd)
+	if !ok {
+		v, _ = latencyHistograms.LoadOrStore(cmd, &LatencyHistogram{})
+	}
+	h := v.(*LatencyHistogram)
+	i := bits.Len64(uint64(latency / time.Microsecond))
+	if i >= latencyBuckets {
+		i = latencyBuckets - 1
+	}
+	atomic.AddInt64(&h.Calls, 1)
+	atomic.AddInt64(&h.buckets[i], 1)
+}
+
+//CommandHistograms returns a copy of the histograms of the commands called at least once
+func CommandHistograms() map[string]*LatencyHistogram {
+	histograms := make(map[string]*LatencyHistogram)
+	latencyHistograms.Range(func(k, v interface{}) bool {
+		h, c := v.(*LatencyHistogram), &LatencyHistogram{}
+		c.Calls = atomic.LoadInt64(&h.Calls)
+		for i := range h.buckets {
+			c.buckets[i] = atomic.LoadInt64(&h.buckets[i])
+		}
+		histograms[k.(string)] = c
+		return true
+	})
+	return histograms
+}
+
+//Cumulative returns pairs of the upper bound of a bucket in microseconds and the number of calls
+//which took less than it, only buckets with calls are returned.
+func (h *LatencyHistogram) Cumulative() [][2]int64 {
+	var pairs [][2]int64
+	total := int64(0)
+	for i, n := range h.buckets {
+		if n == 0 {
+			continue
+		}
+		total += n
+		pairs = append(pairs, [2]int64{1 << uint(i), total})
+	}
+	return pairs
+}
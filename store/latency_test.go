@@ -0,0 +1,50 @@
+package store
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestAddLatencySample(t *testing.T) {
+	defer SetLatencyThreshold(0)
+	ResetLatency(nil)
+
+	AddLatencySample("command", 200*time.Millisecond)
+	assert.Empty(t, LatencyLatest(), "latency monitor is disabled by default")
+
+	SetLatencyThreshold(100 * time.Millisecond)
+	AddLatencySample("command", 50*time.Millisecond)
+	assert.Empty(t, LatencyLatest(), "samples below threshold are ignored")
+
+	AddLatencySample("command", 200*time.Millisecond)
+	AddLatencySample("command", 300*time.Millisecond)
+	AddLatencySample("expire-cycle", 150*time.Millisecond)
+
+	latest := LatencyLatest()
+	assert.Equal(t, 2, len(latest))
+	assert.Equal(t, "command", latest[0].Event)
+	assert.Equal(t, int64(300), latest[0].Latency)
+	assert.Equal(t, int64(300), latest[0].Max)
+	assert.Equal(t, "expire-cycle", latest[1].Event)
+
+	history := LatencyHistory("command")
+	assert.Equal(t, 1, len(history), "samples of the same second are merged")
+	assert.Equal(t, int64(300), history[0].Latency)
+	assert.Nil(t, LatencyHistory("unknown"))
+
+	assert.Equal(t, 1, ResetLatency([]string{"command", "unknown"}))
+	assert.Equal(t, 1, ResetLatency(nil))
+	assert.Empty(t, LatencyLatest())
+}
+
+func TestRecordCommandLatency(t *testing.T) {
+	RecordCommandLatency("histogram-test", 0)
+	RecordCommandLatency("histogram-test", 3*time.Microsecond)
+	RecordCommandLatency("histogram-test", 3*time.Microsecond)
+	RecordCommandLatency("histogram-test", 100*time.Microsecond)
+
+	h := CommandHistograms()["histogram-test"]
+	assert.Equal(t, int64(4), h.Calls)
+	assert.Equal(t, [][2]int64{{1, 1}, {4, 3}, {128, 4}}, h.Cumulative())
+}
@@ -2,11 +2,138 @@ package store
 
 import (
 	"github.com/medusar/lucas/util"
-	"time"
 )
 
+//minDequeSize is the smallest buffer of a deque, it's never shrunk below it
+const minDequeSize = 8
+
+//deque is a double ended queue on a ring buffer, whose size is a power of two.
+//Pushing and popping at both ends is amortized O(1) and so is accessing an element by its index.
+type deque struct {
+	buf  []string
+	head int
+	n    int
+}
+
+func (d *deque) len() int {
+	return d.n
+}
+
+//slot returns the position in buf of the ith element
+func (d *deque) slot(i int) int {
+	return (d.head + i) & (len(d.buf) - 1)
+}
+
+func (d *deque) at(i int) string {
+	return d.buf[d.slot(i)]
+}
+
+func (d *deque) setAt(i int, v string) {
+	d.buf[d.slot(i)] = v
+}
+
+//resize moves the elements to a buffer of size, the first one at its start
+func (d *deque) resize(size int) {
+	buf := make([]string, size)
+	if d.n > 0 {
+		if end := d.head + d.n; end <= len(d.buf) {
+			copy(buf, d.buf[d.head:end])
+		} else {
+			n := copy(buf, d.buf[d.head:])
+			copy(buf[n:], d.buf[:end-len(d.buf)])
+		}
+	}
+	d.buf, d.head = buf, 0
+}
+
+//grow makes room for one more element
+func (d *deque) grow() {
+	if d.n < len(d.buf) {
+		return
+	}
+	size := 2 * len(d.buf)
+	if size < minDequeSize {
+		size = minDequeSize
+	}
+	d.resize(size)
+}
+
+//shrink releases half of the buffer once it's used at a quarter
+func (d *deque) shrink() {
+	if len(d.buf) > minDequeSize && d.n <= len(d.buf)/4 {
+		d.resize(len(d.buf) / 2)
+	}
+}
+
+func (d *deque) pushFront(v string) {
+	d.grow()
+	d.head = (d.head - 1) & (len(d.buf) - 1)
+	d.buf[d.head] = v
+	d.n++
+}
+
+func (d *deque) pushBack(v string) {
+	d.grow()
+	d.buf[d.slot(d.n)] = v
+	d.n++
+}
+
+func (d *deque) popFront() string {
+	v := d.buf[d.head]
+	//the slot is cleared so that the string can be collected
+	d.buf[d.head] = ""
+	d.head = d.slot(1)
+	d.n--
+	d.shrink()
+	return v
+}
+
+func (d *deque) popBack() string {
+	i := d.slot(d.n - 1)
+	v := d.buf[i]
+	d.buf[i] = ""
+	d.n--
+	d.shrink()
+	return v
+}
+
+//insert inserts v at index i, the elements on the shorter side of i are moved
+func (d *deque) insert(i int, v string) {
+	d.grow()
+	if i < d.n/2 {
+		d.head = (d.head - 1) & (len(d.buf) - 1)
+		for j := 0; j < i; j++ {
+			d.setAt(j, d.at(j+1))
+		}
+	} else {
+		for j := d.n; j > i; j-- {
+			d.setAt(j, d.at(j-1))
+		}
+	}
+	d.setAt(i, v)
+	d.n++
+}
+
+//filter keeps the elements for which keep returns true, in their order
+func (d *deque) filter(keep func(i int, v string) bool) int {
+	kept := 0
+	for i := 0; i < d.n; i++ {
+		if v := d.at(i); keep(i, v) {
+			d.setAt(kept, v)
+			kept++
+		}
+	}
+	removed := d.n - kept
+	for i := kept; i < d.n; i++ {
+		d.setAt(i, "")
+	}
+	d.n = kept
+	d.shrink()
+	return removed
+}
+
 type listVal struct {
-	val      []string
+	val      deque
 	expireAt int64
 }
 
@@ -15,73 +142,64 @@ func (s *listVal) isAlive() bool {
 	if s.len() == 0 { //when list is empty, it is regarded as expired
 		return false
 	}
-
-	if s.expireAt == -1 {
-		return true
-	}
-	ttl := s.expireAt - time.Now().Unix()
-	return ttl >= 0
+	return aliveUntil(s.expireAt)
 }
 
 func (s *listVal) ttl() int {
-	//returns -1 if the key exists but has no associated expire
-	if s.expireAt == -1 {
-		return -1
-	}
-
-	ttl := s.expireAt - time.Now().Unix()
-	if ttl > 0 {
-		return int(ttl)
-	}
-	//returns -2 if the key does not exist.
-	return -2
+	return ttlUntil(s.expireAt)
 }
 
 func (s *listVal) setExpireAt(at int64) {
 	s.expireAt = at
 }
 
+func (s *listVal) expiration() int64 {
+	return s.expireAt
+}
+
 func (s *listVal) dataType() string {
 	return "list"
 }
 
+//encoding is the one of redis for lists of any size, the deque is its counterpart
+func (s *listVal) encoding() string {
+	return encodingQuicklist
+}
+
+func (s *listVal) clone() expired {
+	c := *s
+	c.val.buf = append([]string(nil), s.val.buf...)
+	return &c
+}
+
 func (s *listVal) lpush(elements []string) int {
-	list := s.val
 	for _, e := range elements {
-		list = append(list, "")
-		copy(list[1:], list[:])
-		list[0] = e
+		s.val.pushFront(util.Clone(e))
 	}
-	s.val = list
-	return len(s.val)
+	return s.len()
 }
 
 func (s *listVal) rpush(elements []string) int {
-	list := s.val
-	list = append(list, elements...)
-	s.val = list
-	return len(s.val)
+	for _, e := range elements {
+		s.val.pushBack(util.Clone(e))
+	}
+	return s.len()
 }
 
 func (s *listVal) len() int {
-	return len(s.val)
+	return s.val.len()
 }
 
 func (s *listVal) lpop() string {
-	if len(s.val) > 0 {
-		r := s.val[0]
-		s.val = s.val[1:]
-		return r
+	if s.len() > 0 {
+		return s.val.popFront()
 	}
 	return ""
 }
 
 func (s *listVal) rpop() string {
-	l := len(s.val)
-	if l > 0 {
-		r := s.val[l-1]
-		s.val = s.val[:l-1]
-		return r
+	if s.len() > 0 {
+		return s.val.popBack()
 	}
 	//should not happen by out caller
 	return ""
@@ -95,7 +213,7 @@ func (s *listVal) lindex(i int) (string, bool, error) {
 	if i < 0 || i > l-1 {
 		return "", false, nil
 	}
-	return s.val[i], true, nil
+	return s.val.at(i), true, nil
 }
 
 // The count argument influences the operation in the following ways:
@@ -103,41 +221,27 @@ func (s *listVal) lindex(i int) (string, bool, error) {
 // 2) count < 0: Remove elements equal to element moving from tail to head.
 // 3) count = 0: Remove all elements equal to element.
 func (s *listVal) rem(count int, element string) (int, error) {
-	removed := 0
-	if count > 0 {
-		for i, v := range s.val {
-			if v == element {
-				s.val = util.DeleteStringArray(i, s.val)
-				removed++
-			}
-			if removed == count {
-				break
-			}
-		}
-
-	} else if count == 0 {
-		i := 0
-		for _, v := range s.val {
-			if v != element {
-				s.val[i] = v
-				i++
-			} else {
-				removed++
-			}
-		}
-		s.val = s.val[:i]
-	} else { //count < 0
-		count = -1 * count
-		for i := len(s.val) - 1; i >= 0; i-- {
-			if s.val[i] == element {
-				s.val = util.DeleteStringArray(i, s.val)
-				removed++
-			}
-			if removed == count {
-				break
+	//the matches before the index from are kept,
+	//moving from tail to head it's the index of the last match to remove
+	from, limit := 0, count
+	if count < 0 {
+		limit = -count
+		from = s.len()
+		for matched := 0; from > 0 && matched < limit; {
+			from--
+			if s.val.at(from) == element {
+				matched++
 			}
 		}
 	}
+	removed := 0
+	s.val.filter(func(i int, v string) bool {
+		if v != element || i < from || (limit > 0 && removed == limit) {
+			return true
+		}
+		removed++
+		return false
+	})
 	return removed, nil
 }
 
@@ -151,13 +255,12 @@ func (s *listVal) set(index int, element string) error {
 		return errorIndexOutOfRange
 	}
 
-	s.val[index] = element
+	s.val.setAt(index, util.Clone(element))
 	return nil
 }
 
 func (s *listVal) lrange(start, end int) []string {
 	l := s.len()
-
 	if start < 0 {
 		start = l + start
 	}
@@ -183,11 +286,94 @@ func (s *listVal) lrange(start, end int) []string {
 		return nil
 	}
 
-	return s.val[start:end]
+	ret := make([]string, end-start)
+	for i := range ret {
+		ret[i] = s.val.at(start + i)
+	}
+	return ret
+}
+
+//insert inserts the element before or after the first occurrence of pivot,
+//it returns the new length or -1 when pivot is not found
+func (s *listVal) insert(pivot, element string, before bool) int {
+	for i := 0; i < s.len(); i++ {
+		if s.val.at(i) != pivot {
+			continue
+		}
+		if !before {
+			i++
+		}
+		s.val.insert(i, util.Clone(element))
+		return s.len()
+	}
+	return -1
+}
+
+//trim keeps the elements from start to stop, both included, negative indexes count from the tail
+func (s *listVal) trim(start, stop int) {
+	l := s.len()
+	if start < 0 {
+		start = l + start
+	}
+	if stop < 0 {
+		stop = l + stop
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= l {
+		stop = l - 1
+	}
+	s.val.filter(func(i int, _ string) bool {
+		return i >= start && i <= stop
+	})
+}
+
+//pos returns the indexes of the elements equal to element, starting from the rank-th match,
+//from the tail when rank is negative. It stops after count matches unless count is 0,
+//and after comparing maxlen elements unless maxlen is 0.
+func (s *listVal) pos(element string, rank, count, maxlen int) []int {
+	l := s.len()
+	start, step := 0, 1
+	if rank < 0 {
+		rank = -rank
+		start, step = l-1, -1
+	}
+	var matches []int
+	for i, n := start, 0; i >= 0 && i < l && (maxlen == 0 || n < maxlen); i, n = i+step, n+1 {
+		if s.val.at(i) != element {
+			continue
+		}
+		if rank > 1 {
+			rank--
+			continue
+		}
+		matches = append(matches, i)
+		if len(matches) == count {
+			break
+		}
+	}
+	return matches
+}
+
+//popn pops up to count elements from the head, or from the tail when left is false
+func (s *listVal) popn(count int, left bool) []string {
+	if count > s.len() {
+		count = s.len()
+	}
+	ret := make([]string, count)
+	for i := range ret {
+		if left {
+			ret[i] = s.lpop()
+		} else {
+			ret[i] = s.rpop()
+		}
+	}
+	return ret
 }
 
 func listOf(key string) (*listVal, error) {
-	v, ok := values[key]
+	v, ok := lookup(key)
 	if !ok || !v.isAlive() {
 		return nil, nil
 	}
@@ -204,8 +390,8 @@ func getOrCreateList(key string) (*listVal, error) {
 		return nil, err
 	}
 	if lv == nil {
-		lv = &listVal{val: make([]string, 0), expireAt: -1}
-		values[key] = lv
+		lv = &listVal{expireAt: -1}
+		setValue(key, lv)
 	}
 	return lv, nil
 }
@@ -215,7 +401,9 @@ func Lpush(key string, elements []string) (int, error) {
 	if err != nil {
 		return -1, err
 	}
-	return lv.lpush(elements), nil
+	n := lv.lpush(elements)
+	signalModifiedKey(key)
+	return n, nil
 }
 
 // LpushX inserts specified values at the head of the list stored at key,
@@ -229,7 +417,9 @@ func LpushX(key string, elements []string) (int, error) {
 	if list == nil {
 		return 0, nil
 	}
-	return list.lpush(elements), nil
+	n := list.lpush(elements)
+	signalModifiedKey(key)
+	return n, nil
 }
 
 func Rpush(key string, elements []string) (int, error) {
@@ -237,7 +427,9 @@ func Rpush(key string, elements []string) (int, error) {
 	if err != nil {
 		return -1, err
 	}
-	return lv.rpush(elements), nil
+	n := lv.rpush(elements)
+	signalModifiedKey(key)
+	return n, nil
 }
 
 // RpushX inserts specified values at the tail of the list stored at key,
@@ -251,7 +443,9 @@ func RpushX(key string, elements []string) (int, error) {
 	if list == nil {
 		return 0, nil
 	}
-	return list.rpush(elements), nil
+	n := list.rpush(elements)
+	signalModifiedKey(key)
+	return n, nil
 }
 
 func Llen(key string) (int, error) {
@@ -265,6 +459,14 @@ func Llen(key string) (int, error) {
 	return lv.len(), nil
 }
 
+//listModified signals a list modified by a removal, the key is deleted once the list is empty
+func listModified(key string, lv *listVal) {
+	if lv.len() == 0 {
+		deleteValue(key)
+	}
+	signalModifiedKey(key)
+}
+
 func Lpop(key string) (string, bool, error) {
 	lv, err := listOf(key)
 	if err != nil {
@@ -273,7 +475,9 @@ func Lpop(key string) (string, bool, error) {
 	if lv == nil {
 		return "", false, nil
 	}
-	return lv.lpop(), true, nil
+	v := lv.lpop()
+	listModified(key, lv)
+	return v, true, nil
 }
 
 func Rpop(key string) (string, bool, error) {
@@ -284,7 +488,93 @@ func Rpop(key string) (string, bool, error) {
 	if lv == nil {
 		return "", false, nil
 	}
-	return lv.rpop(), true, nil
+	v := lv.rpop()
+	listModified(key, lv)
+	return v, true, nil
+}
+
+// PopN pops up to count elements from the head of the list, or from its tail when left is false.
+// It returns nil when the key doesn't exist.
+func PopN(key string, count int, left bool) ([]string, error) {
+	lv, err := listOf(key)
+	if err != nil || lv == nil {
+		return nil, err
+	}
+	ret := lv.popn(count, left)
+	if len(ret) > 0 {
+		listModified(key, lv)
+	}
+	return ret, nil
+}
+
+// Linsert inserts the element before or after the first occurrence of pivot.
+// It returns the length of the list, 0 when the key doesn't exist and -1 when pivot is not found.
+func Linsert(key string, before bool, pivot, element string) (int, error) {
+	lv, err := listOf(key)
+	if err != nil || lv == nil {
+		return 0, err
+	}
+	n := lv.insert(pivot, element, before)
+	if n > 0 {
+		signalModifiedKey(key)
+	}
+	return n, nil
+}
+
+// Ltrim trims the list to the elements from start to stop, both included.
+// Negative indexes count from the tail, the key is deleted when no element is left.
+func Ltrim(key string, start, stop int) error {
+	lv, err := listOf(key)
+	if err != nil || lv == nil {
+		return err
+	}
+	n := lv.len()
+	lv.trim(start, stop)
+	if lv.len() != n {
+		listModified(key, lv)
+	}
+	return nil
+}
+
+// Lpos returns the indexes of the elements equal to element, see listVal.pos for rank, count and maxlen.
+func Lpos(key, element string, rank, count, maxlen int) ([]int, error) {
+	lv, err := listOf(key)
+	if err != nil || lv == nil {
+		return nil, err
+	}
+	return lv.pos(element, rank, count, maxlen), nil
+}
+
+// Lmove pops an element from the head of source, or its tail when fromLeft is false,
+// and pushes it to the head of destination, or its tail when toLeft is false.
+// Source and destination may be the same list, which rotates it.
+// It reports false when source doesn't exist.
+func Lmove(source, destination string, fromLeft, toLeft bool) (string, bool, error) {
+	src, err := listOf(source)
+	if err != nil || src == nil {
+		return "", false, err
+	}
+	//the destination is checked before anything is popped
+	dst, err := listOf(destination)
+	if err != nil {
+		return "", false, err
+	}
+	v := src.popn(1, fromLeft)[0]
+	if dst == nil {
+		dst = &listVal{expireAt: -1}
+		setValue(destination, dst)
+	}
+	//v is owned by the store already, it's pushed without cloning
+	if toLeft {
+		dst.val.pushFront(v)
+	} else {
+		dst.val.pushBack(v)
+	}
+	listModified(source, src)
+	if destination != source {
+		signalModifiedKey(destination)
+	}
+	return v, true, nil
 }
 
 // Returns the element at index index in the list stored at key.
@@ -319,7 +609,11 @@ func Lrem(key string, count int, element string) (int, error) {
 	if lv == nil {
 		return 0, nil
 	}
-	return lv.rem(count, element)
+	n, err := lv.rem(count, element)
+	if n > 0 {
+		listModified(key, lv)
+	}
+	return n, err
 }
 
 // Sets the list element at index to element.
@@ -333,7 +627,11 @@ func Lset(key string, index int, element string) error {
 	if lv == nil {
 		return errorNoSuchKey
 	}
-	return lv.set(index, element)
+	if err := lv.set(index, element); err != nil {
+		return err
+	}
+	signalModifiedKey(key)
+	return nil
 }
 
 // Lrange returns the specified elements of the list stored at key, and index range from start to end.
@@ -9,7 +9,7 @@ import (
 )
 
 func TestLpush(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Set("s1", "s1")
 
 	//TODO: rpush empty list
@@ -21,7 +21,7 @@ func TestLpush(t *testing.T) {
 	list, err := listOf("list0")
 	assert.Nil(t, err)
 	for i := 0; i < 4; i++ {
-		assert.Equal(t, strconv.Itoa(9-i), list.val[i])
+		assert.Equal(t, strconv.Itoa(9-i), list.val.at(i))
 	}
 
 	type args struct {
@@ -55,7 +55,7 @@ func TestLpush(t *testing.T) {
 }
 
 func TestRpush(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Set("s1", "s1")
 
 	for i := 0; i < 4; i++ {
@@ -65,7 +65,7 @@ func TestRpush(t *testing.T) {
 	list, err := listOf("list0")
 	assert.Nil(t, err)
 	for i := 0; i < 4; i++ {
-		assert.Equal(t, strconv.Itoa(i), list.val[i])
+		assert.Equal(t, strconv.Itoa(i), list.val.at(i))
 	}
 
 	type args struct {
@@ -99,7 +99,7 @@ func TestRpush(t *testing.T) {
 }
 
 func TestLlen(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Set("s1", "s1")
 
 	for i := 0; i < 10; i++ {
@@ -137,7 +137,7 @@ func TestLlen(t *testing.T) {
 }
 
 func TestLpop(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Set("s1", "s1")
 
 	for i := 6; i < 10; i++ {
@@ -180,7 +180,7 @@ func TestLpop(t *testing.T) {
 }
 
 func TestRpop(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Set("s1", "s1")
 
 	for i := 0; i < 4; i++ {
@@ -231,7 +231,7 @@ func TestRpop(t *testing.T) {
 }
 
 func TestLindex(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Set("s1", "s1")
 
 	for i := 0; i < 4; i++ {
@@ -281,7 +281,7 @@ func TestLindex(t *testing.T) {
 }
 
 func TestLrem(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Set("s1", "s1")
 
 	Lpush("list1", []string{"0", "1", "2", "3"})
@@ -362,7 +362,7 @@ func TestLrem(t *testing.T) {
 	assert.Equal(t, 3, i)
 
 	list, _ = listOf("list3")
-	log.Println(list.val)
+	log.Println(list.lrange(0, -1))
 	//remain  "0", "1", "2", "1", "0", "2", "4", "5"
 	s, exist, err = Lindex("list3", -4)
 	assert.Nil(t, err)
@@ -380,7 +380,7 @@ func TestLrem(t *testing.T) {
 }
 
 func TestLset(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Set("s1", "s1")
 	Rpush("list", []string{"0", "1", "2", "3"})
 
@@ -418,14 +418,14 @@ func TestLset(t *testing.T) {
 	assert.Nil(t, Lset("list1", -3, "-3/1"))
 	list, err := listOf("list1")
 	assert.Nil(t, err)
-	assert.Equal(t, "-4/0", list.val[0])
-	assert.Equal(t, "-1/3", list.val[3])
-	assert.Equal(t, "-2/2", list.val[2])
-	assert.Equal(t, "-3/1", list.val[1])
+	assert.Equal(t, "-4/0", list.val.at(0))
+	assert.Equal(t, "-1/3", list.val.at(3))
+	assert.Equal(t, "-2/2", list.val.at(2))
+	assert.Equal(t, "-3/1", list.val.at(1))
 }
 
 func TestRpushX(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Set("s1", "s1")
 	Rpush("list", []string{"0"})
 
@@ -458,7 +458,7 @@ func TestRpushX(t *testing.T) {
 }
 
 func TestLpushX(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Set("s1", "s1")
 	Rpush("list", []string{"0"})
 
@@ -491,7 +491,7 @@ func TestLpushX(t *testing.T) {
 }
 
 func TestLrange(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Set("s1", "s1")
 	array := make([]string, 10)
 	for i := 0; i < 10; i++ {
@@ -536,3 +536,352 @@ func TestLrange(t *testing.T) {
 		})
 	}
 }
+
+func TestPopN(t *testing.T) {
+	flushAll()
+	Set("s1", "s1")
+	Rpush("list1", []string{"0", "1", "2", "3", "4"})
+
+	list, err := PopN("list1", 2, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"0", "1"}, list)
+
+	list, err = PopN("list1", 0, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{}, list)
+
+	list, err = PopN("list1", 10, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"4", "3", "2"}, list)
+	assert.False(t, Exists("list1"))
+
+	list, err = PopN("list1", 1, true)
+	assert.Nil(t, err)
+	assert.Nil(t, list)
+
+	_, err = PopN("s1", 1, true)
+	assert.NotNil(t, err)
+}
+
+func TestLinsert(t *testing.T) {
+	flushAll()
+	Set("s1", "s1")
+	Rpush("list1", []string{"a", "c"})
+
+	n, err := Linsert("list1", true, "c", "b")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	n, err = Linsert("list1", false, "c", "d")
+	assert.Nil(t, err)
+	assert.Equal(t, 4, n)
+	n, err = Linsert("list1", true, "x", "y")
+	assert.Nil(t, err)
+	assert.Equal(t, -1, n)
+	list, _ := Lrange("list1", 0, -1)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, list)
+
+	n, err = Linsert("noexists", true, "a", "b")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	_, err = Linsert("s1", true, "a", "b")
+	assert.NotNil(t, err)
+}
+
+func TestLtrim(t *testing.T) {
+	flushAll()
+	Set("s1", "s1")
+	Rpush("list1", []string{"0", "1", "2", "3", "4"})
+
+	assert.Nil(t, Ltrim("list1", 1, -2))
+	list, _ := Lrange("list1", 0, -1)
+	assert.Equal(t, []string{"1", "2", "3"}, list)
+
+	assert.Nil(t, Ltrim("list1", -100, 100))
+	list, _ = Lrange("list1", 0, -1)
+	assert.Equal(t, []string{"1", "2", "3"}, list)
+
+	assert.Nil(t, Ltrim("list1", 2, 1))
+	assert.False(t, Exists("list1"))
+
+	assert.Nil(t, Ltrim("noexists", 0, 1))
+	assert.NotNil(t, Ltrim("s1", 0, 1))
+}
+
+func TestLpos(t *testing.T) {
+	flushAll()
+	Set("s1", "s1")
+	Rpush("list1", []string{"a", "b", "c", "1", "2", "3", "c", "c"})
+
+	tests := []struct {
+		name   string
+		rank   int
+		count  int
+		maxlen int
+		want   []int
+	}{
+		{"first", 1, 1, 0, []int{2}},
+		{"rank", 2, 1, 0, []int{6}},
+		{"negative rank", -1, 1, 0, []int{7}},
+		{"count", 1, 2, 0, []int{2, 6}},
+		{"all", 1, 0, 0, []int{2, 6, 7}},
+		{"rank and count", -2, 0, 0, []int{6, 2}},
+		{"maxlen", 1, 0, 3, []int{2}},
+		{"maxlen from tail", -1, 0, 2, []int{7, 6}},
+		{"no match", 4, 0, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Lpos("list1", "c", tt.rank, tt.count, tt.maxlen)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	got, err := Lpos("noexists", "c", 1, 0, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, got)
+	_, err = Lpos("s1", "c", 1, 0, 0)
+	assert.NotNil(t, err)
+}
+
+func TestLmove(t *testing.T) {
+	flushAll()
+	Set("s1", "s1")
+	Rpush("list1", []string{"a", "b", "c"})
+
+	v, ok, err := Lmove("list1", "list2", false, true)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "c", v)
+	v, _, _ = Lmove("list1", "list2", true, false)
+	assert.Equal(t, "a", v)
+	list, _ := Lrange("list2", 0, -1)
+	assert.Equal(t, []string{"c", "a"}, list)
+
+	//rotation
+	Rpush("list2", []string{"b"})
+	v, _, _ = Lmove("list2", "list2", true, false)
+	assert.Equal(t, "c", v)
+	list, _ = Lrange("list2", 0, -1)
+	assert.Equal(t, []string{"a", "b", "c"}, list)
+
+	//the destination is checked before popping
+	_, _, err = Lmove("list1", "s1", true, true)
+	assert.NotNil(t, err)
+	n, _ := Llen("list1")
+	assert.Equal(t, 1, n)
+
+	//the source is deleted once empty
+	v, _, _ = Lmove("list1", "list2", true, true)
+	assert.Equal(t, "b", v)
+	assert.False(t, Exists("list1"))
+
+	_, ok, err = Lmove("noexists", "s1", true, true)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func Test_deque(t *testing.T) {
+	var d deque
+	var want []string
+	check := func() {
+		assert.Equal(t, len(want), d.len())
+		for i, v := range want {
+			assert.Equal(t, v, d.at(i))
+		}
+	}
+	//pushes at both ends wrap around the buffer and grow it
+	for i := 0; i < 100; i++ {
+		v := strconv.Itoa(i)
+		if i%3 == 0 {
+			d.pushFront(v)
+			want = append([]string{v}, want...)
+		} else {
+			d.pushBack(v)
+			want = append(want, v)
+		}
+	}
+	check()
+
+	d.insert(1, "x")
+	want = append(want[:1], append([]string{"x"}, want[1:]...)...)
+	d.insert(98, "y")
+	want = append(want[:98], append([]string{"y"}, want[98:]...)...)
+	d.insert(d.len(), "z")
+	want = append(want, "z")
+	check()
+
+	//pops shrink the buffer
+	for len(want) > 3 {
+		assert.Equal(t, want[0], d.popFront())
+		assert.Equal(t, want[len(want)-1], d.popBack())
+		want = want[1 : len(want)-1]
+	}
+	check()
+	assert.Equal(t, minDequeSize, len(d.buf))
+
+	removed := d.filter(func(i int, _ string) bool { return i != 1 })
+	assert.Equal(t, 1, removed)
+	want = append(want[:1], want[2:]...)
+	check()
+}
+
+func Test_listVal_rem(t *testing.T) {
+	tests := []struct {
+		name  string
+		count int
+		want  []string
+	}{
+		{"head", 2, []string{"b", "a", "c", "a"}},
+		{"tail", -2, []string{"a", "a", "b", "c"}},
+		{"all", 0, []string{"b", "c"}},
+		{"more than matches", 10, []string{"b", "c"}},
+		{"more than matches from tail", -10, []string{"b", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &listVal{expireAt: -1}
+			l.rpush([]string{"a", "a", "b", "a", "c", "a"})
+			l.rem(tt.count, "a")
+			assert.Equal(t, tt.want, l.lrange(0, -1))
+		})
+	}
+}
+
+//sliceList is the list on a plain slice replaced by deque, it's kept to compare them
+type sliceList []string
+
+func (s *sliceList) lpush(v string) {
+	*s = append(*s, "")
+	copy((*s)[1:], *s)
+	(*s)[0] = v
+}
+
+func (s *sliceList) rpush(v string) {
+	*s = append(*s, v)
+}
+
+func (s *sliceList) lpop() string {
+	v := (*s)[0]
+	*s = (*s)[1:]
+	return v
+}
+
+var listSizes = []int{100, 10000, 1000000}
+
+func BenchmarkLpush(b *testing.B) {
+	for _, n := range listSizes {
+		b.Run("deque/"+strconv.Itoa(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var d deque
+				for j := 0; j < n; j++ {
+					d.pushFront("v")
+				}
+			}
+		})
+		if n > 10000 {
+			//pushing to the head of the slice is quadratic, it would take minutes
+			continue
+		}
+		b.Run("slice/"+strconv.Itoa(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var s sliceList
+				for j := 0; j < n; j++ {
+					s.lpush("v")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRpushLpop(b *testing.B) {
+	for _, n := range listSizes {
+		//a queue holding n elements
+		b.Run("deque/"+strconv.Itoa(n), func(b *testing.B) {
+			var d deque
+			for j := 0; j < n; j++ {
+				d.pushBack("v")
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				d.pushBack("v")
+				d.popFront()
+			}
+		})
+		b.Run("slice/"+strconv.Itoa(n), func(b *testing.B) {
+			var s sliceList
+			for j := 0; j < n; j++ {
+				s.rpush("v")
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s.rpush("v")
+				s.lpop()
+			}
+		})
+	}
+}
+
+func BenchmarkLindex(b *testing.B) {
+	for _, n := range listSizes {
+		b.Run("deque/"+strconv.Itoa(n), func(b *testing.B) {
+			l := &listVal{expireAt: -1}
+			for j := 0; j < n; j++ {
+				l.val.pushFront(strconv.Itoa(j))
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				l.lindex(i % n)
+			}
+		})
+		b.Run("slice/"+strconv.Itoa(n), func(b *testing.B) {
+			var s sliceList
+			for j := 0; j < n; j++ {
+				s.rpush(strconv.Itoa(j))
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_ = s[i%n]
+			}
+		})
+	}
+}
+
+func BenchmarkLrange(b *testing.B) {
+	for _, n := range listSizes {
+		b.Run("deque/"+strconv.Itoa(n), func(b *testing.B) {
+			l := &listVal{expireAt: -1}
+			for j := 0; j < n; j++ {
+				l.val.pushFront(strconv.Itoa(j))
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				start := i % n
+				l.lrange(start, start+9)
+			}
+		})
+		b.Run("slice/"+strconv.Itoa(n), func(b *testing.B) {
+			var s sliceList
+			for j := 0; j < n; j++ {
+				s.rpush(strconv.Itoa(j))
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				start := i % n
+				end := start + 10
+				if end > n {
+					end = n
+				}
+				_ = s[start:end]
+			}
+		})
+	}
+}
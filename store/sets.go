@@ -2,47 +2,219 @@ package store
 
 import (
 	"github.com/medusar/lucas/util"
-	"time"
+	"math/rand"
+	"sort"
+	"strconv"
 )
 
-var obj = &struct{}{}
-
+//setVal is an intset while its members are integers, ints keeps them sorted.
+//Otherwise a small set is a listpack whose members are found by a scan of members,
+//and a larger one is a hashtable where val maps every member to its index in members.
+//A random member is picked in O(1) whatever the encoding.
 type setVal struct {
-	val      map[string]*struct{}
+	enc      string
+	ints     []int64
+	members  []string
+	val      map[string]int
 	expireAt int64
 }
 
+func newSetVal() *setVal {
+	return &setVal{enc: encodingIntset, expireAt: -1}
+}
+
 func (s *setVal) isAlive() bool {
-	if s.expireAt == -1 {
-		return true
-	}
-	ttl := s.expireAt - time.Now().Unix()
-	return ttl >= 0
+	return aliveUntil(s.expireAt)
 }
 
 func (s *setVal) ttl() int {
-	//returns -1 if the key exists but has no associated expire
-	if s.expireAt == -1 {
-		return -1
-	}
-	ttl := s.expireAt - time.Now().Unix()
-	if ttl > 0 {
-		return int(ttl)
-	}
-	//returns -2 if the key does not exist.
-	return -2
+	return ttlUntil(s.expireAt)
 }
 
 func (s *setVal) setExpireAt(at int64) {
 	s.expireAt = at
 }
 
+func (s *setVal) expiration() int64 {
+	return s.expireAt
+}
+
 func (s *setVal) dataType() string {
 	return "set"
 }
 
+func (s *setVal) encoding() string {
+	return s.enc
+}
+
+func (s *setVal) clone() expired {
+	c := *s
+	c.ints = append([]int64(nil), s.ints...)
+	c.members = append([]string(nil), s.members...)
+	if s.val != nil {
+		c.val = make(map[string]int, len(s.val))
+		for m, i := range s.val {
+			c.val[m] = i
+		}
+	}
+	return &c
+}
+
+func (s *setVal) len() int {
+	if s.enc == encodingIntset {
+		return len(s.ints)
+	}
+	return len(s.members)
+}
+
+//at returns the ith member, in the order of ints or members
+func (s *setVal) at(i int) string {
+	if s.enc == encodingIntset {
+		return strconv.FormatInt(s.ints[i], 10)
+	}
+	return s.members[i]
+}
+
+//index returns the position of member in ints or members, or -1
+func (s *setVal) index(member string) int {
+	switch s.enc {
+	case encodingIntset:
+		v, ok := intOf(member)
+		if !ok {
+			return -1
+		}
+		i := sort.Search(len(s.ints), func(i int) bool { return s.ints[i] >= v })
+		if i < len(s.ints) && s.ints[i] == v {
+			return i
+		}
+	case encodingListpack:
+		for i, m := range s.members {
+			if m == member {
+				return i
+			}
+		}
+	default:
+		if i, ok := s.val[member]; ok {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *setVal) has(member string) bool {
+	return s.index(member) >= 0
+}
+
+//add adds a copy of member, it reports false when it's a member already
+func (s *setVal) add(member string) bool {
+	if s.has(member) {
+		return false
+	}
+	if s.enc == encodingIntset {
+		v, ok := intOf(member)
+		if ok && len(s.ints) < limits.SetMaxIntsetEntries {
+			i := sort.Search(len(s.ints), func(i int) bool { return s.ints[i] >= v })
+			s.ints = append(s.ints, 0)
+			copy(s.ints[i+1:], s.ints[i:])
+			s.ints[i] = v
+			return true
+		}
+		s.convert(member)
+	} else if s.enc == encodingListpack {
+		s.convert(member)
+	}
+	member = util.Clone(member)
+	if s.val != nil {
+		s.val[member] = len(s.members)
+	}
+	s.members = append(s.members, member)
+	return true
+}
+
+//convert changes the encoding when member can't be added to the current one:
+//an intset becomes a listpack, and a listpack becomes a hashtable once it's too large
+func (s *setVal) convert(member string) {
+	listpack := s.len() < limits.SetMaxListpackEntries && len(member) <= limits.SetMaxListpackValue
+	if s.enc == encodingIntset {
+		s.members = make([]string, len(s.ints), len(s.ints)+1)
+		for i, v := range s.ints {
+			s.members[i] = strconv.FormatInt(v, 10)
+		}
+		s.ints = nil
+		s.enc = encodingListpack
+	}
+	if s.enc == encodingListpack && !listpack {
+		s.val = make(map[string]int, len(s.members)+1)
+		for i, m := range s.members {
+			s.val[m] = i
+		}
+		s.enc = encodingHashtable
+	}
+}
+
+func (s *setVal) remove(member string) bool {
+	i := s.index(member)
+	if i < 0 {
+		return false
+	}
+	if s.enc == encodingIntset {
+		s.ints = s.ints[:i+copy(s.ints[i:], s.ints[i+1:])]
+		return true
+	}
+	//the last member takes the place of the removed one
+	last := len(s.members) - 1
+	if i != last {
+		s.members[i] = s.members[last]
+		if s.val != nil {
+			s.val[s.members[i]] = i
+		}
+	}
+	s.members[last] = ""
+	s.members = s.members[:last]
+	if s.val != nil {
+		delete(s.val, member)
+	}
+	return true
+}
+
+//each calls fn for every member until it returns false
+func (s *setVal) each(fn func(member string) bool) {
+	for i := 0; i < s.len(); i++ {
+		if !fn(s.at(i)) {
+			return
+		}
+	}
+}
+
+//pop removes up to count random members
+func (s *setVal) pop(count int) []string {
+	if count > s.len() {
+		count = s.len()
+	}
+	ret := make([]string, count)
+	for i := range ret {
+		ret[i] = s.at(rand.Intn(s.len()))
+		s.remove(ret[i])
+	}
+	return ret
+}
+
+//random returns count random members: distinct ones when count is positive,
+//-count ones which may be repeated when it's negative
+func (s *setVal) random(count int) []string {
+	indexes := randomIndexes(s.len(), count)
+	if indexes == nil {
+		return nil
+	}
+	ret := make([]string, len(indexes))
+	for i, j := range indexes {
+		ret[i] = s.at(j)
+	}
+	return ret
+}
+
 func setOf(key string) (*setVal, error) {
-	v, ok := values[key]
+	v, ok := lookup(key)
 	if !ok || !v.isAlive() {
 		return nil, nil
 	}
@@ -53,40 +225,32 @@ func setOf(key string) (*setVal, error) {
 	return set, nil
 }
 
-func mapOf(key string) (map[string]*struct{}, error) {
-	v, ok := values[key]
-	if !ok || !v.isAlive() {
-		return nil, nil
+//setModified signals a set modified by a removal, the key is deleted once the set is empty
+func setModified(key string, set *setVal) {
+	if set.len() == 0 {
+		deleteValue(key)
 	}
-	s, ok := v.(*setVal)
-	if !ok {
-		return nil, errorWrongType
-	}
-	return s.val, nil
+	signalModifiedKey(key)
 }
 
 func Sadd(key string, els []string) (int, error) {
-	v, ok := values[key]
-	if !ok || !v.isAlive() {
-		m := make(map[string]*struct{})
-		for _, el := range els {
-			m[el] = obj
-		}
-		values[key] = &setVal{val: m, expireAt: -1}
-		return len(els), nil
+	set, err := setOf(key)
+	if err != nil {
+		return -1, err
 	}
-	s, ok := v.(*setVal)
-	if !ok {
-		return -1, errorWrongType
+	if set == nil {
+		set = newSetVal()
+		setValue(key, set)
 	}
 	t := 0
 	for _, el := range els {
-		_, exists := s.val[el]
-		if !exists {
-			s.val[el] = obj
+		if set.add(el) {
 			t++
 		}
 	}
+	if t > 0 {
+		signalModifiedKey(key)
+	}
 	return t, nil
 }
 
@@ -99,7 +263,7 @@ func Scard(key string) (int, error) {
 	if set == nil {
 		return 0, nil
 	}
-	return len(set.val), nil
+	return set.len(), nil
 }
 
 //Sdiff returns the members of the set resulting from the difference between the first set and all the successive sets.
@@ -128,8 +292,9 @@ func SdiffStore(dest, key string, keys ...string) (int, error) {
 		return -1, err
 	}
 	//remove
-	delete(values, dest)
+	deleteValue(dest)
 	if len(set) == 0 {
+		signalModifiedKey(dest)
 		return 0, nil
 	}
 	return Sadd(dest, set)
@@ -139,36 +304,60 @@ func SdiffStore(dest, key string, keys ...string) (int, error) {
 // With one of the keys being an empty set, the resulting set is also empty
 // (since set intersection with an empty set always results in an empty set).
 func Sinter(key string, keys ...string) ([]string, error) {
-	m1, err := mapOf(key)
-	if err != nil {
+	sets, err := setsOf(append([]string{key}, keys...))
+	if err != nil || sets == nil {
 		return nil, err
 	}
+	r := make([]string, 0)
+	sets[0].each(func(member string) bool {
+		if inAll(member, sets[1:]) {
+			r = append(r, member)
+		}
+		return true
+	})
+	return r, nil
+}
 
-	if m1 == nil {
-		return nil, nil
+//setsOf returns the sets at keys, smallest first, or nil when one of them doesn't exist
+func setsOf(keys []string) ([]*setVal, error) {
+	sets := make([]*setVal, len(keys))
+	for i, k := range keys {
+		set, err := setOf(k)
+		if err != nil || set == nil {
+			return nil, err
+		}
+		sets[i] = set
 	}
+	sort.Slice(sets, func(i, j int) bool {
+		return sets[i].len() < sets[j].len()
+	})
+	return sets, nil
+}
 
-	r := make([]string, 0)
-	for mk := range m1 {
-		in := true
-		for _, k := range keys {
-			m, err := mapOf(k)
-			if err != nil {
-				return nil, err
-			}
-			if m == nil {
-				return nil, nil
-			}
-			if _, ok := m[mk]; !ok {
-				in = false
-				break
-			}
-		}
-		if in {
-			r = append(r, mk)
+func inAll(member string, sets []*setVal) bool {
+	for _, set := range sets {
+		if !set.has(member) {
+			return false
 		}
 	}
-	return r, nil
+	return true
+}
+
+// Sintercard returns the cardinality of the intersection of the sets at keys,
+// the count stops at limit unless it's 0.
+func Sintercard(keys []string, limit int) (int, error) {
+	sets, err := setsOf(keys)
+	if err != nil || sets == nil {
+		return 0, err
+	}
+	n := 0
+	sets[0].each(func(member string) bool {
+		if inAll(member, sets[1:]) {
+			n++
+		}
+		return limit == 0 || n < limit
+	})
+	return n, nil
 }
 
 func SinterStore(dest, key string, keys ...string) (int, error) {
@@ -176,8 +365,9 @@ func SinterStore(dest, key string, keys ...string) (int, error) {
 	if err != nil {
 		return -1, err
 	}
-	delete(values, dest)
+	deleteValue(dest)
 	if len(ins) == 0 {
+		signalModifiedKey(dest)
 		return 0, nil
 	}
 	return Sadd(dest, ins)
@@ -191,12 +381,11 @@ func Smembers(key string) ([]string, error) {
 	if set == nil {
 		return nil, nil
 	}
-	ret := make([]string, len(set.val))
-	i := 0
-	for k := range set.val {
-		ret[i] = k
-		i++
-	}
+	ret := make([]string, 0, set.len())
+	set.each(func(member string) bool {
+		ret = append(ret, member)
+		return true
+	})
 	return ret, nil
 }
 
@@ -208,70 +397,87 @@ func Sismember(key, member string) (bool, error) {
 	if set == nil {
 		return false, nil
 	}
-	_, is := set.val[member]
-	return is, nil
+	return set.has(member), nil
 }
 
-func Spop(key string, count int) ([]string, error) {
-	m, err := mapOf(key)
+// Smismember returns whether each of members is a member of the set at key.
+func Smismember(key string, members []string) ([]bool, error) {
+	set, err := setOf(key)
 	if err != nil {
 		return nil, err
 	}
-	if m == nil {
-		return nil, nil
+	ret := make([]bool, len(members))
+	if set != nil {
+		for i, m := range members {
+			ret[i] = set.has(m)
+		}
 	}
+	return ret, nil
+}
 
-	if count <= 0 {
-		return nil, nil
+// Srandmember returns count random members: distinct ones when count is positive,
+// -count ones which may be repeated when it's negative.
+func Srandmember(key string, count int) ([]string, error) {
+	set, err := setOf(key)
+	if err != nil || set == nil {
+		return nil, err
 	}
+	return set.random(count), nil
+}
 
-	var r []string
-	i := 0
-	for k := range m {
-		if i >= count {
-			break
-		}
-		delete(m, k)
-		r = append(r, k)
-		i++
+// Spop removes and returns up to count random members, the key is deleted once the set is empty.
+func Spop(key string, count int) ([]string, error) {
+	set, err := setOf(key)
+	if err != nil {
+		return nil, err
+	}
+	if set == nil || count <= 0 {
+		return nil, nil
 	}
+	r := set.pop(count)
+	setModified(key, set)
 	return r, nil
 }
 
 func Srem(key string, members []string) (int, error) {
-	mp, err := mapOf(key)
+	set, err := setOf(key)
 	if err != nil {
 		return -1, err
 	}
-	if mp == nil {
+	if set == nil {
 		return 0, nil
 	}
 
 	t := 0
 	for _, m := range members {
-		if _, ok := mp[m]; ok {
-			delete(mp, m)
+		if set.remove(m) {
 			t++
 		}
 	}
+	if t > 0 {
+		setModified(key, set)
+	}
 	return t, nil
 }
 
 func Sunion(keys []string) ([]string, error) {
-	mp := make(map[string]*struct{})
+	union := make(map[string]struct{})
+	ret := make([]string, 0)
 	for _, key := range keys {
-		m, err := mapOf(key)
+		set, err := setOf(key)
 		if err != nil {
 			return nil, err
 		}
-		for k := range m {
-			mp[k] = obj
+		if set == nil {
+			continue
 		}
-	}
-
-	ret := make([]string, 0)
-	for k := range mp {
-		ret = append(ret, k)
+		set.each(func(member string) bool {
+			if _, ok := union[member]; !ok {
+				union[member] = struct{}{}
+				ret = append(ret, member)
+			}
+			return true
+		})
 	}
 	return ret, nil
 }
@@ -281,8 +487,9 @@ func SunionStore(dest, key string, keys ...string) (int, error) {
 	if err != nil {
 		return -1, err
 	}
-	delete(values, dest)
+	deleteValue(dest)
 	if len(set) == 0 {
+		signalModifiedKey(dest)
 		return 0, nil
 	}
 	return Sadd(dest, set)
@@ -291,19 +498,27 @@ func SunionStore(dest, key string, keys ...string) (int, error) {
 //1 if the element is moved.
 //0 if the element is not a member of source and no operation was performed.
 func Smove(source, dest, member string) (int, error) {
-	smp, err := mapOf(source)
+	src, err := setOf(source)
 	if err != nil {
 		return -1, err
 	}
 	//If the source set does not exist or does not contain the specified element,
 	// no operation is performed and 0 is returned.
-	if smp == nil {
+	if src == nil {
 		return 0, nil
 	}
-	if _, ok := smp[member]; !ok {
+	//the destination is checked before anything is moved
+	if _, err := setOf(dest); err != nil {
+		return -1, err
+	}
+	if !src.has(member) {
 		return 0, nil
 	}
-	delete(smp, member)
+	if source == dest {
+		return 1, nil
+	}
+	src.remove(member)
+	setModified(source, src)
 	Sadd(dest, []string{member})
 	return 1, nil
 }
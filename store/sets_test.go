@@ -2,11 +2,12 @@ package store
 
 import (
 	"github.com/stretchr/testify/assert"
+	"strconv"
 	"testing"
 )
 
 func TestSadd(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Set("s1", "hello")
 
 	type args struct {
@@ -39,7 +40,7 @@ func TestSadd(t *testing.T) {
 }
 
 func TestScard(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Set("s1", "hello")
 	Sadd("set", []string{"1", "2", "3"})
 
@@ -71,7 +72,7 @@ func TestScard(t *testing.T) {
 }
 
 func TestSdiff(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Set("s1", "hello")
 	Sadd("set", []string{"1", "2", "3"})
 	Sadd("set1", []string{"2", "3"})
@@ -106,7 +107,7 @@ func TestSdiff(t *testing.T) {
 }
 
 func TestSdiffStore(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Set("s1", "hello")
 	Sadd("set", []string{"1", "2", "3"})
 	Sadd("set1", []string{"2", "3"})
@@ -144,7 +145,7 @@ func TestSdiffStore(t *testing.T) {
 }
 
 func TestSinter(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Set("s1", "hello")
 	Sadd("set", []string{"1", "2", "3"})
 	Sadd("set1", []string{"2", "3"})
@@ -178,7 +179,7 @@ func TestSinter(t *testing.T) {
 }
 
 func TestSinterStore(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Set("s1", "hello")
 	Sadd("set", []string{"1", "2", "3"})
 	Sadd("set1", []string{"2", "3"})
@@ -216,7 +217,7 @@ func TestSinterStore(t *testing.T) {
 }
 
 func TestSmembers(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Set("s1", "hello")
 	Sadd("set", []string{"1", "2"})
 
@@ -246,7 +247,7 @@ func TestSmembers(t *testing.T) {
 }
 
 func TestSismember(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Set("s1", "hello")
 	Sadd("set", []string{"1", "2"})
 
@@ -281,7 +282,7 @@ func TestSismember(t *testing.T) {
 }
 
 func TestSpop(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Set("s1", "hello")
 	Sadd("set", []string{"1", "2", "3", "4", "5"})
 
@@ -314,7 +315,7 @@ func TestSpop(t *testing.T) {
 }
 
 func TestSrem(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Set("s1", "hello")
 	Sadd("set", []string{"1", "2", "3", "4", "5"})
 
@@ -349,7 +350,7 @@ func TestSrem(t *testing.T) {
 }
 
 func TestSunion(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Set("s1", "hello")
 	Sadd("set", []string{"1", "2", "3"})
 	Sadd("set1", []string{"2", "3"})
@@ -383,7 +384,7 @@ func TestSunion(t *testing.T) {
 }
 
 func TestSunionStore(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Set("s1", "hello")
 	Sadd("set", []string{"1", "2", "3"})
 	Sadd("set1", []string{"2", "3"})
@@ -421,7 +422,7 @@ func TestSunionStore(t *testing.T) {
 }
 
 func TestSmove(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Set("s1", "hello")
 	Sadd("set", []string{"1", "2", "3"})
 	Sadd("set1", []string{"2", "3"})
@@ -456,3 +457,189 @@ func TestSmove(t *testing.T) {
 		})
 	}
 }
+
+func Test_setVal_remove(t *testing.T) {
+	defer SetEncodingLimits(DefaultEncodingLimits)
+	tests := []struct {
+		name     string
+		members  []string
+		limits   EncodingLimits
+		encoding string
+	}{
+		{"intset", []string{"4", "-2", "3", "1"}, DefaultEncodingLimits, encodingIntset},
+		{"listpack", []string{"a", "b", "c", "d"}, DefaultEncodingLimits, encodingListpack},
+		{"hashtable", []string{"a", "b", "c", "d"}, EncodingLimits{SetMaxListpackEntries: 2, SetMaxListpackValue: 64}, encodingHashtable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetEncodingLimits(tt.limits)
+			s := newSetVal()
+			for _, m := range tt.members {
+				assert.True(t, s.add(m))
+			}
+			assert.Equal(t, tt.encoding, s.encoding())
+			assert.False(t, s.add(tt.members[0]))
+			assert.True(t, s.remove(tt.members[0]))
+			assert.True(t, s.remove(tt.members[2]))
+			assert.False(t, s.remove(tt.members[2]))
+			assert.Equal(t, 2, s.len())
+			//the index of every member is kept up to date
+			for i := 0; i < s.len(); i++ {
+				assert.Equal(t, i, s.index(s.at(i)))
+			}
+			var members []string
+			s.each(func(m string) bool {
+				members = append(members, m)
+				return true
+			})
+			assert.ElementsMatch(t, []string{tt.members[1], tt.members[3]}, members)
+		})
+	}
+}
+
+func Test_setVal_encoding(t *testing.T) {
+	defer SetEncodingLimits(DefaultEncodingLimits)
+	SetEncodingLimits(EncodingLimits{SetMaxIntsetEntries: 3, SetMaxListpackEntries: 4, SetMaxListpackValue: 5})
+
+	s := newSetVal()
+	s.add("3")
+	s.add("1")
+	s.add("2")
+	assert.Equal(t, encodingIntset, s.encoding())
+	assert.Equal(t, []string{"1", "2", "3"}, []string{s.at(0), s.at(1), s.at(2)})
+	//not canonical integers aren't kept in the intset
+	assert.False(t, s.has("01"))
+	s.add("01")
+	assert.Equal(t, encodingListpack, s.encoding())
+	assert.True(t, s.has("1") && s.has("01"))
+	s.add("x")
+	assert.Equal(t, encodingHashtable, s.encoding())
+	assert.Equal(t, 5, s.len())
+
+	//too many integers
+	s = newSetVal()
+	for i := 0; i < 4; i++ {
+		s.add(strconv.Itoa(i))
+	}
+	assert.Equal(t, encodingListpack, s.encoding())
+	//a long member
+	s = newSetVal()
+	s.add("abcdef")
+	assert.Equal(t, encodingHashtable, s.encoding())
+	assert.True(t, s.has("abcdef"))
+}
+
+func Test_setVal_pop(t *testing.T) {
+	//every member is popped about as often
+	const members, runs = 4, 4000
+	popped := make(map[string]int)
+	for i := 0; i < runs; i++ {
+		s := newSetVal()
+		for j := 0; j < members; j++ {
+			s.add(strconv.Itoa(j))
+		}
+		popped[s.pop(1)[0]]++
+	}
+	for j := 0; j < members; j++ {
+		assert.InDelta(t, runs/members, popped[strconv.Itoa(j)], runs/members/4)
+	}
+
+	s := newSetVal()
+	s.add("a")
+	s.add("b")
+	assert.ElementsMatch(t, []string{"a", "b"}, s.pop(3))
+	assert.Equal(t, 0, s.len())
+}
+
+func Test_setVal_random(t *testing.T) {
+	s := newSetVal()
+	for i := 0; i < 100; i++ {
+		s.add(strconv.Itoa(i))
+	}
+	tests := []struct {
+		name  string
+		count int
+		want  int
+	}{
+		{"few", 10, 10},
+		{"most", 80, 80},
+		{"more than the members", 200, 100},
+		{"repeated", -200, 200},
+		{"zero", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := s.random(tt.count)
+			assert.Equal(t, tt.want, len(got))
+			distinct := make(map[string]bool)
+			for _, m := range got {
+				assert.True(t, s.has(m))
+				distinct[m] = true
+			}
+			if tt.count > 0 {
+				assert.Equal(t, len(got), len(distinct))
+			}
+		})
+	}
+	assert.Equal(t, 100, s.len())
+}
+
+func TestSpopDeletesEmptySet(t *testing.T) {
+	flushAll()
+	Sadd("set", []string{"1", "2"})
+	got, err := Spop("set", 5)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"1", "2"}, got)
+	assert.False(t, Exists("set"))
+
+	Sadd("set", []string{"1"})
+	Srem("set", []string{"1"})
+	assert.False(t, Exists("set"))
+}
+
+func TestSmismember(t *testing.T) {
+	flushAll()
+	Set("s1", "hello")
+	Sadd("set", []string{"1", "2"})
+
+	got, err := Smismember("set", []string{"1", "3", "2"})
+	assert.Nil(t, err)
+	assert.Equal(t, []bool{true, false, true}, got)
+	got, err = Smismember("noexists", []string{"1"})
+	assert.Nil(t, err)
+	assert.Equal(t, []bool{false}, got)
+	_, err = Smismember("s1", []string{"1"})
+	assert.NotNil(t, err)
+}
+
+func TestSintercard(t *testing.T) {
+	flushAll()
+	Set("s1", "hello")
+	Sadd("set1", []string{"1", "2", "3", "4"})
+	Sadd("set2", []string{"2", "3", "4", "5"})
+
+	tests := []struct {
+		name    string
+		keys    []string
+		limit   int
+		want    int
+		wantErr bool
+	}{
+		{"all", []string{"set1", "set2"}, 0, 3, false},
+		{"limit", []string{"set1", "set2"}, 2, 2, false},
+		{"limit above", []string{"set1", "set2"}, 10, 3, false},
+		{"single", []string{"set1"}, 0, 4, false},
+		{"missing", []string{"set1", "noexists"}, 0, 0, false},
+		{"wrong type", []string{"set1", "s1"}, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Sintercard(tt.keys, tt.limit)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Sintercard() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
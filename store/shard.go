@@ -0,0 +1,227 @@
+package store
+
+import (
+	"github.com/medusar/lucas/util"
+	"math/rand"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+//ShardCount is the number of partitions of the keyspace, every shard has its own executor
+var ShardCount = runtime.NumCPU()
+
+var shards = newShards(ShardCount)
+
+//shard is a partition of the keyspace.
+//The store doesn't lock by itself, the executor locks the shards of the keys a command accesses with LockShards.
+type shard struct {
+	sync.Mutex
+	values map[string]*entry
+	//keys lists the keys of values, so that a random one is picked in O(1)
+	keys []string
+	//rng is used under the lock of the shard, unlike the global source of math/rand which has its own lock
+	rng *rand.Rand
+}
+
+//entry is a value of the keyspace with the statistics of its accesses, see OBJECT IDLETIME and OBJECT FREQ
+type entry struct {
+	val expired
+	//accessed is the unix time in milliseconds of the last access
+	accessed int64
+	//freq is the logarithmic access counter of the LFU of redis
+	freq uint8
+	//pos is the position of the key in the keys of the shard
+	pos int
+}
+
+//LFU parameters, the defaults of redis: the counter of a new value, how hard it's to increment
+//and the minutes without access that decrement it
+const (
+	lfuInitVal   = 5
+	lfuLogFactor = 10
+	lfuDecayTime = 1
+)
+
+//touch records an access to the value
+func (e *entry) touch(now int64, rng *rand.Rand) {
+	counter := e.decayedFreq(now)
+	if counter < 255 {
+		base := float64(counter) - lfuInitVal
+		if base < 0 {
+			base = 0
+		}
+		if rng.Float64() < 1/(base*lfuLogFactor+1) {
+			counter++
+		}
+	}
+	e.freq, e.accessed = counter, now
+}
+
+//decayedFreq returns the access counter decremented once for every period without access
+func (e *entry) decayedFreq(now int64) uint8 {
+	periods := (now - e.accessed) / (lfuDecayTime * 60 * 1000)
+	if periods >= int64(e.freq) {
+		return 0
+	}
+	return e.freq - uint8(periods)
+}
+
+func newShards(n int) []*shard {
+	s := make([]*shard, n)
+	for i := range s {
+		s[i] = &shard{values: make(map[string]*entry), rng: rand.New(rand.NewSource(time.Now().UnixNano() + int64(i)))}
+	}
+	return s
+}
+
+//ShardIndex returns the shard of a key, using the FNV-1a hash of the key
+func ShardIndex(key string) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h % uint32(len(shards)))
+}
+
+//ShardsOf appends to ids[:0] the distinct shards of the keys in ascending order, the order they must be locked in
+func ShardsOf(ids []int, keys []string) []int {
+	ids = ids[:0]
+	for _, key := range keys {
+		ids = append(ids, ShardIndex(key))
+	}
+	sort.Ints(ids)
+	n := 0
+	for i, id := range ids {
+		if i == 0 || id != ids[n-1] {
+			ids[n] = id
+			n++
+		}
+	}
+	return ids[:n]
+}
+
+//LockShards locks the shards returned by ShardsOf.
+//Locking them in ascending order means two multi-key commands never wait for each other in a cycle.
+func LockShards(ids []int) {
+	for _, id := range ids {
+		shards[id].Lock()
+	}
+}
+
+func UnlockShards(ids []int) {
+	for i := len(ids) - 1; i >= 0; i-- {
+		shards[ids[i]].Unlock()
+	}
+}
+
+//lookup returns the value at key and records the access
+func lookup(key string) (expired, bool) {
+	s := shards[ShardIndex(key)]
+	e, ok := s.values[key]
+	if !ok {
+		return nil, false
+	}
+	e.touch(nowMs(), s.rng)
+	return e.val, true
+}
+
+//peek returns the value at key without recording an access, like redis does for TYPE, TTL or OBJECT
+func peek(key string) (expired, bool) {
+	e, ok := shards[ShardIndex(key)].values[key]
+	if !ok {
+		return nil, false
+	}
+	return e.val, true
+}
+
+//entryOf returns the entry of a live key without recording an access
+func entryOf(key string) (*entry, bool) {
+	e, ok := shards[ShardIndex(key)].values[key]
+	if !ok || !e.val.isAlive() {
+		return nil, false
+	}
+	return e, true
+}
+
+//setValue stores a value, the key is cloned since the map keeps it even when it's overwritten.
+//A value replacing another one keeps its access counter.
+func setValue(key string, v expired) {
+	s := shards[ShardIndex(key)]
+	if e, ok := s.values[key]; ok {
+		e.val, e.accessed = v, nowMs()
+		return
+	}
+	key = util.Clone(key)
+	s.values[key] = &entry{val: v, accessed: nowMs(), freq: lfuInitVal, pos: len(s.keys)}
+	s.keys = append(s.keys, key)
+}
+
+func deleteValue(key string) {
+	shards[ShardIndex(key)].remove(key)
+}
+
+//remove deletes the entry of a key, the last key takes its place in keys
+func (s *shard) remove(key string) *entry {
+	e, ok := s.values[key]
+	if !ok {
+		return nil
+	}
+	last := len(s.keys) - 1
+	if e.pos != last {
+		moved := s.keys[last]
+		s.keys[e.pos] = moved
+		s.values[moved].pos = e.pos
+	}
+	s.keys[last] = ""
+	s.keys = s.keys[:last]
+	delete(s.values, key)
+	return e
+}
+
+//moveValue renames the key of a value, which keeps its access statistics. A value at dst is replaced.
+func moveValue(src, dst string) {
+	e := shards[ShardIndex(src)].remove(src)
+	s := shards[ShardIndex(dst)]
+	s.remove(dst)
+	dst = util.Clone(dst)
+	e.pos = len(s.keys)
+	s.values[dst] = e
+	s.keys = append(s.keys, dst)
+}
+
+//randomKey returns a key picked uniformly, including the expired ones not reclaimed yet.
+//It reports false when there's no key. Every shard must be locked.
+func randomKey() (string, bool) {
+	n := dbSize()
+	if n == 0 {
+		return "", false
+	}
+	i := rand.Intn(n)
+	for _, s := range shards {
+		if i < len(s.keys) {
+			return s.keys[i], true
+		}
+		i -= len(s.keys)
+	}
+	return "", false
+}
+
+//dbSize returns the number of keys, including the expired ones not reclaimed yet
+func dbSize() int {
+	n := 0
+	for _, s := range shards {
+		n += len(s.values)
+	}
+	return n
+}
+
+//flushAll removes every key
+func flushAll() {
+	for _, s := range shards {
+		s.values = make(map[string]*entry)
+		s.keys = nil
+	}
+}
@@ -1,8 +1,17 @@
 package store
 
-import "time"
+import (
+	"github.com/medusar/lucas/util"
+	"sync"
+	"time"
+)
+
+//slowlogThreshold is the minimum execution time in microseconds of a command added to the slow log,
+//0 logs every command and a negative value disables the slow log
+var slowlogThreshold = int64(10000)
 
 var (
+	slowMu   sync.Mutex
 	id       = int64(1)
 	slowReqs = make([]*slowReq, 1024)
 )
@@ -20,8 +29,19 @@ type slowReq struct {
 	args []string
 }
 
-//AddSlowLog is used to save a new slow log record
+//SetSlowlogThreshold sets the minimum execution time in microseconds of a command added to the slow log
+func SetSlowlogThreshold(threshold int64) {
+	slowlogThreshold = threshold
+}
+
+//AddSlowLog is used to save a new slow log record if the command took at least the threshold,
+//the faster commands don't take the lock.
 func AddSlowLog(start time.Time, args []string, timeTake int64) {
+	if slowlogThreshold < 0 || timeTake < slowlogThreshold {
+		return
+	}
+	slowMu.Lock()
+	defer slowMu.Unlock()
 	id++
-	slowReqs[id%1024] = &slowReq{id: id, timestamp: start, timeTake: timeTake, args: args}
+	slowReqs[id%1024] = &slowReq{id: id, timestamp: start, timeTake: timeTake, args: util.CloneArray(args)}
 }
@@ -4,27 +4,136 @@ import (
 	"errors"
 	"github.com/mb0/glob"
 	"log"
+	"math/rand"
 	"time"
 )
 
 var (
-	values               = make(map[string]expired)
+	modifiedKeyHooks     []func(key string)
 	errorWrongType       = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
 	errorInvalidInt      = errors.New("ERR value is not an integer or out of range")
 	errorInvalidFloat    = errors.New("ERR value is not a valid float")
 	errorNoSuchKey       = errors.New("ERR no such key")
 	errorIndexOutOfRange = errors.New("ERR index out of range")
+	errorScoreNaN        = errors.New("ERR resulting score is not a number (NaN)")
+	errorIncrNaN         = errors.New("ERR increment would produce NaN or Infinity")
+	errorLcsType         = errors.New("ERR The specified keys must contain string values")
 )
 
+//nowMs returns the unix time in milliseconds, values expire at unix times in milliseconds
+func nowMs() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+//aliveUntil reports whether a value expiring at the unix time in milliseconds is still alive, -1 means it never expires
+func aliveUntil(expireAt int64) bool {
+	return expireAt == -1 || expireAt >= nowMs()
+}
+
+//ttlUntil returns the seconds left until the expiration time, rounded like redis does.
+//It returns -1 when the value never expires and -2 once it's expired.
+func ttlUntil(expireAt int64) int {
+	if expireAt == -1 {
+		return -1
+	}
+	ms := expireAt - nowMs()
+	if ms < 0 {
+		return -2
+	}
+	return int((ms + 500) / 1000)
+}
+
+//randomIndexes returns count random indexes below n for SRANDMEMBER, HRANDFIELD and ZRANDMEMBER:
+//distinct ones when count is positive, at most n of them, and -count ones which may be repeated when it's negative
+func randomIndexes(n, count int) []int {
+	if n == 0 || count == 0 {
+		return nil
+	}
+	if count < 0 {
+		ret := make([]int, -count)
+		for i := range ret {
+			ret[i] = rand.Intn(n)
+		}
+		return ret
+	}
+	//most of the indexes are returned, shuffling them is cheaper than picking them one by one
+	if count*3 > n {
+		all := make([]int, n)
+		for i := range all {
+			all[i] = i
+		}
+		if count > n {
+			count = n
+		}
+		for i := 0; i < count; i++ {
+			j := i + rand.Intn(n-i)
+			all[i], all[j] = all[j], all[i]
+		}
+		return all[:count]
+	}
+	picked := make(map[int]struct{}, count)
+	for len(picked) < count {
+		picked[rand.Intn(n)] = struct{}{}
+	}
+	ret := make([]int, 0, count)
+	for i := range picked {
+		ret = append(ret, i)
+	}
+	return ret
+}
+
 type expired interface {
 	isAlive() bool
 	ttl() int
 	setExpireAt(at int64)
+	//expiration returns the unix time in milliseconds the value expires at, -1 when it doesn't expire
+	expiration() int64
 	dataType() string
+	//encoding returns the representation of the value, see OBJECT ENCODING
+	encoding() string
+	//clone returns a copy of the value with its expiration time, see COPY
+	clone() expired
+}
+
+//OnKeyModified registers a hook which is called every time a key is changed by a write operation,
+//including when the key is deleted or reclaimed by the active expire cycle.
+func OnKeyModified(hook func(key string)) {
+	modifiedKeyHooks = append(modifiedKeyHooks, hook)
+}
+
+func signalModifiedKey(key string) {
+	for _, hook := range modifiedKeyHooks {
+		hook(key)
+	}
+}
+
+//ActiveExpireCycle visits at most `sample` keys of a shard and deletes the ones that are no longer alive,
+//so that expired keys which are never accessed again are still reclaimed. The expired fields of the hashes are deleted too.
+//The shard must be locked. It returns the number of keys deleted.
+func ActiveExpireCycle(shard int, sample int) int {
+	s := shards[shard]
+	visited, deleted := 0, 0
+	for key, e := range s.values {
+		if visited >= sample {
+			break
+		}
+		visited++
+		if v := e.val; !v.isAlive() {
+			s.remove(key)
+			signalModifiedKey(key)
+			deleted++
+		} else if h, ok := v.(*hashVal); ok && h.expireFields() > 0 {
+			if h.len() == 0 {
+				deleted++
+			}
+			hashModified(key, h)
+		}
+	}
+	return deleted
 }
 
 func Ttl(key string) int {
-	v, ok := values[key]
+	v, ok := peek(key)
 	if !ok {
 		//returns -2 if the key does not exist.
 		return -2
@@ -33,11 +142,17 @@ func Ttl(key string) int {
 }
 
 func Expire(key string, ttl int) bool {
-	return ExpireAt(key, time.Now().Unix()+int64(ttl))
+	return PexpireAt(key, nowMs()+int64(ttl)*1000)
 }
 
+//ExpireAt sets the expiration time of a key to a unix time in seconds
 func ExpireAt(key string, timestamp int64) bool {
-	v, ok := values[key]
+	return PexpireAt(key, timestamp*1000)
+}
+
+//PexpireAt sets the expiration time of a key to a unix time in milliseconds
+func PexpireAt(key string, timestamp int64) bool {
+	v, ok := lookup(key)
 	if !ok {
 		return false
 	}
@@ -46,15 +161,18 @@ func ExpireAt(key string, timestamp int64) bool {
 	}
 
 	v.setExpireAt(timestamp)
+	signalModifiedKey(key)
 	return true
 }
 
 func Keys(pattern string) []string {
 	//TODO: check pattern
 	keys := make([]string, 0)
-	for key, v := range values {
-		if v.isAlive() && patternMatch(key, pattern) {
-			keys = append(keys, key)
+	for _, s := range shards {
+		for key, e := range s.values {
+			if e.val.isAlive() && patternMatch(key, pattern) {
+				keys = append(keys, key)
+			}
 		}
 	}
 	return keys
@@ -70,16 +188,17 @@ func patternMatch(key, pattern string) bool {
 }
 
 func Exists(key string) bool {
-	v, ok := values[key]
+	v, ok := peek(key)
 	return ok && v.isAlive()
 }
 
 func Del(key string) bool {
-	v, ok := values[key]
+	v, ok := peek(key)
 
 	if ok {
 		alive := v.isAlive()
-		delete(values, key)
+		deleteValue(key)
+		signalModifiedKey(key)
 
 		if alive {
 			return true
@@ -90,9 +209,84 @@ func Del(key string) bool {
 }
 
 func Type(key string) string {
-	v, ok := values[key]
+	v, ok := peek(key)
 	if !ok || !v.isAlive() {
 		return "none"
 	}
 	return v.dataType()
 }
+
+//Rename moves the value at src to dst with its TTL and replaces the value at dst, unless nx is set and dst exists.
+//It reports whether the value is moved, renaming a key to itself succeeds without nx.
+func Rename(src, dst string, nx bool) (bool, error) {
+	v, ok := lookup(src)
+	if !ok || !v.isAlive() {
+		return false, errorNoSuchKey
+	}
+	if src == dst {
+		return !nx, nil
+	}
+	if nx && Exists(dst) {
+		return false, nil
+	}
+	moveValue(src, dst)
+	signalModifiedKey(src)
+	signalModifiedKey(dst)
+	return true, nil
+}
+
+//Copy stores a copy of the value at src with its TTL at dst. The value at dst is only replaced when replace is set,
+//it reports false when nothing is copied.
+func Copy(src, dst string, replace bool) bool {
+	v, ok := lookup(src)
+	if !ok || !v.isAlive() {
+		return false
+	}
+	if Exists(dst) && !replace {
+		return false
+	}
+	deleteValue(dst)
+	setValue(dst, v.clone())
+	signalModifiedKey(dst)
+	return true
+}
+
+//RandomKey returns a random key, the expired ones picked are deleted on the way.
+//It reports false when there's no key. Every shard must be locked.
+func RandomKey() (string, bool) {
+	for {
+		key, ok := randomKey()
+		if !ok {
+			return "", false
+		}
+		if v, _ := peek(key); v.isAlive() {
+			return key, true
+		}
+		deleteValue(key)
+		signalModifiedKey(key)
+	}
+}
+
+//Touch records an access to the key, it reports whether the key exists
+func Touch(key string) bool {
+	v, ok := lookup(key)
+	return ok && v.isAlive()
+}
+
+//ObjectIdletime returns the seconds since the last access to the key, it reports false when the key doesn't exist
+func ObjectIdletime(key string) (int, bool) {
+	e, ok := entryOf(key)
+	if !ok {
+		return 0, false
+	}
+	return int((nowMs() - e.accessed) / 1000), true
+}
+
+//ObjectFreq returns the logarithmic access counter of the key, it reports false when the key doesn't exist
+func ObjectFreq(key string) (int, bool) {
+	e, ok := entryOf(key)
+	if !ok {
+		return 0, false
+	}
+	return int(e.decayedFreq(nowMs())), true
+}
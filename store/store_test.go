@@ -1,8 +1,12 @@
 package store
 
 import (
+	"github.com/stretchr/testify/assert"
+	"math/rand"
 	"reflect"
+	"strconv"
 	"testing"
+	"time"
 )
 
 func TestTtl(t *testing.T) {
@@ -146,3 +150,244 @@ func TestType(t *testing.T) {
 		})
 	}
 }
+
+func TestOnKeyModified(t *testing.T) {
+	flushAll()
+	var modified []string
+	OnKeyModified(func(key string) {
+		modified = append(modified, key)
+	})
+	defer func() { modifiedKeyHooks = nil }()
+
+	Set("s1", "v")
+	Hset("h1", "f", "v")
+	Hdel("h1", []string{"noexist"})
+	Del("s1")
+	assert.Equal(t, []string{"s1", "h1", "s1"}, modified)
+}
+
+func TestShardsOf(t *testing.T) {
+	keys := []string{"a", "b", "c", "a", "d", "e"}
+	ids := ShardsOf(nil, keys)
+	for i := 1; i < len(ids); i++ {
+		assert.True(t, ids[i-1] < ids[i])
+	}
+	for _, key := range keys {
+		assert.Contains(t, ids, ShardIndex(key))
+	}
+}
+
+func TestActiveExpireCycle(t *testing.T) {
+	flushAll()
+	Set("alive", "v")
+	Set("expired", "v")
+	ExpireAt("expired", time.Now().Unix()-1)
+
+	deleted := 0
+	for i := 0; i < ShardCount; i++ {
+		deleted += ActiveExpireCycle(i, 10)
+	}
+	assert.Equal(t, 1, deleted)
+	assert.Equal(t, 1, dbSize())
+	assert.True(t, Exists("alive"))
+}
+
+//assertKeysIndexed checks that the keys of every shard are the ones of its values, at their position
+func assertKeysIndexed(t *testing.T) {
+	for _, s := range shards {
+		assert.Equal(t, len(s.values), len(s.keys))
+		for i, key := range s.keys {
+			assert.Equal(t, i, s.values[key].pos, key)
+		}
+	}
+}
+
+func TestRename(t *testing.T) {
+	flushAll()
+	Set("a", "1")
+	Expire("a", 100)
+	Set("b", "2")
+	Set("c", "3")
+
+	ok, err := Rename("a", "x", false)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.False(t, Exists("a"))
+	v, _ := Get("x")
+	assert.Equal(t, "1", *v)
+	assert.Equal(t, 100, Ttl("x"))
+
+	ok, _ = Rename("x", "b", true)
+	assert.False(t, ok)
+	ok, _ = Rename("x", "b", false)
+	assert.True(t, ok)
+	v, _ = Get("b")
+	assert.Equal(t, "1", *v)
+	ok, _ = Rename("b", "b", false)
+	assert.True(t, ok)
+	ok, _ = Rename("b", "b", true)
+	assert.False(t, ok)
+
+	_, err = Rename("none", "b", false)
+	assert.Equal(t, errorNoSuchKey, err)
+	assert.Equal(t, 2, dbSize())
+	assertKeysIndexed(t)
+}
+
+func TestCopy(t *testing.T) {
+	flushAll()
+	Set("str", "v")
+	Rpush("list", []string{"a", "b"})
+	Sadd("set", []string{"a", "b"})
+	Hset("hash", "f", "v")
+	Hexpire("hash", []string{"f"}, nowMs()+100000, 0)
+	Zadd("zset", []float64{1, 2}, []string{"a", "b"}, 0)
+	big := make([]string, 200)
+	scores := make([]float64, 200)
+	for i := range big {
+		big[i], scores[i] = strconv.Itoa(i), float64(i)
+	}
+	Zadd("bigzset", scores, big, 0)
+	Expire("list", 100)
+
+	for _, key := range []string{"str", "list", "set", "hash", "zset", "bigzset"} {
+		assert.True(t, Copy(key, key+"2", false), key)
+		src, _ := peek(key)
+		dst, _ := peek(key + "2")
+		assert.True(t, src != dst, key)
+		assert.Equal(t, src.encoding(), dst.encoding(), key)
+		if z, ok := src.(*zsetVal); ok {
+			//the levels of the skip lists are random
+			assert.Equal(t, z.msMap, dst.(*zsetVal).msMap, key)
+			assert.Equal(t, z.zsl.rangeByRank(0, -1, false), dst.(*zsetVal).zsl.rangeByRank(0, -1, false), key)
+			continue
+		}
+		assert.Equal(t, src, dst, key)
+	}
+	assert.Equal(t, 100, Ttl("list2"))
+	enc, _ := ObjectEncoding("bigzset2")
+	assert.Equal(t, encodingSkiplist, enc)
+	z, _ := peek("bigzset2")
+	assertConsistent(t, z.(*zsetVal))
+
+	//the copies are independent
+	Rpush("list2", []string{"c"})
+	Sadd("set2", []string{"c"})
+	Hset("hash2", "f", "w")
+	Zadd("zset2", []float64{3}, []string{"c"}, 0)
+	list, _ := Lrange("list", 0, -1)
+	assert.Equal(t, []string{"a", "b"}, list)
+	n, _ := Scard("set")
+	assert.Equal(t, 2, n)
+	v, _, _ := Hget("hash", "f")
+	assert.Equal(t, "v", v)
+	times, _ := HfieldExpireAt("hash2", []string{"f"})
+	assert.Equal(t, []int64{-1}, times)
+	times, _ = HfieldExpireAt("hash", []string{"f"})
+	assert.NotEqual(t, int64(-1), times[0])
+	card, _ := Zcard("zset")
+	assert.Equal(t, 2, card)
+
+	assert.False(t, Copy("none", "dst", false))
+	assert.False(t, Copy("str", "list", false))
+	assert.True(t, Copy("str", "list", true))
+	assert.Equal(t, "string", Type("list"))
+	assertKeysIndexed(t)
+}
+
+func TestRandomKey(t *testing.T) {
+	flushAll()
+	_, ok := RandomKey()
+	assert.False(t, ok)
+
+	keys := []string{"a", "b", "c", "d"}
+	for _, key := range keys {
+		Set(key, "v")
+	}
+	Set("expired", "v")
+	PexpireAt("expired", 1)
+	seen := make(map[string]int)
+	for i := 0; i < 4000; i++ {
+		key, ok := RandomKey()
+		assert.True(t, ok)
+		seen[key]++
+	}
+	assert.Len(t, seen, 4)
+	for _, key := range keys {
+		assert.InDelta(t, 1000, seen[key], 200, key)
+	}
+	//the expired key is deleted once it's picked
+	assert.Equal(t, 4, dbSize())
+	assertKeysIndexed(t)
+}
+
+func TestObjectIdletime(t *testing.T) {
+	flushAll()
+	Set("a", "v")
+	shards[ShardIndex("a")].values["a"].accessed -= 5000
+	idle, ok := ObjectIdletime("a")
+	assert.True(t, ok)
+	assert.Equal(t, 5, idle)
+	//reading the type or the TTL doesn't count as an access
+	Type("a")
+	Ttl("a")
+	idle, _ = ObjectIdletime("a")
+	assert.Equal(t, 5, idle)
+	assert.True(t, Touch("a"))
+	idle, _ = ObjectIdletime("a")
+	assert.Equal(t, 0, idle)
+
+	assert.False(t, Touch("none"))
+	_, ok = ObjectIdletime("none")
+	assert.False(t, ok)
+}
+
+func Test_entry_freq(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	now := nowMs()
+	e := &entry{accessed: now, freq: lfuInitVal}
+	for i := 0; i < 1000; i++ {
+		e.touch(now, rng)
+	}
+	//the counter grows like a logarithm of the accesses
+	assert.True(t, e.freq > lfuInitVal+5 && e.freq < 40, "freq %d", e.freq)
+	freq := e.freq
+
+	//it's decremented once every minute without access
+	assert.Equal(t, freq-3, e.decayedFreq(now+3*60*1000+1))
+	assert.Equal(t, uint8(0), e.decayedFreq(now+1000*60*1000))
+
+	e.freq = 255
+	e.touch(now, rng)
+	assert.Equal(t, uint8(255), e.freq)
+
+	flushAll()
+	Set("a", "v")
+	f, ok := ObjectFreq("a")
+	assert.True(t, ok)
+	assert.Equal(t, lfuInitVal, f)
+}
+
+func Test_randomIndexes(t *testing.T) {
+	assert.Nil(t, randomIndexes(0, 5))
+	assert.Nil(t, randomIndexes(5, 0))
+
+	//distinct indexes, whether they are shuffled or picked
+	for _, count := range []int{1, 3, 10, 20} {
+		indexes := randomIndexes(100, count)
+		assert.Equal(t, count, len(indexes))
+		seen := make(map[int]bool)
+		for _, i := range indexes {
+			assert.True(t, i >= 0 && i < 100)
+			assert.False(t, seen[i])
+			seen[i] = true
+		}
+	}
+	assert.Equal(t, 5, len(randomIndexes(5, 10)))
+
+	indexes := randomIndexes(2, -10)
+	assert.Equal(t, 10, len(indexes))
+	for _, i := range indexes {
+		assert.True(t, i == 0 || i == 1)
+	}
+}
@@ -2,8 +2,9 @@ package store
 
 import (
 	"fmt"
+	"github.com/medusar/lucas/util"
+	"math"
 	"strconv"
-	"time"
 )
 
 const (
@@ -20,35 +21,40 @@ type stringVal struct {
 }
 
 func (s *stringVal) isAlive() bool {
-	if s.expireAt == -1 {
-		return true
-	}
-	ttl := s.expireAt - time.Now().Unix()
-	return ttl >= 0
+	return aliveUntil(s.expireAt)
 }
 
 func (s *stringVal) ttl() int {
-	//returns -1 if the key exists but has no associated expire
-	if s.expireAt == -1 {
-		return -1
-	}
-
-	ttl := s.expireAt - time.Now().Unix()
-	if ttl > 0 {
-		return int(ttl)
-	}
-	//returns -2 if the key does not exist.
-	return -2
+	return ttlUntil(s.expireAt)
 }
 
 func (s *stringVal) setExpireAt(at int64) {
 	s.expireAt = at
 }
 
+func (s *stringVal) expiration() int64 {
+	return s.expireAt
+}
+
 func (s *stringVal) dataType() string {
 	return "string"
 }
 
+func (s *stringVal) encoding() string {
+	if _, ok := intOf(s.val); ok {
+		return encodingInt
+	}
+	if len(s.val) <= embstrSizeLimit {
+		return encodingEmbstr
+	}
+	return encodingRaw
+}
+
+func (s *stringVal) clone() expired {
+	c := *s
+	return &c
+}
+
 func (s *stringVal) getRange(start, end int) string {
 	l := len(s.val)
 	//check negative
@@ -179,7 +185,7 @@ func hasBit(n int, pos uint) bool {
 }
 
 func stringOf(key string) (*stringVal, error) {
-	v, ok := values[key]
+	v, ok := lookup(key)
 	if !ok || !v.isAlive() {
 		return nil, nil
 	}
@@ -203,7 +209,8 @@ func Get(key string) (*string, error) {
 }
 
 func Set(key, val string) {
-	values[key] = &stringVal{val: val, expireAt: -1}
+	setValue(key, &stringVal{val: util.Clone(val), expireAt: -1})
+	signalModifiedKey(key)
 }
 
 func GetSet(key, val string) (*string, error) {
@@ -215,7 +222,8 @@ func GetSet(key, val string) (*string, error) {
 		return nil, nil
 	}
 	old := str.val
-	str.val = val
+	str.val = util.Clone(val)
+	signalModifiedKey(key)
 	return &old, nil
 }
 
@@ -223,17 +231,85 @@ func SetEX(key, val string, ttl int) error {
 	if ttl <= 0 {
 		return fmt.Errorf("ERR invalid expire time in setex")
 	}
-	values[key] = &stringVal{val: val, expireAt: time.Now().Unix() + int64(ttl)}
+	setValue(key, &stringVal{val: util.Clone(val), expireAt: nowMs() + int64(ttl)*1000})
+	signalModifiedKey(key)
 	return nil
 }
 
 func SetNX(key, val string) bool {
-	if _, ok := values[key]; ok {
+	if Exists(key) {
 		return false
-	} else {
-		values[key] = &stringVal{val: val, expireAt: -1}
-		return true
 	}
+	Set(key, val)
+	return true
+}
+
+// SetOptions are the options of SET.
+type SetOptions struct {
+	//NX only sets a key which doesn't exist, XX only one which exists
+	NX, XX bool
+	//ExpireAt is the unix time in milliseconds the value expires at, 0 when it doesn't expire
+	ExpireAt int64
+	//KeepTTL keeps the expiration time of the previous value
+	KeepTTL bool
+	//Get returns the previous value, which must be a string
+	Get bool
+}
+
+// SetWith sets the string value under the conditions of opts.
+// It returns whether the value was set and, with opts.Get, the previous value.
+func SetWith(key, val string, opts *SetOptions) (*string, bool, error) {
+	var old *string
+	if opts.Get {
+		var err error
+		if old, err = Get(key); err != nil {
+			return nil, false, err
+		}
+	}
+	exists := Exists(key)
+	if (opts.NX && exists) || (opts.XX && !exists) {
+		return old, false, nil
+	}
+	expireAt := int64(-1)
+	if opts.ExpireAt != 0 {
+		expireAt = opts.ExpireAt
+	} else if v, ok := lookup(key); ok && opts.KeepTTL && exists {
+		expireAt = v.expiration()
+	}
+	setValue(key, &stringVal{val: util.Clone(val), expireAt: expireAt})
+	signalModifiedKey(key)
+	return old, true, nil
+}
+
+// GetEx returns the string value and changes its expiration time to expireAt, a unix time in milliseconds.
+// An expireAt of 0 keeps the expiration time and -1 removes it, a time in the past deletes the key.
+func GetEx(key string, expireAt int64) (*string, error) {
+	str, err := stringOf(key)
+	if err != nil || str == nil {
+		return nil, err
+	}
+	val := str.val
+	switch {
+	case expireAt == 0 || (expireAt == -1 && str.expireAt == -1):
+		return &val, nil
+	case expireAt != -1 && expireAt < nowMs():
+		deleteValue(key)
+	default:
+		str.expireAt = expireAt
+	}
+	signalModifiedKey(key)
+	return &val, nil
+}
+
+// GetDel returns the string value and deletes the key.
+func GetDel(key string) (*string, error) {
+	str, err := stringOf(key)
+	if err != nil || str == nil {
+		return nil, err
+	}
+	deleteValue(key)
+	signalModifiedKey(key)
+	return &str.val, nil
 }
 
 func StrLen(key string) (int, error) {
@@ -270,9 +346,37 @@ func IncrBy(key string, intV int) (int, error) {
 
 	i = i + intV
 	str.val = strconv.Itoa(i)
+	signalModifiedKey(key)
 	return i, nil
 }
 
+// IncrByFloat increments the number stored at key by incr and returns the result the way it's stored.
+// The expiration time of the key is kept.
+func IncrByFloat(key string, incr float64) (string, error) {
+	str, err := stringOf(key)
+	if err != nil {
+		return "", err
+	}
+	f := 0.0
+	if str != nil {
+		if f, err = strconv.ParseFloat(str.val, 64); err != nil || math.IsNaN(f) {
+			return "", errorInvalidFloat
+		}
+	}
+	f += incr
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return "", errorIncrNaN
+	}
+	val := strconv.FormatFloat(f, 'f', -1, 64)
+	if str == nil {
+		Set(key, val)
+	} else {
+		str.val = val
+		signalModifiedKey(key)
+	}
+	return val, nil
+}
+
 //If key already exists and is a string, this command appends the value at the end of the string.
 //If key does not exist it is created and set as an empty string,
 //so APPEND will be similar to SET in this special case.
@@ -286,11 +390,15 @@ func Append(key, val string) (int, error) {
 		return len(val), nil
 	}
 	str.val = str.val + val
+	signalModifiedKey(key)
 	return len(str.val), nil
 }
 
 //offset means byte index, not rune index
 func SetRange(key, val string, offset int) (int, error) {
+	if offset < 0 {
+		return -1, fmt.Errorf("ERR offset is out of range")
+	}
 	str, err := stringOf(key)
 	if err != nil {
 		return -1, err
@@ -325,6 +433,7 @@ func SetRange(key, val string, offset int) (int, error) {
 		rs[offset+i] = val[i]
 	}
 	str.val = string(rs)
+	signalModifiedKey(key)
 	return len(rs), nil
 }
 
@@ -371,6 +480,17 @@ func Mset(kvs []string) {
 	}
 }
 
+// Msetnx sets the keys to their values only if none of them exists, it reports whether they were set.
+func Msetnx(kvs []string) bool {
+	for i := 0; i < len(kvs); i += 2 {
+		if Exists(kvs[i]) {
+			return false
+		}
+	}
+	Mset(kvs)
+	return true
+}
+
 // SetBit implements redis setbit commands.
 // https://redis.io/commands/setbit
 // Sets or clears the bit at offset in the string value stored at key.
@@ -394,9 +514,11 @@ func SetBit(key string, offset, bit int) (int, error) {
 	}
 	if str == nil {
 		str = &stringVal{val: "", expireAt: -1}
-		values[key] = str
+		setValue(key, str)
 	}
-	return str.setBit(offset, bit), nil
+	old := str.setBit(offset, bit)
+	signalModifiedKey(key)
+	return old, nil
 }
 
 // GetBit returns the bit value at offset in the string value stored at key.
@@ -427,3 +549,83 @@ func BitCount(key string, start, end int) (int, error) {
 	}
 	return str.countBit(start, end), nil
 }
+
+// LcsMatch is a range of the longest common subsequence found in both strings, the indexes are inclusive.
+type LcsMatch struct {
+	A, B [2]int
+	Len  int
+}
+
+// Lcs returns the longest common subsequence of the strings at the keys, a missing key is an empty string.
+// With idx it also returns the ranges matching in both strings from the last one,
+// skipping the ones shorter than minMatchLen.
+func Lcs(key1, key2 string, idx bool, minMatchLen int) (string, []LcsMatch, error) {
+	var strs [2]string
+	for i, key := range []string{key1, key2} {
+		str, err := stringOf(key)
+		if err != nil {
+			return "", nil, errorLcsType
+		}
+		if str != nil {
+			strs[i] = str.val
+		}
+	}
+	a, b := strs[0], strs[1]
+	//lcs[i*(len(b)+1)+j] is the length of the longest common subsequence of a[:i] and b[:j]
+	width := len(b) + 1
+	lcs := make([]uint32, (len(a)+1)*width)
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			if a[i-1] == b[j-1] {
+				lcs[i*width+j] = lcs[(i-1)*width+j-1] + 1
+			} else if up, left := lcs[(i-1)*width+j], lcs[i*width+j-1]; up > left {
+				lcs[i*width+j] = up
+			} else {
+				lcs[i*width+j] = left
+			}
+		}
+	}
+
+	//the subsequence is built backward, the ranges are found on the way like redis does
+	n := int(lcs[len(lcs)-1])
+	result := make([]byte, n)
+	var matches []LcsMatch
+	var match LcsMatch
+	inRange := false
+	for i, j := len(a), len(b); i > 0 && j > 0; {
+		emit := false
+		if a[i-1] == b[j-1] {
+			n--
+			result[n] = a[i-1]
+			if !inRange {
+				match = LcsMatch{A: [2]int{i - 1, i - 1}, B: [2]int{j - 1, j - 1}}
+				inRange = true
+			} else if match.A[0] == i && match.B[0] == j {
+				match.A[0]--
+				match.B[0]--
+			} else {
+				emit = true
+			}
+			if match.A[0] == 0 || match.B[0] == 0 {
+				emit = true
+			}
+			i--
+			j--
+		} else {
+			if lcs[(i-1)*width+j] > lcs[i*width+j-1] {
+				i--
+			} else {
+				j--
+			}
+			emit = inRange
+		}
+		if emit {
+			match.Len = match.A[1] - match.A[0] + 1
+			if idx && match.Len >= minMatchLen {
+				matches = append(matches, match)
+			}
+			inRange = false
+		}
+	}
+	return string(result), matches, nil
+}
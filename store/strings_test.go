@@ -3,12 +3,13 @@ package store
 import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"math"
 	"reflect"
 	"testing"
 )
 
 func TestGet(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Hset("hash", "f1", "1")
 
 	s1 := "hello world"
@@ -50,7 +51,7 @@ func TestGet(t *testing.T) {
 }
 
 func TestSet(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Hset("hash", "f1", "1")
 	s1 := "hello"
 	Set("s1", s1)
@@ -74,7 +75,7 @@ func BenchmarkSet(b *testing.B) {
 }
 
 func TestGetSet(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Hset("hash", "f1", "1")
 
 	s1 := "hello"
@@ -118,7 +119,7 @@ func TestGetSet(t *testing.T) {
 }
 
 func TestSetEX(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Hset("hash", "f1", "1")
 	Set("s1", "hello")
 
@@ -155,7 +156,7 @@ func TestSetEX(t *testing.T) {
 }
 
 func TestSetNX(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Hset("hash", "f1", "1")
 	Set("s1", "hello")
 
@@ -181,8 +182,101 @@ func TestSetNX(t *testing.T) {
 	}
 }
 
+func TestSetWith(t *testing.T) {
+	flushAll()
+	_, set, err := SetWith("lock", "a", &SetOptions{NX: true, ExpireAt: nowMs() + 30000})
+	assert.Nil(t, err)
+	assert.True(t, set)
+	assert.Equal(t, 30, Ttl("lock"))
+	old, set, _ := SetWith("lock", "b", &SetOptions{NX: true, Get: true})
+	assert.False(t, set)
+	assert.Equal(t, "a", *old)
+
+	_, set, _ = SetWith("lock", "c", &SetOptions{XX: true, KeepTTL: true})
+	assert.True(t, set)
+	assert.Equal(t, 30, Ttl("lock"))
+	SetWith("lock", "d", &SetOptions{})
+	assert.Equal(t, -1, Ttl("lock"))
+
+	_, set, _ = SetWith("nokey", "v", &SetOptions{XX: true})
+	assert.False(t, set)
+	assert.False(t, Exists("nokey"))
+
+	SetWith("past", "v", &SetOptions{ExpireAt: nowMs() - 1})
+	assert.False(t, Exists("past"))
+	_, set, _ = SetWith("past", "v", &SetOptions{NX: true})
+	assert.True(t, set)
+
+	Hset("hash", "f1", "1")
+	_, _, err = SetWith("hash", "v", &SetOptions{Get: true})
+	assert.Equal(t, errorWrongType, err)
+}
+
+func TestGetEx(t *testing.T) {
+	flushAll()
+	Set("s", "v")
+	v, err := GetEx("s", nowMs()+10000)
+	assert.Nil(t, err)
+	assert.Equal(t, "v", *v)
+	assert.Equal(t, 10, Ttl("s"))
+	GetEx("s", 0)
+	assert.Equal(t, 10, Ttl("s"))
+	GetEx("s", -1)
+	assert.Equal(t, -1, Ttl("s"))
+	v, _ = GetEx("s", 1)
+	assert.Equal(t, "v", *v)
+	assert.False(t, Exists("s"))
+
+	Set("s", "v")
+	v, _ = GetDel("s")
+	assert.Equal(t, "v", *v)
+	v, _ = GetDel("s")
+	assert.Nil(t, v)
+}
+
+func TestIncrByFloat(t *testing.T) {
+	flushAll()
+	Set("f", "10.50")
+	v, err := IncrByFloat("f", 0.1)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.6", v)
+	v, _ = IncrByFloat("nokey", 5e3)
+	assert.Equal(t, "5000", v)
+	_, err = IncrByFloat("f", math.Inf(1))
+	assert.Equal(t, errorIncrNaN, err)
+	Set("s", "abc")
+	_, err = IncrByFloat("s", 1)
+	assert.Equal(t, errorInvalidFloat, err)
+}
+
+func TestMsetnx(t *testing.T) {
+	flushAll()
+	assert.True(t, Msetnx([]string{"a", "1", "b", "2"}))
+	assert.False(t, Msetnx([]string{"c", "3", "b", "4"}))
+	assert.False(t, Exists("c"))
+	v, _ := Get("b")
+	assert.Equal(t, "2", *v)
+}
+
+func TestLcs(t *testing.T) {
+	flushAll()
+	Set("key1", "ohmytext")
+	Set("key2", "mynewtext")
+	lcs, matches, err := Lcs("key1", "key2", true, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "mytext", lcs)
+	assert.Equal(t, []LcsMatch{{A: [2]int{4, 7}, B: [2]int{5, 8}, Len: 4}, {A: [2]int{2, 3}, B: [2]int{0, 1}, Len: 2}}, matches)
+	_, matches, _ = Lcs("key1", "key2", true, 3)
+	assert.Len(t, matches, 1)
+	lcs, _, _ = Lcs("key1", "nokey", false, 0)
+	assert.Equal(t, "", lcs)
+	Hset("hash", "f1", "1")
+	_, _, err = Lcs("key1", "hash", false, 0)
+	assert.Equal(t, errorLcsType, err)
+}
+
 func TestStrLen(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Hset("hash", "f1", "1")
 	Set("s1", "hello")
 	Set("s2", "你好")
@@ -216,7 +310,7 @@ func TestStrLen(t *testing.T) {
 }
 
 func TestIncr(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Hset("hash", "f1", "1")
 	Set("s1", "hello")
 
@@ -250,7 +344,7 @@ func TestIncr(t *testing.T) {
 }
 
 func TestIncrBy(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Hset("hash", "f1", "1")
 	Set("s1", "hello")
 
@@ -289,7 +383,7 @@ func TestIncrBy(t *testing.T) {
 }
 
 func TestAppend(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Hset("hash", "f1", "1")
 
 	Set("s1", "01234")
@@ -324,7 +418,7 @@ func TestAppend(t *testing.T) {
 }
 
 func TestSetRange(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Hset("hash", "f1", "1")
 
 	str := "0123456789"
@@ -347,6 +441,10 @@ func TestSetRange(t *testing.T) {
 	assert.NotNil(t, e)
 	assert.Equal(t, -1, n)
 
+	n, e = SetRange("range1", strNew, -1)
+	assert.EqualError(t, e, "ERR offset is out of range")
+	assert.Equal(t, -1, n)
+
 	type args struct {
 		key    string
 		val    string
@@ -378,7 +476,7 @@ func TestSetRange(t *testing.T) {
 }
 
 func TestGetRange(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Hset("hash", "f1", "1")
 	str := "0123456789"
 	Set("range", str)
@@ -435,7 +533,7 @@ func TestGetRange(t *testing.T) {
 }
 
 func TestMget(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Hset("hash", "f1", "1")
 	s1 := "hello"
 	Set("s1", s1)
@@ -466,7 +564,7 @@ func TestMget(t *testing.T) {
 }
 
 func TestMset(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Hset("hash", "f1", "1")
 	Mset([]string{"s1", "s1", "s2", "s2", "s3", "s3", "hash", "hash"})
 	for i := 1; i < 4; i++ {
@@ -482,7 +580,7 @@ func TestMset(t *testing.T) {
 }
 
 func TestSetBit(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Hset("hash", "f1", "1")
 
 	i, e := SetBit("s1", 0, 1)
@@ -525,7 +623,7 @@ func TestSetBit(t *testing.T) {
 }
 
 func TestGetBit(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Hset("hash", "f1", "1")
 
 	SetBit("s1", 10, 1)
@@ -567,7 +665,7 @@ func TestGetBit(t *testing.T) {
 }
 
 func TestBitCount(t *testing.T) {
-	values = make(map[string]expired)
+	flushAll()
 	Hset("hash", "f1", "1")
 
 	for i := 0; i < 1000; i++ {
@@ -2,170 +2,398 @@ package store
 
 import (
 	"fmt"
+	"github.com/medusar/lucas/util"
+	"math"
+	"math/rand"
 	"sort"
-	"time"
+	"strings"
 )
 
-type zsetMember struct {
+//ZsetMember is a member of a sorted set with its score
+type ZsetMember struct {
 	Member string
 	Score  float64
 }
 
-type scoreMember struct {
-	next    *scoreMember
-	pre     *scoreMember
-	score   float64
-	members []string //members are ordered lexicographically
+const (
+	//skipListMaxLevel is enough for 2^64 members with skipListP
+	skipListMaxLevel = 32
+	//skipListP is the probability of a node to have one more level
+	skipListP = 0.25
+)
+
+type skipListLevel struct {
+	forward *skipListNode
+	span    int //number of nodes from this node to forward, forward included
+}
+
+type skipListNode struct {
+	member   string
+	score    float64
+	backward *skipListNode
+	level    []skipListLevel
+}
+
+//less reports whether the node is ordered before the member with the score
+func (n *skipListNode) less(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
 }
 
-// sorted by score from low to high
-// members with same score are order lexicographically
-type scoreMemberMap struct {
-	head *scoreMember
-	tail *scoreMember
-	size int
+// skipList is sorted by score from low to high,
+// members with same score are ordered lexicographically.
+// Every level keeps the number of nodes it spans, so the rank of a member
+// and the member at a rank are both found in O(log n).
+type skipList struct {
+	head   *skipListNode //head is a sentinel without member
+	tail   *skipListNode
+	length int
+	level  int
 }
 
-func (sm *scoreMemberMap) String() string {
-	if sm.head == nil {
+func newSkipList() *skipList {
+	return &skipList{head: &skipListNode{level: make([]skipListLevel, skipListMaxLevel)}, level: 1}
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skipListMaxLevel && rand.Float64() < skipListP {
+		level++
+	}
+	return level
+}
+
+func (sl *skipList) String() string {
+	if sl.length == 0 {
 		return "nil"
 	}
+	sb := ""
+	for x := sl.head.level[0].forward; x != nil; x = x.level[0].forward {
+		if x != sl.head.level[0].forward {
+			sb += "<->"
+		}
+		sb += fmt.Sprintf("%s:%v", x.member, x.score)
+	}
+	return sb
+}
 
-	logElement := func(m *scoreMember) string {
-		if m == nil {
-			return "nil"
+//insert adds a member which is not in the list yet
+func (sl *skipList) insert(score float64, member string) {
+	var update [skipListMaxLevel]*skipListNode
+	var rank [skipListMaxLevel]int
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		if i < sl.level-1 {
+			rank[i] = rank[i+1]
 		}
-		return fmt.Sprintf("%v", m.members)
+		for x.level[i].forward != nil && x.level[i].forward.less(score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
 	}
 
-	sb := logElement(sm.head)
-	for cur := sm.head.next; cur != nil; cur = cur.next {
-		sb = sb + "<->" + logElement(cur)
+	level := randomLevel()
+	if level > sl.level {
+		for i := sl.level; i < level; i++ {
+			update[i] = sl.head
+			update[i].level[i].span = sl.length
+		}
+		sl.level = level
 	}
-	return sb
+	x = &skipListNode{member: member, score: score, level: make([]skipListLevel, level)}
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		//rank[0] - rank[i] is the number of nodes between update[i] and update[0]
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	//the levels above the new node now span one more node
+	for i := level; i < sl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != sl.head {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		sl.tail = x
+	}
+	sl.length++
 }
 
-// remove the member associated with score
-func (sm *scoreMemberMap) remove(score float64, member string) bool {
-	cur := sm.tail
-	for cur != nil {
-		if cur.score == score {
-			break
+//find returns the node of the member and its predecessors on every level
+func (sl *skipList) find(score float64, member string, update *[skipListMaxLevel]*skipListNode) *skipListNode {
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.less(score, member) {
+			x = x.level[i].forward
 		}
-		cur = cur.pre
+		update[i] = x
 	}
-	if cur == nil {
-		return false
+	x = x.level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return nil
 	}
+	return x
+}
 
-	if len(cur.members) == 1 {
-		if cur.next != nil {
-			cur.next.pre = cur.pre
+func (sl *skipList) deleteNode(x *skipListNode, update *[skipListMaxLevel]*skipListNode) {
+	for i := 0; i < sl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
 		} else {
-			sm.tail = cur.pre
+			update[i].level[i].span--
 		}
-		if cur.pre != nil {
-			cur.pre.next = cur.next
-		} else {
-			sm.head = cur.next
-		}
-		cur = nil
-		sm.size -= 1
-		return true
 	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		sl.tail = x.backward
+	}
+	for sl.level > 1 && sl.head.level[sl.level-1].forward == nil {
+		sl.level--
+	}
+	sl.length--
+}
 
-	i := -1
-	for j, v := range cur.members {
-		if v == member {
-			i = j
-			break
-		}
+// remove the member associated with score
+func (sl *skipList) remove(score float64, member string) bool {
+	var update [skipListMaxLevel]*skipListNode
+	x := sl.find(score, member, &update)
+	if x == nil {
+		return false
 	}
-	cur.members = append(cur.members[0:i], cur.members[i+1:]...)
-	sm.size -= 1
+	sl.deleteNode(x, &update)
 	return true
 }
 
-func (sm *scoreMemberMap) put(score float64, member string) {
-	if sm.head == nil {
-		sm.head = &scoreMember{score: score, members: []string{member}}
-		sm.tail = sm.head
-		sm.size += 1
+//updateScore changes the score of a member, the node is kept in place if its position doesn't change
+func (sl *skipList) updateScore(score float64, member string, newScore float64) {
+	var update [skipListMaxLevel]*skipListNode
+	x := sl.find(score, member, &update)
+	if x == nil {
 		return
 	}
-	if sm.tail.score < score {
-		element := &scoreMember{score: score, members: []string{member}}
-		element.pre = sm.tail
-		sm.tail.next = element
-		sm.tail = element
-		sm.size += 1
+	if (x.backward == nil || x.backward.less(newScore, x.member)) &&
+		(x.level[0].forward == nil || !x.level[0].forward.less(newScore, x.member)) {
+		x.score = newScore
 		return
 	}
-	cur := sm.tail
-	for cur != nil {
-		if cur.score <= score {
-			break
-		}
-		cur = cur.pre
-	}
-	if cur == nil {
-		element := &scoreMember{score: score, members: []string{member}}
-		element.next = sm.head
-		sm.head.pre = element
-		sm.head = element
-		sm.size += 1
-	} else if cur.score < score {
-		element := &scoreMember{score: score, members: []string{member}}
-		cur.next.pre = element
-		element.next = cur.next
-		element.pre = cur
-		cur.next = element
-		sm.size += 1
-	} else { //cur.score == score
-		//check if member exists
-		for _, v := range cur.members {
-			if v == member {
-				return
-			}
+	sl.deleteNode(x, &update)
+	sl.insert(newScore, x.member)
+}
+
+//rank returns the 1-based rank of the member, or 0 if it's not in the list
+func (sl *skipList) rank(score float64, member string) int {
+	rank := 0
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil &&
+			(x.level[i].forward.less(score, member) || x.level[i].forward.score == score && x.level[i].forward.member == member) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x != sl.head && x.score == score && x.member == member {
+			return rank
 		}
-		sm.size += 1
-		cur.members = append(cur.members, member)
-		sort.Strings(cur.members)
 	}
+	return 0
 }
 
-func (sm *scoreMemberMap) get(score float64) []string {
-	for cur := sm.head; cur != nil; cur = cur.next {
-		if cur.score == score {
-			return cur.members
+//byRank returns the node at the 1-based rank
+func (sl *skipList) byRank(rank int) *skipListNode {
+	traversed := 0
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank {
+			return x
 		}
 	}
 	return nil
 }
 
-// count returns the number of elements in the sorted set at key with a score between min and max.
-func (sm *scoreMemberMap) count(min, max float64) int {
-	n := 0
-	for cur := sm.head; cur != nil && cur.score <= max; cur = cur.next {
-		if cur.score >= min {
-			n += len(cur.members)
+func (sl *skipList) at(rank int) ZsetMember {
+	x := sl.byRank(rank)
+	return ZsetMember{Member: x.member, Score: x.score}
+}
+
+func (sl *skipList) len() int {
+	return sl.length
+}
+
+//rangeSpec selects the members between two bounds, by score or by member
+type rangeSpec interface {
+	gteMin(score float64, member string) bool
+	lteMax(score float64, member string) bool
+	//isEmpty reports whether no node can be in the range, such as when min is greater than max
+	isEmpty() bool
+}
+
+//ScoreRange is an interval of scores, the bounds are included unless they are exclusive like (1.5 in ZRANGEBYSCORE
+type ScoreRange struct {
+	Min, Max     float64
+	MinEx, MaxEx bool
+}
+
+func (r *ScoreRange) gteMin(score float64, _ string) bool {
+	if r.MinEx {
+		return score > r.Min
+	}
+	return score >= r.Min
+}
+
+func (r *ScoreRange) lteMax(score float64, _ string) bool {
+	if r.MaxEx {
+		return score < r.Max
+	}
+	return score <= r.Max
+}
+
+func (r *ScoreRange) isEmpty() bool {
+	return r.Min > r.Max || (r.Min == r.Max && (r.MinEx || r.MaxEx))
+}
+
+//LexBound is a bound of a LexRange: [member, (member, or - and + which are lower and greater than every member
+type LexBound struct {
+	Member string
+	Ex     bool
+	//Inf is -1 for - and 1 for +
+	Inf int
+}
+
+//compare compares the bound with a member like strings.Compare
+func (b *LexBound) compare(member string) int {
+	if b.Inf != 0 {
+		return b.Inf
+	}
+	return strings.Compare(b.Member, member)
+}
+
+//LexRange is an interval of members, it's meant for sorted sets whose members have the same score like ZRANGEBYLEX
+type LexRange struct {
+	Min, Max LexBound
+}
+
+func (r *LexRange) gteMin(_ float64, member string) bool {
+	c := r.Min.compare(member)
+	if r.Min.Ex {
+		return c < 0
+	}
+	return c <= 0
+}
+
+func (r *LexRange) lteMax(_ float64, member string) bool {
+	c := r.Max.compare(member)
+	if r.Max.Ex {
+		return c > 0
+	}
+	return c >= 0
+}
+
+func (r *LexRange) isEmpty() bool {
+	if r.Min.Inf == 1 || r.Max.Inf == -1 {
+		return true
+	}
+	if r.Min.Inf == -1 || r.Max.Inf == 1 {
+		return false
+	}
+	c := strings.Compare(r.Min.Member, r.Max.Member)
+	return c > 0 || (c == 0 && (r.Min.Ex || r.Max.Ex))
+}
+
+func (n *skipListNode) gteMin(r rangeSpec) bool {
+	return r.gteMin(n.score, n.member)
+}
+
+func (n *skipListNode) lteMax(r rangeSpec) bool {
+	return r.lteMax(n.score, n.member)
+}
+
+func (sl *skipList) inRange(r rangeSpec) bool {
+	if r.isEmpty() || sl.tail == nil {
+		return false
+	}
+	return sl.tail.gteMin(r) && sl.head.level[0].forward.lteMax(r)
+}
+
+//firstInRange returns the first node in the range
+func (sl *skipList) firstInRange(r rangeSpec) *skipListNode {
+	if !sl.inRange(r) {
+		return nil
+	}
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !x.level[i].forward.gteMin(r) {
+			x = x.level[i].forward
 		}
 	}
-	return n
+	x = x.level[0].forward
+	if x == nil || !x.lteMax(r) {
+		return nil
+	}
+	return x
 }
 
-func (sm *scoreMemberMap) rangeByScore(min, max float64) []string {
-	var ret []string
-	for cur := sm.head; cur != nil && cur.score <= max; cur = cur.next {
-		if cur.score >= min {
-			ret = append(ret, cur.members...)
+//lastInRange returns the last node in the range
+func (sl *skipList) lastInRange(r rangeSpec) *skipListNode {
+	if !sl.inRange(r) {
+		return nil
+	}
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.lteMax(r) {
+			x = x.level[i].forward
 		}
 	}
+	if x == sl.head || !x.gteMin(r) {
+		return nil
+	}
+	return x
+}
+
+// count returns the number of members in the range, it's O(log n) thanks to the ranks.
+func (sl *skipList) count(r rangeSpec) int {
+	first := sl.firstInRange(r)
+	if first == nil {
+		return 0
+	}
+	last := sl.lastInRange(r)
+	return sl.rank(last.score, last.member) - sl.rank(first.score, first.member) + 1
+}
+
+//rangeByRank returns the members from start to stop, both included.
+//Negative ranks count from the end, with rev the ranks are counted from the highest score.
+func (sl *skipList) rangeByRank(start, stop int, rev bool) []ZsetMember {
+	size := sl.length
+	start, stop, ok := ranksOf(start, stop, size)
+	if !ok {
+		return nil
+	}
+
+	ret := make([]ZsetMember, 0, stop-start+1)
+	if rev {
+		for x := sl.byRank(size - start); len(ret) < cap(ret); x = x.backward {
+			ret = append(ret, ZsetMember{Member: x.member, Score: x.score})
+		}
+		return ret
+	}
+	for x := sl.byRank(start + 1); len(ret) < cap(ret); x = x.level[0].forward {
+		ret = append(ret, ZsetMember{Member: x.member, Score: x.score})
+	}
 	return ret
 }
 
-func (sm *scoreMemberMap) doRange(start, stop int, apply func(score float64, member string)) {
-	size := sm.size
+//ranksOf turns the 0-based ranks start and stop, which count from the end when negative,
+//into ranks within the size. It reports false when no rank is between them.
+func ranksOf(start, stop, size int) (int, int, bool) {
 	if start < 0 {
 		start = size + start
 	}
@@ -176,182 +404,521 @@ func (sm *scoreMemberMap) doRange(start, stop int, apply func(score float64, mem
 		start = 0
 	}
 	if start > stop || start >= size {
-		return
+		return 0, 0, false
 	}
 	if stop >= size {
 		stop = size - 1
 	}
-	i := 0
-	for cur := sm.head; cur != nil; cur = cur.next {
-		for _, m := range cur.members {
-			if i < start {
-				i++
-				continue
+	return start, stop, true
+}
+
+//rangeOf returns the members in the range, from the lowest or with rev from the highest.
+//It skips offset members first and returns at most count members, all of them if count is negative.
+func (sl *skipList) rangeOf(r rangeSpec, rev bool, offset, count int) []ZsetMember {
+	if offset < 0 {
+		return nil
+	}
+	var x *skipListNode
+	if rev {
+		x = sl.lastInRange(r)
+	} else {
+		x = sl.firstInRange(r)
+	}
+	if x == nil {
+		return nil
+	}
+	if offset > 0 {
+		//jump to the offset by rank instead of walking through the skipped members
+		rank := sl.rank(x.score, x.member)
+		if rev {
+			rank -= offset
+		} else {
+			rank += offset
+		}
+		if rank < 1 || rank > sl.length {
+			return nil
+		}
+		x = sl.byRank(rank)
+	}
+
+	var ret []ZsetMember
+	for ; x != nil && count != 0; count-- {
+		if rev {
+			if !x.gteMin(r) {
+				break
 			}
-			if i > stop {
+			ret = append(ret, ZsetMember{Member: x.member, Score: x.score})
+			x = x.backward
+		} else {
+			if !x.lteMax(r) {
 				break
 			}
-			apply(cur.score, m)
-			i++
+			ret = append(ret, ZsetMember{Member: x.member, Score: x.score})
+			x = x.level[0].forward
+		}
+	}
+	return ret
+}
+
+//deleteRange removes the nodes in the range, removed is called with the member of each of them
+func (sl *skipList) deleteRange(r rangeSpec, removed func(member string)) int {
+	if !sl.inRange(r) {
+		return 0
+	}
+	var update [skipListMaxLevel]*skipListNode
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !x.level[i].forward.gteMin(r) {
+			x = x.level[i].forward
 		}
-		if i > stop {
-			break
+		update[i] = x
+	}
+	n := 0
+	for x = x.level[0].forward; x != nil && x.lteMax(r); n++ {
+		next := x.level[0].forward
+		sl.deleteNode(x, &update)
+		removed(x.member)
+		x = next
+	}
+	return n
+}
+
+//deleteRangeByRank removes the nodes from the 1-based rank start to stop, both included
+func (sl *skipList) deleteRangeByRank(start, stop int, removed func(member string)) int {
+	var update [skipListMaxLevel]*skipListNode
+	traversed := 0
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span < start {
+			traversed += x.level[i].span
+			x = x.level[i].forward
 		}
+		update[i] = x
+	}
+	n := 0
+	for x, traversed = x.level[0].forward, traversed+1; x != nil && traversed <= stop; traversed++ {
+		next := x.level[0].forward
+		sl.deleteNode(x, &update)
+		removed(x.member)
+		x = next
+		n++
 	}
+	return n
 }
 
-func (sm *scoreMemberMap) rangeByIndex(start, stop int) []string {
-	var ret []string
-	sm.doRange(start, stop, func(_ float64, member string) {
-		ret = append(ret, member)
+//zsetIndex orders the members of a sorted set by score, then by member.
+//Ranks are 1-based except for rangeByRank which takes the ones of ZRANGE.
+type zsetIndex interface {
+	insert(score float64, member string)
+	remove(score float64, member string) bool
+	updateScore(score float64, member string, newScore float64)
+	rank(score float64, member string) int
+	at(rank int) ZsetMember
+	len() int
+	count(r rangeSpec) int
+	rangeByRank(start, stop int, rev bool) []ZsetMember
+	rangeOf(r rangeSpec, rev bool, offset, count int) []ZsetMember
+	deleteRange(r rangeSpec, removed func(member string)) int
+	deleteRangeByRank(start, stop int, removed func(member string)) int
+}
+
+//zsetPack is the index of a small sorted set, its members are packed in an array in order
+type zsetPack struct {
+	members []ZsetMember
+}
+
+//search returns the position of the member, or the one it's inserted at
+func (p *zsetPack) search(score float64, member string) int {
+	return sort.Search(len(p.members), func(i int) bool {
+		x := p.members[i]
+		return x.Score > score || (x.Score == score && x.Member >= member)
 	})
-	return ret
 }
 
-func (sm *scoreMemberMap) rangeByIndexWithScore(start, stop int) []*zsetMember {
-	var ret []*zsetMember
-	sm.doRange(start, stop, func(score float64, member string) {
-		ret = append(ret, &zsetMember{Member: member, Score: score})
+//find returns the score of the member, it scans the array
+func (p *zsetPack) find(member string) (float64, bool) {
+	for _, x := range p.members {
+		if x.Member == member {
+			return x.Score, true
+		}
+	}
+	return 0, false
+}
+
+func (p *zsetPack) insert(score float64, member string) {
+	i := p.search(score, member)
+	p.members = append(p.members, ZsetMember{})
+	copy(p.members[i+1:], p.members[i:])
+	p.members[i] = ZsetMember{Member: member, Score: score}
+}
+
+func (p *zsetPack) remove(score float64, member string) bool {
+	i := p.rank(score, member) - 1
+	if i < 0 {
+		return false
+	}
+	p.delete(i, i+1)
+	return true
+}
+
+//delete removes the members from i to j, j excluded
+func (p *zsetPack) delete(i, j int) {
+	n := copy(p.members[i:], p.members[j:])
+	for k := i + n; k < len(p.members); k++ {
+		p.members[k] = ZsetMember{}
+	}
+	p.members = p.members[:i+n]
+}
+
+func (p *zsetPack) updateScore(score float64, member string, newScore float64) {
+	i := p.rank(score, member) - 1
+	//the member of the array is kept, member may be a view of the request
+	member = p.members[i].Member
+	p.delete(i, i+1)
+	p.insert(newScore, member)
+}
+
+func (p *zsetPack) rank(score float64, member string) int {
+	i := p.search(score, member)
+	if i < len(p.members) && p.members[i].Score == score && p.members[i].Member == member {
+		return i + 1
+	}
+	return 0
+}
+
+func (p *zsetPack) at(rank int) ZsetMember {
+	return p.members[rank-1]
+}
+
+func (p *zsetPack) len() int {
+	return len(p.members)
+}
+
+//bounds returns the positions of the first member in the range and of the one after the last,
+//they are equal when the range is empty
+func (p *zsetPack) bounds(r rangeSpec) (int, int) {
+	if r.isEmpty() {
+		return 0, 0
+	}
+	first := sort.Search(len(p.members), func(i int) bool {
+		return r.gteMin(p.members[i].Score, p.members[i].Member)
 	})
-	return ret
+	end := sort.Search(len(p.members), func(i int) bool {
+		return !r.lteMax(p.members[i].Score, p.members[i].Member)
+	})
+	if end < first {
+		return first, first
+	}
+	return first, end
 }
 
-func (sm *scoreMemberMap) rangeByScoreWithScore(min, max float64) []*zsetMember {
-	var ret []*zsetMember
-	for cur := sm.head; cur != nil && cur.score <= max; cur = cur.next {
-		if cur.score >= min {
-			for _, m := range cur.members {
-				ret = append(ret, &zsetMember{Member: m, Score: cur.score})
-			}
+func (p *zsetPack) count(r rangeSpec) int {
+	first, end := p.bounds(r)
+	return end - first
+}
+
+func (p *zsetPack) rangeByRank(start, stop int, rev bool) []ZsetMember {
+	size := len(p.members)
+	start, stop, ok := ranksOf(start, stop, size)
+	if !ok {
+		return nil
+	}
+	ret := make([]ZsetMember, stop-start+1)
+	if rev {
+		for i := range ret {
+			ret[i] = p.members[size-1-start-i]
 		}
+		return ret
 	}
+	copy(ret, p.members[start:stop+1])
 	return ret
 }
 
-func (sm *scoreMemberMap) rank(member string) int {
-	i := 0
-	for cur := sm.head; cur != nil; cur = cur.next {
-		for _, m := range cur.members {
-			if m == member {
-				return i
-			}
-			i++
+func (p *zsetPack) rangeOf(r rangeSpec, rev bool, offset, count int) []ZsetMember {
+	first, end := p.bounds(r)
+	if offset < 0 || first+offset >= end {
+		return nil
+	}
+	n := end - first - offset
+	if count >= 0 && count < n {
+		n = count
+	}
+	if n == 0 {
+		return nil
+	}
+	ret := make([]ZsetMember, n)
+	if rev {
+		for i := range ret {
+			ret[i] = p.members[end-1-offset-i]
 		}
+		return ret
 	}
-	return -1
+	copy(ret, p.members[first+offset:])
+	return ret
 }
 
-func (sm *scoreMemberMap) revrank(member string) int {
-	n := 0
-	for cur := sm.tail; cur != nil; cur = cur.pre {
-		for i := len(cur.members) - 1; i >= 0; i-- {
-			if cur.members[i] == member {
-				return n
-			}
-			n++
-		}
+func (p *zsetPack) deleteRange(r rangeSpec, removed func(member string)) int {
+	first, end := p.bounds(r)
+	for _, x := range p.members[first:end] {
+		removed(x.Member)
 	}
-	return -1
+	p.delete(first, end)
+	return end - first
 }
 
+func (p *zsetPack) deleteRangeByRank(start, stop int, removed func(member string)) int {
+	for _, x := range p.members[start-1 : stop] {
+		removed(x.Member)
+	}
+	p.delete(start-1, stop)
+	return stop - start + 1
+}
+
+//ZrangeQuery selects members of a sorted set like ZRANGE: by rank from Start to Stop,
+//or by score or by member when Score or Lex is set.
+type ZrangeQuery struct {
+	Start, Stop int
+	Score       *ScoreRange
+	Lex         *LexRange
+	//Rev orders the members from the highest score
+	Rev bool
+	//Offset and Count come from LIMIT, a negative Count means all the members after Offset
+	Offset, Count int
+}
+
+//ZADD flags, they can be combined except NX with XX, GT or LT
+const (
+	ZaddNX   = 1 << iota //only add new members
+	ZaddXX               //only update existing members
+	ZaddGT               //only update when the new score is greater
+	ZaddLT               //only update when the new score is less
+	ZaddIncr             //increment the score instead of setting it
+)
+
+//operations of Zcombine
+const (
+	ZsetUnion = iota
+	ZsetInter
+	ZsetDiff
+)
+
+//AGGREGATE of ZUNION and ZINTER, how the scores of a member in several inputs are combined
+const (
+	AggregateSum = iota
+	AggregateMin
+	AggregateMax
+)
+
+//what zsetVal.add did with a member
+const (
+	zaddOutNone    = iota //the member already had the score
+	zaddOutNop            //the flags prevented the operation
+	zaddOutAdded          //the member is new
+	zaddOutUpdated        //the score of the member changed
+)
+
+//zsetVal is packed while it's small: zsl is a zsetPack, msMap is nil and the score of a member is found by a scan.
+//Once it has more members or a longer one than the limits, zsl becomes a skip list and msMap holds the scores.
 type zsetVal struct {
 	msMap    map[string]float64 //key:member,value:score
-	smMap    *scoreMemberMap
+	zsl      zsetIndex
 	expireAt int64
 }
 
 func newZset() *zsetVal {
-	return &zsetVal{msMap: make(map[string]float64), smMap: new(scoreMemberMap), expireAt: -1}
+	return &zsetVal{zsl: &zsetPack{}, expireAt: -1}
 }
 
 func (s *zsetVal) isAlive() bool {
-	if s.expireAt == -1 {
-		return true
-	}
-	ttl := s.expireAt - time.Now().Unix()
-	return ttl >= 0
+	return aliveUntil(s.expireAt)
 }
 
 func (s *zsetVal) ttl() int {
-	//returns -1 if the key exists but has no associated expire
-	if s.expireAt == -1 {
-		return -1
-	}
-	ttl := s.expireAt - time.Now().Unix()
-	if ttl > 0 {
-		return int(ttl)
-	}
-	//returns -2 if the key does not exist.
-	return -2
+	return ttlUntil(s.expireAt)
 }
 
 func (s *zsetVal) setExpireAt(at int64) {
 	s.expireAt = at
 }
 
+func (s *zsetVal) expiration() int64 {
+	return s.expireAt
+}
+
 func (s *zsetVal) dataType() string {
 	return "zset"
 }
 
-// return the number of elements added to the sorted set,
-// not including elements already existing for which the score was updated.
-func (s *zsetVal) add(score float64, member string) int {
-	oldScore, exist := s.msMap[member]
-	if exist {
-		if oldScore != score { //update only when score changed
+func (s *zsetVal) encoding() string {
+	if s.msMap == nil {
+		return encodingListpack
+	}
+	return encodingSkiplist
+}
+
+//clone packs the members in order, then builds a skip list from them when the sorted set isn't packed
+func (s *zsetVal) clone() expired {
+	c := &zsetVal{zsl: &zsetPack{members: s.zsl.rangeByRank(0, -1, false)}, expireAt: s.expireAt}
+	if s.msMap != nil {
+		c.unpack()
+	}
+	return c
+}
+
+//scoreOf returns the score of the member
+func (s *zsetVal) scoreOf(member string) (float64, bool) {
+	if s.msMap == nil {
+		return s.zsl.(*zsetPack).find(member)
+	}
+	score, ok := s.msMap[member]
+	return score, ok
+}
+
+//unpack moves the members to a skip list
+func (s *zsetVal) unpack() {
+	pack := s.zsl.(*zsetPack)
+	zsl := newSkipList()
+	s.msMap = make(map[string]float64, pack.len()+1)
+	for _, x := range pack.members {
+		zsl.insert(x.Score, x.Member)
+		s.msMap[x.Member] = x.Score
+	}
+	s.zsl = zsl
+}
+
+//each calls fn with every member and its score until it returns false
+func (s *zsetVal) each(fn func(member string, score float64) bool) {
+	if s.msMap == nil {
+		for _, x := range s.zsl.(*zsetPack).members {
+			if !fn(x.Member, x.Score) {
+				return
+			}
+		}
+		return
+	}
+	for m, score := range s.msMap {
+		if !fn(m, score) {
+			return
+		}
+	}
+}
+
+// add sets the score of a member under the conditions of the ZADD flags, with ZaddIncr the score is an increment.
+// It returns the new score and what was done.
+func (s *zsetVal) add(score float64, member string, flags int) (float64, int, error) {
+	oldScore, exist := s.scoreOf(member)
+	if !exist {
+		if flags&ZaddXX != 0 {
+			return 0, zaddOutNop, nil
+		}
+		if s.msMap == nil && (s.card() >= limits.ZsetMaxListpackEntries || len(member) > limits.ZsetMaxListpackValue) {
+			s.unpack()
+		}
+		member = util.Clone(member)
+		if s.msMap != nil {
 			s.msMap[member] = score
-			s.smMap.remove(oldScore, member)
-			s.smMap.put(score, member)
 		}
-		return 0
+		s.zsl.insert(score, member)
+		return score, zaddOutAdded, nil
 	}
 
-	//add new member
-	s.msMap[member] = score
-	s.smMap.put(score, member)
-	return 1
+	if flags&ZaddNX != 0 {
+		return oldScore, zaddOutNop, nil
+	}
+	if flags&ZaddIncr != 0 {
+		score += oldScore
+		if math.IsNaN(score) {
+			return 0, zaddOutNop, errorScoreNaN
+		}
+	}
+	if (flags&ZaddGT != 0 && score <= oldScore) || (flags&ZaddLT != 0 && score >= oldScore) {
+		return oldScore, zaddOutNop, nil
+	}
+	if score == oldScore {
+		return score, zaddOutNone, nil
+	}
+	if s.msMap != nil {
+		//the map keeps the new key on assignment, so it mustn't be a view of the request
+		s.msMap[util.Clone(member)] = score
+	}
+	s.zsl.updateScore(oldScore, member, score)
+	return score, zaddOutUpdated, nil
 }
 
 func (s *zsetVal) card() int {
-	return len(s.msMap)
+	return s.zsl.len()
 }
 
-func (s *zsetVal) count(min, max float64) int {
-	return s.smMap.count(min, max)
+func (s *zsetVal) rangeOf(q *ZrangeQuery) []ZsetMember {
+	switch {
+	case q.Score != nil:
+		return s.zsl.rangeOf(q.Score, q.Rev, q.Offset, q.Count)
+	case q.Lex != nil:
+		return s.zsl.rangeOf(q.Lex, q.Rev, q.Offset, q.Count)
+	}
+	return s.zsl.rangeByRank(q.Start, q.Stop, q.Rev)
 }
 
-func (s *zsetVal) rangeByIndex(start, stop int) []string {
-	return s.smMap.rangeByIndex(start, stop)
+//removeRange removes the members in the range and returns their number
+func (s *zsetVal) removeRange(r rangeSpec) int {
+	return s.zsl.deleteRange(r, s.forget)
 }
 
-func (s *zsetVal) rangeByIndexWithScore(start, stop int) []string {
-	array := s.smMap.rangeByIndexWithScore(start, stop)
-	var ret []string
-	for _, m := range array {
-		ret = append(ret, m.Member, fmt.Sprintf("%f", m.Score))
+//removeRangeByRank removes the members from start to stop, negative ranks count from the end
+func (s *zsetVal) removeRangeByRank(start, stop int) int {
+	start, stop, ok := ranksOf(start, stop, s.card())
+	if !ok {
+		return 0
+	}
+	return s.zsl.deleteRangeByRank(start+1, stop+1, s.forget)
+}
+
+//forget removes a member from msMap once it's removed from the index
+func (s *zsetVal) forget(member string) {
+	if s.msMap != nil {
+		delete(s.msMap, member)
 	}
-	return ret
 }
 
-func (s *zsetVal) rangeByScore(min, max float64) []string {
-	return s.smMap.rangeByScore(min, max)
+//pop removes and returns up to count members with the lowest scores, or the highest ones with max
+func (s *zsetVal) pop(count int, max bool) []ZsetMember {
+	if count > s.card() {
+		count = s.card()
+	}
+	ret := make([]ZsetMember, 0, count)
+	for len(ret) < count {
+		rank := 1
+		if max {
+			rank = s.card()
+		}
+		x := s.zsl.at(rank)
+		ret = append(ret, x)
+		s.zsl.remove(x.Score, x.Member)
+		s.forget(x.Member)
+	}
+	return ret
 }
 
-func (s *zsetVal) rangeByScoreWithScore(min, max float64) []string {
-	array := s.smMap.rangeByScoreWithScore(min, max)
-	var ret []string
-	for _, m := range array {
-		ret = append(ret, m.Member, fmt.Sprintf("%f", m.Score))
+//random returns count random members: distinct ones when count is positive,
+//and when it's negative -count members which may be repeated.
+func (s *zsetVal) random(count int) []ZsetMember {
+	indexes := randomIndexes(s.card(), count)
+	if indexes == nil {
+		return nil
+	}
+	ret := make([]ZsetMember, len(indexes))
+	for i, j := range indexes {
+		ret[i] = s.zsl.at(j + 1)
 	}
 	return ret
 }
 
 func (s *zsetVal) rank(member string) *int {
-	_, exist := s.msMap[member]
+	score, exist := s.scoreOf(member)
 	if !exist {
 		return nil
 	}
-	i := s.smMap.rank(member)
+	i := s.zsl.rank(score, member) - 1
 	if i < 0 {
 		panic("illegal state, rank is little than 0")
 	}
@@ -359,41 +926,42 @@ func (s *zsetVal) rank(member string) *int {
 }
 
 func (s *zsetVal) revrank(member string) *int {
-	_, exist := s.msMap[member]
+	score, exist := s.scoreOf(member)
 	if !exist {
 		return nil
 	}
-	i := s.smMap.revrank(member)
-	if i < 0 {
+	rank := s.zsl.rank(score, member)
+	if rank <= 0 {
 		panic("illegal state, rank is little than 0")
 	}
+	i := s.zsl.len() - rank
 	return &i
 }
 
 func (s *zsetVal) remove(members []string) int {
 	n := 0
 	for _, m := range members {
-		score, exist := s.msMap[m]
+		score, exist := s.scoreOf(m)
 		if !exist {
 			continue
 		}
-		s.smMap.remove(score, m)
+		s.zsl.remove(score, m)
+		s.forget(m)
 		n++
 	}
 	return n
 }
 
-func (s *zsetVal) score(member string) *string {
-	score, exist := s.msMap[member]
+func (s *zsetVal) score(member string) *float64 {
+	score, exist := s.scoreOf(member)
 	if !exist {
 		return nil
 	}
-	scoreStr := fmt.Sprintf("%f", score)
-	return &scoreStr
+	return &score
 }
 
 func zsetOf(key string) (*zsetVal, error) {
-	z, ok := values[key]
+	z, ok := lookup(key)
 	if !ok || !z.isAlive() {
 		return nil, nil
 	}
@@ -404,16 +972,69 @@ func zsetOf(key string) (*zsetVal, error) {
 	return zset, nil
 }
 
-func Zadd(key string, score float64, member string) (int, error) {
+//zsetForAdd returns the sorted set at key, or a new one which is stored only once it has members
+func zsetForAdd(key string) (*zsetVal, bool, error) {
 	zset, err := zsetOf(key)
 	if err != nil {
-		return -1, err
+		return nil, false, err
 	}
 	if zset == nil {
-		zset = newZset()
-		values[key] = zset
+		return newZset(), true, nil
 	}
-	return zset.add(score, member), nil
+	return zset, false, nil
+}
+
+// Zadd adds the members with their scores, or updates the scores of the existing ones, under the conditions of flags.
+// It returns the number of members added and the number of members whose score changed.
+func Zadd(key string, scores []float64, members []string, flags int) (int, int, error) {
+	zset, created, err := zsetForAdd(key)
+	if err != nil {
+		return -1, -1, err
+	}
+	added, updated := 0, 0
+	for i, m := range members {
+		_, out, err := zset.add(scores[i], m, flags&^ZaddIncr)
+		if err != nil {
+			return -1, -1, err
+		}
+		switch out {
+		case zaddOutAdded:
+			added++
+		case zaddOutUpdated:
+			updated++
+		}
+	}
+	if created && zset.card() > 0 {
+		setValue(key, zset)
+	}
+	if added+updated > 0 {
+		signalModifiedKey(key)
+	}
+	return added, updated, nil
+}
+
+// Zincrby increments the score of a member under the conditions of flags like ZADD INCR does,
+// a member which doesn't exist gets the increment as score.
+// It returns the new score, or nil when the flags prevented the operation.
+func Zincrby(key string, incr float64, member string, flags int) (*float64, error) {
+	zset, created, err := zsetForAdd(key)
+	if err != nil {
+		return nil, err
+	}
+	score, out, err := zset.add(incr, member, flags|ZaddIncr)
+	if err != nil {
+		return nil, err
+	}
+	if out == zaddOutNop {
+		return nil, nil
+	}
+	if created {
+		setValue(key, zset)
+	}
+	if out != zaddOutNone {
+		signalModifiedKey(key)
+	}
+	return &score, nil
 }
 
 // Zcard returns the cardinality (number of elements) of the sorted set, or 0 if key does not exist.
@@ -428,8 +1049,8 @@ func Zcard(key string) (int, error) {
 	return zset.card(), nil
 }
 
-// Zcount returns the number of elements in the sorted set at key with a score between min and max.
-func Zcount(key string, min, max float64) (int, error) {
+// Zcount returns the number of elements in the sorted set at key with a score in the range.
+func Zcount(key string, r *ScoreRange) (int, error) {
 	zset, err := zsetOf(key)
 	if err != nil {
 		return -1, err
@@ -437,21 +1058,24 @@ func Zcount(key string, min, max float64) (int, error) {
 	if zset == nil {
 		return 0, nil
 	}
-	return zset.count(min, max), nil
+	return zset.zsl.count(r), nil
 }
 
-func ZrangeWithScore(key string, start, stop int) ([]string, error) {
+// Zlexcount returns the number of elements in the sorted set at key with a member in the range,
+// when all the elements have the same score.
+func Zlexcount(key string, r *LexRange) (int, error) {
 	zset, err := zsetOf(key)
 	if err != nil {
-		return nil, err
+		return -1, err
 	}
 	if zset == nil {
-		return nil, nil
+		return 0, nil
 	}
-	return zset.rangeByIndexWithScore(start, stop), nil
+	return zset.zsl.count(r), nil
 }
 
-func Zrange(key string, start, stop int) ([]string, error) {
+// Zrange returns the members selected by the query with their scores.
+func Zrange(key string, q *ZrangeQuery) ([]ZsetMember, error) {
 	zset, err := zsetOf(key)
 	if err != nil {
 		return nil, err
@@ -459,29 +1083,237 @@ func Zrange(key string, start, stop int) ([]string, error) {
 	if zset == nil {
 		return nil, nil
 	}
-	return zset.rangeByIndex(start, stop), nil
+	return zset.rangeOf(q), nil
 }
 
-func ZRangeByScore(key string, min, max float64) ([]string, error) {
-	zset, err := zsetOf(key)
+// ZrangeStore stores the members selected by the query in dest, replacing any value of dest,
+// and returns their number. dest is deleted when no member is selected.
+func ZrangeStore(dest, key string, q *ZrangeQuery) (int, error) {
+	members, err := Zrange(key, q)
+	if err != nil {
+		return -1, err
+	}
+	zset := newZset()
+	for _, m := range members {
+		zset.add(m.Score, m.Member, 0)
+	}
+	return storeZset(dest, zset), nil
+}
+
+//storeZset replaces the value at dest by the sorted set, an empty one deletes dest. It returns the card.
+func storeZset(dest string, zset *zsetVal) int {
+	_, existed := lookup(dest)
+	deleteValue(dest)
+	if zset.card() == 0 {
+		if existed {
+			signalModifiedKey(dest)
+		}
+		return 0
+	}
+	setValue(dest, zset)
+	signalModifiedKey(dest)
+	return zset.card()
+}
+
+//zsetInput is a key read by ZUNION, ZINTER or ZDIFF, it holds a sorted set or a set whose members score 1
+type zsetInput struct {
+	zset *zsetVal
+	set  *setVal
+	//weight multiplies the scores of the input
+	weight float64
+}
+
+//zsetInputOf returns the input at key, which is empty when the key doesn't exist
+func zsetInputOf(key string, weight float64) (zsetInput, error) {
+	in := zsetInput{weight: weight}
+	v, ok := lookup(key)
+	if !ok || !v.isAlive() {
+		return in, nil
+	}
+	switch v := v.(type) {
+	case *zsetVal:
+		in.zset = v
+	case *setVal:
+		in.set = v
+	default:
+		return in, errorWrongType
+	}
+	return in, nil
+}
+
+func (in *zsetInput) card() int {
+	if in.zset != nil {
+		return in.zset.card()
+	}
+	if in.set != nil {
+		return in.set.len()
+	}
+	return 0
+}
+
+//score returns the weighted score of a member, if it's in the input
+func (in *zsetInput) score(member string) (float64, bool) {
+	score := 1.0
+	if in.zset != nil {
+		s, ok := in.zset.scoreOf(member)
+		if !ok {
+			return 0, false
+		}
+		score = s
+	} else if in.set != nil {
+		if !in.set.has(member) {
+			return 0, false
+		}
+	} else {
+		return 0, false
+	}
+	return in.weighted(score), true
+}
+
+//weighted multiplies a score by the weight, like redis a NaN from 0 times infinity is 0
+func (in *zsetInput) weighted(score float64) float64 {
+	score *= in.weight
+	if math.IsNaN(score) {
+		return 0
+	}
+	return score
+}
+
+//each calls fn with every member of the input and its weighted score until fn returns false
+func (in *zsetInput) each(fn func(member string, score float64) bool) {
+	if in.zset != nil {
+		in.zset.each(func(member string, score float64) bool {
+			return fn(member, in.weighted(score))
+		})
+	} else if in.set != nil {
+		in.set.each(func(member string) bool {
+			return fn(member, in.weighted(1))
+		})
+	}
+}
+
+//aggregate combines the score of a member found in another input with its current one
+func aggregate(current, score float64, how int) float64 {
+	switch how {
+	case AggregateMin:
+		return math.Min(current, score)
+	case AggregateMax:
+		return math.Max(current, score)
+	}
+	//+inf plus -inf is 0 in redis
+	if sum := current + score; !math.IsNaN(sum) {
+		return sum
+	}
+	return 0
+}
+
+//zsetInputsOf reads the inputs at keys, weights may be nil when every weight is 1
+func zsetInputsOf(keys []string, weights []float64) ([]zsetInput, error) {
+	inputs := make([]zsetInput, len(keys))
+	for i, key := range keys {
+		weight := 1.0
+		if weights != nil {
+			weight = weights[i]
+		}
+		in, err := zsetInputOf(key, weight)
+		if err != nil {
+			return nil, err
+		}
+		inputs[i] = in
+	}
+	return inputs, nil
+}
+
+//zcombine computes the union, intersection or difference of the keys into a new sorted set
+func zcombine(op int, keys []string, weights []float64, how int) (*zsetVal, error) {
+	inputs, err := zsetInputsOf(keys, weights)
 	if err != nil {
 		return nil, err
 	}
-	if zset == nil {
-		return nil, nil
+	scores := make(map[string]float64)
+	switch op {
+	case ZsetUnion:
+		for i := range inputs {
+			inputs[i].each(func(member string, score float64) bool {
+				if current, ok := scores[member]; ok {
+					score = aggregate(current, score, how)
+				}
+				scores[member] = score
+				return true
+			})
+		}
+	case ZsetInter:
+		//the smallest input is walked and its members looked up in the others
+		sort.SliceStable(inputs, func(i, j int) bool { return inputs[i].card() < inputs[j].card() })
+		inputs[0].each(func(member string, score float64) bool {
+			for i := 1; i < len(inputs); i++ {
+				other, ok := inputs[i].score(member)
+				if !ok {
+					return true
+				}
+				score = aggregate(score, other, how)
+			}
+			scores[member] = score
+			return true
+		})
+	case ZsetDiff:
+		inputs[0].each(func(member string, score float64) bool {
+			for i := 1; i < len(inputs); i++ {
+				if _, ok := inputs[i].score(member); ok {
+					return true
+				}
+			}
+			scores[member] = score
+			return true
+		})
+	}
+	zset := newZset()
+	for member, score := range scores {
+		zset.add(score, member, 0)
 	}
-	return zset.rangeByScore(min, max), nil
+	return zset, nil
 }
 
-func ZRangeByScoreWithScore(key string, min, max float64) ([]string, error) {
-	zset, err := zsetOf(key)
+// Zcombine returns the union, intersection or difference of the sorted sets or sets at keys, ordered by score.
+// The scores of every key are multiplied by its weight, weights may be nil when they are all 1,
+// and the scores of a member in several keys are combined by the aggregate function.
+// The difference keeps the scores of the first key.
+func Zcombine(op int, keys []string, weights []float64, how int) ([]ZsetMember, error) {
+	zset, err := zcombine(op, keys, weights, how)
 	if err != nil {
 		return nil, err
 	}
-	if zset == nil {
-		return nil, nil
+	return zset.zsl.rangeByRank(0, -1, false), nil
+}
+
+// ZcombineStore stores the result of Zcombine at dest and returns its number of members.
+func ZcombineStore(dest string, op int, keys []string, weights []float64, how int) (int, error) {
+	zset, err := zcombine(op, keys, weights, how)
+	if err != nil {
+		return 0, err
+	}
+	return storeZset(dest, zset), nil
+}
+
+// Zintercard returns the number of members of the intersection of the keys,
+// counting stops at limit unless it's 0.
+func Zintercard(keys []string, limit int) (int, error) {
+	inputs, err := zsetInputsOf(keys, nil)
+	if err != nil {
+		return 0, err
 	}
-	return zset.rangeByScoreWithScore(min, max), nil
+	sort.SliceStable(inputs, func(i, j int) bool { return inputs[i].card() < inputs[j].card() })
+	n := 0
+	inputs[0].each(func(member string, _ float64) bool {
+		for i := 1; i < len(inputs); i++ {
+			if _, ok := inputs[i].score(member); !ok {
+				return true
+			}
+		}
+		n++
+		return limit == 0 || n < limit
+	})
+	return n, nil
 }
 
 func Zrank(key, member string) (*int, error) {
@@ -514,10 +1346,93 @@ func Zrem(key string, members []string) (int, error) {
 	if zset == nil {
 		return 0, nil
 	}
-	return zset.remove(members), nil
+	n := zset.remove(members)
+	zsetRemoved(key, zset, n)
+	return n, nil
+}
+
+//zsetRemoved signals that n members were removed from the sorted set at key, which is deleted once it's empty
+func zsetRemoved(key string, zset *zsetVal, n int) {
+	if n == 0 {
+		return
+	}
+	if zset.card() == 0 {
+		deleteValue(key)
+	}
+	signalModifiedKey(key)
+}
+
+// ZremRangeByRank removes the members from start to stop, negative ranks count from the end.
+// It returns the number of members removed.
+func ZremRangeByRank(key string, start, stop int) (int, error) {
+	zset, err := zsetOf(key)
+	if err != nil || zset == nil {
+		return 0, err
+	}
+	n := zset.removeRangeByRank(start, stop)
+	zsetRemoved(key, zset, n)
+	return n, nil
+}
+
+// ZremRangeByScore removes the members with a score in the range and returns their number.
+func ZremRangeByScore(key string, r *ScoreRange) (int, error) {
+	zset, err := zsetOf(key)
+	if err != nil || zset == nil {
+		return 0, err
+	}
+	n := zset.removeRange(r)
+	zsetRemoved(key, zset, n)
+	return n, nil
+}
+
+// ZremRangeByLex removes the members in the range and returns their number.
+func ZremRangeByLex(key string, r *LexRange) (int, error) {
+	zset, err := zsetOf(key)
+	if err != nil || zset == nil {
+		return 0, err
+	}
+	n := zset.removeRange(r)
+	zsetRemoved(key, zset, n)
+	return n, nil
+}
+
+// Zpop removes and returns up to count members with the lowest scores, or the highest ones with max.
+func Zpop(key string, count int, max bool) ([]ZsetMember, error) {
+	zset, err := zsetOf(key)
+	if err != nil || zset == nil {
+		return nil, err
+	}
+	members := zset.pop(count, max)
+	zsetRemoved(key, zset, len(members))
+	return members, nil
+}
+
+// Zmscore returns the scores of the members, nil for the ones which are not in the sorted set.
+func Zmscore(key string, members []string) ([]*float64, error) {
+	zset, err := zsetOf(key)
+	if err != nil {
+		return nil, err
+	}
+	scores := make([]*float64, len(members))
+	if zset != nil {
+		for i, m := range members {
+			scores[i] = zset.score(m)
+		}
+	}
+	return scores, nil
+}
+
+// Zrandmember returns count random members: distinct ones when count is positive,
+// and when it's negative -count members which may be repeated.
+func Zrandmember(key string, count int) ([]ZsetMember, error) {
+	zset, err := zsetOf(key)
+	if err != nil || zset == nil {
+		return nil, err
+	}
+	return zset.random(count), nil
 }
 
-func Zscore(key, member string) (*string, error) {
+func Zscore(key, member string) (*float64, error) {
 	zset, err := zsetOf(key)
 	if err != nil {
 		return nil, err
@@ -3,252 +3,685 @@ package store
 import (
 	"github.com/stretchr/testify/assert"
 	"log"
+	"math"
+	"math/rand"
 	"reflect"
+	"sort"
 	"strconv"
 	"testing"
 )
 
-func Test_scoreMemberMap_put(t *testing.T) {
-	sm := new(scoreMemberMap)
+func putSample(sl *skipList) {
+	sl.insert(1, "1")
+	sl.insert(2, "2")
+	sl.insert(9, "9")
+	sl.insert(7, "7")
+	sl.insert(-100, "-100")
+	sl.insert(9, "01")
+	sl.insert(9, "02")
+	sl.insert(9, "03")
+}
 
-	type pair struct {
-		k float64
-		v []string
+func names(members []ZsetMember) []string {
+	var ret []string
+	for _, m := range members {
+		ret = append(ret, m.Member)
 	}
+	return ret
+}
 
-	sm.put(1, "1")
-	sm.put(2, "2")
-	sm.put(9, "9")
-	sm.put(7, "7")
-	sm.put(-100, "-100")
-	sm.put(9, "01")
-	sm.put(9, "02")
-	sm.put(9, "03")
-	sm.put(9, "03")
-	log.Println(sm)
+func byScore(sl *skipList, min, max float64) []string {
+	return names(sl.rangeOf(&ScoreRange{Min: min, Max: max}, false, 0, -1))
+}
 
-	pairs := []pair{
-		{-100, []string{"-100"}},
-		{1, []string{"1"}},
-		{2, []string{"2"}},
-		{7, []string{"7"}},
-		{9, []string{"01", "02", "03", "9"}},
-	}
+func byRank(sl *skipList, start, stop int) []string {
+	return names(sl.rangeByRank(start, stop, false))
+}
+
+func Test_skipList_insert(t *testing.T) {
+	sl := newSkipList()
+	putSample(sl)
+	log.Println(sl)
 
-	cur := sm.head
-	for _, p := range pairs {
-		assert.Equal(t, p.k, cur.score)
-		assert.ElementsMatch(t, p.v, cur.members)
-		cur = cur.next
+	want := []ZsetMember{{"-100", -100}, {"1", 1}, {"2", 2}, {"7", 7}, {"01", 9}, {"02", 9}, {"03", 9}, {"9", 9}}
+	x := sl.head.level[0].forward
+	for i, m := range want {
+		assert.Equal(t, m.Score, x.score)
+		assert.Equal(t, m.Member, x.member)
+		if i > 0 {
+			assert.Equal(t, want[i-1].Member, x.backward.member)
+		}
+		x = x.level[0].forward
 	}
+	assert.Nil(t, x)
+	assert.Equal(t, "9", sl.tail.member)
+	assert.Equal(t, len(want), sl.length)
 }
 
-func Test_scoreMemberMap_remove(t *testing.T) {
-	sm := new(scoreMemberMap)
-	removed := sm.remove(1.0, "noexist")
+func Test_skipList_remove(t *testing.T) {
+	sl := newSkipList()
+	removed := sl.remove(1.0, "noexist")
 	assert.False(t, removed)
 
-	sm.put(1, "100")
-	removed = sm.remove(1, "100")
+	sl.insert(1, "100")
+	removed = sl.remove(1, "100")
 	assert.True(t, removed)
-	members := sm.get(1)
-	assert.Nil(t, members)
-
-	sm.put(1, "1")
-	sm.put(2, "2")
-	sm.put(9, "9")
-	sm.put(7, "7")
-	sm.put(-100, "-100")
-	sm.put(9, "01")
-	sm.put(9, "02")
-	sm.put(9, "03")
-	sm.put(9, "03")
-	log.Println(sm)
-
-	removed = sm.remove(1, "1")
+	assert.Nil(t, byScore(sl, 1, 1))
+	assert.Nil(t, sl.tail)
+
+	putSample(sl)
+	removed = sl.remove(1, "1")
 	assert.True(t, removed)
-	assert.Nil(t, sm.get(1))
-	log.Println(sm)
-	removed = sm.remove(1, "noexist")
+	assert.Nil(t, byScore(sl, 1, 1))
+	removed = sl.remove(1, "noexist")
 	assert.False(t, removed)
-	log.Println(sm)
-
-	removed = sm.remove(-1000, "sss")
+	removed = sl.remove(-1000, "sss")
+	assert.False(t, removed)
+	removed = sl.remove(7, "9")
 	assert.False(t, removed)
-	log.Println(sm)
 
-	removed = sm.remove(9, "03")
+	removed = sl.remove(9, "03")
+	assert.True(t, removed)
+	assert.True(t, reflect.DeepEqual([]string{"01", "02", "9"}, byScore(sl, 9, 9)))
+	removed = sl.remove(9, "9")
 	assert.True(t, removed)
-	assert.True(t, reflect.DeepEqual([]string{"01", "02", "9"}, sm.get(9)))
-}
-
-func Test_scoreMemberMap_get(t *testing.T) {
-	sm := new(scoreMemberMap)
-
-	assert.Nil(t, sm.get(-1))
-	sm.put(1, "1")
-	sm.put(2, "2")
-	sm.put(9, "9")
-	sm.put(7, "7")
-	sm.put(-100, "-100")
-	sm.put(9, "01")
-	sm.put(9, "02")
-	sm.put(9, "03")
-	sm.put(9, "03")
-	assert.True(t, reflect.DeepEqual(sm.get(1), []string{"1"}))
-	assert.True(t, reflect.DeepEqual(sm.get(2), []string{"2"}))
-	assert.True(t, reflect.DeepEqual(sm.get(9), []string{"01", "02", "03", "9"}))
-	assert.True(t, reflect.DeepEqual(sm.get(7), []string{"7"}))
-}
-
-func Test_scoreMemberMap_count(t *testing.T) {
-	sm := new(scoreMemberMap)
-	assert.Equal(t, 0, sm.count(-1, 100))
-	sm.put(1, "1")
-	sm.put(2, "2")
-	sm.put(9, "9")
-	sm.put(7, "7")
-	sm.put(-100, "-100")
-	sm.put(9, "01")
-	sm.put(9, "02")
-	sm.put(9, "03")
-	sm.put(9, "03")
-	log.Println(sm)
-
-	assert.Equal(t, 0, sm.count(-1, -100))
-	assert.Equal(t, 1, sm.count(-100, -1))
-	assert.Equal(t, 1, sm.count(-100, -100))
-	assert.Equal(t, 4, sm.count(8, 100))
-	assert.Equal(t, 5, sm.count(7, 100))
-	assert.Equal(t, 8, sm.count(-100, 100))
-}
-
-func Test_scoreMemberMap_rangeByIndex(t *testing.T) {
-	sm := new(scoreMemberMap)
-	assert.Equal(t, 0, len(sm.rangeByIndex(-1, 100)))
-
-	sm.put(1, "1")
-	sm.put(2, "2")
-	sm.put(9, "9")
-	sm.put(7, "7")
-	sm.put(-100, "-100")
-	sm.put(9, "01")
-	sm.put(9, "02")
-	sm.put(9, "03")
-	sm.put(9, "03")
-	log.Println(sm)
-
-	assert.ElementsMatch(t, sm.rangeByIndex(0, 1), []string{"-100", "1"})
-	assert.ElementsMatch(t, sm.rangeByIndex(1, 3), []string{"1", "2", "7"})
-	assert.ElementsMatch(t, sm.rangeByIndex(1, 1), []string{"1"})
-	assert.ElementsMatch(t, sm.rangeByIndex(4, 7), []string{"01", "02", "03", "9"})
-	assert.ElementsMatch(t, sm.rangeByIndex(3, 7), []string{"7", "01", "02", "03", "9"})
-	assert.ElementsMatch(t, sm.rangeByIndex(-4, -1), []string{"01", "02", "03", "9"})
-	assert.ElementsMatch(t, sm.rangeByIndex(-100, -8), []string{"-100"})
-	assert.ElementsMatch(t, sm.rangeByIndex(-7, -6), []string{"1", "2"})
-	assert.Nil(t, sm.rangeByIndex(-7, 0))
-	assert.ElementsMatch(t, sm.rangeByIndex(-9, 0), []string{"-100"})
-}
-
-func Test_scoreMemberMap_rangeByIndexWithScore(t *testing.T) {
-	sm := new(scoreMemberMap)
-	assert.Equal(t, 0, len(sm.rangeByIndex(-1, 100)))
-	sm.put(1, "1")
-	sm.put(2, "2")
-	sm.put(9, "9")
-	sm.put(7, "7")
-	sm.put(-100, "-100")
-	sm.put(9, "01")
-	sm.put(9, "02")
-	sm.put(9, "03")
-	sm.put(9, "03")
-	log.Println(sm)
-	assert.ElementsMatch(t, sm.rangeByIndexWithScore(0, 1), []*zsetMember{{"-100", -100}, {"1", 1}})
-	assert.Nil(t, sm.rangeByIndexWithScore(-7, 0))
-}
-
-func Test_scoreMemberMap_rangeByScore(t *testing.T) {
-	sm := new(scoreMemberMap)
-	assert.Nil(t, sm.rangeByScore(-1, 1000))
-	sm.put(1, "1")
-	sm.put(2, "2")
-	sm.put(9, "9")
-	sm.put(7, "7")
-	sm.put(-100, "-100")
-	sm.put(9, "01")
-	sm.put(9, "02")
-	sm.put(9, "03")
-	sm.put(9, "03")
-
-	assert.ElementsMatch(t, sm.rangeByScore(-100, 0), []string{"-100"})
-	assert.Nil(t, sm.rangeByScore(1000, 2000))
-	assert.ElementsMatch(t, sm.rangeByScore(2, 9), []string{"2", "7", "01", "02", "03", "9"})
-}
-
-func Test_scoreMemberMap_count1(t *testing.T) {
-	sm := new(scoreMemberMap)
-	assert.Equal(t, sm.count(-1, 1000), 0)
-
-	sm.put(1, "1")
-	sm.put(2, "2")
-	sm.put(9, "9")
-	sm.put(7, "7")
-	sm.put(-100, "-100")
-	sm.put(9, "01")
-	sm.put(9, "02")
-	sm.put(9, "03")
-	sm.put(9, "03")
-	assert.Equal(t, sm.count(-100, 0), 1)
-	assert.Equal(t, sm.count(1000, 200), 0)
-	assert.Equal(t, sm.count(1000, 2000), 0)
-	assert.Equal(t, sm.count(7, 2), 0)
-	assert.Equal(t, sm.count(2, 9), 6)
-}
-
-func Test_scoreMemberMap_rank(t *testing.T) {
-
-	sm := new(scoreMemberMap)
-	assert.Equal(t, -1, sm.rank("nonono"))
+	assert.Equal(t, "02", sl.tail.member)
+	assert.Equal(t, 5, sl.length)
+	assert.Equal(t, []string{"-100", "2", "7", "01", "02"}, byRank(sl, 0, -1))
+}
+
+func Test_skipList_updateScore(t *testing.T) {
+	sl := newSkipList()
+	putSample(sl)
+
+	sl.updateScore(2, "2", 3)
+	assert.Equal(t, []string{"-100", "1", "2", "7", "01", "02", "03", "9"}, byRank(sl, 0, -1))
+	assert.Equal(t, []string{"2"}, byScore(sl, 3, 3))
+	sl.updateScore(3, "2", 100)
+	assert.Equal(t, []string{"-100", "1", "7", "01", "02", "03", "9", "2"}, byRank(sl, 0, -1))
+	assert.Equal(t, "2", sl.tail.member)
+	sl.updateScore(9, "9", -200)
+	assert.Equal(t, []string{"9", "-100", "1", "7", "01", "02", "03", "2"}, byRank(sl, 0, -1))
+	assert.Equal(t, 1, sl.rank(-200, "9"))
+	assert.Equal(t, 8, sl.rank(100, "2"))
+}
+
+func Test_skipList_count(t *testing.T) {
+	sl := newSkipList()
+	assert.Equal(t, 0, sl.count(&ScoreRange{Min: -1, Max: 100}))
+	putSample(sl)
+
+	assert.Equal(t, 0, sl.count(&ScoreRange{Min: -1, Max: -100}))
+	assert.Equal(t, 1, sl.count(&ScoreRange{Min: -100, Max: -1}))
+	assert.Equal(t, 1, sl.count(&ScoreRange{Min: -100, Max: -100}))
+	assert.Equal(t, 4, sl.count(&ScoreRange{Min: 8, Max: 100}))
+	assert.Equal(t, 5, sl.count(&ScoreRange{Min: 7, Max: 100}))
+	assert.Equal(t, 8, sl.count(&ScoreRange{Min: -100, Max: 100}))
+	assert.Equal(t, 1, sl.count(&ScoreRange{Min: -100, Max: 0}))
+	assert.Equal(t, 0, sl.count(&ScoreRange{Min: 1000, Max: 200}))
+	assert.Equal(t, 0, sl.count(&ScoreRange{Min: 1000, Max: 2000}))
+	assert.Equal(t, 0, sl.count(&ScoreRange{Min: 7, Max: 2}))
+	assert.Equal(t, 6, sl.count(&ScoreRange{Min: 2, Max: 9}))
+}
+
+func Test_skipList_rangeByIndex(t *testing.T) {
+	sl := newSkipList()
+	assert.Equal(t, 0, len(byRank(sl, -1, 100)))
+	putSample(sl)
+
+	assert.Equal(t, []string{"-100", "1"}, byRank(sl, 0, 1))
+	assert.Equal(t, []string{"1", "2", "7"}, byRank(sl, 1, 3))
+	assert.Equal(t, []string{"1"}, byRank(sl, 1, 1))
+	assert.Equal(t, []string{"01", "02", "03", "9"}, byRank(sl, 4, 7))
+	assert.Equal(t, []string{"7", "01", "02", "03", "9"}, byRank(sl, 3, 7))
+	assert.Equal(t, []string{"01", "02", "03", "9"}, byRank(sl, -4, -1))
+	assert.Equal(t, []string{"-100"}, byRank(sl, -100, -8))
+	assert.Equal(t, []string{"1", "2"}, byRank(sl, -7, -6))
+	assert.Nil(t, byRank(sl, -7, 0))
+	assert.Equal(t, []string{"-100"}, byRank(sl, -9, 0))
+	assert.Equal(t, []string{"03", "9"}, byRank(sl, 6, 100))
+	assert.Nil(t, byRank(sl, 8, 100))
+}
+
+func Test_skipList_rangeByIndexWithScore(t *testing.T) {
+	sl := newSkipList()
+	assert.Equal(t, 0, len(sl.rangeByRank(-1, 100, false)))
+	putSample(sl)
+	assert.Equal(t, []ZsetMember{{"-100", -100}, {"1", 1}}, sl.rangeByRank(0, 1, false))
+	assert.Nil(t, sl.rangeByRank(-7, 0, false))
+	assert.Equal(t, []ZsetMember{{"9", 9}, {"03", 9}}, sl.rangeByRank(0, 1, true))
+	assert.Equal(t, []string{"1", "-100"}, names(sl.rangeByRank(-2, 100, true)))
+	assert.Equal(t, []string{"9", "03", "02", "01", "7", "2", "1", "-100"}, names(sl.rangeByRank(0, -1, true)))
+	assert.Nil(t, sl.rangeByRank(8, 9, true))
+}
+
+func Test_skipList_rangeByScore(t *testing.T) {
+	sl := newSkipList()
+	assert.Nil(t, byScore(sl, -1, 1000))
+	putSample(sl)
+
+	assert.Equal(t, []string{"-100"}, byScore(sl, -100, 0))
+	assert.Nil(t, byScore(sl, 1000, 2000))
+	assert.Nil(t, byScore(sl, 3, 6))
+	assert.Equal(t, []string{"2", "7", "01", "02", "03", "9"}, byScore(sl, 2, 9))
+	assert.Equal(t, []ZsetMember{{"7", 7}, {"01", 9}, {"02", 9}, {"03", 9}, {"9", 9}}, sl.rangeOf(&ScoreRange{Min: 3, Max: 100}, false, 0, -1))
+
+	all := &ScoreRange{Min: math.Inf(-1), Max: math.Inf(1)}
+	assert.Equal(t, []string{"9", "03", "02", "01", "7", "2", "1", "-100"}, names(sl.rangeOf(all, true, 0, -1)))
+	assert.Equal(t, []string{"2", "7"}, names(sl.rangeOf(all, false, 2, 2)))
+	assert.Equal(t, []string{"02", "01", "7"}, names(sl.rangeOf(all, true, 2, 3)))
+	assert.Equal(t, []string{"-100"}, names(sl.rangeOf(all, false, 0, 1)))
+	assert.Nil(t, sl.rangeOf(all, false, 0, 0))
+	assert.Nil(t, sl.rangeOf(all, false, -1, 5))
+	assert.Nil(t, sl.rangeOf(all, false, 8, 5))
+	assert.Nil(t, sl.rangeOf(all, true, 8, 5))
+	assert.Equal(t, []string{"9"}, names(sl.rangeOf(all, false, 7, -1)))
+	assert.Equal(t, []string{"03", "9"}, names(sl.rangeOf(&ScoreRange{Min: 2, Max: 9}, false, 4, 10)))
+	assert.Equal(t, []string{"7"}, names(sl.rangeOf(&ScoreRange{Min: 2, Max: 9, MinEx: true, MaxEx: true}, true, 0, -1)))
+	assert.Equal(t, []string{"2"}, names(sl.rangeOf(&ScoreRange{Min: 1, Max: 9, MinEx: true, MaxEx: true}, true, 1, -1)))
+}
+
+func Test_skipList_lexRange(t *testing.T) {
+	sl := newSkipList()
+	for _, m := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		sl.insert(0, m)
+	}
+	lex := func(min, max LexBound, rev bool, offset, count int) []string {
+		return names(sl.rangeOf(&LexRange{Min: min, Max: max}, rev, offset, count))
+	}
+	minInf, maxInf := LexBound{Inf: -1}, LexBound{Inf: 1}
+
+	assert.Equal(t, []string{"a", "b", "c", "d", "e", "f", "g"}, lex(minInf, maxInf, false, 0, -1))
+	assert.Equal(t, []string{"a", "b", "c"}, lex(minInf, LexBound{Member: "c"}, false, 0, -1))
+	assert.Equal(t, []string{"a", "b"}, lex(minInf, LexBound{Member: "c", Ex: true}, false, 0, -1))
+	assert.Equal(t, []string{"b", "c", "d", "e", "f"}, lex(LexBound{Member: "aaa"}, LexBound{Member: "g", Ex: true}, false, 0, -1))
+	assert.Equal(t, []string{"f", "e"}, lex(LexBound{Member: "aaa"}, LexBound{Member: "g", Ex: true}, true, 0, 2))
+	assert.Equal(t, []string{"e", "f"}, lex(LexBound{Member: "c", Ex: true}, maxInf, false, 1, 2))
+	assert.Nil(t, lex(maxInf, maxInf, false, 0, -1))
+	assert.Nil(t, lex(minInf, minInf, false, 0, -1))
+	assert.Nil(t, lex(LexBound{Member: "c"}, LexBound{Member: "c", Ex: true}, false, 0, -1))
+	assert.Nil(t, lex(LexBound{Member: "d"}, LexBound{Member: "c"}, false, 0, -1))
+	assert.Equal(t, []string{"c"}, lex(LexBound{Member: "c"}, LexBound{Member: "c"}, false, 0, -1))
+
+	assert.Equal(t, 7, sl.count(&LexRange{Min: minInf, Max: maxInf}))
+	assert.Equal(t, 2, sl.count(&LexRange{Min: LexBound{Member: "b", Ex: true}, Max: LexBound{Member: "d"}}))
+	assert.Equal(t, 0, sl.count(&LexRange{Min: LexBound{Member: "x"}, Max: maxInf}))
+}
+
+func Test_skipList_scoreRange(t *testing.T) {
+	sl := newSkipList()
+	putSample(sl)
+
+	first := sl.firstInRange(&ScoreRange{Min: 2, Max: 9, MinEx: true})
+	assert.Equal(t, "7", first.member)
+	last := sl.lastInRange(&ScoreRange{Min: 2, Max: 9, MaxEx: true})
+	assert.Equal(t, "7", last.member)
+	assert.Nil(t, sl.firstInRange(&ScoreRange{Min: 7, Max: 7, MinEx: true}))
+	assert.Nil(t, sl.firstInRange(&ScoreRange{Min: 7, Max: 9, MinEx: true, MaxEx: true}))
+	assert.Nil(t, sl.lastInRange(&ScoreRange{Min: 9, Max: 10, MinEx: true}))
+}
+
+func Test_skipList_rank(t *testing.T) {
+	sl := newSkipList()
+	assert.Equal(t, 0, sl.rank(0, "nonono"))
 
 	for i := 0; i < 10; i++ {
-		sm.put(float64(i), strconv.Itoa(i))
+		sl.insert(float64(i), strconv.Itoa(i))
 	}
 
 	for i := 0; i < 10; i++ {
-		rank := sm.rank(strconv.Itoa(i))
-		assert.NotNil(t, rank)
-		assert.Equal(t, i, rank)
+		assert.Equal(t, i+1, sl.rank(float64(i), strconv.Itoa(i)))
+		assert.Equal(t, strconv.Itoa(i), sl.byRank(i+1).member)
+	}
+	assert.Equal(t, 0, sl.rank(1, "2"))
+	assert.Nil(t, sl.byRank(11))
+}
+
+//Test_skipList_random checks the order and the spans against a sorted slice after random updates
+func Test_skipList_random(t *testing.T) {
+	sl := newSkipList()
+	scores := make(map[string]float64)
+	for i := 0; i < 5000; i++ {
+		member := strconv.Itoa(rand.Intn(1000))
+		score := float64(rand.Intn(100))
+		old, ok := scores[member]
+		switch {
+		case !ok:
+			sl.insert(score, member)
+			scores[member] = score
+		case rand.Intn(2) == 0:
+			assert.True(t, sl.remove(old, member))
+			delete(scores, member)
+		default:
+			sl.updateScore(old, member, score)
+			scores[member] = score
+		}
+	}
+
+	want := make([]ZsetMember, 0, len(scores))
+	for m, s := range scores {
+		want = append(want, ZsetMember{m, s})
+	}
+	sort.Slice(want, func(i, j int) bool {
+		return want[i].Score < want[j].Score || (want[i].Score == want[j].Score && want[i].Member < want[j].Member)
+	})
+	assert.Equal(t, len(want), sl.length)
+	for i, m := range want {
+		assert.Equal(t, i+1, sl.rank(m.Score, m.Member))
+		x := sl.byRank(i + 1)
+		assert.Equal(t, m.Member, x.member)
+	}
+	assert.Equal(t, len(want), len(byRank(sl, 0, -1)))
+}
+
+func Test_zsetVal_remove(t *testing.T) {
+	z := newZset()
+	z.add(1, "a", 0)
+	z.add(2, "b", 0)
+	assert.Equal(t, 1, z.remove([]string{"a", "c"}))
+	assert.Equal(t, 1, z.card())
+	assert.Nil(t, z.score("a"))
+	assert.Nil(t, z.rank("a"))
+	assert.Equal(t, 0, *z.rank("b"))
+	_, out, _ := z.add(1, "a", 0)
+	assert.Equal(t, zaddOutAdded, out)
+	assert.Equal(t, 1, *z.revrank("a"))
+}
+
+//assertConsistent checks that the index and msMap hold the same members with the same scores
+func assertConsistent(t *testing.T, z *zsetVal) {
+	if z.msMap != nil {
+		assert.Equal(t, len(z.msMap), z.zsl.len())
+	}
+	for _, m := range z.zsl.rangeByRank(0, -1, false) {
+		score, ok := z.scoreOf(m.Member)
+		assert.True(t, ok, m.Member)
+		assert.Equal(t, score, m.Score)
+	}
+}
+
+func Test_zsetVal_removeRange(t *testing.T) {
+	z := newZset()
+	for i := 0; i < 10; i++ {
+		z.add(float64(i), strconv.Itoa(i), 0)
+	}
+	assert.Equal(t, 3, z.removeRange(&ScoreRange{Min: 2, Max: 5, MinEx: true}))
+	assertConsistent(t, z)
+	assert.Equal(t, []string{"0", "1", "2", "6", "7", "8", "9"}, names(z.zsl.rangeByRank(0, -1, false)))
+	assert.Equal(t, 0, z.removeRange(&ScoreRange{Min: 3, Max: 5}))
+	assert.Equal(t, 2, z.removeRange(&LexRange{Min: LexBound{Member: "7"}, Max: LexBound{Member: "8"}}))
+	assertConsistent(t, z)
+
+	assert.Equal(t, 2, z.removeRangeByRank(-2, 100))
+	assert.Equal(t, 0, z.removeRangeByRank(2, 1))
+	assert.Equal(t, 0, z.removeRangeByRank(5, 10))
+	assert.Equal(t, 2, z.removeRangeByRank(1, 2))
+	assertConsistent(t, z)
+	assert.Equal(t, []string{"0"}, names(z.zsl.rangeByRank(0, -1, false)))
+}
+
+func Test_zsetVal_pop(t *testing.T) {
+	z := newZset()
+	z.add(1, "a", 0)
+	z.add(2, "b", 0)
+	z.add(3, "c", 0)
+	assert.Equal(t, []ZsetMember{{"c", 3}, {"b", 2}}, z.pop(2, true))
+	assertConsistent(t, z)
+	assert.Equal(t, []ZsetMember{{"a", 1}}, z.pop(5, false))
+	assert.Equal(t, 0, z.card())
+	assertConsistent(t, z)
+	assert.Empty(t, z.pop(1, false))
+}
+
+func Test_zsetVal_random(t *testing.T) {
+	z := newZset()
+	for i := 0; i < 100; i++ {
+		z.add(float64(i), strconv.Itoa(i), 0)
+	}
+	distinct := func(members []ZsetMember) int {
+		seen := make(map[string]bool)
+		for _, m := range members {
+			assert.Equal(t, m.Member, strconv.Itoa(int(m.Score)))
+			seen[m.Member] = true
+		}
+		return len(seen)
 	}
+	for _, count := range []int{1, 10, 50, 100, 200} {
+		members := z.random(count)
+		n := count
+		if n > 100 {
+			n = 100
+		}
+		assert.Len(t, members, n)
+		assert.Equal(t, n, distinct(members))
+	}
+	assert.Len(t, z.random(-300), 300)
+	assert.Empty(t, z.random(0))
+	assert.Empty(t, newZset().random(-3))
+}
+
+func TestZremRange(t *testing.T) {
+	flushAll()
+	Zadd("zrem", []float64{1, 2, 3, 4}, []string{"a", "b", "c", "d"}, 0)
+	n, err := ZremRangeByScore("zrem", &ScoreRange{Min: math.Inf(-1), Max: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	n, _ = ZremRangeByLex("zrem", &LexRange{Min: LexBound{Member: "d"}, Max: LexBound{Inf: 1}})
+	assert.Equal(t, 1, n)
+	scores, _ := Zmscore("zrem", []string{"a", "b", "c"})
+	assert.Equal(t, []*float64{nil, scores[1], scores[2]}, scores)
+	assert.Equal(t, 2.0, *scores[1])
+
+	members, err := Zpop("zrem", 1, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []ZsetMember{{"c", 3}}, members)
+	n, _ = ZremRangeByRank("zrem", 0, -1)
+	assert.Equal(t, 1, n)
+	_, ok := lookup("zrem")
+	assert.False(t, ok)
+	members, err = Zpop("zrem", 1, false)
+	assert.Nil(t, err)
+	assert.Empty(t, members)
+	scores, _ = Zmscore("zrem", []string{"a"})
+	assert.Equal(t, []*float64{nil}, scores)
 
+	Zadd("zrem", []float64{1}, []string{"a"}, 0)
+	Zrem("zrem", []string{"a"})
+	_, ok = lookup("zrem")
+	assert.False(t, ok)
 }
 
-func Test_scoreMemberMap_revrank(t *testing.T) {
-	type fields struct {
-		head *scoreMember
-		tail *scoreMember
-		size int
+func Test_zsetVal_add(t *testing.T) {
+	z := newZset()
+	add := func(score float64, member string, flags int) (float64, int) {
+		score, out, err := z.add(score, member, flags)
+		assert.Nil(t, err)
+		return score, out
 	}
-	type args struct {
-		member string
+
+	_, out := add(1, "a", ZaddXX)
+	assert.Equal(t, zaddOutNop, out)
+	assert.Equal(t, 0, z.card())
+	_, out = add(1, "a", ZaddNX)
+	assert.Equal(t, zaddOutAdded, out)
+	_, out = add(2, "a", ZaddNX)
+	assert.Equal(t, zaddOutNop, out)
+	_, out = add(1, "a", 0)
+	assert.Equal(t, zaddOutNone, out)
+
+	_, out = add(0, "a", ZaddGT)
+	assert.Equal(t, zaddOutNop, out)
+	_, out = add(3, "a", ZaddGT)
+	assert.Equal(t, zaddOutUpdated, out)
+	_, out = add(5, "a", ZaddLT)
+	assert.Equal(t, zaddOutNop, out)
+	_, out = add(2, "a", ZaddLT|ZaddXX)
+	assert.Equal(t, zaddOutUpdated, out)
+	assert.Equal(t, 2.0, *z.score("a"))
+
+	score, out := add(1.5, "a", ZaddIncr)
+	assert.Equal(t, zaddOutUpdated, out)
+	assert.Equal(t, 3.5, score)
+	_, out = add(-1, "a", ZaddIncr|ZaddGT)
+	assert.Equal(t, zaddOutNop, out)
+	score, out = add(0, "a", ZaddIncr)
+	assert.Equal(t, zaddOutNone, out)
+	assert.Equal(t, 3.5, score)
+	score, out = add(4, "b", ZaddIncr|ZaddLT)
+	assert.Equal(t, zaddOutAdded, out)
+	assert.Equal(t, 4.0, score)
+	assert.Equal(t, []string{"a", "b"}, names(z.zsl.rangeByRank(0, -1, false)))
+
+	add(math.Inf(1), "b", 0)
+	_, _, err := z.add(math.Inf(-1), "b", ZaddIncr)
+	assert.Equal(t, errorScoreNaN, err)
+	assert.Equal(t, math.Inf(1), *z.score("b"))
+}
+
+func TestZadd(t *testing.T) {
+	flushAll()
+	added, updated, err := Zadd("zadd", []float64{1}, []string{"a"}, ZaddXX)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, added+updated)
+	_, ok := lookup("zadd")
+	assert.False(t, ok)
+
+	added, updated, err = Zadd("zadd", []float64{1, 2, 3}, []string{"a", "b", "a"}, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, added)
+	assert.Equal(t, 1, updated)
+	members, _ := Zrange("zadd", &ZrangeQuery{Start: 0, Stop: -1})
+	assert.Equal(t, []string{"b", "a"}, names(members))
+
+	score, err := Zincrby("zadd", 2, "b", ZaddXX)
+	assert.Nil(t, err)
+	assert.Equal(t, 4.0, *score)
+	score, err = Zincrby("zadd", 2, "c", ZaddXX)
+	assert.Nil(t, err)
+	assert.Nil(t, score)
+	n, _ := Zcard("zadd")
+	assert.Equal(t, 2, n)
+
+	n, err = ZrangeStore("zadd-dest", "zadd", &ZrangeQuery{Score: &ScoreRange{Min: 2, Max: 4}, Count: -1})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	members, _ = Zrange("zadd-dest", &ZrangeQuery{Start: 0, Stop: -1, Rev: true})
+	assert.Equal(t, []ZsetMember{{"b", 4}, {"a", 3}}, members)
+	n, _ = ZrangeStore("zadd-dest", "zadd", &ZrangeQuery{Score: &ScoreRange{Min: 5, Max: 6}, Count: -1})
+	assert.Equal(t, 0, n)
+	_, ok = lookup("zadd-dest")
+	assert.False(t, ok)
+
+	Set("zadd-str", "v")
+	_, _, err = Zadd("zadd-str", []float64{1}, []string{"a"}, 0)
+	assert.Equal(t, errorWrongType, err)
+}
+
+func TestZcombine(t *testing.T) {
+	flushAll()
+	Zadd("za", []float64{1, 2, 3}, []string{"a", "b", "c"}, 0)
+	Zadd("zb", []float64{10, 20, math.Inf(1)}, []string{"b", "c", "d"}, 0)
+	Sadd("sc", []string{"c", "d", "e"})
+
+	members, err := Zcombine(ZsetUnion, []string{"za", "zb", "nokey"}, nil, AggregateSum)
+	assert.Nil(t, err)
+	assert.Equal(t, []ZsetMember{{"a", 1}, {"b", 12}, {"c", 23}, {"d", math.Inf(1)}}, members)
+	members, _ = Zcombine(ZsetUnion, []string{"za", "sc"}, []float64{2, 0.5}, AggregateMax)
+	assert.Equal(t, []ZsetMember{{"d", 0.5}, {"e", 0.5}, {"a", 2}, {"b", 4}, {"c", 6}}, members)
+	//0 times infinity is 0
+	members, _ = Zcombine(ZsetUnion, []string{"zb"}, []float64{0}, AggregateSum)
+	assert.Equal(t, []ZsetMember{{"b", 0}, {"c", 0}, {"d", 0}}, members)
+
+	members, _ = Zcombine(ZsetInter, []string{"za", "zb", "sc"}, nil, AggregateMin)
+	assert.Equal(t, []ZsetMember{{"c", 1}}, members)
+	members, _ = Zcombine(ZsetInter, []string{"za", "nokey"}, nil, AggregateSum)
+	assert.Empty(t, members)
+
+	members, _ = Zcombine(ZsetDiff, []string{"zb", "za"}, nil, AggregateSum)
+	assert.Equal(t, []ZsetMember{{"d", math.Inf(1)}}, members)
+
+	n, err := Zintercard([]string{"za", "zb"}, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	n, _ = Zintercard([]string{"za", "zb"}, 1)
+	assert.Equal(t, 1, n)
+
+	n, err = ZcombineStore("za", ZsetInter, []string{"za", "zb"}, []float64{1, -1}, AggregateSum)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	members, _ = Zrange("za", &ZrangeQuery{Start: 0, Stop: -1})
+	assert.Equal(t, []ZsetMember{{"c", -17}, {"b", -8}}, members)
+	n, _ = ZcombineStore("za", ZsetDiff, []string{"za", "za"}, nil, AggregateSum)
+	assert.Equal(t, 0, n)
+	_, ok := lookup("za")
+	assert.False(t, ok)
+
+	Set("str", "v")
+	_, err = Zcombine(ZsetUnion, []string{"zb", "str"}, nil, AggregateSum)
+	assert.Equal(t, errorWrongType, err)
+}
+
+var zsetSizes = []int{1000, 1000000}
+
+//zsetOfSize returns a sorted set with n members and their names, the scores are random in [0, n)
+func zsetOfSize(n int) (*zsetVal, []string) {
+	z := newZset()
+	members := make([]string, n)
+	for i := range members {
+		members[i] = "member:" + strconv.Itoa(i)
+		z.add(rand.Float64()*float64(n), members[i], 0)
 	}
-	tests := []struct {
-		name   string
-		fields fields
-		args   args
-		want   int
-	}{
-		// TODO: Add test cases.
+	return z, members
+}
+
+func BenchmarkZadd(b *testing.B) {
+	for _, n := range zsetSizes {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			z, members := zsetOfSize(n)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				//updating the score removes and inserts the node
+				z.add(rand.Float64()*float64(n), members[i%n], 0)
+			}
+		})
 	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			sm := &scoreMemberMap{
-				head: tt.fields.head,
-				tail: tt.fields.tail,
-				size: tt.fields.size,
+}
+
+func BenchmarkZrem(b *testing.B) {
+	for _, n := range zsetSizes {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			z, members := zsetOfSize(n)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				m := members[i%n]
+				z.remove([]string{m})
+				z.add(float64(i%n), m, 0)
+			}
+		})
+	}
+}
+
+func BenchmarkZrank(b *testing.B) {
+	for _, n := range zsetSizes {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			z, members := zsetOfSize(n)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				z.rank(members[i%n])
 			}
-			if got := sm.revrank(tt.args.member); got != tt.want {
-				t.Errorf("revrank() = %v, want %v", got, tt.want)
+		})
+	}
+}
+
+func BenchmarkZrangeByIndex(b *testing.B) {
+	for _, n := range zsetSizes {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			z, _ := zsetOfSize(n)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				start := i % n
+				z.rangeOf(&ZrangeQuery{Start: start, Stop: start + 9})
+			}
+		})
+	}
+}
+
+func BenchmarkZrangeByScore(b *testing.B) {
+	for _, n := range zsetSizes {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			z, _ := zsetOfSize(n)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				min := float64(i % n)
+				z.rangeOf(&ZrangeQuery{Score: &ScoreRange{Min: min, Max: min + 10}, Count: -1})
 			}
 		})
 	}
 }
+
+//Test_zsetPack runs the same operations on a packed sorted set and on a skip list
+func Test_zsetPack(t *testing.T) {
+	var packed, listed *zsetVal
+	var specs []rangeSpec
+	check := func() {
+		assert.Equal(t, encodingListpack, packed.encoding())
+		assert.Equal(t, encodingSkiplist, listed.encoding())
+		assertConsistent(t, packed)
+		assertConsistent(t, listed)
+		for _, rev := range []bool{false, true} {
+			assert.Equal(t, listed.zsl.rangeByRank(0, -1, rev), packed.zsl.rangeByRank(0, -1, rev))
+			assert.Equal(t, listed.zsl.rangeByRank(2, -3, rev), packed.zsl.rangeByRank(2, -3, rev))
+			for _, r := range specs {
+				assert.Equal(t, listed.zsl.count(r), packed.zsl.count(r))
+				assert.Equal(t, listed.zsl.rangeOf(r, rev, 0, -1), packed.zsl.rangeOf(r, rev, 0, -1))
+				assert.Equal(t, listed.zsl.rangeOf(r, rev, 1, 2), packed.zsl.rangeOf(r, rev, 1, 2))
+				assert.Equal(t, listed.zsl.rangeOf(r, rev, 100, 2), packed.zsl.rangeOf(r, rev, 100, 2))
+			}
+		}
+		for _, m := range []string{"m0", "m3", "m9", "none"} {
+			assert.Equal(t, listed.rank(m), packed.rank(m))
+			assert.Equal(t, listed.revrank(m), packed.revrank(m))
+		}
+	}
+
+	fill := func(scores int) {
+		packed, listed = newZset(), newZset()
+		listed.unpack()
+		for i := 0; i < 40; i++ {
+			score, member := float64(rand.Intn(scores)), "m"+strconv.Itoa(rand.Intn(20))
+			packed.add(score, member, 0)
+			listed.add(score, member, 0)
+		}
+	}
+
+	//lex ranges are only defined when all the members have the same score
+	fill(1)
+	specs = []rangeSpec{
+		&LexRange{Min: LexBound{Member: "m1"}, Max: LexBound{Member: "m5", Ex: true}},
+		&LexRange{Min: LexBound{Member: "m3", Ex: true}, Max: LexBound{Inf: 1}},
+		&LexRange{Min: LexBound{Inf: -1}, Max: LexBound{Inf: 1}},
+	}
+	check()
+	assert.Equal(t, listed.removeRange(specs[0]), packed.removeRange(specs[0]))
+	check()
+
+	fill(10)
+	specs = []rangeSpec{
+		&ScoreRange{Min: 3, Max: 7},
+		&ScoreRange{Min: 3, Max: 7, MinEx: true, MaxEx: true},
+		&ScoreRange{Min: 7, Max: 3},
+	}
+	check()
+	assert.Equal(t, listed.removeRange(&ScoreRange{Min: 2, Max: 3}), packed.removeRange(&ScoreRange{Min: 2, Max: 3}))
+	check()
+	assert.Equal(t, listed.removeRangeByRank(1, 2), packed.removeRangeByRank(1, 2))
+	check()
+	assert.Equal(t, listed.pop(2, true), packed.pop(2, true))
+	assert.Equal(t, listed.pop(1, false), packed.pop(1, false))
+	check()
+}
+
+func Test_zsetVal_encoding(t *testing.T) {
+	defer SetEncodingLimits(DefaultEncodingLimits)
+	SetEncodingLimits(EncodingLimits{ZsetMaxListpackEntries: 3, ZsetMaxListpackValue: 5})
+
+	z := newZset()
+	z.add(3, "c", 0)
+	z.add(1, "a", 0)
+	z.add(2, "b", 0)
+	z.add(0, "a", 0)
+	assert.Equal(t, encodingListpack, z.encoding())
+	z.add(4, "d", 0)
+	assert.Equal(t, encodingSkiplist, z.encoding())
+	assertConsistent(t, z)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, names(z.zsl.rangeByRank(0, -1, false)))
+
+	z = newZset()
+	z.add(1, "abcdef", 0)
+	assert.Equal(t, encodingSkiplist, z.encoding())
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+//tlsReloader builds the tls.Config of the TLS listener,
+//the certificates are loaded again from the files on SIGHUP without restarting the server.
+type tlsReloader struct {
+	certFile    string
+	keyFile     string
+	caFile      string
+	authClients string
+	minVersion  uint16
+	ciphers     []uint16
+
+	mu     sync.RWMutex
+	config *tls.Config
+}
+
+//newTLSReloader checks the options and loads the certificates for the first time
+func newTLSReloader(certFile, keyFile, caFile, authClients, minVersion, ciphers string) (*tlsReloader, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("tls-cert-file and tls-key-file are required by tls-port")
+	}
+	t := &tlsReloader{certFile: certFile, keyFile: keyFile, caFile: caFile, authClients: authClients}
+
+	v, ok := tlsVersions[minVersion]
+	if !ok {
+		return nil, fmt.Errorf("invalid tls-min-version '%s'", minVersion)
+	}
+	t.minVersion = v
+
+	if ciphers != "" {
+		suites := make(map[string]uint16)
+		for _, s := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+			suites[s.Name] = s.ID
+		}
+		for _, name := range strings.Split(ciphers, ",") {
+			id, ok := suites[strings.TrimSpace(name)]
+			if !ok {
+				return nil, fmt.Errorf("unknown cipher suite '%s'", name)
+			}
+			t.ciphers = append(t.ciphers, id)
+		}
+	}
+
+	switch authClients {
+	case "no", "optional":
+	case "yes":
+		if caFile == "" {
+			return nil, fmt.Errorf("tls-ca-cert-file is required to verify client certificates")
+		}
+	default:
+		return nil, fmt.Errorf("invalid tls-auth-clients '%s', should be yes, no or optional", authClients)
+	}
+
+	if err := t.reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+//reload reads the certificates again, the current config is kept if any of them is invalid
+func (t *tlsReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(t.certFile, t.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load tls certificate: %v", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   t.minVersion,
+		CipherSuites: t.ciphers,
+	}
+
+	if t.caFile != "" {
+		pem, err := os.ReadFile(t.caFile)
+		if err != nil {
+			return fmt.Errorf("failed to load tls ca certificate: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in %s", t.caFile)
+		}
+		config.ClientCAs = pool
+	}
+	switch t.authClients {
+	case "yes":
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	case "optional":
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	t.mu.Lock()
+	t.config = config
+	t.mu.Unlock()
+	return nil
+}
+
+//configForClient returns the latest config, so new connections pick up reloaded certificates
+func (t *tlsReloader) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.config, nil
+}
+
+//tlsConfig returns the config of the TLS listener
+func (t *tlsReloader) tlsConfig() *tls.Config {
+	return &tls.Config{GetConfigForClient: t.configForClient}
+}
+
+//reloadOnSignal reloads the certificates every time the process receives SIGHUP
+func (t *tlsReloader) reloadOnSignal() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	for range c {
+		if err := t.reload(); err != nil {
+			log.Println("Failed to reload tls certificates,", err)
+			continue
+		}
+		log.Println("tls certificates reloaded")
+	}
+}
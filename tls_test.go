@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+//writeCert writes a new self-signed certificate and its key to cert.pem and key.pem in dir,
+//it returns the DER bytes of the certificate
+func writeCert(t *testing.T, dir string, serial int64) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "lucas"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(dir, "cert.pem"), "CERTIFICATE", der)
+	writePEM(t, filepath.Join(dir, "key.pem"), "EC PRIVATE KEY", keyDer)
+	return der
+}
+
+func writePEM(t *testing.T, path, kind string, der []byte) {
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//servedCert makes a TLS handshake with the config and returns the certificate presented by the server
+func servedCert(t *testing.T, config *tls.Config) []byte {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go tls.Server(server, config).Handshake()
+
+	conn := tls.Client(client, &tls.Config{InsecureSkipVerify: true})
+	if err := conn.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	return conn.ConnectionState().PeerCertificates[0].Raw
+}
+
+func TestNewTLSReloader(t *testing.T) {
+	dir := t.TempDir()
+	writeCert(t, dir, 1)
+	cert, key := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	empty := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(empty, []byte("no certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	type args struct {
+		cert, key, ca, authClients, minVersion, ciphers string
+	}
+	tests := []struct {
+		name       string
+		args       args
+		wantErr    bool
+		clientAuth tls.ClientAuthType
+	}{
+		{"no client auth", args{cert, key, "", "no", "1.2", ""}, false, tls.NoClientCert},
+		{"optional client auth", args{cert, key, cert, "optional", "1.2", ""}, false, tls.VerifyClientCertIfGiven},
+		{"client auth", args{cert, key, cert, "yes", "1.3", ""}, false, tls.RequireAndVerifyClientCert},
+		{"client auth without ca", args{cert, key, "", "yes", "1.2", ""}, true, 0},
+		{"missing cert", args{"", key, "", "no", "1.2", ""}, true, 0},
+		{"missing key", args{cert, "", "", "no", "1.2", ""}, true, 0},
+		{"bad auth clients", args{cert, key, cert, "always", "1.2", ""}, true, 0},
+		{"bad min version", args{cert, key, "", "no", "1.4", ""}, true, 0},
+		{"ciphers", args{cert, key, "", "no", "1.2", "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}, false, tls.NoClientCert},
+		{"unknown cipher", args{cert, key, "", "no", "1.2", "TLS_FOO"}, true, 0},
+		{"cert not found", args{filepath.Join(dir, "none.pem"), key, "", "no", "1.2", ""}, true, 0},
+		{"no ca in file", args{cert, key, empty, "yes", "1.2", ""}, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := tt.args
+			got, err := newTLSReloader(a.cert, a.key, a.ca, a.authClients, a.minVersion, a.ciphers)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newTLSReloader() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil {
+				assert.Equal(t, tt.clientAuth, got.config.ClientAuth)
+				assert.Equal(t, tlsVersions[a.minVersion], got.config.MinVersion)
+			}
+		})
+	}
+}
+
+func TestTLSReloaderReload(t *testing.T) {
+	dir := t.TempDir()
+	first := writeCert(t, dir, 1)
+	reloader, err := newTLSReloader(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), "", "no", "1.2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := reloader.tlsConfig()
+	assert.Equal(t, first, servedCert(t, config))
+
+	//an invalid certificate is reported and the current one is kept
+	os.WriteFile(filepath.Join(dir, "cert.pem"), []byte("garbage"), 0600)
+	assert.NotNil(t, reloader.reload())
+	assert.Equal(t, first, servedCert(t, config))
+
+	//SIGHUP loads the new certificate, the listener config picks it up for the next connections.
+	//The test catches SIGHUP too, so the signal can't kill it before reloadOnSignal is listening.
+	second := writeCert(t, dir, 2)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go reloader.reloadOnSignal()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		syscall.Kill(os.Getpid(), syscall.SIGHUP)
+		time.Sleep(10 * time.Millisecond)
+		if bytes.Equal(second, servedCert(t, config)) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("certificate not reloaded on SIGHUP")
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package util
+
+import "strings"
+
+//Clone returns a copy of s which doesn't share memory with it.
+//The arguments of a command are views into the read buffer of its connection,
+//they are cloned when they are kept after the command.
+func Clone(s string) string {
+	if len(s) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	b.WriteString(s)
+	return b.String()
+}
+
+//CloneArray clones every string of the array into a new array
+func CloneArray(array []string) []string {
+	ret := make([]string, len(array))
+	for i, s := range array {
+		ret[i] = Clone(s)
+	}
+	return ret
+}